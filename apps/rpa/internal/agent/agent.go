@@ -4,6 +4,7 @@
 package agent
 
 import (
+	"context"
 	"fmt"
 	"net"
 	"os/exec"
@@ -18,6 +19,7 @@ import (
 	"reverse-proxy-agent/pkg/logging"
 	"reverse-proxy-agent/pkg/monitor"
 	"reverse-proxy-agent/pkg/restart"
+	"reverse-proxy-agent/pkg/sshutil"
 	"reverse-proxy-agent/pkg/state"
 	"reverse-proxy-agent/pkg/statefile"
 )
@@ -26,7 +28,10 @@ type Agent struct {
 	cfg    *config.Config
 	runner *supervisor.Runner
 
-	forwardMu sync.Mutex
+	forwardMu       sync.Mutex
+	forwardsVersion int
+
+	extraSSHArgs []string
 }
 
 func New(cfg *config.Config) *Agent {
@@ -37,6 +42,8 @@ func New(cfg *config.Config) *Agent {
 	runner := supervisor.New(restart.ParsePolicy(cfg.Agent.RestartPolicy), restart.NewBackoff(cfg.Agent.Restart))
 	if path != "" {
 		runner.SetStateWriter(func(snap statefile.Snapshot) {
+			snap.Version = buildinfo.Current().Version
+			snap.SSHVersion = sshutil.Version()
 			_ = statefile.Write(path, snap)
 		})
 	}
@@ -46,10 +53,16 @@ func New(cfg *config.Config) *Agent {
 	}
 }
 
+// SetExtraSSHArgs appends raw arguments to every ssh invocation. It is meant
+// for experiments from `agent run --ssh-arg` and must be called before running.
+func (a *Agent) SetExtraSSHArgs(args []string) {
+	a.extraSSHArgs = append([]string(nil), args...)
+}
+
 func (a *Agent) Start() error {
 	return a.runner.Start(func() (*exec.Cmd, error) {
-		return buildSSHCommand(a.cfg, a.currentRemoteForwards())
-	})
+		return buildSSHCommand(a.cfg, a.currentRemoteForwards(), a.extraSSHArgs)
+	}, a.cfg.Logging.SSHStderrLines)
 }
 
 func (a *Agent) Stop() error {
@@ -73,17 +86,41 @@ func (a *Agent) RunWithLogger(logger *logging.Logger) error {
 			SleepCheckSec:  a.cfg.Agent.SleepCheckSec,
 			SleepGapSec:    a.cfg.Agent.SleepGapSec,
 			NetworkPollSec: a.cfg.Agent.NetworkPollSec,
+			PowerPollSec:   a.cfg.Agent.PowerPollSec,
 		},
-		PeriodicRestartSec: a.cfg.Agent.PeriodicRestartSec,
-		DebounceMs:         a.cfg.Agent.Restart.DebounceMs,
-		TCPCheckSec:        a.cfg.SSH.CheckSec,
-		TCPCheckAddr:       net.JoinHostPort(a.cfg.SSH.Host, strconv.Itoa(a.cfg.SSH.Port)),
+		PeriodicRestartSec:  a.cfg.Agent.PeriodicRestartSec,
+		PeriodicRestartCron: a.cfg.Agent.PeriodicRestartCron,
+		DebounceMs:          a.cfg.Agent.Restart.DebounceMs,
+		SuccessAfterMs:      config.SuccessAfterMs(a.cfg.Agent.Restart),
+		RapidFailureLimit:   a.cfg.Agent.Restart.RapidFailureLimit,
+		TCPCheckSec:         a.cfg.SSH.TCPCheckSec,
+		TCPCheckAddr:        a.tcpCheckAddr,
+		ProbeRTT:            a.cfg.SSH.ProbeRTT,
+		TCPCheckJitter:      a.cfg.SSH.CheckJitter,
+		TCPCheckFailures:    a.cfg.SSH.TCPCheckFailures,
+		TCPCheckRestart:     a.cfg.SSH.TCPCheckRestart,
+		OnConnect:           a.cfg.Hooks.OnConnect,
+		OnDisconnect:        a.cfg.Hooks.OnDisconnect,
+		HookTimeoutMs:       a.cfg.Hooks.TimeoutMs,
+		NotifyOnReconnect:   a.cfg.Hooks.NotifyOnReconnect,
+		RestartOnStderr:     a.cfg.Agent.Restart.RestartOnStderr,
+		SSHStderrLines:      a.cfg.Logging.SSHStderrLines,
 	}
 	return a.runner.RunWithLogger(logger, func() (*exec.Cmd, error) {
-		return buildSSHCommand(a.cfg, a.currentRemoteForwards())
+		return buildSSHCommand(a.cfg, a.currentRemoteForwards(), a.extraSSHArgs)
 	}, opts)
 }
 
+// tcpCheckAddr uses ssh.check_addr when set. Remote forward binds live on the
+// ssh server and are usually not reachable from here, so the default stays the
+// ssh host itself.
+func (a *Agent) tcpCheckAddr() string {
+	if addr := strings.TrimSpace(a.cfg.SSH.CheckAddr); addr != "" {
+		return addr
+	}
+	return net.JoinHostPort(a.cfg.SSH.Host, strconv.Itoa(a.cfg.SSH.Port))
+}
+
 func (a *Agent) RequestStop() {
 	a.runner.RequestStop()
 }
@@ -92,6 +129,29 @@ func (a *Agent) RequestRestart(reason string) {
 	a.runner.RequestRestart(reason, a.cfg.Agent.Restart.DebounceMs)
 }
 
+func (a *Agent) Reconnect() {
+	a.runner.Reconnect("reconnect")
+}
+
+// Pause stops ssh but keeps the agent process (and its launchd job) running.
+func (a *Agent) Pause() bool {
+	return a.runner.Pause("pause")
+}
+
+func (a *Agent) Resume() bool {
+	return a.runner.Resume("resume")
+}
+
+func (a *Agent) Paused() bool {
+	return a.runner.Paused()
+}
+
+// SetMonitorIntervals retunes the running sleep/network/power monitors; an
+// empty changes map only reports the current settings.
+func (a *Agent) SetMonitorIntervals(changes map[string]int) (monitor.Config, error) {
+	return a.runner.SetMonitorIntervals(changes)
+}
+
 func (a *Agent) RestartCount() int {
 	return a.runner.RestartCount()
 }
@@ -104,6 +164,14 @@ func (a *Agent) LastSuccess() time.Time {
 	return a.runner.LastSuccess()
 }
 
+func (a *Agent) FirstSuccess() time.Time {
+	return a.runner.FirstSuccess()
+}
+
+func (a *Agent) StartupConnect() (time.Duration, bool) {
+	return a.runner.StartupConnect()
+}
+
 func (a *Agent) LastClass() string {
 	return a.runner.LastClass()
 }
@@ -112,10 +180,56 @@ func (a *Agent) LastTriggerReason() string {
 	return a.runner.LastTriggerReason()
 }
 
+func (a *Agent) LastTriggerAt() time.Time {
+	return a.runner.LastTriggerAt()
+}
+
+func (a *Agent) SSHStderr() []string {
+	return a.runner.SSHStderr()
+}
+
+func (a *Agent) Subscribe() (<-chan supervisor.Event, func()) {
+	return a.runner.Subscribe()
+}
+
+func (a *Agent) ProbeRTT() (time.Duration, time.Duration, bool) {
+	return a.runner.ProbeRTT()
+}
+
+func (a *Agent) TriggerCounts() map[string]int {
+	return a.runner.TriggerCounts()
+}
+
+func (a *Agent) StateTransitions() int {
+	return a.runner.StateTransitions()
+}
+
+func (a *Agent) ExitClassCounts() map[string]int {
+	return a.runner.ExitClassCounts()
+}
+
 func (a *Agent) TCPCheckStatus() (string, string, time.Time) {
 	return a.runner.TCPCheckStatus()
 }
 
+// Health is state.Health for the current state and tcp check.
+func (a *Agent) Health() string {
+	status, _, _ := a.runner.TCPCheckStatus()
+	return state.Health(a.State(), status)
+}
+
+func (a *Agent) TCPCheckFailures() int {
+	return a.runner.TCPCheckFailures()
+}
+
+func (a *Agent) ConnectAttempts() int {
+	return a.runner.ConnectAttempts()
+}
+
+func (a *Agent) FlapCount() int {
+	return a.runner.FlapCount()
+}
+
 func (a *Agent) StartSuccessCount() int {
 	return a.runner.StartSuccessCount()
 }
@@ -151,7 +265,7 @@ func (a *Agent) AddRemoteForward(forward string) (bool, error) {
 	}
 	current = append(current, trimmed)
 	config.SetRemoteForwards(a.cfg, current)
-	a.RequestRestart("remote forward added")
+	a.forwardsChangedLocked("added", trimmed, len(current))
 	return true, nil
 }
 
@@ -179,10 +293,110 @@ func (a *Agent) RemoveRemoteForward(forward string) (bool, error) {
 		return false, fmt.Errorf("at least one remote forward is required")
 	}
 	config.SetRemoteForwards(a.cfg, next)
-	a.RequestRestart("remote forward removed")
+	a.forwardsChangedLocked("removed", trimmed, len(next))
 	return true, nil
 }
 
+// ReplaceRemoteForward swaps old for next as a single change so the caller
+// can apply both with one restart.
+func (a *Agent) ReplaceRemoteForward(old, next string) (bool, error) {
+	old = strings.TrimSpace(old)
+	next = strings.TrimSpace(next)
+	if old == "" || next == "" {
+		return false, fmt.Errorf("both the old and the new remote forward are required")
+	}
+	a.forwardMu.Lock()
+	defer a.forwardMu.Unlock()
+	if old == next {
+		return false, nil
+	}
+	current := config.NormalizeRemoteForwards(a.cfg)
+	found := false
+	for _, existing := range current {
+		if existing == next {
+			return false, fmt.Errorf("remote forward %q already present", next)
+		}
+		if existing == old {
+			found = true
+		}
+	}
+	if !found {
+		return false, fmt.Errorf("remote forward %q not found", old)
+	}
+	config.ReplaceRemoteForward(a.cfg, old, next)
+	a.forwardsChangedLocked("replaced", old+" -> "+next, len(current))
+	return true, nil
+}
+
+// ApplyForward pushes a forward change to the running session through the
+// ssh ControlMaster socket when ssh.control_master is on, so other forwards
+// stay up. It falls back to a restart and reports whether the live path worked.
+func (a *Agent) ApplyForward(op, forward, reason string) bool {
+	if a.cfg.SSH.ControlMaster && a.State() == state.StateConnected {
+		err := a.controlForward(op, forward)
+		if err == nil {
+			a.runner.LogEvent("INFO", "forward_applied", map[string]any{
+				"op":      op,
+				"forward": forward,
+				"method":  "control",
+			})
+			return true
+		}
+		a.runner.LogEvent("WARN", "control_forward_failed", map[string]any{
+			"op":      op,
+			"forward": forward,
+			"error":   err.Error(),
+		})
+	}
+	a.RequestRestart(reason)
+	return false
+}
+
+// ApplyReplace is ApplyForward for a replace: it cancels old and forwards
+// next over the control socket, or requests a single restart for both.
+func (a *Agent) ApplyReplace(old, next, reason string) bool {
+	if a.cfg.SSH.ControlMaster && a.State() == state.StateConnected {
+		err := a.controlForward("cancel", old)
+		if err == nil {
+			err = a.controlForward("forward", next)
+		}
+		if err == nil {
+			a.runner.LogEvent("INFO", "forward_applied", map[string]any{
+				"op":      "replace",
+				"forward": next,
+				"old":     old,
+				"method":  "control",
+			})
+			return true
+		}
+		a.runner.LogEvent("WARN", "control_forward_failed", map[string]any{
+			"op":      "replace",
+			"forward": next,
+			"old":     old,
+			"error":   err.Error(),
+		})
+	}
+	a.RequestRestart(reason)
+	return false
+}
+
+func (a *Agent) controlForward(op, forward string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), controlTimeout)
+	defer cancel()
+	cmd, err := buildControlCommand(ctx, a.cfg, op, forward)
+	if err != nil {
+		return err
+	}
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		if msg := strings.TrimSpace(string(out)); msg != "" {
+			return fmt.Errorf("%w: %s", err, msg)
+		}
+		return err
+	}
+	return nil
+}
+
 func (a *Agent) ClearRemoteForwards() bool {
 	a.forwardMu.Lock()
 	defer a.forwardMu.Unlock()
@@ -191,10 +405,29 @@ func (a *Agent) ClearRemoteForwards() bool {
 		return false
 	}
 	config.SetRemoteForwards(a.cfg, nil)
+	a.forwardsChangedLocked("cleared", strings.Join(current, ","), 0)
 	a.RequestStop()
 	return true
 }
 
+// forwardsChangedLocked bumps the forwards version and logs the change.
+// Callers must hold forwardMu.
+func (a *Agent) forwardsChangedLocked(action, spec string, count int) {
+	a.forwardsVersion++
+	a.runner.LogEvent("INFO", "forwards_changed", map[string]any{
+		"action":  action,
+		"forward": spec,
+		"count":   count,
+		"version": a.forwardsVersion,
+	})
+}
+
+func (a *Agent) ForwardsVersion() int {
+	a.forwardMu.Lock()
+	defer a.forwardMu.Unlock()
+	return a.forwardsVersion
+}
+
 func (a *Agent) currentRemoteForwards() []string {
 	a.forwardMu.Lock()
 	defer a.forwardMu.Unlock()
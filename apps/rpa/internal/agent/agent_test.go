@@ -0,0 +1,74 @@
+package agent
+
+import (
+	"testing"
+
+	"reverse-proxy-agent/pkg/config"
+)
+
+func TestForwardsVersion(t *testing.T) {
+	cases := []struct {
+		name        string
+		change      func(a *Agent) (bool, error)
+		wantChanged bool
+		wantVersion int
+	}{
+		{name: "add", change: func(a *Agent) (bool, error) { return a.AddRemoteForward("9090:localhost:90") }, wantChanged: true, wantVersion: 1},
+		{name: "add duplicate", change: func(a *Agent) (bool, error) { return a.AddRemoteForward("8080:localhost:80") }},
+		{name: "remove", change: func(a *Agent) (bool, error) {
+			if _, err := a.AddRemoteForward("9090:localhost:90"); err != nil {
+				return false, err
+			}
+			return a.RemoveRemoteForward("9090:localhost:90")
+		}, wantChanged: true, wantVersion: 2},
+		{name: "remove missing", change: func(a *Agent) (bool, error) { return a.RemoveRemoteForward("9090:localhost:90") }},
+		{name: "remove last refused", change: func(a *Agent) (bool, error) {
+			changed, err := a.RemoveRemoteForward("8080:localhost:80")
+			if err == nil {
+				t.Fatal("removing the last forward succeeded")
+			}
+			return changed, nil
+		}},
+		{name: "replace", change: func(a *Agent) (bool, error) { return a.ReplaceRemoteForward("8080:localhost:80", "9090:localhost:90") }, wantChanged: true, wantVersion: 1},
+		{name: "replace with itself", change: func(a *Agent) (bool, error) { return a.ReplaceRemoteForward("8080:localhost:80", "8080:localhost:80") }},
+		{name: "clear", change: func(a *Agent) (bool, error) { return a.ClearRemoteForwards(), nil }, wantChanged: true, wantVersion: 1},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			a := New(testSSHConfig(nil))
+			if v := a.ForwardsVersion(); v != 0 {
+				t.Fatalf("initial ForwardsVersion = %d, want 0", v)
+			}
+			changed, err := tc.change(a)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if changed != tc.wantChanged {
+				t.Fatalf("changed = %v, want %v", changed, tc.wantChanged)
+			}
+			if v := a.ForwardsVersion(); v != tc.wantVersion {
+				t.Fatalf("ForwardsVersion = %d, want %d", v, tc.wantVersion)
+			}
+		})
+	}
+}
+
+func TestTCPCheckAddr(t *testing.T) {
+	cases := []struct {
+		name      string
+		checkAddr string
+		want      string
+	}{
+		// Remote binds live on the server, so the agent probes the ssh host.
+		{name: "ssh host by default", want: "bastion.example.com:22"},
+		{name: "explicit check addr", checkAddr: " 10.0.0.1:443 ", want: "10.0.0.1:443"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			a := New(testSSHConfig(func(cfg *config.Config) { cfg.SSH.CheckAddr = tc.checkAddr }))
+			if got := a.tcpCheckAddr(); got != tc.want {
+				t.Fatalf("tcpCheckAddr = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,541 @@
+package ipc
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"reverse-proxy-agent/internal/agent"
+	"reverse-proxy-agent/pkg/config"
+	ipcclient "reverse-proxy-agent/pkg/ipc/agent"
+	"reverse-proxy-agent/pkg/logging"
+	"reverse-proxy-agent/pkg/monitor"
+	"reverse-proxy-agent/pkg/state"
+)
+
+func TestSocketMode(t *testing.T) {
+	cases := []struct {
+		name string
+		mode string
+		want os.FileMode
+	}{
+		{name: "default", want: 0o600},
+		{name: "group access", mode: "0660", want: 0o660},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			server, _ := startServer(t, func(cfg *config.Config) { cfg.IPC.SocketMode = tc.mode })
+			info, err := os.Stat(server.socketPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := info.Mode().Perm(); got != tc.want {
+				t.Fatalf("socket mode = %o, want %o", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestMaxConns(t *testing.T) {
+	cases := []struct {
+		name  string
+		limit int
+		want  int
+	}{
+		{name: "configured limit", limit: 2, want: 2},
+		{name: "default limit", want: 16},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			server, _ := startServer(t, func(cfg *config.Config) {
+				cfg.IPC.MaxConns = tc.limit
+				cfg.IPC.ReadTimeoutMs = 10000
+			})
+			// Idle connections hold a slot until they send or hang up.
+			idle := make([]net.Conn, 0, tc.want)
+			for i := 0; i < tc.want; i++ {
+				conn, err := net.Dial("unix", server.socketPath)
+				if err != nil {
+					t.Fatal(err)
+				}
+				idle = append(idle, conn)
+			}
+			if resp := call(t, server, "ping", nil); resp.OK || resp.Message != "too many connections" {
+				t.Fatalf("ping over the limit = %+v, want a too many connections error", resp)
+			}
+
+			idle[0].Close()
+			deadline := time.Now().Add(5 * time.Second)
+			for !call(t, server, "ping", nil).OK {
+				if time.Now().After(deadline) {
+					t.Fatal("a freed slot was never reused")
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+			for _, conn := range idle[1:] {
+				conn.Close()
+			}
+		})
+	}
+}
+
+func TestEventStreamsLeaveSlots(t *testing.T) {
+	server, _ := startServer(t, func(cfg *config.Config) {
+		cfg.IPC.MaxConns = 2
+		cfg.IPC.ReadTimeoutMs = 10000
+	})
+	subscribe := func() (net.Conn, response) {
+		t.Helper()
+		conn, err := net.DialTimeout("unix", server.socketPath, time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		if _, err := conn.Write([]byte(`{"command":"events"}` + "\n")); err != nil {
+			t.Fatal(err)
+		}
+		_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		line, err := bufio.NewReader(conn).ReadBytes('\n')
+		if err != nil {
+			t.Fatalf("read subscribe reply: %v", err)
+		}
+		var resp response
+		if err := json.Unmarshal(line, &resp); err != nil {
+			t.Fatal(err)
+		}
+		return conn, resp
+	}
+
+	// Streams up to max_conns stay open and requests still get a slot.
+	var streams []net.Conn
+	for i := 0; i < 2; i++ {
+		conn, resp := subscribe()
+		if !resp.OK || resp.Message != "subscribed" {
+			t.Fatalf("stream %d = %+v, want subscribed", i, resp)
+		}
+		streams = append(streams, conn)
+	}
+	for _, command := range []string{"status", "ping", "status"} {
+		if resp := call(t, server, command, nil); !resp.OK {
+			t.Fatalf("%s with %d open streams = %+v", command, len(streams), resp)
+		}
+	}
+	if _, resp := subscribe(); resp.OK || resp.Message != "too many event streams" {
+		t.Fatalf("stream over the limit = %+v, want too many event streams", resp)
+	}
+
+	streams[0].Close()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, resp := subscribe(); resp.OK {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("a closed stream never gave its slot back")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestReadTimeout(t *testing.T) {
+	cases := []struct {
+		name     string
+		send     bool
+		wantDrop bool
+	}{
+		{name: "silent client dropped", wantDrop: true},
+		{name: "prompt client answered", send: true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			server, _ := startServer(t, func(cfg *config.Config) {
+				cfg.IPC.ReadTimeoutMs = 100
+			})
+			conn, err := net.Dial("unix", server.socketPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			if tc.send {
+				if _, err := conn.Write([]byte("{\"command\":\"ping\"}\n")); err != nil {
+					t.Fatal(err)
+				}
+			}
+			conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+			var resp response
+			err = json.NewDecoder(conn).Decode(&resp)
+			if tc.wantDrop {
+				if err != io.EOF {
+					t.Fatalf("silent client read = %v, want io.EOF", err)
+				}
+				return
+			}
+			if err != nil || !resp.OK {
+				t.Fatalf("ping = %+v, %v", resp, err)
+			}
+		})
+	}
+}
+
+func TestLastTriggerUnix(t *testing.T) {
+	server, _ := startServer(t, nil)
+	keys := []struct {
+		command string
+		key     string
+	}{
+		{command: "status", key: "last_trigger_unix"},
+		{command: "metrics", key: "rpa_agent_last_trigger_unix"},
+	}
+	for _, k := range keys {
+		if v, ok := call(t, server, k.command, nil).Data[k.key]; ok {
+			t.Fatalf("%s before any trigger has %s = %q", k.command, k.key, v)
+		}
+	}
+
+	before := time.Now().Unix()
+	if resp := call(t, server, "reconnect", nil); !resp.OK {
+		t.Fatalf("reconnect: %s", resp.Message)
+	}
+	after := time.Now().Unix()
+	for _, k := range keys {
+		v := call(t, server, k.command, nil).Data[k.key]
+		got, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || got < before || got > after {
+			t.Fatalf("%s %s = %q, want a unix time in [%d, %d]", k.command, k.key, v, before, after)
+		}
+	}
+}
+
+func TestEvents(t *testing.T) {
+	server, cfg := startServer(t, nil)
+	got := make(chan ipcclient.Event, 1)
+	errStop := errors.New("stop")
+	done := make(chan error, 1)
+	go func() {
+		done <- ipcclient.Events(cfg, func(ev ipcclient.Event) error {
+			got <- ev
+			return errStop
+		})
+	}()
+
+	// The subscription races the first reconnect, so keep asking until one
+	// is seen.
+	deadline := time.After(5 * time.Second)
+	tick := time.NewTicker(20 * time.Millisecond)
+	defer tick.Stop()
+	for {
+		select {
+		case ev := <-got:
+			if ev.Type != "restart_triggered" || ev.Fields["reason"] != "reconnect" || ev.Time.IsZero() {
+				t.Fatalf("event = %+v, want restart_triggered for reconnect", ev)
+			}
+			if err := <-done; !errors.Is(err, errStop) {
+				t.Fatalf("Events returned %v, want the handler error", err)
+			}
+			return
+		case <-tick.C:
+			if resp := call(t, server, "reconnect", nil); !resp.OK {
+				t.Fatalf("reconnect: %s", resp.Message)
+			}
+		case <-deadline:
+			t.Fatal("no event reached the subscriber")
+		}
+	}
+}
+
+func TestSSHStderrCommand(t *testing.T) {
+	server, _ := startServer(t, nil)
+	resp := call(t, server, "ssh_stderr", nil)
+	if !resp.OK || len(resp.Logs) != 0 {
+		t.Fatalf("ssh_stderr before any run = %+v, want ok with no lines", resp)
+	}
+}
+
+func TestFormatCounts(t *testing.T) {
+	cases := []struct {
+		counts map[string]int
+		want   string
+	}{
+		{counts: nil, want: ""},
+		{counts: map[string]int{"clean": 12}, want: "clean=12"},
+		{counts: map[string]int{"timeout": 1, "clean": 12, "network": 3}, want: "clean=12 network=3 timeout=1"},
+	}
+	for _, tc := range cases {
+		if got := formatCounts(tc.counts); got != tc.want {
+			t.Errorf("formatCounts(%v) = %q, want %q", tc.counts, got, tc.want)
+		}
+	}
+}
+
+func TestReplaceForwardRestartsOnce(t *testing.T) {
+	const (
+		old  = "0.0.0.0:2222:localhost:22"
+		next = "0.0.0.0:2200:localhost:22"
+	)
+	cases := []struct {
+		name         string
+		args         map[string]string
+		extra        string
+		wantOK       bool
+		wantRestarts int
+		wantSpec     string
+	}{
+		{name: "replace", args: map[string]string{"replace": old, "remote_forward": next}, wantOK: true, wantRestarts: 1, wantSpec: next},
+		{name: "same spec", args: map[string]string{"replace": old, "remote_forward": old}, wantOK: true, wantSpec: old},
+		{name: "old missing", args: map[string]string{"replace": "0.0.0.0:9999:localhost:99", "remote_forward": next}, wantSpec: old},
+		{name: "new already present", args: map[string]string{"replace": old, "remote_forward": next}, extra: next, wantSpec: old},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			bin := t.TempDir()
+			argv := bin + "/argv"
+			script := "#!/bin/sh\n[ \"$1\" = -V ] && { echo OpenSSH_9.6p1 >&2; exit 0; }\necho \"$*\" >> " + argv + "\nexec sleep 30\n"
+			if err := os.WriteFile(bin+"/ssh", []byte(script), 0o700); err != nil {
+				t.Fatal(err)
+			}
+			t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+			server, _ := startServer(t, func(cfg *config.Config) {
+				cfg.Agent.Restart.MinDelayMs = 1
+				cfg.Agent.Restart.MaxDelayMs = 5
+				if tc.extra != "" {
+					cfg.SSH.RemoteForwards = append(cfg.SSH.RemoteForwards, config.Forward{Spec: tc.extra})
+				}
+			})
+			logger, err := logging.NewLoggerWithPath(bin+"/agent.log", logging.NewLogBuffer())
+			if err != nil {
+				t.Fatal(err)
+			}
+			done := make(chan error, 1)
+			go func() { done <- server.agent.RunWithLogger(logger) }()
+			t.Cleanup(func() {
+				server.agent.RequestStop()
+				select {
+				case <-done:
+				case <-time.After(10 * time.Second):
+					t.Error("agent did not stop")
+				}
+			})
+			waitUntil(t, func() bool { return server.agent.State() == state.StateConnected })
+
+			resp := call(t, server, "replace_forward", tc.args)
+			if resp.OK != tc.wantOK {
+				t.Fatalf("replace_forward OK = %v (%s), want %v", resp.OK, resp.Message, tc.wantOK)
+			}
+			wantStarts := 1 + tc.wantRestarts
+			waitUntil(t, func() bool {
+				return server.agent.ConnectAttempts() == wantStarts && server.agent.State() == state.StateConnected
+			})
+			// Nothing else restarts it after the single swap.
+			time.Sleep(300 * time.Millisecond)
+			if got := server.agent.ConnectAttempts(); got != wantStarts {
+				t.Fatalf("connect attempts = %d, want %d", got, wantStarts)
+			}
+			restarts := 0
+			for _, n := range server.agent.TriggerCounts() {
+				restarts += n
+			}
+			if restarts != tc.wantRestarts {
+				t.Fatalf("restart triggers = %v, want %d", server.agent.TriggerCounts(), tc.wantRestarts)
+			}
+			data, err := os.ReadFile(argv)
+			if err != nil {
+				t.Fatal(err)
+			}
+			runs := strings.Split(strings.TrimSpace(string(data)), "\n")
+			last := runs[len(runs)-1]
+			if !strings.Contains(last, "-R "+tc.wantSpec) {
+				t.Fatalf("last ssh argv %q lacks -R %s", last, tc.wantSpec)
+			}
+			if tc.wantRestarts > 0 && strings.Contains(last, old) {
+				t.Fatalf("last ssh argv %q still forwards %s", last, old)
+			}
+		})
+	}
+}
+
+// waitUntil polls cond for a few seconds.
+func waitUntil(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met in time")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestStatusMonitors(t *testing.T) {
+	server, _ := startServer(t, nil)
+	resp := call(t, server, "status", nil)
+	if got, want := resp.Data["monitors"], monitor.Info().String(); got != want {
+		t.Fatalf("status monitors = %q, want %q", got, want)
+	}
+}
+
+func TestPing(t *testing.T) {
+	server, _ := startServer(t, nil)
+	before := time.Now().UnixMilli()
+	resp := call(t, server, "ping", nil)
+	after := time.Now().UnixMilli()
+	if !resp.OK || resp.Message != "pong" {
+		t.Fatalf("ping = %+v, want OK pong", resp)
+	}
+	got, err := strconv.ParseInt(resp.Data["server_unix_ms"], 10, 64)
+	if err != nil || got < before || got > after {
+		t.Fatalf("server_unix_ms = %q, want a unix ms time in [%d, %d]", resp.Data["server_unix_ms"], before, after)
+	}
+}
+
+func TestPauseResume(t *testing.T) {
+	server, _ := startServer(t, nil)
+	steps := []struct {
+		command     string
+		wantOK      bool
+		wantMessage string
+		wantData    map[string]string
+		wantPaused  bool
+	}{
+		{command: "resume", wantOK: true, wantMessage: "agent is not paused", wantData: map[string]string{"resumed": "false"}},
+		{command: "pause", wantOK: true, wantMessage: "agent paused", wantData: map[string]string{"paused": "true"}, wantPaused: true},
+		{command: "pause", wantOK: true, wantMessage: "agent already paused", wantData: map[string]string{"paused": "false"}, wantPaused: true},
+		{command: "reconnect", wantMessage: "agent is paused", wantPaused: true},
+		{command: "resume", wantOK: true, wantMessage: "agent resumed", wantData: map[string]string{"resumed": "true"}},
+		{command: "reconnect", wantOK: true, wantMessage: "reconnecting"},
+	}
+	for i, step := range steps {
+		resp := call(t, server, step.command, nil)
+		if resp.OK != step.wantOK || !strings.Contains(resp.Message, step.wantMessage) {
+			t.Fatalf("step %d %s = %+v, want OK %v with %q", i, step.command, resp, step.wantOK, step.wantMessage)
+		}
+		for key, want := range step.wantData {
+			if got := resp.Data[key]; got != want {
+				t.Fatalf("step %d %s: %s = %q, want %q", i, step.command, key, got, want)
+			}
+		}
+		if got := server.agent.Paused(); got != step.wantPaused {
+			t.Fatalf("step %d %s: Paused() = %v, want %v", i, step.command, got, step.wantPaused)
+		}
+	}
+}
+
+func TestMonitorIntervals(t *testing.T) {
+	// The server runs without a started session, so the monitors are down.
+	server, _ := startServer(t, nil)
+	cases := []struct {
+		name        string
+		args        map[string]string
+		wantMessage string
+	}{
+		{name: "read while stopped", wantMessage: "monitors are not running"},
+		{name: "set while stopped", args: map[string]string{"network_poll_sec": "2"}, wantMessage: "monitors are not running"},
+		{name: "not a number", args: map[string]string{"network_poll_sec": "2s"}, wantMessage: `network_poll_sec must be a whole number of seconds (got "2s")`},
+		{name: "fraction", args: map[string]string{"sleep_gap_sec": "1.5"}, wantMessage: "sleep_gap_sec must be a whole number of seconds"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			resp := call(t, server, "monitor_intervals", tc.args)
+			if resp.OK || !strings.Contains(resp.Message, tc.wantMessage) {
+				t.Fatalf("monitor_intervals = %+v, want failure with %q", resp, tc.wantMessage)
+			}
+		})
+	}
+}
+
+func TestStatusTyped(t *testing.T) {
+	_, cfg := startServer(t, nil)
+	st, err := ipcclient.StatusTyped(cfg)
+	if err != nil {
+		t.Fatalf("StatusTyped: %v", err)
+	}
+	if st.State != "STOPPED" || st.Health != "down" || st.Socket == "" {
+		t.Fatalf("status = %+v, want a stopped agent, down, with a socket", st)
+	}
+	if len(st.RemoteForwards) != 1 || !strings.Contains(st.RemoteForwards[0], "0.0.0.0:2222:localhost:22") {
+		t.Fatalf("RemoteForwards = %q", st.RemoteForwards)
+	}
+	if st.Uptime < 0 || st.Restarts != 0 || st.ForwardsVersion != 0 {
+		t.Fatalf("counters = %+v, want zero for a fresh agent", st)
+	}
+}
+
+func TestStatusForwardsVersion(t *testing.T) {
+	server, _ := startServer(t, nil)
+	steps := []struct {
+		command     string
+		args        map[string]string
+		wantVersion string
+	}{
+		{command: "status", wantVersion: "0"},
+		{command: "add_forward", args: map[string]string{"remote_forward": "9090:localhost:90"}, wantVersion: "1"},
+		{command: "add_forward", args: map[string]string{"remote_forward": "9090:localhost:90"}, wantVersion: "1"},
+		{command: "remove_forward", args: map[string]string{"remote_forward": "9090:localhost:90"}, wantVersion: "2"},
+	}
+	for _, step := range steps {
+		if resp := call(t, server, step.command, step.args); !resp.OK {
+			t.Fatalf("%s: %s", step.command, resp.Message)
+		}
+		status := call(t, server, "status", nil)
+		if got := status.Data["forwards_version"]; got != step.wantVersion {
+			t.Fatalf("after %s %v: forwards_version = %q, want %q", step.command, step.args, got, step.wantVersion)
+		}
+	}
+}
+
+// startServer serves an agent that was never started from a short temp
+// home; setup adjusts the config first.
+func startServer(t *testing.T, setup func(cfg *config.Config)) (*Server, *config.Config) {
+	t.Helper()
+	home, err := os.MkdirTemp("", "rpa")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(home) })
+	config.SetHomeDir(home)
+	t.Cleanup(func() { config.SetHomeDir("") })
+
+	cfg := &config.Config{}
+	cfg.SSH.User = "me"
+	cfg.SSH.Host = "example.com"
+	cfg.SSH.IdentityFile = "/keys/id_ed25519"
+	cfg.SSH.RemoteForwards = []config.Forward{{Spec: "0.0.0.0:2222:localhost:22"}}
+	if setup != nil {
+		setup(cfg)
+	}
+	config.ApplyDefaults(cfg)
+	server, err := NewServer(cfg, agent.New(cfg), logging.NewLogBuffer())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := server.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(server.Stop)
+	return server, cfg
+}
+
+// call sends one request to server and decodes the reply.
+func call(t *testing.T, server *Server, command string, args map[string]string) response {
+	t.Helper()
+	conn, err := net.DialTimeout("unix", server.socketPath, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if err := json.NewEncoder(conn).Encode(request{Command: command, Args: args}); err != nil {
+		t.Fatal(err)
+	}
+	var resp response
+	if err := json.NewDecoder(conn).Decode(&resp); err != nil {
+		t.Fatalf("%s: %v", command, err)
+	}
+	return resp
+}
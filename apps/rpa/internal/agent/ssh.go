@@ -4,26 +4,78 @@
 package agent
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 
 	"reverse-proxy-agent/pkg/config"
 )
 
-func buildSSHCommand(cfg *config.Config, remoteForwards []string) (*exec.Cmd, error) {
+// buildSSHCommand assembles the ssh argv. extra comes from --ssh-arg and goes
+// after every managed option, right before the destination, since anything
+// after the destination would be sent as a remote command.
+func buildSSHCommand(cfg *config.Config, remoteForwards []string, extra []string) (*exec.Cmd, error) {
 	if err := config.ValidateAgent(cfg); err != nil {
 		return nil, err
 	}
 
-	args := []string{
-		"-N",
-		"-T",
-		"-o", "ExitOnForwardFailure=yes",
-		"-o", "BatchMode=yes",
+	args := []string{"-N"}
+	tty := config.RequestTTY(cfg)
+	userTTY := config.HasSSHOption(cfg.SSH.Options, "RequestTTY")
+	if tty == "no" && !userTTY {
+		args = append(args, "-T")
+	}
+
+	// ssh keeps the first value it sees for each option, so user options go
+	// first and built-in defaults are only added when the user did not set them.
+	for _, opt := range cfg.SSH.Options {
+		if strings.TrimSpace(opt) == "" {
+			continue
+		}
+		args = append(args, "-o", opt)
+	}
+	for _, opt := range config.SetEnvOptions(cfg) {
+		args = append(args, "-o", opt)
+	}
+	if agent := strings.TrimSpace(cfg.SSH.IdentityAgent); agent != "" && !config.HasSSHOption(cfg.SSH.Options, "IdentityAgent") {
+		args = append(args, "-o", "IdentityAgent="+expandTilde(agent))
+	}
+	if level := strings.TrimSpace(cfg.SSH.LogLevel); level != "" && !config.HasSSHOption(cfg.SSH.Options, "LogLevel") {
+		args = append(args, "-o", "LogLevel="+strings.ToUpper(level))
+	}
+	if !userTTY {
+		args = append(args, "-o", "RequestTTY="+tty)
+	}
+	if keys := strings.TrimSpace(cfg.SSH.AddKeysToAgent); keys != "" && !config.HasSSHOption(cfg.SSH.Options, "AddKeysToAgent") {
+		args = append(args, "-o", "AddKeysToAgent="+strings.ToLower(keys))
+	}
+	if cfg.SSH.ForwardAgent {
+		args = append(args, "-A")
+	}
+	if cfg.SSH.ControlMaster {
+		path, err := config.ControlPath(cfg, "agent")
+		if err != nil {
+			return nil, err
+		}
+		// A leftover socket from a crashed run would make ssh skip the master.
+		_ = os.Remove(path)
+		args = append(args, "-o", "ControlMaster=yes", "-o", "ControlPath="+path)
+	}
+	defaults := []string{"BatchMode=yes"}
+	if config.ExitOnForwardFailure(cfg) {
+		defaults = append(defaults, "ExitOnForwardFailure=yes")
+	}
+	for _, opt := range defaults {
+		if config.HasSSHOption(cfg.SSH.Options, opt) {
+			continue
+		}
+		args = append(args, "-o", opt)
 	}
 
 	for _, forward := range remoteForwards {
@@ -37,13 +89,6 @@ func buildSSHCommand(cfg *config.Config, remoteForwards []string) (*exec.Cmd, er
 		args = append(args, "-i", expandTilde(cfg.SSH.IdentityFile))
 	}
 
-	for _, opt := range cfg.SSH.Options {
-		if strings.TrimSpace(opt) == "" {
-			continue
-		}
-		args = append(args, "-o", opt)
-	}
-
 	if cfg.SSH.Port > 0 {
 		args = append(args, "-p", strconv.Itoa(cfg.SSH.Port))
 	}
@@ -53,15 +98,41 @@ func buildSSHCommand(cfg *config.Config, remoteForwards []string) (*exec.Cmd, er
 		userHost = fmt.Sprintf("%s@%s", cfg.SSH.User, cfg.SSH.Host)
 	}
 
+	args = append(args, extra...)
 	args = append(args, userHost)
 	return exec.Command("ssh", args...), nil
 }
 
+// controlTimeout bounds a single ssh -O request to the ControlMaster.
+const controlTimeout = 5 * time.Second
+
+// buildControlCommand asks the running ControlMaster session to add
+// (op "forward") or drop (op "cancel") a single forward without restarting.
+func buildControlCommand(ctx context.Context, cfg *config.Config, op, forward string) (*exec.Cmd, error) {
+	if !cfg.SSH.ControlMaster {
+		return nil, errors.New("ssh.control_master is off")
+	}
+	path, err := config.ControlPath(cfg, "agent")
+	if err != nil {
+		return nil, err
+	}
+	args := []string{"-S", path, "-O", op, "-R", forward}
+	if cfg.SSH.Port > 0 {
+		args = append(args, "-p", strconv.Itoa(cfg.SSH.Port))
+	}
+	userHost := cfg.SSH.Host
+	if cfg.SSH.User != "" {
+		userHost = fmt.Sprintf("%s@%s", cfg.SSH.User, cfg.SSH.Host)
+	}
+	args = append(args, userHost)
+	return exec.CommandContext(ctx, "ssh", args...), nil
+}
+
 func expandTilde(path string) string {
 	if path == "" || path[0] != '~' {
 		return path
 	}
-	home, err := os.UserHomeDir()
+	home, err := config.UserHomeDir()
 	if err != nil {
 		return path
 	}
@@ -0,0 +1,314 @@
+package cli
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"reverse-proxy-agent/pkg/config"
+)
+
+const addTestConfig = "ssh:\n  user: me\n  host: example.com\n  remote_forwards:\n    - \"0.0.0.0:2222:localhost:22\"\nclient:\n  local_forwards:\n    - \"127.0.0.1:5432:db.internal:5432\"\n"
+
+func TestEphemeralLeavesConfig(t *testing.T) {
+	cases := []struct {
+		name        string
+		args        []string
+		socket      string
+		wantCode    int
+		wantCommand string
+		wantArg     string
+	}{
+		{
+			name:        "agent add",
+			args:        []string{"agent", "add", "--remote-forward", "0.0.0.0:8080:localhost:80", "--ephemeral"},
+			socket:      "agent.sock",
+			wantCommand: "add_forward",
+			wantArg:     "0.0.0.0:8080:localhost:80",
+		},
+		{
+			name:        "client up",
+			args:        []string{"client", "up", "--local-forward", "127.0.0.1:6379:cache.internal:6379", "--ephemeral"},
+			socket:      "client.sock",
+			wantCommand: "add_local_forward",
+			wantArg:     "127.0.0.1:6379:cache.internal:6379",
+		},
+		{
+			name:        "agent add replace with flags after the specs",
+			args:        []string{"agent", "add", "--replace", "0.0.0.0:2222:localhost:22", "0.0.0.0:2200:localhost:22", "--ephemeral"},
+			socket:      "agent.sock",
+			wantCommand: "replace_forward",
+			wantArg:     "0.0.0.0:2200:localhost:22",
+		},
+		{
+			name:     "agent add without a running agent",
+			args:     []string{"agent", "add", "--remote-forward", "0.0.0.0:8080:localhost:80", "--ephemeral"},
+			wantCode: exitError,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			home := shortHome(t)
+			cfgPath := filepath.Join(home, "rpa.yaml")
+			writeConfig(t, cfgPath, addTestConfig)
+			requests := func() []ipcRequest { return nil }
+			if tc.socket != "" {
+				requests = fakeIPC(t, filepath.Join(home, tc.socket), func(ipcRequest) ipcReply {
+					return ipcReply{OK: true, Message: "applied", Data: map[string]string{"applied": "live"}}
+				})
+			}
+
+			code := quietRun(t, append(append([]string{"--home", home}, tc.args...), "--config", cfgPath))
+			if code != tc.wantCode {
+				t.Fatalf("exit code = %d, want %d", code, tc.wantCode)
+			}
+			data, err := os.ReadFile(cfgPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(data) != addTestConfig {
+				t.Fatalf("config changed:\n%s", data)
+			}
+			if tc.wantCommand == "" {
+				return
+			}
+			got := requests()
+			if len(got) != 1 || got[0].Command != tc.wantCommand || !hasValue(got[0].Args, tc.wantArg) {
+				t.Fatalf("requests = %+v, want one %s with %q", got, tc.wantCommand, tc.wantArg)
+			}
+		})
+	}
+}
+
+func TestAddPersistsWithoutEphemeral(t *testing.T) {
+	home := shortHome(t)
+	cfgPath := filepath.Join(home, "rpa.yaml")
+	writeConfig(t, cfgPath, addTestConfig)
+	fakeIPC(t, filepath.Join(home, "agent.sock"), func(ipcRequest) ipcReply {
+		return ipcReply{OK: true, Data: map[string]string{"applied": "live"}}
+	})
+
+	if code := quietRun(t, []string{"--home", home, "agent", "add", "--remote-forward", "0.0.0.0:8080:localhost:80", "--config", cfgPath}); code != exitOK {
+		t.Fatalf("exit code = %d, want %d", code, exitOK)
+	}
+	data, err := os.ReadFile(cfgPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) == addTestConfig {
+		t.Fatal("agent add without --ephemeral did not save the forward")
+	}
+}
+
+func hasValue(m map[string]string, want string) bool {
+	for _, v := range m {
+		if v == want {
+			return true
+		}
+	}
+	return false
+}
+
+func TestAddWait(t *testing.T) {
+	old := reconnectPoll
+	reconnectPoll = 20 * time.Millisecond
+	t.Cleanup(func() { reconnectPoll = old })
+
+	cases := []struct {
+		name      string
+		args      []string
+		socket    string
+		applied   string
+		reconnect bool
+		lastClass string
+		wantCode  int
+		wantErr   string
+	}{
+		{name: "agent reconnects", args: []string{"agent", "add", "--remote-forward", "0.0.0.0:8080:localhost:80"}, socket: "agent.sock", reconnect: true, wantCode: exitOK},
+		{name: "client reconnects", args: []string{"client", "add", "--local-forward", "127.0.0.1:6379:cache.internal:6379"}, socket: "client.sock", reconnect: true, wantCode: exitOK},
+		{name: "agent times out", args: []string{"agent", "add", "--remote-forward", "0.0.0.0:8080:localhost:80"}, socket: "agent.sock", wantCode: exitError, wantErr: "agent did not reconnect within 200ms"},
+		{name: "timeout names the exit class", args: []string{"client", "add", "--local-forward", "127.0.0.1:6379:cache.internal:6379"}, socket: "client.sock", lastClass: "auth", wantCode: exitError, wantErr: "(last_class: auth)"},
+		{name: "live apply does not wait", args: []string{"agent", "add", "--remote-forward", "0.0.0.0:8080:localhost:80"}, socket: "agent.sock", applied: "live", wantCode: exitOK},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Cleanup(resetGlobals)
+			home := shortHome(t)
+			cfgPath := filepath.Join(home, "rpa.yaml")
+			writeConfig(t, cfgPath, addTestConfig)
+			start := time.Now()
+			var mu sync.Mutex
+			statusCalls := 0
+			fakeIPC(t, filepath.Join(home, tc.socket), func(req ipcRequest) ipcReply {
+				if req.Command != "status" {
+					applied := tc.applied
+					if applied == "" {
+						applied = "restart"
+					}
+					return ipcReply{OK: true, Data: map[string]string{"added": "true", "applied": applied}}
+				}
+				mu.Lock()
+				defer mu.Unlock()
+				statusCalls++
+				data := map[string]string{"state": "CONNECTED", "last_class": tc.lastClass}
+				lastSuccess := start.Add(-time.Minute)
+				// The session comes back on the third poll.
+				if tc.reconnect && statusCalls >= 3 {
+					lastSuccess = start.Add(2 * time.Second)
+				}
+				data["last_success_unix"] = strconv.FormatInt(lastSuccess.Unix(), 10)
+				return ipcReply{OK: true, Data: data}
+			})
+
+			args := append(append([]string{"--home", home}, tc.args...), "--config", cfgPath, "--wait", "--wait-timeout", "200ms")
+			var code int
+			stdout, stderr := captureOutput(t, func() { code = Run(args) })
+			if code != tc.wantCode {
+				t.Fatalf("exit code = %d, want %d (stderr %q)", code, tc.wantCode, stderr)
+			}
+			if !strings.Contains(stderr, tc.wantErr) {
+				t.Fatalf("stderr %q lacks %q", stderr, tc.wantErr)
+			}
+			if tc.reconnect && !strings.Contains(stdout, "reconnected") {
+				t.Fatalf("stdout %q does not report the reconnect", stdout)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if tc.applied == "live" && statusCalls != 0 {
+				t.Fatalf("live apply still polled status %d times", statusCalls)
+			}
+		})
+	}
+}
+
+func TestAgentReplace(t *testing.T) {
+	const (
+		old  = "0.0.0.0:2222:localhost:22"
+		next = "0.0.0.0:2200:localhost:22"
+	)
+	cases := []struct {
+		name       string
+		args       []string
+		wantCode   int
+		wantSaved  bool
+		wantIPC    bool
+		wantStderr string
+	}{
+		{name: "swaps in config with one update", args: []string{"--replace", old, next}, wantSaved: true, wantIPC: true},
+		{name: "new spec as a flag", args: []string{"--replace", old, "--remote-forward", next}, wantSaved: true, wantIPC: true},
+		{name: "old spec missing", args: []string{"--replace", "0.0.0.0:9999:localhost:99", next}, wantCode: exitError, wantStderr: "not found in config"},
+		{name: "extra argument", args: []string{"--replace", old, next, "0.0.0.0:3333:localhost:33"}, wantCode: exitUsage, wantStderr: `unexpected argument "0.0.0.0:3333:localhost:33"`},
+		{name: "stray argument without replace", args: []string{"--remote-forward", next, "extra"}, wantCode: exitUsage, wantStderr: `unexpected argument "extra"`},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Cleanup(resetGlobals)
+			home := shortHome(t)
+			cfgPath := filepath.Join(home, "rpa.yaml")
+			writeConfig(t, cfgPath, addTestConfig)
+			requests := fakeIPC(t, filepath.Join(home, "agent.sock"), func(ipcRequest) ipcReply {
+				return ipcReply{OK: true, Message: "remote forward replaced", Data: map[string]string{"replaced": "true", "applied": "restart"}}
+			})
+
+			args := append(append([]string{"--home", home, "agent", "add"}, tc.args...), "--config", cfgPath)
+			var code int
+			_, stderr := captureOutput(t, func() { code = Run(args) })
+			if code != tc.wantCode {
+				t.Fatalf("exit code = %d, want %d\nstderr:\n%s", code, tc.wantCode, stderr)
+			}
+			if !strings.Contains(stderr, tc.wantStderr) {
+				t.Fatalf("stderr %q lacks %q", stderr, tc.wantStderr)
+			}
+			cfg, err := config.Load(cfgPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := []string{old}
+			if tc.wantSaved {
+				want = []string{next}
+			}
+			if got := config.NormalizeRemoteForwards(cfg); !equalStrings(got, want) {
+				t.Fatalf("saved remote forwards = %q, want %q", got, want)
+			}
+			got := requests()
+			if !tc.wantIPC {
+				if len(got) != 0 {
+					t.Fatalf("failed replace still sent %+v", got)
+				}
+				return
+			}
+			if len(got) != 1 || got[0].Command != "replace_forward" || got[0].Args["replace"] != old || got[0].Args["remote_forward"] != next {
+				t.Fatalf("requests = %+v, want one replace_forward %s -> %s", got, old, next)
+			}
+		})
+	}
+}
+
+func TestClientDynamicForward(t *testing.T) {
+	withDynamic := addTestConfig + "  dynamic_forwards:\n    - \"1080\"\n"
+	const local = "127.0.0.1:5432:db.internal:5432"
+	cases := []struct {
+		name        string
+		config      string
+		args        []string
+		wantCode    int
+		wantStderr  string
+		wantLocal   []string
+		wantDynamic []string
+		wantCommand string
+		wantArg     string
+	}{
+		{name: "add", config: addTestConfig, args: []string{"add", "--dynamic-forward", " 127.0.0.1:1080 "}, wantLocal: []string{local}, wantDynamic: []string{"127.0.0.1:1080"}, wantCommand: "add_dynamic_forward", wantArg: "127.0.0.1:1080"},
+		{name: "remove", config: withDynamic, args: []string{"remove", "--dynamic-forward", "1080"}, wantLocal: []string{local}, wantDynamic: []string{}, wantCommand: "remove_dynamic_forward", wantArg: "1080"},
+		{name: "remove last local keeps dynamic", config: withDynamic, args: []string{"remove", "--local-forward", local}, wantLocal: []string{}, wantDynamic: []string{"1080"}, wantCommand: "remove_local_forward", wantArg: local},
+		{name: "remove last forward", config: addTestConfig, args: []string{"remove", "--local-forward", local}, wantCode: exitError, wantStderr: "at least one local or dynamic forward is required", wantLocal: []string{local}, wantDynamic: []string{}},
+		{name: "both flags", config: addTestConfig, args: []string{"add", "--local-forward", "6379:cache:6379", "--dynamic-forward", "1080"}, wantCode: exitUsage, wantStderr: "mutually exclusive", wantLocal: []string{local}, wantDynamic: []string{}},
+		{name: "neither flag", config: addTestConfig, args: []string{"remove"}, wantCode: exitUsage, wantStderr: "local-forward or dynamic-forward is required", wantLocal: []string{local}, wantDynamic: []string{}},
+		{name: "bad dynamic spec", config: addTestConfig, args: []string{"add", "--dynamic-forward", "socks"}, wantCode: exitUsage, wantStderr: "dynamic-forward: invalid dynamic forward spec", wantLocal: []string{local}, wantDynamic: []string{}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Cleanup(resetGlobals)
+			home := shortHome(t)
+			cfgPath := filepath.Join(home, "rpa.yaml")
+			writeConfig(t, cfgPath, tc.config)
+			requests := fakeIPC(t, filepath.Join(home, "client.sock"), func(ipcRequest) ipcReply {
+				return ipcReply{OK: true, Data: map[string]string{"applied": "restart"}}
+			})
+
+			args := append(append([]string{"--home", home, "client"}, tc.args...), "--config", cfgPath)
+			var code int
+			_, stderr := captureOutput(t, func() { code = Run(args) })
+			if code != tc.wantCode {
+				t.Fatalf("exit code = %d, want %d\nstderr:\n%s", code, tc.wantCode, stderr)
+			}
+			if !strings.Contains(stderr, tc.wantStderr) {
+				t.Fatalf("stderr %q lacks %q", stderr, tc.wantStderr)
+			}
+			cfg, err := config.Load(cfgPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := config.NormalizeLocalForwards(cfg); !equalStrings(got, tc.wantLocal) {
+				t.Fatalf("saved local forwards = %q, want %q", got, tc.wantLocal)
+			}
+			if got := config.NormalizeDynamicForwards(cfg); !equalStrings(got, tc.wantDynamic) {
+				t.Fatalf("saved dynamic forwards = %q, want %q", got, tc.wantDynamic)
+			}
+			got := requests()
+			if tc.wantCommand == "" {
+				if len(got) != 0 {
+					t.Fatalf("failed change still sent %+v", got)
+				}
+				return
+			}
+			if len(got) != 1 || got[0].Command != tc.wantCommand || !hasValue(got[0].Args, tc.wantArg) {
+				t.Fatalf("requests = %+v, want one %s with %q", got, tc.wantCommand, tc.wantArg)
+			}
+		})
+	}
+}
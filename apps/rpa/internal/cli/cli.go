@@ -5,6 +5,8 @@ package cli
 
 import (
 	"bufio"
+	"bytes"
+	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
@@ -15,6 +17,9 @@ import (
 	"os/signal"
 	"path/filepath"
 	"reflect"
+	"regexp"
+	"runtime"
+	"sort"
 	"strconv"
 	"strings"
 	"syscall"
@@ -26,11 +31,17 @@ import (
 	ipcserver "reverse-proxy-agent/internal/agent/ipc"
 	"reverse-proxy-agent/internal/client"
 	clientipcserver "reverse-proxy-agent/internal/client/ipc"
+	"reverse-proxy-agent/pkg/caffeinate"
 	"reverse-proxy-agent/pkg/config"
 	ipcclient "reverse-proxy-agent/pkg/ipc/agent"
 	ipcclientlocal "reverse-proxy-agent/pkg/ipc/client"
 	"reverse-proxy-agent/pkg/launchd"
 	"reverse-proxy-agent/pkg/logging"
+	"reverse-proxy-agent/pkg/monitor"
+	"reverse-proxy-agent/pkg/pidfile"
+	"reverse-proxy-agent/pkg/restart"
+	"reverse-proxy-agent/pkg/sshutil"
+	"reverse-proxy-agent/pkg/state"
 	"reverse-proxy-agent/pkg/statefile"
 )
 
@@ -40,7 +51,34 @@ const (
 	exitError = 1
 )
 
+// quiet suppresses informational stdout; errors, warnings, and command
+// output such as status or config values are still printed.
+var quiet bool
+
+// jsonErrors makes fail report errors as a JSON object on stdout.
+var jsonErrors bool
+
+type globalFlags struct {
+	configPath string
+	home       string
+	quiet      bool
+	json       bool
+}
+
 func Run(args []string) int {
+	globals, args, err := extractGlobalFlags(args)
+	if err != nil {
+		return fail(exitUsage, "%v", err)
+	}
+	if globals.home != "" {
+		config.SetHomeDir(globals.home)
+	}
+	// A --config/-c given before the command becomes the default for every
+	// subcommand's own --config flag.
+	defaultPath := resolveConfigPath(globals.configPath)
+	quiet = globals.quiet
+	jsonErrors = globals.json
+
 	if len(args) == 0 {
 		printUsage()
 		return exitUsage
@@ -61,103 +99,219 @@ func Run(args []string) int {
 		printUsage()
 		return exitOK
 	case "init":
-		return runInit(args[1:])
+		return runInit(defaultPath, args[1:])
 	case "agent":
-		return runAgent(args[1:])
+		return runAgent(defaultPath, args[1:])
 	case "client":
-		return runClient(args[1:])
+		return runClient(defaultPath, args[1:])
 	case "status":
-		return runStatus(args[1:])
+		return runStatus(defaultPath, args[1:])
 	case "logs":
-		return runLogs(args[1:])
+		return runLogs(defaultPath, args[1:])
 	case "metrics":
-		return runMetrics(args[1:])
+		return runMetrics(defaultPath, args[1:])
+	case "events":
+		return runEvents(defaultPath, args[1:])
+	case "state":
+		return runState(defaultPath, args[1:])
 	case "doctor":
-		return runDoctor(args[1:])
+		return runDoctor(defaultPath, args[1:])
 	case "config":
-		return runConfig(args[1:])
+		return runConfig(defaultPath, args[1:])
+	case "completion":
+		return runCompletion(args[1:])
+	case "ipc":
+		return runIPC(defaultPath, args[1:])
+	case "uninstall":
+		return runUninstall(defaultPath, args[1:])
 	default:
-		fmt.Fprintf(os.Stderr, "unknown command: %s\n", args[0])
-		printUsage()
-		return exitUsage
+		return failUsage(printUsage, "unknown command: %s", args[0])
+	}
+}
+
+func extractGlobalFlags(args []string) (globalFlags, []string, error) {
+	var globals globalFlags
+	for len(args) > 0 {
+		arg := args[0]
+		switch {
+		case arg == "--quiet" || arg == "-quiet" || arg == "-q":
+			globals.quiet = true
+			args = args[1:]
+		case arg == "--json" || arg == "-json":
+			globals.json = true
+			args = args[1:]
+		case arg == "--config" || arg == "-config" || arg == "-c":
+			if len(args) < 2 || strings.TrimSpace(args[1]) == "" {
+				return globalFlags{}, nil, fmt.Errorf("%s requires a path", arg)
+			}
+			globals.configPath = args[1]
+			args = args[2:]
+		case arg == "--home" || arg == "-home":
+			if len(args) < 2 || strings.TrimSpace(args[1]) == "" {
+				return globalFlags{}, nil, fmt.Errorf("%s requires a directory", arg)
+			}
+			globals.home = args[1]
+			args = args[2:]
+		case strings.HasPrefix(arg, "--home="), strings.HasPrefix(arg, "-home="):
+			dir := arg[strings.Index(arg, "=")+1:]
+			if strings.TrimSpace(dir) == "" {
+				return globalFlags{}, nil, fmt.Errorf("%s requires a directory", arg[:strings.Index(arg, "=")])
+			}
+			globals.home = dir
+			args = args[1:]
+		case strings.HasPrefix(arg, "--config="), strings.HasPrefix(arg, "-config="), strings.HasPrefix(arg, "-c="):
+			path := arg[strings.Index(arg, "=")+1:]
+			if strings.TrimSpace(path) == "" {
+				return globalFlags{}, nil, fmt.Errorf("%s requires a path", arg[:strings.Index(arg, "=")])
+			}
+			globals.configPath = path
+			args = args[1:]
+		default:
+			return globals, args, nil
+		}
+	}
+	return globals, args, nil
+}
+
+// fail reports a command error and returns code. With --json the error is
+// written to stdout as {"error": ..., "code": ...} for wrappers to parse.
+func fail(code int, format string, args ...any) int {
+	msg := fmt.Sprintf(format, args...)
+	if jsonErrors {
+		out, err := json.Marshal(struct {
+			Error string `json:"error"`
+			Code  int    `json:"code"`
+		}{Error: msg, Code: code})
+		if err == nil {
+			fmt.Println(string(out))
+			return code
+		}
+	}
+	fmt.Fprintln(os.Stderr, msg)
+	return code
+}
+
+// flagFail is the exit for a failed fs.Parse. The flag package has already
+// printed the error and usage to stderr; with --json the error is also
+// reported through fail so stdout carries it.
+func flagFail(err error) int {
+	if jsonErrors && !errors.Is(err, flag.ErrHelp) {
+		return fail(exitUsage, "%v", err)
+	}
+	return exitUsage
+}
+
+// failUsage is fail(exitUsage, ...) followed by usage. With --json the usage
+// text is left out so stdout holds only the error object.
+func failUsage(usage func(), format string, args ...any) int {
+	code := fail(exitUsage, format, args...)
+	if !jsonErrors {
+		usage()
+	}
+	return code
+}
+
+func infof(format string, args ...any) {
+	if quiet {
+		return
+	}
+	fmt.Printf(format, args...)
+}
+
+func infoln(args ...any) {
+	if quiet {
+		return
 	}
+	fmt.Println(args...)
 }
 
-func runAgent(args []string) int {
+func runAgent(defaultPath string, args []string) int {
 	if len(args) == 0 {
-		fmt.Fprintln(os.Stderr, "missing agent subcommand (up|down|run|add|remove|clear)")
-		printAgentUsage()
-		return exitUsage
+		return failUsage(printAgentUsage, "missing agent subcommand (up|down|run|add|remove|clear|reconnect|pause|resume|monitors|ping)")
 	}
 	switch args[0] {
 	case "help", "-h", "--help":
 		printAgentUsage()
 		return exitOK
 	case "up":
-		return runAgentUp(args[1:])
+		return runAgentUp(defaultPath, args[1:])
 	case "down":
-		return runAgentDown(args[1:])
+		return runAgentDown(defaultPath, args[1:])
 	case "run":
-		return runAgentRun(args[1:])
+		return runAgentRun(defaultPath, args[1:])
 	case "add":
-		return runAgentAdd(args[1:])
+		return runAgentAdd(defaultPath, args[1:])
 	case "remove":
-		return runAgentRemove(args[1:])
+		return runAgentRemove(defaultPath, args[1:])
 	case "clear":
-		return runAgentClear(args[1:])
+		return runAgentClear(defaultPath, args[1:])
+	case "reconnect":
+		return runAgentReconnect(defaultPath, args[1:])
+	case "pause":
+		return runAgentPauseResume(defaultPath, "pause", args[1:])
+	case "resume":
+		return runAgentPauseResume(defaultPath, "resume", args[1:])
+	case "monitors":
+		return runMonitors(defaultPath, "agent", args[1:])
+	case "ping":
+		return runAgentPing(defaultPath, args[1:])
 	default:
-		fmt.Fprintf(os.Stderr, "unknown agent subcommand: %s\n", args[0])
-		return exitUsage
+		return fail(exitUsage, "unknown agent subcommand: %s", args[0])
 	}
 }
 
-func runClient(args []string) int {
+func runClient(defaultPath string, args []string) int {
 	if len(args) == 0 {
-		fmt.Fprintln(os.Stderr, "missing client subcommand (up|down|run|add|remove|clear)")
-		printClientUsage()
-		return exitUsage
+		return failUsage(printClientUsage, "missing client subcommand (up|down|run|add|remove|clear|reconnect|monitors|ping)")
 	}
 	switch args[0] {
 	case "help", "-h", "--help":
 		printClientUsage()
 		return exitOK
 	case "up":
-		return runClientUp(args[1:])
+		return runClientUp(defaultPath, args[1:])
 	case "down":
-		return runClientDown(args[1:])
+		return runClientDown(defaultPath, args[1:])
 	case "run":
-		return runClientRun(args[1:])
+		return runClientRun(defaultPath, args[1:])
 	case "add":
-		return runClientAdd(args[1:])
+		return runClientAdd(defaultPath, args[1:])
 	case "remove":
-		return runClientRemove(args[1:])
+		return runClientRemove(defaultPath, args[1:])
 	case "clear":
-		return runClientClear(args[1:])
+		return runClientClear(defaultPath, args[1:])
+	case "reconnect":
+		return runClientReconnect(defaultPath, args[1:])
+	case "monitors":
+		return runMonitors(defaultPath, "client", args[1:])
+	case "ping":
+		return runClientPing(defaultPath, args[1:])
 	default:
-		fmt.Fprintf(os.Stderr, "unknown client subcommand: %s\n", args[0])
-		return exitUsage
+		return fail(exitUsage, "unknown client subcommand: %s", args[0])
 	}
 }
 
-func runInit(args []string) int {
+func runInit(defaultPath string, args []string) int {
 	fs := flag.NewFlagSet("init", flag.ContinueOnError)
 	fs.SetOutput(os.Stderr)
-	configPath := fs.String("config", defaultConfigPath(), "path to write config file")
+	configPath := fs.String("config", defaultPath, "path to write config file")
 	sshUser := fs.String("ssh-user", "", "ssh username (required)")
 	sshHost := fs.String("ssh-host", "", "ssh host (required)")
 	sshPort := fs.Int("ssh-port", 22, "ssh port")
 	var remoteForwards []string
 	var localForwards []string
 	sshIdentityFile := fs.String("ssh-identity-file", "~/.ssh/id_ed25519", "ssh identity file")
+	sshIdentityAgent := fs.String("ssh-identity-agent", "", "ssh agent socket (IdentityAgent); identity file is skipped unless also given")
 	agentName := fs.String("agent-name", "rpa-agent", "agent name")
 	launchdLabel := fs.String("launchd-label", "com.rpa.agent", "launchd label")
-	restartPolicy := fs.String("restart-policy", "always", "restart policy (always|on-failure)")
+	restartPolicy := fs.String("restart-policy", "always", "restart policy (always|on-failure|never)")
 	periodicRestartSec := fs.Int("periodic-restart-sec", 3600, "periodic restart interval seconds (0 disables)")
 	logLevel := fs.String("log-level", "info", "log level")
 	logPath := fs.String("log-path", "~/.rpa/logs/agent.log", "log path")
 	agentPreventSleep := fs.Bool("agent-prevent-sleep", false, "prevent system sleep while agent is running")
 	clientPreventSleep := fs.Bool("client-prevent-sleep", false, "prevent system sleep while client is running")
 	force := fs.Bool("force", false, "overwrite config if it exists")
+	generateKey := fs.Bool("generate-key", false, "create the identity file with ssh-keygen (ed25519, no passphrase) if it does not exist")
 	var sshOptions []string
 	fs.Func("ssh-option", "additional ssh option (repeatable)", func(value string) error {
 		if strings.TrimSpace(value) == "" {
@@ -180,6 +334,8 @@ func runInit(args []string) int {
 		localForwards = append(localForwards, value)
 		return nil
 	})
+	remoteForwardFile := fs.String("remote-forward-file", "", "file with one remote forward spec per line")
+	localForwardFile := fs.String("local-forward-file", "", "file with one local forward spec per line")
 	fs.Usage = func() {
 		fmt.Fprintln(fs.Output(), "Usage:")
 		fmt.Fprintln(fs.Output(), "  rpa init --ssh-user user --ssh-host host --remote-forward spec [flags]")
@@ -187,37 +343,62 @@ func runInit(args []string) int {
 		fmt.Fprintln(fs.Output(), "")
 		fmt.Fprintln(fs.Output(), "Required:")
 		fmt.Fprintln(fs.Output(), "  --ssh-user, --ssh-host")
-		fmt.Fprintln(fs.Output(), "  --remote-forward or --local-forward")
+		fmt.Fprintln(fs.Output(), "  --remote-forward or --local-forward (or the matching --*-forward-file)")
 		fmt.Fprintln(fs.Output(), "")
 		fmt.Fprintln(fs.Output(), "Spec examples:")
 		fmt.Fprintln(fs.Output(), "  --remote-forward \"0.0.0.0:2222:localhost:22\"")
 		fmt.Fprintln(fs.Output(), "  --local-forward \"127.0.0.1:15432:127.0.0.1:5432\"")
 		fmt.Fprintln(fs.Output(), "")
+		fmt.Fprintln(fs.Output(), "Forward files list one spec per line; blank lines and # comments are ignored.")
+		fmt.Fprintln(fs.Output(), "")
 		fmt.Fprintln(fs.Output(), "Flags:")
 		fs.PrintDefaults()
 	}
 	if err := fs.Parse(args); err != nil {
-		return exitUsage
+		return flagFail(err)
 	}
 
 	if strings.TrimSpace(*sshUser) == "" || strings.TrimSpace(*sshHost) == "" {
-		fmt.Fprintln(os.Stderr, "missing required flags: --ssh-user, --ssh-host")
-		fs.Usage()
-		return exitUsage
+		return failUsage(fs.Usage, "missing required flags: --ssh-user, --ssh-host")
+	}
+	if strings.TrimSpace(*remoteForwardFile) != "" {
+		specs, err := readForwardFile(*remoteForwardFile)
+		if err != nil {
+			return fail(exitUsage, "remote-forward-file: %v", err)
+		}
+		remoteForwards = append(remoteForwards, specs...)
+	}
+	if strings.TrimSpace(*localForwardFile) != "" {
+		specs, err := readForwardFile(*localForwardFile)
+		if err != nil {
+			return fail(exitUsage, "local-forward-file: %v", err)
+		}
+		localForwards = append(localForwards, specs...)
 	}
 	if len(remoteForwards) == 0 && len(localForwards) == 0 {
-		fmt.Fprintln(os.Stderr, "missing required flags: --remote-forward or --local-forward")
-		fs.Usage()
-		return exitUsage
+		return failUsage(fs.Usage, "missing required flags: --remote-forward or --local-forward")
 	}
 
 	if !*force {
 		if _, err := os.Stat(*configPath); err == nil {
-			fmt.Fprintf(os.Stderr, "config already exists: %s (use --force to overwrite)\n", *configPath)
-			return exitUsage
+			return fail(exitUsage, "config already exists: %s (use --force to overwrite)", *configPath)
 		} else if !errors.Is(err, os.ErrNotExist) {
-			fmt.Fprintf(os.Stderr, "config stat failed: %v\n", err)
-			return exitError
+			return fail(exitError, "config stat failed: %v", err)
+		}
+	}
+
+	identityFile := *sshIdentityFile
+	if strings.TrimSpace(*sshIdentityAgent) != "" && !flagWasSet(fs, "ssh-identity-file") {
+		identityFile = ""
+	}
+	if *generateKey {
+		if strings.TrimSpace(identityFile) == "" {
+			return fail(exitUsage, "--generate-key needs --ssh-identity-file")
+		}
+		if _, err := os.Stat(expandTilde(identityFile)); err == nil {
+			return fail(exitUsage, "identity file already exists: %s (drop --generate-key to use it)", identityFile)
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return fail(exitError, "identity file stat failed: %v", err)
 		}
 	}
 
@@ -230,11 +411,12 @@ func runInit(args []string) int {
 			PreventSleep:       *agentPreventSleep,
 		},
 		SSH: config.SSHConfig{
-			User:         *sshUser,
-			Host:         *sshHost,
-			Port:         *sshPort,
-			IdentityFile: *sshIdentityFile,
-			Options:      sshOptions,
+			User:          *sshUser,
+			Host:          *sshHost,
+			Port:          *sshPort,
+			IdentityFile:  identityFile,
+			IdentityAgent: strings.TrimSpace(*sshIdentityAgent),
+			Options:       sshOptions,
 		},
 		Logging: config.LoggingConfig{
 			Level: *logLevel,
@@ -243,81 +425,165 @@ func runInit(args []string) int {
 	}
 	cfg.Client.PreventSleep = *clientPreventSleep
 	if len(remoteForwards) > 0 {
-		cfg.SSH.RemoteForwards = append([]string(nil), remoteForwards...)
+		cfg.SSH.RemoteForwards = config.WithForwardSpecs(nil, remoteForwards)
 	}
 	if len(localForwards) > 0 {
-		cfg.Client.LocalForwards = append([]string(nil), localForwards...)
+		cfg.Client.LocalForwards = config.WithForwardSpecs(nil, localForwards)
 	}
 	config.ApplyDefaults(cfg)
 	if len(remoteForwards) > 0 {
 		if err := config.ValidateAgent(cfg); err != nil {
-			fmt.Fprintf(os.Stderr, "config validation failed: %v\n", err)
-			return exitError
+			return fail(exitError, "config validation failed: %v", err)
 		}
 	}
 	if len(localForwards) > 0 {
 		if err := config.ValidateClient(cfg); err != nil {
-			fmt.Fprintf(os.Stderr, "config validation failed: %v\n", err)
-			return exitError
+			return fail(exitError, "config validation failed: %v", err)
 		}
 	}
 
 	out, err := yaml.Marshal(cfg)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "config marshal failed: %v\n", err)
-		return exitError
+		return fail(exitError, "config marshal failed: %v", err)
+	}
+
+	// The key comes first so a failed ssh-keygen leaves no config behind, and
+	// a failed config write removes the new key so a rerun starts clean.
+	keyPath := expandTilde(identityFile)
+	undoKey := func() {}
+	var pub string
+	if *generateKey {
+		pub, err = generateIdentityKey(keyPath)
+		if err != nil {
+			return fail(exitError, "generate key failed: %v", err)
+		}
+		undoKey = func() { removeIdentityKey(keyPath) }
 	}
 
 	if err := ensureDir(filepath.Dir(*configPath)); err != nil {
-		fmt.Fprintf(os.Stderr, "create config dir failed: %v\n", err)
-		return exitError
+		undoKey()
+		return fail(exitError, "create config dir failed: %v", err)
 	}
 	if err := os.WriteFile(*configPath, out, 0o600); err != nil {
-		fmt.Fprintf(os.Stderr, "write config failed: %v\n", err)
-		return exitError
+		undoKey()
+		return fail(exitError, "write config failed: %v", err)
+	}
+	if *generateKey {
+		infof("generated %s; add this public key to ~/.ssh/authorized_keys for %s on %s:\n", identityFile, *sshUser, *sshHost)
+		fmt.Println(pub)
 	}
-	fmt.Printf("config initialized: %s\n", *configPath)
+	infof("config initialized: %s\n", *configPath)
 	return exitOK
 }
 
-func runAgentUp(args []string) int {
+// keygenCommand builds the ssh-keygen call behind init --generate-key; tests
+// replace it with a stub.
+var keygenCommand = func(path string) *exec.Cmd {
+	return exec.Command("ssh-keygen", "-t", "ed25519", "-f", path, "-N", "")
+}
+
+// generateIdentityKey creates an ed25519 key without a passphrase at path and
+// returns the public key. It never overwrites: ssh-keygen is only run when
+// neither half of the key exists, and a failed run leaves nothing behind.
+func generateIdentityKey(path string) (string, error) {
+	for _, p := range []string{path, path + ".pub"} {
+		if _, err := os.Lstat(p); err == nil {
+			return "", fmt.Errorf("%s already exists", p)
+		}
+	}
+	if err := ensureDir(filepath.Dir(path)); err != nil {
+		return "", fmt.Errorf("create key dir: %w", err)
+	}
+	out, err := keygenCommand(path).CombinedOutput()
+	if err != nil {
+		removeIdentityKey(path)
+		return "", fmt.Errorf("ssh-keygen: %v (%s)", err, strings.TrimSpace(string(out)))
+	}
+	pub, err := os.ReadFile(path + ".pub")
+	if err != nil {
+		removeIdentityKey(path)
+		return "", fmt.Errorf("read public key: %w", err)
+	}
+	return strings.TrimSpace(string(pub)), nil
+}
+
+// removeIdentityKey deletes both halves of a key generateIdentityKey made.
+func removeIdentityKey(path string) {
+	_ = os.Remove(path)
+	_ = os.Remove(path + ".pub")
+}
+
+func readForwardFile(path string) ([]string, error) {
+	f, err := os.Open(expandTilde(path))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var specs []string
+	scanner := bufio.NewScanner(f)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if err := config.ValidateForwardSpec(line); err != nil {
+			return nil, fmt.Errorf("%s line %d: %w", path, lineNo, err)
+		}
+		specs = append(specs, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return specs, nil
+}
+
+func runAgentUp(defaultPath string, args []string) int {
 	fs := flag.NewFlagSet("agent up", flag.ContinueOnError)
-	configPath := fs.String("config", defaultConfigPath(), "path to config file")
+	configPath := fs.String("config", defaultPath, "path to config file")
+	plistStdout := fs.Bool("plist-stdout", false, "print the launchd plist instead of installing it")
+	readyTimeout := fs.Duration("ready-timeout", 3*time.Second, "how long to wait for the agent to answer status after loading")
+	attach := fs.Bool("attach", false, "stream the agent log after it is ready; Ctrl+C detaches and leaves it running")
 	if err := fs.Parse(args); err != nil {
-		return exitUsage
+		return flagFail(err)
+	}
+	if *readyTimeout <= 0 {
+		return fail(exitUsage, "--ready-timeout must be a positive duration")
+	}
+	if *attach && *plistStdout {
+		return fail(exitUsage, "--attach cannot be combined with --plist-stdout")
 	}
 
 	cfg, err := config.Load(*configPath)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "config load failed: %v\n", err)
-		return exitError
+		return fail(exitError, "config load failed: %v", err)
 	}
 	if err := config.ValidateAgent(cfg); err != nil {
-		fmt.Fprintf(os.Stderr, "config validation failed: %v\n", err)
-		return exitError
+		return fail(exitError, "config validation failed: %v", err)
 	}
 
 	exe, err := os.Executable()
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "resolve executable: %v\n", err)
-		return exitError
+		return fail(exitError, "resolve executable: %v", err)
 	}
 
 	spec := launchd.Spec{
 		Label:       cfg.Agent.LaunchdLabel,
-		ProgramArgs: []string{exe, "agent", "run", "--config", *configPath},
+		ProgramArgs: append(homeArgs(exe), "agent", "run", "--config", *configPath),
 		RunAtLoad:   true,
 		KeepAlive:   true,
 		StdoutPath:  "",
 		StderrPath:  "",
 	}
 	if logPath, err := config.LogPath(cfg); err != nil {
-		fmt.Fprintf(os.Stderr, "resolve agent log path failed: %v\n", err)
-		return exitError
+		return fail(exitError, "resolve agent log path failed: %v", err)
 	} else {
-		if err := ensureDir(filepath.Dir(logPath)); err != nil {
-			fmt.Fprintf(os.Stderr, "create agent log dir failed: %v\n", err)
-			return exitError
+		if !*plistStdout {
+			if err := ensureDir(filepath.Dir(logPath)); err != nil {
+				return fail(exitError, "create agent log dir failed: %v", err)
+			}
 		}
 		spec.StdoutPath = logPath
 		spec.StderrPath = logPath
@@ -325,119 +591,205 @@ func runAgentUp(args []string) int {
 	if cfg.Agent.PreventSleep {
 		argv, err := wrapWithCaffeinate(spec.ProgramArgs)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "caffeinate not available: %v\n", err)
-			return exitError
+			return fail(exitError, "caffeinate not available: %v", err)
 		}
 		spec.ProgramArgs = argv
 	}
+	if *plistStdout {
+		out, err := launchd.Render(spec)
+		if err != nil {
+			return fail(exitError, "render plist failed: %v", err)
+		}
+		fmt.Print(string(out))
+		return exitOK
+	}
 	plistPath, err := launchd.Install(spec)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "launchd install failed: %v\n", err)
-		return exitError
+		return fail(exitError, "launchd install failed: %v", err)
 	}
 	if err := launchd.Bootstrap(plistPath); err != nil {
-		fmt.Fprintf(os.Stderr, "launchd bootstrap failed: %v\n", err)
-		return exitError
+		return fail(exitError, "launchd bootstrap failed: %v", err)
+	}
+	infof("agent up: launchd loaded (%s)\n", plistPath)
+	if err := waitForServiceReady(cfg, "agent", *readyTimeout); err != nil {
+		code := fail(exitError, "agent up: not ready after %s: %v", *readyTimeout, err)
+		if !jsonErrors {
+			printLaunchdSummary(cfg.Agent.LaunchdLabel)
+			_ = printLogFileFallback(cfg, "agent", logFilter{})
+		}
+		return code
 	}
-	fmt.Printf("agent up: launchd loaded (%s)\n", plistPath)
-	if err := waitForServiceReady(cfg, "agent", 3*time.Second); err != nil {
-		fmt.Fprintf(os.Stderr, "agent up: not ready after 3s: %v\n", err)
-		printLaunchdSummary(cfg.Agent.LaunchdLabel)
-		_ = printLogFileFallback(cfg, "agent")
-		return exitError
+	infoln("agent up: ready")
+	if *attach {
+		return attachLogs(cfg, "agent")
 	}
-	fmt.Println("agent up: ready")
 	return exitOK
 }
 
-func runAgentDown(args []string) int {
+func runAgentDown(defaultPath string, args []string) int {
 	fs := flag.NewFlagSet("agent down", flag.ContinueOnError)
-	configPath := fs.String("config", defaultConfigPath(), "path to config file")
+	configPath := fs.String("config", defaultPath, "path to config file")
 	if err := fs.Parse(args); err != nil {
-		return exitUsage
+		return flagFail(err)
 	}
 
 	cfg, err := config.Load(*configPath)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "config load failed: %v\n", err)
-		return exitError
+		return fail(exitError, "config load failed: %v", err)
 	}
 
 	plistPath, err := launchd.PlistPath(cfg.Agent.LaunchdLabel)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "resolve plist path failed: %v\n", err)
-		return exitError
+		return fail(exitError, "resolve plist path failed: %v", err)
 	}
 	if err := launchd.Bootout(plistPath); err != nil {
-		fmt.Fprintf(os.Stderr, "launchd bootout failed: %v\n", err)
-		return exitError
+		return fail(exitError, "launchd bootout failed: %v", err)
 	}
 	if _, err := launchd.Uninstall(cfg.Agent.LaunchdLabel); err != nil {
-		fmt.Fprintf(os.Stderr, "launchd uninstall failed: %v\n", err)
-		return exitError
+		return fail(exitError, "launchd uninstall failed: %v", err)
 	}
-	fmt.Printf("agent down: launchd unloaded (%s)\n", plistPath)
+	infof("agent down: launchd unloaded (%s)\n", plistPath)
 	return exitOK
 }
 
-func runAgentAdd(args []string) int {
+func runAgentAdd(defaultPath string, args []string) int {
 	fs := flag.NewFlagSet("agent add", flag.ContinueOnError)
-	configPath := fs.String("config", defaultConfigPath(), "path to config file")
+	configPath := fs.String("config", defaultPath, "path to config file")
 	remoteForward := fs.String("remote-forward", "", "ssh remote forward spec (required)")
+	ephemeral := fs.Bool("ephemeral", false, "apply to the running agent only; do not write config")
+	wait := fs.Bool("wait", false, "wait until the agent reconnects with the new forward")
+	waitTimeout := fs.Duration("wait-timeout", 30*time.Second, "how long --wait blocks")
+	replace := fs.String("replace", "", "existing remote forward spec to swap for the new one (one restart)")
 	if err := fs.Parse(args); err != nil {
-		return exitUsage
+		return flagFail(err)
+	}
+	if strings.TrimSpace(*replace) != "" && strings.TrimSpace(*remoteForward) == "" && fs.NArg() > 0 {
+		*remoteForward = fs.Arg(0)
+		// Flags may follow the new spec, e.g. --replace old new --ephemeral.
+		if err := fs.Parse(fs.Args()[1:]); err != nil {
+			return flagFail(err)
+		}
+	}
+	if fs.NArg() > 0 {
+		return fail(exitUsage, "unexpected argument %q", fs.Arg(0))
 	}
 	if strings.TrimSpace(*remoteForward) == "" {
-		fmt.Fprintln(os.Stderr, "remote-forward is required")
-		return exitUsage
+		return fail(exitUsage, "remote-forward is required")
 	}
+	addedAt := time.Now().Unix()
 
 	cfg, err := config.Load(*configPath)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "config load failed: %v\n", err)
-		return exitError
+		return fail(exitError, "config load failed: %v", err)
+	}
+	if strings.TrimSpace(*replace) != "" {
+		return runAgentReplace(defaultPath, cfg, *configPath, *replace, *remoteForward, *ephemeral, *wait, *waitTimeout)
+	}
+	if *ephemeral {
+		return runEphemeralUpdate("agent", func() (bool, string, error) {
+			resp, err := ipcclient.AddRemoteForward(cfg, *remoteForward)
+			if err != nil {
+				return false, "", err
+			}
+			return resp.OK, resp.Message, nil
+		})
 	}
 
 	forwards := config.NormalizeRemoteForwards(cfg)
 	forwards = append(forwards, *remoteForward)
 	config.SetRemoteForwards(cfg, forwards)
 	if err := config.Save(*configPath, cfg); err != nil {
-		fmt.Fprintf(os.Stderr, "config save failed: %v\n", err)
-		return exitError
+		return fail(exitError, "config save failed: %v", err)
 	}
 
 	if resp, ok, notRunning := tryRuntimeUpdate(func() (*ipcclient.Response, error) {
 		return ipcclient.AddRemoteForward(cfg, *remoteForward)
 	}); ok {
 		if resp.Message != "" {
-			fmt.Println(resp.Message)
+			infoln(resp.Message)
+		}
+		if resp.Data["added"] == "false" || resp.Data["applied"] == "live" {
+			return exitOK
+		}
+	} else if notRunning {
+		if runAgentUp(defaultPath, []string{"--config", *configPath}) != exitOK {
+			return exitError
+		}
+	} else {
+		return exitError
+	}
+	if *wait {
+		return waitForReconnect(cfg, "agent", addedAt, *waitTimeout)
+	}
+	return exitOK
+}
+
+// runAgentReplace swaps old for next in the config and sends one
+// replace_forward update so the running agent restarts at most once.
+func runAgentReplace(defaultPath string, cfg *config.Config, configPath, old, next string, ephemeral, wait bool, waitTimeout time.Duration) int {
+	old = strings.TrimSpace(old)
+	next = strings.TrimSpace(next)
+	replacedAt := time.Now().Unix()
+	if ephemeral {
+		return runEphemeralUpdate("agent", func() (bool, string, error) {
+			resp, err := ipcclient.ReplaceRemoteForward(cfg, old, next)
+			if err != nil {
+				return false, "", err
+			}
+			return resp.OK, resp.Message, nil
+		})
+	}
+
+	if old != next {
+		for _, existing := range config.NormalizeRemoteForwards(cfg) {
+			if existing == next {
+				return fail(exitError, "remote forward %q already present", next)
+			}
+		}
+		if !config.ReplaceRemoteForward(cfg, old, next) {
+			return fail(exitError, "remote forward %q not found in config", old)
+		}
+		if err := config.Save(configPath, cfg); err != nil {
+			return fail(exitError, "config save failed: %v", err)
+		}
+	}
+
+	if resp, ok, notRunning := tryRuntimeUpdate(func() (*ipcclient.Response, error) {
+		return ipcclient.ReplaceRemoteForward(cfg, old, next)
+	}); ok {
+		if resp.Message != "" {
+			infoln(resp.Message)
+		}
+		if resp.Data["replaced"] == "false" || resp.Data["applied"] == "live" {
+			return exitOK
 		}
 	} else if notRunning {
-		if runAgentUp([]string{"--config", *configPath}) != exitOK {
+		if runAgentUp(defaultPath, []string{"--config", configPath}) != exitOK {
 			return exitError
 		}
 	} else {
 		return exitError
 	}
+	if wait {
+		return waitForReconnect(cfg, "agent", replacedAt, waitTimeout)
+	}
 	return exitOK
 }
 
-func runAgentRemove(args []string) int {
+func runAgentRemove(defaultPath string, args []string) int {
 	fs := flag.NewFlagSet("agent remove", flag.ContinueOnError)
-	configPath := fs.String("config", defaultConfigPath(), "path to config file")
+	configPath := fs.String("config", defaultPath, "path to config file")
 	remoteForward := fs.String("remote-forward", "", "ssh remote forward spec (required)")
 	if err := fs.Parse(args); err != nil {
-		return exitUsage
+		return flagFail(err)
 	}
 	if strings.TrimSpace(*remoteForward) == "" {
-		fmt.Fprintln(os.Stderr, "remote-forward is required")
-		return exitUsage
+		return fail(exitUsage, "remote-forward is required")
 	}
 
 	cfg, err := config.Load(*configPath)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "config load failed: %v\n", err)
-		return exitError
+		return fail(exitError, "config load failed: %v", err)
 	}
 
 	forwards := config.NormalizeRemoteForwards(cfg)
@@ -449,20 +801,18 @@ func runAgentRemove(args []string) int {
 		next = append(next, value)
 	}
 	if len(next) == 0 {
-		fmt.Fprintln(os.Stderr, "at least one remote forward is required")
-		return exitError
+		return fail(exitError, "at least one remote forward is required")
 	}
 	config.SetRemoteForwards(cfg, next)
 	if err := config.Save(*configPath, cfg); err != nil {
-		fmt.Fprintf(os.Stderr, "config save failed: %v\n", err)
-		return exitError
+		return fail(exitError, "config save failed: %v", err)
 	}
 
 	if resp, ok, notRunning := tryRuntimeUpdate(func() (*ipcclient.Response, error) {
 		return ipcclient.RemoveRemoteForward(cfg, *remoteForward)
 	}); ok {
 		if resp.Message != "" {
-			fmt.Println(resp.Message)
+			infoln(resp.Message)
 		}
 	} else if !notRunning {
 		return exitError
@@ -470,27 +820,25 @@ func runAgentRemove(args []string) int {
 	return exitOK
 }
 
-func runAgentClear(args []string) int {
+func runAgentClear(defaultPath string, args []string) int {
 	fs := flag.NewFlagSet("agent clear", flag.ContinueOnError)
-	configPath := fs.String("config", defaultConfigPath(), "path to config file")
+	configPath := fs.String("config", defaultPath, "path to config file")
 	if err := fs.Parse(args); err != nil {
-		return exitUsage
+		return flagFail(err)
 	}
 
 	cfg, err := config.Load(*configPath)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "config load failed: %v\n", err)
-		return exitError
+		return fail(exitError, "config load failed: %v", err)
 	}
 
 	forwards := config.NormalizeRemoteForwards(cfg)
 	if len(forwards) == 0 {
-		fmt.Println("no remote forwards to clear")
+		infoln("no remote forwards to clear")
 	} else {
 		config.SetRemoteForwards(cfg, nil)
 		if err := config.Save(*configPath, cfg); err != nil {
-			fmt.Fprintf(os.Stderr, "config save failed: %v\n", err)
-			return exitError
+			return fail(exitError, "config save failed: %v", err)
 		}
 	}
 
@@ -499,31 +847,52 @@ func runAgentClear(args []string) int {
 		return ipcclient.ClearRemoteForwards(cfg)
 	}); ok {
 		if resp.Message != "" {
-			fmt.Println(resp.Message)
+			infoln(resp.Message)
 		}
 	} else if !notRunning {
 		runtimeFailed = true
 	}
-	downLaunchdIfPresent(cfg)
-	fmt.Println("to start again, run `rpa agent add --remote-forward ...` or `rpa init ...`")
+	code := downLaunchdIfPresent(cfg)
+	infoln("to start again, run `rpa agent add --remote-forward ...` or `rpa init ...`")
 	if runtimeFailed {
 		return exitError
 	}
-	return exitOK
+	return code
 }
 
-func runClientUp(args []string) int {
+func runClientUp(defaultPath string, args []string) int {
 	fs := flag.NewFlagSet("client up", flag.ContinueOnError)
-	configPath := fs.String("config", defaultConfigPath(), "path to config file")
+	configPath := fs.String("config", defaultPath, "path to config file")
+	plistStdout := fs.Bool("plist-stdout", false, "print the launchd plist instead of installing it")
 	localForward := fs.String("local-forward", "", "ssh local forward spec (optional)")
+	ephemeral := fs.Bool("ephemeral", false, "apply --local-forward to the running client only; do not write config")
+	readyTimeout := fs.Duration("ready-timeout", 3*time.Second, "how long to wait for the client to answer status after loading")
+	attach := fs.Bool("attach", false, "stream the client log after it is ready; Ctrl+C detaches and leaves it running")
 	if err := fs.Parse(args); err != nil {
-		return exitUsage
+		return flagFail(err)
+	}
+	if *ephemeral && strings.TrimSpace(*localForward) == "" {
+		return fail(exitUsage, "--ephemeral requires --local-forward")
+	}
+	if *readyTimeout <= 0 {
+		return fail(exitUsage, "--ready-timeout must be a positive duration")
+	}
+	if *attach && (*plistStdout || *ephemeral) {
+		return fail(exitUsage, "--attach cannot be combined with --plist-stdout or --ephemeral")
 	}
 
 	cfg, err := config.Load(*configPath)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "config load failed: %v\n", err)
-		return exitError
+		return fail(exitError, "config load failed: %v", err)
+	}
+	if *ephemeral {
+		return runEphemeralUpdate("client", func() (bool, string, error) {
+			resp, err := ipcclientlocal.AddLocalForward(cfg, *localForward)
+			if err != nil {
+				return false, "", err
+			}
+			return resp.OK, resp.Message, nil
+		})
 	}
 
 	if strings.TrimSpace(*localForward) != "" {
@@ -531,37 +900,34 @@ func runClientUp(args []string) int {
 		forwards = append(forwards, *localForward)
 		config.SetLocalForwards(cfg, forwards)
 		if err := config.Save(*configPath, cfg); err != nil {
-			fmt.Fprintf(os.Stderr, "config save failed: %v\n", err)
-			return exitError
+			return fail(exitError, "config save failed: %v", err)
 		}
 	}
 
 	if err := config.ValidateClient(cfg); err != nil {
-		fmt.Fprintf(os.Stderr, "config validation failed: %v\n", err)
-		return exitError
+		return fail(exitError, "config validation failed: %v", err)
 	}
 
 	exe, err := os.Executable()
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "resolve executable: %v\n", err)
-		return exitError
+		return fail(exitError, "resolve executable: %v", err)
 	}
 
 	spec := launchd.Spec{
 		Label:       cfg.Client.LaunchdLabel,
-		ProgramArgs: []string{exe, "client", "run", "--config", *configPath},
+		ProgramArgs: append(homeArgs(exe), "client", "run", "--config", *configPath),
 		RunAtLoad:   true,
 		KeepAlive:   true,
 		StdoutPath:  "",
 		StderrPath:  "",
 	}
 	if logPath, err := config.ClientLogPath(cfg); err != nil {
-		fmt.Fprintf(os.Stderr, "resolve client log path failed: %v\n", err)
-		return exitError
+		return fail(exitError, "resolve client log path failed: %v", err)
 	} else {
-		if err := ensureDir(filepath.Dir(logPath)); err != nil {
-			fmt.Fprintf(os.Stderr, "create client log dir failed: %v\n", err)
-			return exitError
+		if !*plistStdout {
+			if err := ensureDir(filepath.Dir(logPath)); err != nil {
+				return fail(exitError, "create client log dir failed: %v", err)
+			}
 		}
 		spec.StdoutPath = logPath
 		spec.StderrPath = logPath
@@ -569,164 +935,386 @@ func runClientUp(args []string) int {
 	if cfg.Client.PreventSleep {
 		argv, err := wrapWithCaffeinate(spec.ProgramArgs)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "caffeinate not available: %v\n", err)
-			return exitError
+			return fail(exitError, "caffeinate not available: %v", err)
 		}
 		spec.ProgramArgs = argv
 	}
+	if *plistStdout {
+		out, err := launchd.Render(spec)
+		if err != nil {
+			return fail(exitError, "render plist failed: %v", err)
+		}
+		fmt.Print(string(out))
+		return exitOK
+	}
 	plistPath, err := launchd.Install(spec)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "launchd install failed: %v\n", err)
-		return exitError
+		return fail(exitError, "launchd install failed: %v", err)
 	}
 	if err := launchd.Bootstrap(plistPath); err != nil {
-		fmt.Fprintf(os.Stderr, "launchd bootstrap failed: %v\n", err)
-		return exitError
+		return fail(exitError, "launchd bootstrap failed: %v", err)
+	}
+	infof("client up: launchd loaded (%s)\n", plistPath)
+	if err := waitForServiceReady(cfg, "client", *readyTimeout); err != nil {
+		code := fail(exitError, "client up: not ready after %s: %v", *readyTimeout, err)
+		if !jsonErrors {
+			printLaunchdSummary(cfg.Client.LaunchdLabel)
+			_ = printLogFileFallback(cfg, "client", logFilter{})
+		}
+		return code
 	}
-	fmt.Printf("client up: launchd loaded (%s)\n", plistPath)
-	if err := waitForServiceReady(cfg, "client", 3*time.Second); err != nil {
-		fmt.Fprintf(os.Stderr, "client up: not ready after 3s: %v\n", err)
-		printLaunchdSummary(cfg.Client.LaunchdLabel)
-		_ = printLogFileFallback(cfg, "client")
-		return exitError
+	infoln("client up: ready")
+	if *attach {
+		return attachLogs(cfg, "client")
 	}
-	fmt.Println("client up: ready")
 	return exitOK
 }
 
-func runClientDown(args []string) int {
+func runClientDown(defaultPath string, args []string) int {
 	fs := flag.NewFlagSet("client down", flag.ContinueOnError)
-	configPath := fs.String("config", defaultConfigPath(), "path to config file")
+	configPath := fs.String("config", defaultPath, "path to config file")
 	if err := fs.Parse(args); err != nil {
-		return exitUsage
+		return flagFail(err)
 	}
 
 	cfg, err := config.Load(*configPath)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "config load failed: %v\n", err)
-		return exitError
+		return fail(exitError, "config load failed: %v", err)
 	}
 
 	plistPath, err := launchd.PlistPath(cfg.Client.LaunchdLabel)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "resolve plist path failed: %v\n", err)
-		return exitError
+		return fail(exitError, "resolve plist path failed: %v", err)
 	}
 	if err := launchd.Bootout(plistPath); err != nil {
-		fmt.Fprintf(os.Stderr, "launchd bootout failed: %v\n", err)
-		return exitError
+		return fail(exitError, "launchd bootout failed: %v", err)
 	}
 	if _, err := launchd.Uninstall(cfg.Client.LaunchdLabel); err != nil {
-		fmt.Fprintf(os.Stderr, "launchd uninstall failed: %v\n", err)
-		return exitError
+		return fail(exitError, "launchd uninstall failed: %v", err)
 	}
-	fmt.Printf("client down: launchd unloaded (%s)\n", plistPath)
+	infof("client down: launchd unloaded (%s)\n", plistPath)
 	return exitOK
 }
 
-func runClientRun(args []string) int {
-	fs := flag.NewFlagSet("client run", flag.ContinueOnError)
-	configPath := fs.String("config", defaultConfigPath(), "path to config file")
-	localForward := fs.String("local-forward", "", "ssh local forward spec (optional)")
+// runUninstall boots out and removes the agent and client launchd jobs. It
+// can be repeated: a job that is not installed is only reported. --purge
+// also deletes the rpa home, guarded by purgeHome.
+func runUninstall(defaultPath string, args []string) int {
+	fs := flag.NewFlagSet("uninstall", flag.ContinueOnError)
+	configPath := fs.String("config", defaultPath, "path to config file (for launchd labels)")
+	purge := fs.Bool("purge", false, "also delete the rpa home (sockets, state, logs, default config)")
 	if err := fs.Parse(args); err != nil {
-		return exitUsage
+		return flagFail(err)
 	}
 
 	cfg, err := config.Load(*configPath)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "config load failed: %v\n", err)
+		if !errors.Is(err, os.ErrNotExist) {
+			return fail(exitError, "config load failed: %v", err)
+		}
+		cfg = &config.Config{}
+		config.ApplyDefaults(cfg)
+	}
+
+	agentRemoved, agentOK := uninstallLaunchd("agent", cfg.Agent.LaunchdLabel)
+	clientRemoved, clientOK := uninstallLaunchd("client", cfg.Client.LaunchdLabel)
+	if !agentOK || !clientOK {
 		return exitError
 	}
-	if strings.TrimSpace(*localForward) != "" {
-		config.SetLocalForwards(cfg, []string{*localForward})
+	if *purge {
+		// Only a job that was just booted out is worth waiting for.
+		var wait time.Duration
+		if agentRemoved || clientRemoved {
+			wait = uninstallStopWait
+		}
+		return purgeHome(cfg, wait)
 	}
-
-	return runForegroundClient(cfg, "client run")
+	return exitOK
 }
 
-func runClientAdd(args []string) int {
-	fs := flag.NewFlagSet("client add", flag.ContinueOnError)
-	configPath := fs.String("config", defaultConfigPath(), "path to config file")
-	localForward := fs.String("local-forward", "", "ssh local forward spec (required)")
-	if err := fs.Parse(args); err != nil {
-		return exitUsage
+// uninstallLaunchd boots out label and removes its plist, reporting whether a
+// job was removed and whether that went fine. A missing plist is not an
+// error; a failed bootout is reported but the plist is still removed so a
+// stale job cannot come back at login.
+func uninstallLaunchd(kind, label string) (removed, ok bool) {
+	plistPath, err := launchd.PlistPath(label)
+	if err != nil {
+		fail(exitError, "%s: resolve plist path failed: %v", kind, err)
+		return false, false
 	}
-	if strings.TrimSpace(*localForward) == "" {
-		fmt.Fprintln(os.Stderr, "local-forward is required")
-		return exitUsage
+	if _, err := os.Stat(plistPath); err != nil {
+		if !os.IsNotExist(err) {
+			fail(exitError, "%s: stat plist failed: %v", kind, err)
+			return false, false
+		}
+		infof("%s: not installed\n", kind)
+		return false, true
+	}
+	if err := launchd.Bootout(plistPath); err != nil {
+		fmt.Fprintf(os.Stderr, "%s: launchd bootout failed (removing plist anyway): %v\n", kind, err)
+	}
+	if _, err := launchd.Uninstall(label); err != nil {
+		fail(exitError, "%s: launchd uninstall failed: %v", kind, err)
+		return false, false
 	}
+	infof("%s: launchd job removed (%s)\n", kind, plistPath)
+	return true, true
+}
 
-	cfg, err := config.Load(*configPath)
+// uninstallStopWait is how long purge waits for booted-out services to
+// release their sockets.
+const uninstallStopWait = 5 * time.Second
+
+// purgeHome deletes the rpa home. It refuses a filesystem root, the user's
+// home or any of its parents, and a home where the agent or client (for
+// example an `agent run` in a terminal) still answers after wait. A home that
+// does not look like rpa's own (see purgePlan) only loses the files rpa wrote.
+func purgeHome(cfg *config.Config, wait time.Duration) int {
+	home, err := config.HomeDir()
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "config load failed: %v\n", err)
-		return exitError
+		return fail(exitError, "resolve rpa home failed: %v", err)
 	}
-
-	forwards := config.NormalizeLocalForwards(cfg)
-	forwards = append(forwards, *localForward)
-	config.SetLocalForwards(cfg, forwards)
-	if err := config.Save(*configPath, cfg); err != nil {
-		fmt.Fprintf(os.Stderr, "config save failed: %v\n", err)
-		return exitError
+	home = filepath.Clean(home)
+	if !filepath.IsAbs(home) || filepath.Dir(home) == home {
+		return fail(exitError, "refusing to purge %s: not an rpa home", home)
 	}
-
-	if resp, ok, notRunning := tryClientRuntimeUpdate(func() (*ipcclientlocal.Response, error) {
-		return ipcclientlocal.AddLocalForward(cfg, *localForward)
-	}); ok {
-		if resp.Message != "" {
-			fmt.Println(resp.Message)
-		}
-	} else if notRunning {
-		if runClientUp([]string{"--config", *configPath}) != exitOK {
-			return exitError
+	if user, err := config.UserHomeDir(); err == nil {
+		if rel, err := filepath.Rel(home, filepath.Clean(user)); err == nil && !strings.HasPrefix(rel, "..") {
+			return fail(exitError, "refusing to purge %s: it contains the user home", home)
 		}
-	} else {
-		return exitError
 	}
-	return exitOK
-}
-
-func runClientRemove(args []string) int {
-	fs := flag.NewFlagSet("client remove", flag.ContinueOnError)
-	configPath := fs.String("config", defaultConfigPath(), "path to config file")
-	localForward := fs.String("local-forward", "", "ssh local forward spec (required)")
-	if err := fs.Parse(args); err != nil {
-		return exitUsage
+	if _, err := os.Lstat(home); os.IsNotExist(err) {
+		infof("purge: %s already gone\n", home)
+		return exitOK
 	}
-	if strings.TrimSpace(*localForward) == "" {
-		fmt.Fprintln(os.Stderr, "local-forward is required")
-		return exitUsage
+	deadline := time.Now().Add(wait)
+	for _, kind := range []string{"agent", "client"} {
+		for {
+			socket, answering := serviceAnswering(cfg, kind)
+			if !answering {
+				break
+			}
+			if time.Now().After(deadline) {
+				return fail(exitError, "refusing to purge %s: %s still answers on %s; stop it first", home, kind, socket)
+			}
+			time.Sleep(200 * time.Millisecond)
+		}
 	}
-
-	cfg, err := config.Load(*configPath)
+	whole, known, foreign, err := purgePlan(home)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "config load failed: %v\n", err)
-		return exitError
+		return fail(exitError, "purge %s failed: %v", home, err)
+	}
+	if whole {
+		if err := os.RemoveAll(home); err != nil {
+			return fail(exitError, "purge %s failed: %v", home, err)
+		}
+		infof("purge: removed %s\n", home)
+		return exitOK
+	}
+	for _, path := range known {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return fail(exitError, "purge %s failed: %v", path, err)
+		}
+	}
+	infof("purge: removed %d rpa files from %s; kept %d other entries\n", len(known), home, foreign)
+	return exitOK
+}
+
+// purgePlan decides how much of home purge may delete. The whole directory
+// goes only when it is named .rpa or holds nothing but files rpa writes;
+// otherwise known lists just those files (directories after their contents)
+// and foreign counts the entries left alone.
+func purgePlan(home string) (whole bool, known []string, foreign int, err error) {
+	entries, err := os.ReadDir(home)
+	if err != nil {
+		return false, nil, 0, err
+	}
+	for _, entry := range entries {
+		path := filepath.Join(home, entry.Name())
+		switch {
+		case entry.IsDir() && entry.Name() == "logs":
+			logs, err := os.ReadDir(path)
+			if err != nil {
+				return false, nil, 0, err
+			}
+			others := 0
+			for _, log := range logs {
+				if !log.IsDir() && rpaLogFile(log.Name()) {
+					known = append(known, filepath.Join(path, log.Name()))
+				} else {
+					others++
+				}
+			}
+			if others == 0 {
+				known = append(known, path)
+			}
+			foreign += others
+		case !entry.IsDir() && rpaHomeFile(entry.Name()):
+			known = append(known, path)
+		default:
+			foreign++
+		}
+	}
+	return filepath.Base(home) == ".rpa" || foreign == 0, known, foreign, nil
+}
+
+// rpaHomeFile reports whether name is a file rpa itself keeps in its home:
+// the default config, IPC sockets, pid files, ssh control sockets, and state.
+func rpaHomeFile(name string) bool {
+	if name == "rpa.yaml" {
+		return true
+	}
+	for _, suffix := range []string{".sock", ".pid", ".ssh.ctl", ".state.json"} {
+		if strings.HasSuffix(name, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// rpaLogFile matches a default log and its rotated copies (agent.log.1).
+func rpaLogFile(name string) bool {
+	return strings.HasSuffix(name, ".log") || strings.Contains(name, ".log.")
+}
+
+func runClientRun(defaultPath string, args []string) int {
+	fs := flag.NewFlagSet("client run", flag.ContinueOnError)
+	configPath := fs.String("config", defaultPath, "path to config file")
+	localForward := fs.String("local-forward", "", "ssh local forward spec (optional)")
+	restartPolicy := fs.String("restart-policy", "", "override client.restart_policy for this run (always|on-failure|never)")
+	force := fs.Bool("force", false, "start even if another client already answers on the IPC socket")
+	var sshArgs []string
+	fs.Func("ssh-arg", "extra raw ssh argument, placed before the destination (repeatable)", func(value string) error {
+		sshArgs = append(sshArgs, value)
+		return nil
+	})
+	if err := fs.Parse(args); err != nil {
+		return flagFail(err)
+	}
+
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		return fail(exitError, "config load failed: %v", err)
+	}
+	if *restartPolicy != "" {
+		cfg.Client.RestartPolicy = *restartPolicy
+	}
+	if strings.TrimSpace(*localForward) != "" {
+		config.SetLocalForwards(cfg, []string{*localForward})
+	}
+
+	return runForegroundClient(cfg, "client run", *force, sshArgs)
+}
+
+func runClientAdd(defaultPath string, args []string) int {
+	fs := flag.NewFlagSet("client add", flag.ContinueOnError)
+	configPath := fs.String("config", defaultPath, "path to config file")
+	localForward := fs.String("local-forward", "", "ssh local forward spec")
+	dynamicForward := fs.String("dynamic-forward", "", "ssh dynamic (SOCKS) forward, [bind:]port")
+	wait := fs.Bool("wait", false, "wait until the client reconnects with the new forward")
+	waitTimeout := fs.Duration("wait-timeout", 30*time.Second, "how long --wait blocks")
+	if err := fs.Parse(args); err != nil {
+		return flagFail(err)
+	}
+	dynamic, code := clientForwardFlag(*localForward, *dynamicForward)
+	if code != exitOK {
+		return code
+	}
+	addedAt := time.Now().Unix()
+
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		return fail(exitError, "config load failed: %v", err)
+	}
+
+	if dynamic != "" {
+		config.SetDynamicForwards(cfg, append(config.NormalizeDynamicForwards(cfg), dynamic))
+	} else {
+		forwards := config.NormalizeLocalForwards(cfg)
+		forwards = append(forwards, *localForward)
+		config.SetLocalForwards(cfg, forwards)
+	}
+	if err := config.Save(*configPath, cfg); err != nil {
+		return fail(exitError, "config save failed: %v", err)
+	}
+
+	if resp, ok, notRunning := tryClientRuntimeUpdate(func() (*ipcclientlocal.Response, error) {
+		if dynamic != "" {
+			return ipcclientlocal.AddDynamicForward(cfg, dynamic)
+		}
+		return ipcclientlocal.AddLocalForward(cfg, *localForward)
+	}); ok {
+		if resp.Message != "" {
+			infoln(resp.Message)
+		}
+		if resp.Data["added"] == "false" || resp.Data["applied"] == "live" {
+			return exitOK
+		}
+	} else if notRunning {
+		if runClientUp(defaultPath, []string{"--config", *configPath}) != exitOK {
+			return exitError
+		}
+	} else {
+		return exitError
+	}
+	if *wait {
+		return waitForReconnect(cfg, "client", addedAt, *waitTimeout)
+	}
+	return exitOK
+}
+
+func runClientRemove(defaultPath string, args []string) int {
+	fs := flag.NewFlagSet("client remove", flag.ContinueOnError)
+	configPath := fs.String("config", defaultPath, "path to config file")
+	localForward := fs.String("local-forward", "", "ssh local forward spec")
+	dynamicForward := fs.String("dynamic-forward", "", "ssh dynamic (SOCKS) forward, [bind:]port")
+	if err := fs.Parse(args); err != nil {
+		return flagFail(err)
+	}
+	dynamic, code := clientForwardFlag(*localForward, *dynamicForward)
+	if code != exitOK {
+		return code
+	}
+
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		return fail(exitError, "config load failed: %v", err)
 	}
 
+	target := strings.TrimSpace(*localForward)
 	forwards := config.NormalizeLocalForwards(cfg)
+	others := config.NormalizeDynamicForwards(cfg)
+	if dynamic != "" {
+		target = dynamic
+		forwards, others = others, forwards
+	}
 	next := make([]string, 0, len(forwards))
 	for _, value := range forwards {
-		if strings.TrimSpace(value) == strings.TrimSpace(*localForward) {
+		if strings.TrimSpace(value) == target {
 			continue
 		}
 		next = append(next, value)
 	}
-	if len(next) == 0 {
-		fmt.Fprintln(os.Stderr, "at least one local forward is required")
-		return exitError
+	if len(next) == 0 && len(others) == 0 {
+		return fail(exitError, "at least one local or dynamic forward is required")
+	}
+	if dynamic != "" {
+		config.SetDynamicForwards(cfg, next)
+	} else {
+		config.SetLocalForwards(cfg, next)
 	}
-	config.SetLocalForwards(cfg, next)
 	if err := config.Save(*configPath, cfg); err != nil {
-		fmt.Fprintf(os.Stderr, "config save failed: %v\n", err)
-		return exitError
+		return fail(exitError, "config save failed: %v", err)
 	}
 
 	if resp, ok, notRunning := tryClientRuntimeUpdate(func() (*ipcclientlocal.Response, error) {
+		if dynamic != "" {
+			return ipcclientlocal.RemoveDynamicForward(cfg, dynamic)
+		}
 		return ipcclientlocal.RemoveLocalForward(cfg, *localForward)
 	}); ok {
 		if resp.Message != "" {
-			fmt.Println(resp.Message)
+			infoln(resp.Message)
 		}
 	} else if !notRunning {
 		return exitError
@@ -734,27 +1322,43 @@ func runClientRemove(args []string) int {
 	return exitOK
 }
 
-func runClientClear(args []string) int {
+// clientForwardFlag checks that exactly one of --local-forward and
+// --dynamic-forward is set and returns the trimmed dynamic spec, if any.
+func clientForwardFlag(local, dynamic string) (string, int) {
+	local = strings.TrimSpace(local)
+	dynamic = strings.TrimSpace(dynamic)
+	switch {
+	case local == "" && dynamic == "":
+		return "", fail(exitUsage, "local-forward or dynamic-forward is required")
+	case local != "" && dynamic != "":
+		return "", fail(exitUsage, "--local-forward and --dynamic-forward are mutually exclusive")
+	case dynamic != "":
+		if err := config.ValidateDynamicForwardSpec(dynamic); err != nil {
+			return "", fail(exitUsage, "dynamic-forward: %v", err)
+		}
+	}
+	return dynamic, exitOK
+}
+
+func runClientClear(defaultPath string, args []string) int {
 	fs := flag.NewFlagSet("client clear", flag.ContinueOnError)
-	configPath := fs.String("config", defaultConfigPath(), "path to config file")
+	configPath := fs.String("config", defaultPath, "path to config file")
 	if err := fs.Parse(args); err != nil {
-		return exitUsage
+		return flagFail(err)
 	}
 
 	cfg, err := config.Load(*configPath)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "config load failed: %v\n", err)
-		return exitError
+		return fail(exitError, "config load failed: %v", err)
 	}
 
 	forwards := config.NormalizeLocalForwards(cfg)
 	if len(forwards) == 0 {
-		fmt.Println("no local forwards to clear")
+		infoln("no local forwards to clear")
 	} else {
 		config.SetLocalForwards(cfg, nil)
 		if err := config.Save(*configPath, cfg); err != nil {
-			fmt.Fprintf(os.Stderr, "config save failed: %v\n", err)
-			return exitError
+			return fail(exitError, "config save failed: %v", err)
 		}
 	}
 
@@ -763,131 +1367,300 @@ func runClientClear(args []string) int {
 		return ipcclientlocal.ClearLocalForwards(cfg)
 	}); ok {
 		if resp.Message != "" {
-			fmt.Println(resp.Message)
+			infoln(resp.Message)
 		}
 	} else if !notRunning {
 		runtimeFailed = true
 	}
-	downClientLaunchdIfPresent(cfg)
-	fmt.Println("to start again, run `rpa client add --local-forward ...` or `rpa init ...`")
+	code := downClientLaunchdIfPresent(cfg)
+	infoln("to start again, run `rpa client add --local-forward ...` or `rpa init ...`")
 	if runtimeFailed {
 		return exitError
 	}
+	return code
+}
+
+func runAgentReconnect(defaultPath string, args []string) int {
+	fs := flag.NewFlagSet("agent reconnect", flag.ContinueOnError)
+	configPath := fs.String("config", defaultPath, "path to config file")
+	if err := fs.Parse(args); err != nil {
+		return flagFail(err)
+	}
+
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		return fail(exitError, "config load failed: %v", err)
+	}
+
+	resp, err := ipcclient.Query(cfg, "reconnect")
+	if err != nil {
+		return fail(exitError, "agent reconnect failed: %v", err)
+	}
+	if !resp.OK {
+		return fail(exitError, "agent reconnect error: %s", resp.Message)
+	}
+	if resp.Message != "" {
+		infoln(resp.Message)
+	}
 	return exitOK
 }
 
-func runClientDoctor(args []string) int {
-	fs := flag.NewFlagSet("client doctor", flag.ContinueOnError)
-	configPath := fs.String("config", defaultConfigPath(), "path to config file")
-	localForward := fs.String("local-forward", "", "ssh local forward spec (optional)")
+// runAgentPauseResume sends pause or resume to the running agent. Neither
+// touches launchd, so a paused agent stays loaded and starts unpaused on its
+// next launch.
+func runAgentPauseResume(defaultPath string, command string, args []string) int {
+	fs := flag.NewFlagSet("agent "+command, flag.ContinueOnError)
+	configPath := fs.String("config", defaultPath, "path to config file")
 	if err := fs.Parse(args); err != nil {
-		return exitUsage
+		return flagFail(err)
 	}
 
 	cfg, err := config.Load(*configPath)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "config load failed: %v\n", err)
-		return exitError
+		return fail(exitError, "config load failed: %v", err)
 	}
-	if strings.TrimSpace(*localForward) != "" {
-		config.SetLocalForwards(cfg, []string{*localForward})
+
+	resp, err := ipcclient.Query(cfg, command)
+	if err != nil {
+		return fail(exitError, "agent %s failed: %v", command, err)
 	}
+	if !resp.OK {
+		return fail(exitError, "agent %s error: %s", command, resp.Message)
+	}
+	if resp.Message != "" {
+		infoln(resp.Message)
+	}
+	return exitOK
+}
 
-	if err := config.ValidateClient(cfg); err != nil {
-		fmt.Fprintf(os.Stderr, "config validation failed: %v\n", err)
-		return exitError
+// runMonitors shows the poll intervals of the running agent or client
+// monitors and changes the ones given as flags. Changes are not written to
+// the config, so they last until the service restarts.
+func runMonitors(defaultPath string, kind string, args []string) int {
+	fs := flag.NewFlagSet(kind+" monitors", flag.ContinueOnError)
+	configPath := fs.String("config", defaultPath, "path to config file")
+	for _, key := range monitor.IntervalKeys {
+		fs.Int(strings.ReplaceAll(key, "_", "-"), 0, "set "+kind+"."+key+" on the running "+kind)
+	}
+	if err := fs.Parse(args); err != nil {
+		return flagFail(err)
 	}
+	changes := map[string]string{}
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name != "config" {
+			changes[strings.ReplaceAll(f.Name, "-", "_")] = f.Value.String()
+		}
+	})
 
-	ok := true
-	if _, err := exec.LookPath("ssh"); err != nil {
-		fmt.Fprintf(os.Stderr, "check ssh binary: FAIL (%v)\n", err)
-		ok = false
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		return fail(exitError, "config load failed: %v", err)
+	}
+	var resp statusPayload
+	if kind == "client" {
+		r, err := ipcclientlocal.MonitorIntervals(cfg, changes)
+		if err == nil {
+			resp = statusPayload{ok: r.OK, message: r.Message, data: r.Data}
+		}
+		resp.err = err
 	} else {
-		fmt.Println("check ssh binary: OK")
+		r, err := ipcclient.MonitorIntervals(cfg, changes)
+		if err == nil {
+			resp = statusPayload{ok: r.OK, message: r.Message, data: r.Data}
+		}
+		resp.err = err
+	}
+	if resp.err != nil {
+		return fail(exitError, "%s monitors failed: %v", kind, resp.err)
+	}
+	if !resp.ok {
+		return fail(exitError, "%s monitors error: %s", kind, resp.message)
+	}
+	if len(changes) > 0 {
+		infoln(resp.message)
 	}
+	for _, key := range monitor.IntervalKeys {
+		fmt.Printf("%s: %s\n", key, resp.data[key])
+	}
+	fmt.Printf("monitors: %s\n", resp.data["monitors"])
+	return exitOK
+}
 
-	if cfg.SSH.IdentityFile != "" {
-		path := expandTilde(cfg.SSH.IdentityFile)
-		if _, err := os.Stat(path); err != nil {
-			fmt.Fprintf(os.Stderr, "check identity file: FAIL (%v)\n", err)
-			ok = false
-		} else {
-			fmt.Println("check identity file: OK")
+func runAgentPing(defaultPath string, args []string) int {
+	return runPing(defaultPath, "agent", args, func(cfg *config.Config) (string, string, time.Duration, error) {
+		resp, rtt, err := ipcclient.Ping(cfg)
+		if err != nil {
+			return "", "", rtt, err
+		}
+		if !resp.OK {
+			return "", "", rtt, errors.New(resp.Message)
+		}
+		return resp.Message, resp.Data["server_unix_ms"], rtt, nil
+	})
+}
+
+func runClientPing(defaultPath string, args []string) int {
+	return runPing(defaultPath, "client", args, func(cfg *config.Config) (string, string, time.Duration, error) {
+		resp, rtt, err := ipcclientlocal.Ping(cfg)
+		if err != nil {
+			return "", "", rtt, err
+		}
+		if !resp.OK {
+			return "", "", rtt, errors.New(resp.Message)
 		}
+		return resp.Message, resp.Data["server_unix_ms"], rtt, nil
+	})
+}
+
+// runPing checks that the IPC server answers and prints the round trip. It
+// says nothing about the ssh tunnel; use status for that.
+func runPing(defaultPath string, label string, args []string, ping func(*config.Config) (string, string, time.Duration, error)) int {
+	fs := flag.NewFlagSet(label+" ping", flag.ContinueOnError)
+	configPath := fs.String("config", defaultPath, "path to config file")
+	count := fs.Int("count", 1, "number of pings to send")
+	if err := fs.Parse(args); err != nil {
+		return flagFail(err)
+	}
+	if *count <= 0 {
+		return fail(exitUsage, "count must be > 0")
 	}
 
-	if _, err := net.LookupHost(cfg.SSH.Host); err != nil {
-		fmt.Fprintf(os.Stderr, "check host resolve: FAIL (%v)\n", err)
-		ok = false
-	} else {
-		fmt.Println("check host resolve: OK")
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		return fail(exitError, "config load failed: %v", err)
+	}
+	for i := 0; i < *count; i++ {
+		msg, serverMs, rtt, err := ping(cfg)
+		if err != nil {
+			return fail(exitError, "%s ping failed: %v", label, err)
+		}
+		line := fmt.Sprintf("%s from %s: rtt=%s", msg, label, rtt.Round(time.Microsecond))
+		if ms, err := strconv.ParseInt(serverMs, 10, 64); err == nil {
+			line += " server_time=" + time.UnixMilli(ms).UTC().Format("2006-01-02T15:04:05.000Z")
+		}
+		fmt.Println(line)
+	}
+	return exitOK
+}
+
+func runClientReconnect(defaultPath string, args []string) int {
+	fs := flag.NewFlagSet("client reconnect", flag.ContinueOnError)
+	configPath := fs.String("config", defaultPath, "path to config file")
+	if err := fs.Parse(args); err != nil {
+		return flagFail(err)
+	}
+
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		return fail(exitError, "config load failed: %v", err)
+	}
+
+	resp, err := ipcclientlocal.Query(cfg, "reconnect")
+	if err != nil {
+		return fail(exitError, "client reconnect failed: %v", err)
+	}
+	if !resp.OK {
+		return fail(exitError, "client reconnect error: %s", resp.Message)
+	}
+	if resp.Message != "" {
+		infoln(resp.Message)
+	}
+	return exitOK
+}
+
+func runClientDoctor(defaultPath string, args []string) int {
+	fs := flag.NewFlagSet("client doctor", flag.ContinueOnError)
+	configPath := fs.String("config", defaultPath, "path to config file")
+	localForward := fs.String("local-forward", "", "ssh local forward spec (optional)")
+	strict := fs.Bool("strict", false, "fail on config keys that match no field")
+	jsonOut := fs.Bool("json", false, "print checks as a JSON array")
+	if err := fs.Parse(args); err != nil {
+		return flagFail(err)
+	}
+	report, err := clientDoctorChecks(*configPath, *localForward, *strict)
+	return finishDoctor(report, err, *jsonOut)
+}
+
+// clientDoctorChecks runs the client pre-flight checks. A config that does
+// not load or validate is returned as an error instead of a report.
+func clientDoctorChecks(configPath, localForward string, strict bool) (*doctorReport, error) {
+	cfg, err := loadConfig(configPath, strict)
+	if err != nil {
+		return nil, fmt.Errorf("config load failed: %v", err)
+	}
+	if strings.TrimSpace(localForward) != "" {
+		config.SetLocalForwards(cfg, []string{localForward})
 	}
 
+	if err := config.ValidateClient(cfg); err != nil {
+		return nil, fmt.Errorf("config validation failed: %v", err)
+	}
+
+	report := &doctorReport{}
+	checkSSHBinary(report)
+	checkMonitors(report)
+	checkDeprecations(report, cfg)
+	checkIdentityFile(report, cfg.SSH.IdentityFile)
+	checkIdentityAgent(report, cfg.SSH.IdentityAgent)
+	checkHostResolve(report, cfg.SSH.Host, lookupHost)
+	checkForwardDirection(report, "local", config.NormalizeLocalForwards(cfg))
+
 	forward := firstLocalForward(cfg)
 	if forward != "" {
 		host, port, err := parseLocalForward(forward)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "check local forward: FAIL (%v)\n", err)
-			ok = false
+			report.fail("local forward", err.Error())
 		} else {
 			ln, err := net.Listen("tcp", net.JoinHostPort(host, port))
 			if err != nil {
-				fmt.Fprintf(os.Stderr, "check local port availability: FAIL (%v)\n", err)
-				ok = false
+				report.fail("local port availability", err.Error())
 			} else {
 				_ = ln.Close()
-				fmt.Println("check local port availability: OK")
+				report.ok("local port availability", "")
 			}
 		}
 	}
-
-	if !ok {
-		return exitError
-	}
-	return exitOK
+	return report, nil
 }
 
-func runClientLogs(args []string) int {
+func runClientLogs(defaultPath string, args []string) int {
 	fs := flag.NewFlagSet("client logs", flag.ContinueOnError)
-	configPath := fs.String("config", defaultConfigPath(), "path to config file")
+	configPath := fs.String("config", defaultPath, "path to config file")
 	if err := fs.Parse(args); err != nil {
-		return exitUsage
+		return flagFail(err)
 	}
 
 	cfg, err := config.Load(*configPath)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "config load failed: %v\n", err)
-		return exitError
+		return fail(exitError, "config load failed: %v", err)
 	}
-	return printRecentClientLogs(cfg)
+	return printRecentClientLogs(cfg, logFilter{})
 }
 
-func runClientMetrics(args []string) int {
+func runClientMetrics(defaultPath string, args []string) int {
 	fs := flag.NewFlagSet("client metrics", flag.ContinueOnError)
-	configPath := fs.String("config", defaultConfigPath(), "path to config file")
+	configPath := fs.String("config", defaultPath, "path to config file")
+	format := fs.String("format", "kv", "output format (kv|json|prom)")
 	if err := fs.Parse(args); err != nil {
-		return exitUsage
+		return flagFail(err)
+	}
+	if !validMetricsFormat(*format) {
+		return fail(exitUsage, "unsupported metrics format: %s (use kv, json, or prom)", *format)
 	}
 
 	cfg, err := config.Load(*configPath)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "config load failed: %v\n", err)
-		return exitError
+		return fail(exitError, "config load failed: %v", err)
 	}
 
 	resp, err := ipcclientlocal.Query(cfg, "metrics")
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "client metrics query failed: %v\n", err)
-		return exitError
+		return fail(exitError, "client metrics query failed: %v", err)
 	}
 	if !resp.OK {
-		fmt.Fprintf(os.Stderr, "client metrics error: %s\n", resp.Message)
-		return exitError
+		return fail(exitError, "client metrics error: %s", resp.Message)
 	}
-	for k, v := range resp.Data {
-		fmt.Printf("%s %s\n", k, v)
-	}
-	return exitOK
+	return printMetrics(resp.Data, *format)
 }
 
 func tryClientRuntimeUpdate(fn func() (*ipcclientlocal.Response, error)) (*ipcclientlocal.Response, bool, bool) {
@@ -897,12 +1670,12 @@ func tryClientRuntimeUpdate(fn func() (*ipcclientlocal.Response, error)) (*ipccl
 		if notRunning {
 			fmt.Fprintf(os.Stderr, "client not running; starting service: %v\n", err)
 		} else {
-			fmt.Fprintf(os.Stderr, "client update failed: %v\n", err)
+			fail(exitError, "client update failed: %v", err)
 		}
 		return nil, false, notRunning
 	}
 	if !resp.OK {
-		fmt.Fprintf(os.Stderr, "client update error: %s\n", resp.Message)
+		fail(exitError, "client update error: %s", resp.Message)
 		return resp, false, false
 	}
 	return resp, true, false
@@ -915,61 +1688,75 @@ func tryRuntimeUpdate(fn func() (*ipcclient.Response, error)) (*ipcclient.Respon
 		if notRunning {
 			fmt.Fprintf(os.Stderr, "agent not running; starting service: %v\n", err)
 		} else {
-			fmt.Fprintf(os.Stderr, "agent update failed: %v\n", err)
+			fail(exitError, "agent update failed: %v", err)
 		}
 		return nil, false, notRunning
 	}
 	if !resp.OK {
-		fmt.Fprintf(os.Stderr, "agent update error: %s\n", resp.Message)
+		fail(exitError, "agent update error: %s", resp.Message)
 		return resp, false, false
 	}
 	return resp, true, false
 }
 
-func downLaunchdIfPresent(cfg *config.Config) {
+func runEphemeralUpdate(target string, fn func() (bool, string, error)) int {
+	ok, msg, err := fn()
+	if err != nil {
+		if isNotRunning(err) {
+			return fail(exitError, "%s not running; --ephemeral requires a running %s: %v", target, target, err)
+		}
+		return fail(exitError, "%s update failed: %v", target, err)
+	}
+	if !ok {
+		return fail(exitError, "%s update error: %s", target, msg)
+	}
+	if msg != "" {
+		infoln(msg)
+	}
+	infoln("note: ephemeral change; config file not updated")
+	return exitOK
+}
+
+func downLaunchdIfPresent(cfg *config.Config) int {
 	plistPath, err := launchd.PlistPath(cfg.Agent.LaunchdLabel)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "resolve launchd plist failed: %v\n", err)
-		return
+		return fail(exitError, "resolve launchd plist failed: %v", err)
 	}
 	if _, err := os.Stat(plistPath); err != nil {
 		if !os.IsNotExist(err) {
-			fmt.Fprintf(os.Stderr, "stat launchd plist failed: %v\n", err)
+			return fail(exitError, "stat launchd plist failed: %v", err)
 		}
-		return
+		return exitOK
 	}
 	if err := launchd.Bootout(plistPath); err != nil {
-		fmt.Fprintf(os.Stderr, "launchd bootout failed: %v\n", err)
-		return
+		return fail(exitError, "launchd bootout failed: %v", err)
 	}
 	if _, err := launchd.Uninstall(cfg.Agent.LaunchdLabel); err != nil {
-		fmt.Fprintf(os.Stderr, "launchd uninstall failed: %v\n", err)
-		return
+		return fail(exitError, "launchd uninstall failed: %v", err)
 	}
-	fmt.Printf("agent down: launchd unloaded (%s)\n", plistPath)
+	infof("agent down: launchd unloaded (%s)\n", plistPath)
+	return exitOK
 }
 
-func downClientLaunchdIfPresent(cfg *config.Config) {
+func downClientLaunchdIfPresent(cfg *config.Config) int {
 	plistPath, err := launchd.PlistPath(cfg.Client.LaunchdLabel)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "resolve launchd plist failed: %v\n", err)
-		return
+		return fail(exitError, "resolve launchd plist failed: %v", err)
 	}
 	if _, err := os.Stat(plistPath); err != nil {
 		if !os.IsNotExist(err) {
-			fmt.Fprintf(os.Stderr, "stat launchd plist failed: %v\n", err)
+			return fail(exitError, "stat launchd plist failed: %v", err)
 		}
-		return
+		return exitOK
 	}
 	if err := launchd.Bootout(plistPath); err != nil {
-		fmt.Fprintf(os.Stderr, "launchd bootout failed: %v\n", err)
-		return
+		return fail(exitError, "launchd bootout failed: %v", err)
 	}
 	if _, err := launchd.Uninstall(cfg.Client.LaunchdLabel); err != nil {
-		fmt.Fprintf(os.Stderr, "launchd uninstall failed: %v\n", err)
-		return
+		return fail(exitError, "launchd uninstall failed: %v", err)
 	}
-	fmt.Printf("client down: launchd unloaded (%s)\n", plistPath)
+	infof("client down: launchd unloaded (%s)\n", plistPath)
+	return exitOK
 }
 
 func isNotRunning(err error) bool {
@@ -979,46 +1766,81 @@ func isNotRunning(err error) bool {
 		errors.Is(err, ipcclientlocal.ErrClientSocketRefused)
 }
 
-func runAgentRun(args []string) int {
+func runAgentRun(defaultPath string, args []string) int {
 	fs := flag.NewFlagSet("agent run", flag.ContinueOnError)
-	configPath := fs.String("config", defaultConfigPath(), "path to config file")
+	configPath := fs.String("config", defaultPath, "path to config file")
+	once := fs.Bool("once", false, "exit after the first successful connection (never restart)")
+	onceTimeout := fs.Duration("once-timeout", 30*time.Second, "how long --once waits for a successful connection")
+	restartPolicy := fs.String("restart-policy", "", "override agent.restart_policy for this run (always|on-failure|never)")
+	force := fs.Bool("force", false, "start even if another agent already answers on the IPC socket")
+	var sshArgs []string
+	fs.Func("ssh-arg", "extra raw ssh argument, placed before the destination (repeatable)", func(value string) error {
+		sshArgs = append(sshArgs, value)
+		return nil
+	})
 	if err := fs.Parse(args); err != nil {
-		return exitUsage
+		return flagFail(err)
+	}
+	if *once && *onceTimeout <= 0 {
+		return fail(exitUsage, "once-timeout must be positive")
+	}
+	if *once && *restartPolicy != "" && *restartPolicy != "never" {
+		return fail(exitUsage, "--once always runs with restart policy never")
 	}
 
 	cfg, err := config.Load(*configPath)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "config load failed: %v\n", err)
-		return exitError
+		return fail(exitError, "config load failed: %v", err)
+	}
+	if *restartPolicy != "" {
+		cfg.Agent.RestartPolicy = *restartPolicy
 	}
 
-	return runForegroundAgent(cfg, "agent run")
+	if !*once {
+		return runForegroundAgent(cfg, "agent run", 0, *force, sshArgs)
+	}
+	cfg.Agent.RestartPolicy = "never"
+	return runForegroundAgent(cfg, "agent run", *onceTimeout, *force, sshArgs)
 }
 
-func runForegroundAgent(cfg *config.Config, label string) int {
+// runForegroundAgent runs the agent until stopped. A positive onceTimeout
+// stops it after the first success mark and reports whether one was reached.
+func runForegroundAgent(cfg *config.Config, label string, onceTimeout time.Duration, force bool, sshArgs []string) int {
 	if err := config.ValidateAgent(cfg); err != nil {
-		fmt.Fprintf(os.Stderr, "config validation failed: %v\n", err)
-		return exitError
+		return fail(exitError, "config validation failed: %v", err)
+	}
+	if !force {
+		if socket, ok := serviceAnswering(cfg, "agent"); ok {
+			return fail(exitError, "%s: another agent is already running on %s; stop it first or pass --force", label, socket)
+		}
+	}
+	pidPath, prevPID, code := writePIDFile(cfg, "agent")
+	if code != exitOK {
+		return code
 	}
+	defer pidfile.Release(pidPath, prevPID)
 
 	agt := agent.New(cfg)
+	agt.SetExtraSSHArgs(sshArgs)
 	logs := logging.NewLogBuffer()
 	logger, err := logging.NewLogger(cfg, logs)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "logger init failed: %v\n", err)
-		return exitError
+		return fail(exitError, "logger init failed: %v", err)
 	}
+	// Deferred first so it runs last and writes repeats still held by the
+	// dedupe window; launchd runs this same path.
+	defer logger.Flush()
 	logger.SetConsoleWriter(os.Stdout)
-	startCaffeinate(logger, cfg.Agent.PreventSleep)
+	keeper := startCaffeinate(logger, cfg.Agent.PreventSleep)
+	defer keeper.Stop()
 
 	server, err := ipcserver.NewServer(cfg, agt, logs)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "ipc server init failed: %v\n", err)
-		return exitError
+		return fail(exitError, "ipc server init failed: %v", err)
 	}
+	server.SetSleepKeeper(keeper)
 	if err := server.Start(); err != nil {
-		fmt.Fprintf(os.Stderr, "ipc server start failed: %v\n", err)
-		return exitError
+		return fail(exitError, "ipc server start failed: %v", err)
 	}
 	defer server.Stop()
 
@@ -1030,46 +1852,131 @@ func runForegroundAgent(cfg *config.Config, label string) int {
 		agt.RequestStop()
 	}()
 
-	fmt.Printf("%s: starting ssh (%s)\n", label, agt.ConfigSummary())
-	fmt.Println("note: running until stopped via launchd or Ctrl+C")
+	watchLogReopen(logger)
+	logDeprecations(logger, cfg)
 
-	if err := agt.RunWithLogger(logger); err != nil {
-		fmt.Fprintf(os.Stderr, "agent exited with error: %v\n", err)
-		return exitError
+	infof("%s: starting ssh (%s)\n", label, agt.ConfigSummary())
+	if len(sshArgs) > 0 {
+		infof("note: extra ssh args: %s\n", strings.Join(sshArgs, " "))
+	}
+	if onceTimeout > 0 {
+		infof("note: --once waits up to %s for a successful connection\n", onceTimeout)
+		done := make(chan struct{})
+		defer close(done)
+		go stopAfterFirstSuccess(agt.FirstSuccess, agt.RequestStop, onceTimeout, done)
+	} else {
+		infoln("note: running until stopped via launchd or Ctrl+C")
+	}
+
+	startedAt := time.Now()
+	runErr := agt.RunWithLogger(logger)
+	if onceTimeout > 0 {
+		first := agt.FirstSuccess()
+		if first.IsZero() {
+			class := agt.LastClass()
+			if class == "" {
+				class = "none"
+			}
+			return fail(exitError, "%s: no successful connection within %s (last_class: %s)", label, onceTimeout, class)
+		}
+		infof("%s: connected in %s\n", label, first.Sub(startedAt).Truncate(time.Millisecond))
+		return exitOK
+	}
+	if runErr != nil {
+		return fail(exitError, "agent exited with error: %v", runErr)
 	}
 	return exitOK
 }
 
-func runForegroundClient(cfg *config.Config, label string) int {
+// stopAfterFirstSuccess calls stop once firstSuccess reports a time or the
+// timeout passes, whichever comes first. It returns early when done closes.
+func stopAfterFirstSuccess(firstSuccess func() time.Time, stop func(), timeout time.Duration, done <-chan struct{}) {
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-deadline.C:
+			stop()
+			return
+		case <-ticker.C:
+			if !firstSuccess().IsZero() {
+				stop()
+				return
+			}
+		}
+	}
+}
+
+// writePIDFile records this run in ~/.rpa/<label>.pid. Callers have already
+// checked that no instance answers the IPC socket (unless --force), so a pid
+// that is still alive was most likely reused after a reboot and does not
+// block the run. The replaced live pid is returned for pidfile.Release, which
+// hands the file back to it if that process outlives this run.
+func writePIDFile(cfg *config.Config, kind string) (string, int, int) {
+	path, err := config.PIDPath(cfg, kind)
+	if err != nil {
+		return "", 0, fail(exitError, "resolve pid file failed: %v", err)
+	}
+	prev, alive, err := pidfile.Write(path)
+	if err != nil {
+		return "", 0, fail(exitError, "%v", err)
+	}
+	switch {
+	case prev > 0 && alive:
+		infof("note: pid file %s named running pid %d; replaced\n", path, prev)
+		return path, prev, exitOK
+	case prev > 0:
+		infof("note: replaced stale pid file %s (pid %d is not running)\n", path, prev)
+	}
+	return path, 0, exitOK
+}
+
+func runForegroundClient(cfg *config.Config, label string, force bool, sshArgs []string) int {
 	if err := config.ValidateClient(cfg); err != nil {
-		fmt.Fprintf(os.Stderr, "config validation failed: %v\n", err)
-		return exitError
+		return fail(exitError, "config validation failed: %v", err)
+	}
+	if !force {
+		if socket, ok := serviceAnswering(cfg, "client"); ok {
+			return fail(exitError, "%s: another client is already running on %s; stop it first or pass --force", label, socket)
+		}
+	}
+	pidPath, prevPID, code := writePIDFile(cfg, "client")
+	if code != exitOK {
+		return code
 	}
+	defer pidfile.Release(pidPath, prevPID)
 
 	cli := client.New(cfg)
+	cli.SetExtraSSHArgs(sshArgs)
 	logs := logging.NewLogBuffer()
 	clientLogPath, err := config.ClientLogPath(cfg)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "resolve client log path failed: %v\n", err)
-		return exitError
+		return fail(exitError, "resolve client log path failed: %v", err)
 	}
 	logger, err := logging.NewLoggerWithPath(clientLogPath, logs)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "logger init failed: %v\n", err)
-		return exitError
+		return fail(exitError, "logger init failed: %v", err)
 	}
+	defer logger.Flush()
 	logger.SetLevel(cfg.ClientLogging.Level)
+	logger.SetFormat(cfg.ClientLogging.Format)
+	logger.SetTimeFormat(cfg.ClientLogging.TimeFormat, cfg.ClientLogging.LocalTime)
+	logger.SetDedupeWindow(cfg.ClientLogging.DedupeWindowMs)
 	logger.SetConsoleWriter(os.Stdout)
-	startCaffeinate(logger, cfg.Client.PreventSleep)
+	keeper := startCaffeinate(logger, cfg.Client.PreventSleep)
+	defer keeper.Stop()
 
 	server, err := clientipcserver.NewServer(cfg, cli, logs)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "client ipc server init failed: %v\n", err)
-		return exitError
+		return fail(exitError, "client ipc server init failed: %v", err)
 	}
+	server.SetSleepKeeper(keeper)
 	if err := server.Start(); err != nil {
-		fmt.Fprintf(os.Stderr, "client ipc server start failed: %v\n", err)
-		return exitError
+		return fail(exitError, "client ipc server start failed: %v", err)
 	}
 	defer server.Stop()
 
@@ -1083,45 +1990,179 @@ func runForegroundClient(cfg *config.Config, label string) int {
 		cli.RequestStop()
 	}()
 
-	fmt.Printf("%s: starting ssh (%s)\n", label, cli.ConfigSummary())
-	fmt.Println("note: running until stopped via launchd or Ctrl+C")
+	watchLogReopen(logger)
+	logDeprecations(logger, cfg)
+
+	infof("%s: starting ssh (%s)\n", label, cli.ConfigSummary())
+	if len(sshArgs) > 0 {
+		infof("note: extra ssh args: %s\n", strings.Join(sshArgs, " "))
+	}
+	infoln("note: running until stopped via launchd or Ctrl+C")
 
 	if err := cli.RunWithLogger(logger); err != nil {
-		fmt.Fprintf(os.Stderr, "client exited with error: %v\n", err)
-		return exitError
+		return fail(exitError, "client exited with error: %v", err)
 	}
 	printClientAdvice(cli.LastClass())
 	return exitOK
 }
 
-func runStatus(args []string) int {
+// watchLogReopen reopens the log file whenever reopenSignals fire.
+func watchLogReopen(logger *logging.Logger) {
+	if len(reopenSignals) == 0 {
+		return
+	}
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, reopenSignals...)
+	go func() {
+		for range ch {
+			if err := logger.Reopen(); err != nil {
+				fmt.Fprintf(os.Stderr, "log reopen failed: %v\n", err)
+				continue
+			}
+			logger.Event("INFO", "log_reopened", nil)
+		}
+	}()
+}
+
+func runStatus(defaultPath string, args []string) int {
+	target := ""
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		target = args[0]
+		args = args[1:]
+	}
 	fs := flag.NewFlagSet("status", flag.ContinueOnError)
-	configPath := fs.String("config", defaultConfigPath(), "path to config file")
+	var configPaths []string
+	fs.Func("config", "path to config file (repeatable)", func(value string) error {
+		configPaths = append(configPaths, value)
+		return nil
+	})
+	allConfigs := fs.String("all-configs", "", "glob of config files to show, e.g. '~/.rpa/*.yaml'")
+	exitCode := fs.Bool("exit-code", false, "exit 0 only if the shown services are connected")
 	if err := fs.Parse(args); err != nil {
-		return exitUsage
+		return flagFail(err)
 	}
-
-	cfg, err := config.Load(*configPath)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "config load failed: %v\n", err)
-		return exitError
+	if target == "" && fs.NArg() > 0 {
+		// Flags may follow the target too: status --config x agent --exit-code.
+		target = fs.Arg(0)
+		if err := fs.Parse(fs.Args()[1:]); err != nil {
+			return flagFail(err)
+		}
+	}
+	if fs.NArg() > 0 {
+		return fail(exitUsage, "unexpected argument %q", fs.Arg(0))
+	}
+	if target != "" && target != "agent" && target != "client" {
+		return fail(exitUsage, "status target must be agent or client")
 	}
+	if *allConfigs != "" {
+		matches, err := filepath.Glob(expandTilde(*allConfigs))
+		if err != nil {
+			return fail(exitUsage, "invalid --all-configs pattern: %v", err)
+		}
+		if len(matches) == 0 {
+			return fail(exitError, "no config files match %s", *allConfigs)
+		}
+		configPaths = append(configPaths, matches...)
+	}
+	showAgent := target == "" || target == "agent"
+	showClient := target == "" || target == "client"
 
-	agentOK := printStatusBlock("agent", cfg, func() statusPayload {
-		resp, err := ipcclient.Query(cfg, "status")
+	if len(configPaths) <= 1 {
+		path := defaultPath
+		if len(configPaths) == 1 {
+			path = configPaths[0]
+		}
+		cfg, err := config.Load(path)
 		if err != nil {
-			return statusPayload{err: err}
+			return fail(exitError, "config load failed: %v", err)
 		}
-		return statusPayload{ok: resp.OK, message: resp.Message, data: resp.Data}
-	})
-	clientOK := printStatusBlock("client", cfg, func() statusPayload {
-		resp, err := ipcclientlocal.Query(cfg, "status")
+		shown, connected := printConfigStatus(cfg, showAgent, showClient)
+		return statusCode(*exitCode, shown, connected)
+	}
+
+	// Sockets live under the rpa home rather than in the config, so configs
+	// sharing a home answer from the same services.
+	sockets := map[string]string{}
+	allShown, allConnected := true, true
+	for i, path := range configPaths {
+		if i > 0 {
+			fmt.Println()
+		}
+		fmt.Printf("config: %s\n", path)
+		cfg, err := config.Load(path)
 		if err != nil {
-			return statusPayload{err: err}
+			fmt.Printf("  error: config load failed: %v\n", err)
+			allShown, allConnected = false, false
+			continue
 		}
-		return statusPayload{ok: resp.OK, message: resp.Message, data: resp.Data}
-	})
-	if !agentOK && !clientOK {
+		if socket, err := config.SocketPath(cfg); err == nil {
+			if first, ok := sockets[socket]; ok {
+				fmt.Printf("  note: same rpa home as %s; the services below are shared\n", first)
+			} else {
+				sockets[socket] = path
+			}
+		}
+		shown, connected := printConfigStatus(cfg, showAgent, showClient)
+		allShown = allShown && shown
+		allConnected = allConnected && connected
+	}
+	return statusCode(*exitCode, allShown, allConnected)
+}
+
+// printConfigStatus prints the agent and/or client blocks for cfg. shown is
+// false when neither block had anything to report; connected is true when
+// every shown service is connected.
+func printConfigStatus(cfg *config.Config, showAgent, showClient bool) (shown, connected bool) {
+	agentOK, clientOK := false, false
+	agentState, clientState := "", ""
+	if showAgent {
+		agentOK = printStatusBlock("agent", cfg, func() statusPayload {
+			resp, err := ipcclient.Query(cfg, "status")
+			if err != nil {
+				return statusPayload{err: err}
+			}
+			if resp.OK {
+				agentState = resp.Data["state"]
+			}
+			return statusPayload{ok: resp.OK, message: resp.Message, data: resp.Data}
+		})
+	}
+	if showClient {
+		clientOK = printStatusBlock("client", cfg, func() statusPayload {
+			resp, err := ipcclientlocal.Query(cfg, "status")
+			if err != nil {
+				return statusPayload{err: err}
+			}
+			if resp.OK {
+				clientState = resp.Data["state"]
+			}
+			return statusPayload{ok: resp.OK, message: resp.Message, data: resp.Data}
+		})
+	}
+	connected = statusExitCode(showAgent, agentState, showClient, clientState) == exitOK
+	return agentOK || clientOK, connected
+}
+
+func statusCode(exitCode, shown, connected bool) int {
+	if exitCode {
+		if !connected {
+			return exitError
+		}
+		return exitOK
+	}
+	if !shown {
+		return exitError
+	}
+	return exitOK
+}
+
+// statusExitCode is exitOK only when every shown service reports RUNNING.
+func statusExitCode(showAgent bool, agentState string, showClient bool, clientState string) int {
+	connected := state.StateConnected.String()
+	if showAgent && agentState != connected {
+		return exitError
+	}
+	if showClient && clientState != connected {
 		return exitError
 	}
 	return exitOK
@@ -1152,6 +2193,11 @@ func printStatusBlock(label string, cfg *config.Config, query func() statusPaylo
 		return false
 	}
 	fmt.Printf("  state: %s\n", resp.data["state"])
+	if health := resp.data["health"]; health == state.HealthDegraded && resp.data["tcp_check_error"] != "" {
+		fmt.Printf("  health: %s (tcp check: %s)\n", health, resp.data["tcp_check_error"])
+	} else if health != "" {
+		fmt.Printf("  health: %s\n", health)
+	}
 	fmt.Printf("  summary: %s\n", resp.data["summary"])
 	if label == "agent" {
 		remoteForwards := strings.TrimSpace(resp.data["remote_forwards"])
@@ -1162,6 +2208,7 @@ func printStatusBlock(label string, cfg *config.Config, query func() statusPaylo
 			remoteForwards = "(none)"
 		}
 		fmt.Printf("  remote_forwards: %s\n", remoteForwards)
+		printForwardLabels(remoteForwards, cfg.SSH.RemoteForwards)
 	} else {
 		localForwards := strings.TrimSpace(resp.data["local_forwards"])
 		if localForwards == "" {
@@ -1171,6 +2218,13 @@ func printStatusBlock(label string, cfg *config.Config, query func() statusPaylo
 			localForwards = "(none)"
 		}
 		fmt.Printf("  local_forwards: %s\n", localForwards)
+		printForwardLabels(localForwards, cfg.Client.LocalForwards)
+		if v, ok := resp.data["dynamic_forwards"]; ok && v != "" {
+			fmt.Printf("  dynamic_forwards: %s\n", v)
+		}
+	}
+	if v, ok := resp.data["forwards_version"]; ok && v != "" {
+		fmt.Printf("  forwards_version: %s\n", v)
 	}
 	fmt.Printf("  uptime: %s\n", resp.data["uptime"])
 	fmt.Printf("  restarts: %s\n", resp.data["restarts"])
@@ -1181,23 +2235,44 @@ func printStatusBlock(label string, cfg *config.Config, query func() statusPaylo
 	if v, ok := resp.data["last_trigger"]; ok && v != "" {
 		fmt.Printf("  last_trigger: %s\n", v)
 	}
+	if v, ok := resp.data["last_trigger_unix"]; ok && v != "" {
+		fmt.Printf("  last_trigger_utc: %s\n", formatUnixUTC(v))
+	}
 	if v, ok := resp.data["last_success_unix"]; ok && v != "" {
 		fmt.Printf("  last_success_utc: %s\n", formatUnixUTC(v))
+		if age := formatUnixAge(v, statusNow()); age != "" {
+			fmt.Printf("  last_success_age: %s\n", age)
+		}
 		fmt.Printf("  last_success_unix: %s\n", v)
 	}
+	if v, ok := resp.data["startup_connect_sec"]; ok && v != "" {
+		fmt.Printf("  startup_connect_sec: %s\n", v)
+	}
 	if v, ok := resp.data["tcp_check"]; ok && v != "" {
 		fmt.Printf("  tcp_check: %s\n", v)
 	}
 	if v, ok := resp.data["tcp_check_error"]; ok && v != "" {
 		fmt.Printf("  tcp_check_error: %s\n", v)
 	}
+	if v, ok := resp.data["tcp_check_failures"]; ok && v != "" {
+		fmt.Printf("  tcp_check_failures: %s\n", v)
+	}
 	if v, ok := resp.data["tcp_check_unix"]; ok && v != "" {
 		fmt.Printf("  tcp_check_utc: %s\n", formatUnixUTC(v))
 		fmt.Printf("  tcp_check_unix: %s\n", v)
 	}
+	if v, ok := resp.data["exit_classes"]; ok && v != "" {
+		fmt.Printf("  exits: %s\n", v)
+	}
 	if v, ok := resp.data["backoff_ms"]; ok && v != "" {
 		fmt.Printf("  backoff_ms: %s\n", v)
 	}
+	if v, ok := resp.data["monitors"]; ok && v != "" {
+		fmt.Printf("  monitors: %s\n", v)
+	}
+	if pid := launchdPID(label, cfg); pid > 0 {
+		fmt.Printf("  launchd_pid: %d\n", pid)
+	}
 	return true
 }
 
@@ -1220,6 +2295,11 @@ func printStatusFallback(label string, cfg *config.Config) bool {
 		return false
 	}
 	fmt.Println("  note: using last known state (service not running)")
+	printSnapshot(snap)
+	return true
+}
+
+func printSnapshot(snap statefile.Snapshot) {
 	if snap.LastExit != "" {
 		fmt.Printf("  last_exit: %s\n", snap.LastExit)
 	}
@@ -1231,179 +2311,820 @@ func printStatusFallback(label string, cfg *config.Config) bool {
 	}
 	if snap.LastSuccessUnix > 0 {
 		fmt.Printf("  last_success_utc: %s\n", formatUnixUTC(strconv.FormatInt(snap.LastSuccessUnix, 10)))
+		fmt.Printf("  last_success_age: %s\n", formatUnixAge(strconv.FormatInt(snap.LastSuccessUnix, 10), statusNow()))
 		fmt.Printf("  last_success_unix: %d\n", snap.LastSuccessUnix)
 	}
-	if snap.UpdatedUnix > 0 {
-		fmt.Printf("  updated_unix: %d\n", snap.UpdatedUnix)
+	if snap.UpdatedUnix > 0 {
+		fmt.Printf("  updated_utc: %s\n", formatUnixUTC(strconv.FormatInt(snap.UpdatedUnix, 10)))
+		fmt.Printf("  updated_unix: %d\n", snap.UpdatedUnix)
+	}
+	if snap.Version != "" {
+		fmt.Printf("  written_by: rpa %s\n", snap.Version)
+	}
+	if snap.SSHVersion != "" {
+		fmt.Printf("  ssh_version: %s\n", snap.SSHVersion)
+	}
+}
+
+func runState(defaultPath string, args []string) int {
+	target := "agent"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		target = args[0]
+		args = args[1:]
+	}
+	fs := flag.NewFlagSet("state", flag.ContinueOnError)
+	configPath := fs.String("config", defaultPath, "path to config file")
+	raw := fs.Bool("json", false, "print the statefile JSON as stored")
+	if err := fs.Parse(args); err != nil {
+		return flagFail(err)
+	}
+
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		return fail(exitError, "config load failed: %v", err)
+	}
+	var path string
+	switch target {
+	case "agent":
+		path, err = config.AgentStatePath(cfg)
+	case "client":
+		path, err = config.ClientStatePath(cfg)
+	default:
+		return fail(exitUsage, "state target must be agent or client")
+	}
+	if err != nil {
+		return fail(exitError, "resolve %s state path failed: %v", target, err)
+	}
+
+	if *raw {
+		data, err := statefile.ReadRaw(path)
+		if err != nil {
+			return stateReadFail(target, path, err)
+		}
+		// Indent only; key order and unknown fields stay as stored.
+		var out bytes.Buffer
+		if err := json.Indent(&out, bytes.TrimSpace(data), "", "  "); err != nil {
+			return fail(exitError, "read %s state failed: parse state: %v", target, err)
+		}
+		fmt.Println(out.String())
+		return exitOK
+	}
+	snap, err := statefile.Read(path)
+	if err != nil {
+		return stateReadFail(target, path, err)
+	}
+	fmt.Printf("%s state (%s):\n", target, path)
+	printSnapshot(snap)
+	return exitOK
+}
+
+func stateReadFail(target, path string, err error) int {
+	if errors.Is(err, os.ErrNotExist) {
+		return fail(exitError, "no %s state yet (missing %s)", target, path)
+	}
+	return fail(exitError, "read %s state failed: %v", target, err)
+}
+
+// runIPC sends one raw IPC command and prints the JSON response. It is a
+// development aid, so it is left out of usage and completion and needs
+// --experimental.
+func runIPC(defaultPath string, args []string) int {
+	if len(args) < 2 || strings.HasPrefix(args[0], "-") || strings.HasPrefix(args[1], "-") {
+		return fail(exitUsage, "usage: rpa ipc agent|client <command> [--arg key=value] --experimental")
+	}
+	target, command := args[0], args[1]
+	fs := flag.NewFlagSet("ipc", flag.ContinueOnError)
+	configPath := fs.String("config", defaultPath, "path to config file")
+	experimental := fs.Bool("experimental", false, "acknowledge that this command is for debugging")
+	cmdArgs := map[string]string{}
+	fs.Func("arg", "request argument as key=value (repeatable)", func(value string) error {
+		key, val, err := parseIPCArg(value)
+		if err != nil {
+			return err
+		}
+		cmdArgs[key] = val
+		return nil
+	})
+	if err := fs.Parse(args[2:]); err != nil {
+		return flagFail(err)
+	}
+	if !*experimental {
+		return fail(exitUsage, "rpa ipc is experimental; pass --experimental to use it")
+	}
+	if len(cmdArgs) == 0 {
+		cmdArgs = nil
+	}
+
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		return fail(exitError, "config load failed: %v", err)
+	}
+
+	var resp any
+	ok := false
+	switch target {
+	case "agent":
+		r, err := ipcclient.Send(cfg, command, cmdArgs)
+		if err != nil {
+			return fail(exitError, "ipc %s failed: %v", command, err)
+		}
+		resp, ok = r, r.OK
+	case "client":
+		r, err := ipcclientlocal.Send(cfg, command, cmdArgs)
+		if err != nil {
+			return fail(exitError, "ipc %s failed: %v", command, err)
+		}
+		resp, ok = r, r.OK
+	default:
+		return fail(exitUsage, "unknown ipc target: %s", target)
+	}
+	out, err := json.MarshalIndent(resp, "", "  ")
+	if err != nil {
+		return fail(exitError, "encode response failed: %v", err)
+	}
+	fmt.Println(string(out))
+	if !ok {
+		return exitError
+	}
+	return exitOK
+}
+
+func parseIPCArg(value string) (string, string, error) {
+	key, val, found := strings.Cut(value, "=")
+	key = strings.TrimSpace(key)
+	if !found || key == "" {
+		return "", "", fmt.Errorf("--arg must be key=value (got %q)", value)
+	}
+	return key, val, nil
+}
+
+func formatUnixUTC(raw string) string {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return ""
+	}
+	seconds, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil {
+		return raw
+	}
+	return time.Unix(seconds, 0).UTC().Format(time.RFC3339)
+}
+
+// statusNow is the clock last_success_age is measured against; tests fix it.
+var statusNow = time.Now
+
+// formatUnixAge renders a unix timestamp relative to now, e.g. "2m30s ago".
+// Timestamps in the future (clock skew) read as "0s ago".
+func formatUnixAge(raw string, now time.Time) string {
+	seconds, err := strconv.ParseInt(strings.TrimSpace(raw), 10, 64)
+	if err != nil {
+		return ""
+	}
+	age := now.Sub(time.Unix(seconds, 0)).Truncate(time.Second)
+	if age < 0 {
+		age = 0
+	}
+	return age.String() + " ago"
+}
+
+func runLogs(defaultPath string, args []string) int {
+	target := "agent"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		target = args[0]
+		args = args[1:]
+	}
+	fs := flag.NewFlagSet("logs", flag.ContinueOnError)
+	follow := fs.Bool("follow", false, "follow logs (placeholder)")
+	followShort := fs.Bool("f", false, "follow logs (shorthand)")
+	configPath := fs.String("config", defaultPath, "path to config file")
+	since := fs.Duration("since", 0, "only show lines newer than this duration (e.g. 10m)")
+	grep := fs.String("grep", "", "only show lines matching this regular expression")
+	sshStderr := fs.Bool("ssh-stderr", false, "show the buffered ssh stderr lines from the last run")
+	count := fs.Bool("count", false, "print a tally of events by level instead of the lines")
+	if err := fs.Parse(args); err != nil {
+		return flagFail(err)
+	}
+	if *count && (*sshStderr || *follow || *followShort) {
+		return fail(exitUsage, "--count cannot be combined with --ssh-stderr or --follow")
+	}
+	if *since < 0 {
+		return fail(exitUsage, "--since must be a positive duration")
+	}
+	if *sshStderr && (*since > 0 || *follow || *followShort) {
+		return fail(exitUsage, "--ssh-stderr cannot be combined with --since or --follow")
+	}
+	if *since > 0 && (*follow || *followShort) {
+		return fail(exitUsage, "--since cannot be combined with --follow")
+	}
+	filter := logFilter{since: *since, count: *count}
+	if *grep != "" {
+		pattern, err := regexp.Compile(*grep)
+		if err != nil {
+			return fail(exitUsage, "invalid --grep pattern: %v", err)
+		}
+		filter.pattern = pattern
+	}
+
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		return fail(exitError, "config load failed: %v", err)
+	}
+
+	logCfg := cfg.Logging
+	if target == "client" {
+		logCfg = cfg.ClientLogging
+	}
+	filter.timeLayout = config.LogTimeLayout(logCfg.TimeFormat)
+	filter.timeLocal = logCfg.LocalTime
+
+	if *sshStderr {
+		return printSSHStderr(cfg, target, filter)
+	}
+
+	switch target {
+	case "agent":
+		if *follow || *followShort {
+			return followLogs(cfg, filter)
+		}
+		return printRecentLogs(cfg, filter)
+	case "client":
+		if *follow || *followShort {
+			return followClientLogs(cfg, filter)
+		}
+		return printRecentClientLogs(cfg, filter)
+	default:
+		return fail(exitUsage, "unknown logs target: %s", target)
+	}
+}
+
+func runEvents(defaultPath string, args []string) int {
+	target := "agent"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		target = args[0]
+		args = args[1:]
+	}
+	fs := flag.NewFlagSet("events", flag.ContinueOnError)
+	configPath := fs.String("config", defaultPath, "path to config file")
+	if err := fs.Parse(args); err != nil {
+		return flagFail(err)
+	}
+
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		return fail(exitError, "config load failed: %v", err)
+	}
+
+	switch target {
+	case "agent":
+		err = ipcclient.Events(cfg, func(ev ipcclient.Event) error {
+			return printEvent(ev)
+		})
+	case "client":
+		err = ipcclientlocal.Events(cfg, func(ev ipcclientlocal.Event) error {
+			return printEvent(ev)
+		})
+	default:
+		return fail(exitUsage, "unknown events target: %s", target)
+	}
+	if err != nil {
+		return fail(exitError, "events stream ended: %v", err)
+	}
+	return exitOK
+}
+
+// printEvent writes one event as a JSON line.
+func printEvent(ev any) error {
+	out, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(out))
+	return nil
+}
+
+func runMetrics(defaultPath string, args []string) int {
+	target := "agent"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		target = args[0]
+		args = args[1:]
+	}
+	fs := flag.NewFlagSet("metrics", flag.ContinueOnError)
+	configPath := fs.String("config", defaultPath, "path to config file")
+	format := fs.String("format", "kv", "output format (kv|json|prom)")
+	watch := fs.Bool("watch", false, "reprint metrics every --interval with counter deltas")
+	interval := fs.Duration("interval", 2*time.Second, "refresh interval for --watch")
+	if err := fs.Parse(args); err != nil {
+		return flagFail(err)
+	}
+	if !validMetricsFormat(*format) {
+		return fail(exitUsage, "unsupported metrics format: %s (use kv, json, or prom)", *format)
+	}
+	if *watch && *format != "kv" {
+		return fail(exitUsage, "--watch only supports the kv format")
+	}
+	if *interval <= 0 {
+		return fail(exitUsage, "--interval must be a positive duration")
+	}
+
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		return fail(exitError, "config load failed: %v", err)
+	}
+
+	var query func() (map[string]string, error)
+	switch target {
+	case "agent":
+		query = func() (map[string]string, error) {
+			resp, err := ipcclient.Query(cfg, "metrics")
+			if err != nil {
+				return nil, fmt.Errorf("metrics query failed: %v", err)
+			}
+			if !resp.OK {
+				return nil, fmt.Errorf("metrics error: %s", resp.Message)
+			}
+			return resp.Data, nil
+		}
+	case "client":
+		query = func() (map[string]string, error) {
+			resp, err := ipcclientlocal.Query(cfg, "metrics")
+			if err != nil {
+				return nil, fmt.Errorf("client metrics query failed: %v", err)
+			}
+			if !resp.OK {
+				return nil, fmt.Errorf("client metrics error: %s", resp.Message)
+			}
+			return resp.Data, nil
+		}
+	default:
+		return fail(exitUsage, "unknown metrics target: %s", target)
+	}
+
+	if *watch {
+		return watchMetrics(query, *interval)
+	}
+	data, err := query()
+	if err != nil {
+		return fail(exitError, "%v", err)
+	}
+	return printMetrics(data, *format)
+}
+
+// watchMetrics prints a kv frame every interval until interrupted. Only the
+// first query failure is fatal; later ones are reported and retried.
+func watchMetrics(query func() (map[string]string, error), interval time.Duration) int {
+	data, err := query()
+	if err != nil {
+		return fail(exitError, "%v", err)
+	}
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sigCh)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var prev map[string]float64
+	for {
+		if data != nil {
+			fmt.Printf("--- %s ---\n", time.Now().Format(time.RFC3339))
+			for _, line := range metricsFrame(data, prev) {
+				fmt.Println(line)
+			}
+			prev = numericMetrics(data)
+		}
+		select {
+		case <-sigCh:
+			return exitOK
+		case <-ticker.C:
+		}
+		data, err = query()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+		}
+	}
+}
+
+// metricsFrame renders data as sorted kv lines. Counters (names ending in
+// _total, labels aside) that changed since prev get the difference appended,
+// e.g. "(+1)".
+func metricsFrame(data map[string]string, prev map[string]float64) []string {
+	keys := make([]string, 0, len(data))
+	for k := range data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	lines := make([]string, 0, len(keys))
+	for _, k := range keys {
+		line := k + " " + data[k]
+		if delta, ok := metricDelta(k, data[k], prev); ok && delta != 0 {
+			line += fmt.Sprintf(" (%+g)", delta)
+		}
+		lines = append(lines, line)
 	}
-	return true
+	return lines
 }
 
-func formatUnixUTC(raw string) string {
-	raw = strings.TrimSpace(raw)
-	if raw == "" {
-		return ""
+func metricDelta(key, value string, prev map[string]float64) (float64, bool) {
+	name, _, _ := strings.Cut(key, "{")
+	if !strings.HasSuffix(name, "_total") {
+		return 0, false
 	}
-	seconds, err := strconv.ParseInt(raw, 10, 64)
+	before, ok := prev[key]
+	if !ok {
+		return 0, false
+	}
+	now, err := strconv.ParseFloat(value, 64)
 	if err != nil {
-		return raw
+		return 0, false
 	}
-	return time.Unix(seconds, 0).UTC().Format(time.RFC3339)
+	return now - before, true
 }
 
-func runLogs(args []string) int {
-	target := "agent"
-	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
-		target = args[0]
-		args = args[1:]
-	}
-	fs := flag.NewFlagSet("logs", flag.ContinueOnError)
-	follow := fs.Bool("follow", false, "follow logs (placeholder)")
-	followShort := fs.Bool("f", false, "follow logs (shorthand)")
-	configPath := fs.String("config", defaultConfigPath(), "path to config file")
-	if err := fs.Parse(args); err != nil {
-		return exitUsage
+func numericMetrics(data map[string]string) map[string]float64 {
+	out := make(map[string]float64, len(data))
+	for k, v := range data {
+		if n, err := strconv.ParseFloat(v, 64); err == nil {
+			out[k] = n
+		}
 	}
+	return out
+}
 
-	cfg, err := config.Load(*configPath)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "config load failed: %v\n", err)
-		return exitError
+func validMetricsFormat(format string) bool {
+	switch format {
+	case "kv", "json", "prom":
+		return true
 	}
+	return false
+}
 
-	switch target {
-	case "agent":
-		if *follow || *followShort {
-			return followLogs(cfg)
+// printMetrics renders metrics sorted by key. In prom format non-numeric
+// values such as state become a value label on a gauge of 1.
+func printMetrics(data map[string]string, format string) int {
+	if format == "json" {
+		out, err := json.MarshalIndent(data, "", "  ")
+		if err != nil {
+			return fail(exitError, "metrics encode failed: %v", err)
 		}
-		return printRecentLogs(cfg)
-	case "client":
-		if *follow || *followShort {
-			return followClientLogs(cfg)
+		fmt.Println(string(out))
+		return exitOK
+	}
+	keys := make([]string, 0, len(data))
+	for k := range data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		v := data[k]
+		if format == "prom" {
+			if _, err := strconv.ParseFloat(v, 64); err != nil {
+				fmt.Printf("%s 1\n", withLabel(k, "value", v))
+				continue
+			}
 		}
-		return printRecentClientLogs(cfg)
-	default:
-		fmt.Fprintf(os.Stderr, "unknown logs target: %s\n", target)
-		return exitUsage
+		fmt.Printf("%s %s\n", k, v)
 	}
+	return exitOK
 }
 
-func runMetrics(args []string) int {
-	target := "agent"
+// withLabel adds name="value" to the label set of a metric key, opening one
+// when the key has none.
+func withLabel(key, name, value string) string {
+	label := fmt.Sprintf("%s=%q", name, value)
+	if strings.HasSuffix(key, "}") && strings.Contains(key, "{") {
+		return strings.TrimSuffix(key, "}") + "," + label + "}"
+	}
+	return key + "{" + label + "}"
+}
+
+func runDoctor(defaultPath string, args []string) int {
+	target := "client"
 	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
 		target = args[0]
 		args = args[1:]
 	}
-	fs := flag.NewFlagSet("metrics", flag.ContinueOnError)
-	configPath := fs.String("config", defaultConfigPath(), "path to config file")
-	if err := fs.Parse(args); err != nil {
-		return exitUsage
-	}
-
-	cfg, err := config.Load(*configPath)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "config load failed: %v\n", err)
-		return exitError
+	rest := make([]string, 0, len(args))
+	for _, arg := range args {
+		if arg == "--all" || arg == "-all" {
+			target = "all"
+			continue
+		}
+		rest = append(rest, arg)
 	}
-
 	switch target {
+	case "client":
+		return runClientDoctor(defaultPath, rest)
 	case "agent":
-		resp, err := ipcclient.Query(cfg, "metrics")
+		return runAgentDoctor(defaultPath, rest)
+	case "all":
+		return runAllDoctor(defaultPath, rest)
+	default:
+		return fail(exitUsage, "doctor target must be agent, client, or all")
+	}
+}
+
+func runAllDoctor(defaultPath string, args []string) int {
+	fs := flag.NewFlagSet("doctor --all", flag.ContinueOnError)
+	configPath := fs.String("config", defaultPath, "path to config file")
+	strict := fs.Bool("strict", false, "fail on config keys that match no field")
+	jsonOut := fs.Bool("json", false, "print checks as a JSON object keyed by agent and client")
+	if err := fs.Parse(args); err != nil {
+		return flagFail(err)
+	}
+
+	agentReport, agentErr := agentDoctorChecks(*configPath, "", *strict)
+	clientReport, clientErr := clientDoctorChecks(*configPath, "", *strict)
+	if *jsonOut {
+		agentReport = configFailureReport(agentReport, agentErr)
+		clientReport = configFailureReport(clientReport, clientErr)
+		out, err := json.MarshalIndent(map[string][]doctorCheck{
+			"agent":  agentReport.checks,
+			"client": clientReport.checks,
+		}, "", "  ")
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "metrics query failed: %v\n", err)
-			return exitError
+			return fail(exitError, "doctor encode failed: %v", err)
 		}
-		if !resp.OK {
-			fmt.Fprintf(os.Stderr, "metrics error: %s\n", resp.Message)
+		fmt.Println(string(out))
+		if agentReport.failed() || clientReport.failed() {
 			return exitError
 		}
-		for k, v := range resp.Data {
-			fmt.Printf("%s %s\n", k, v)
-		}
 		return exitOK
-	case "client":
-		resp, err := ipcclientlocal.Query(cfg, "metrics")
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "client metrics query failed: %v\n", err)
-			return exitError
+	}
+
+	fmt.Println("agent:")
+	agentCode := finishDoctor(agentReport, agentErr, false)
+	fmt.Println("client:")
+	clientCode := finishDoctor(clientReport, clientErr, false)
+
+	fmt.Printf("summary: agent %s, client %s\n", doctorResult(agentCode), doctorResult(clientCode))
+	if agentCode != exitOK || clientCode != exitOK {
+		return exitError
+	}
+	return exitOK
+}
+
+func doctorResult(code int) string {
+	if code == exitOK {
+		return "OK"
+	}
+	return "FAIL"
+}
+
+// doctorCheck is one doctor result. Status is ok, warn or fail; only fail
+// makes doctor exit non-zero.
+type doctorCheck struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	Detail string `json:"detail"`
+}
+
+// doctorReport collects checks in run order so they can be rendered as text
+// or JSON once all of them ran.
+type doctorReport struct {
+	checks []doctorCheck
+}
+
+func (r *doctorReport) add(name, status, detail string) {
+	r.checks = append(r.checks, doctorCheck{Name: name, Status: status, Detail: detail})
+}
+
+func (r *doctorReport) ok(name, detail string)   { r.add(name, "ok", detail) }
+func (r *doctorReport) warn(name, detail string) { r.add(name, "warn", detail) }
+func (r *doctorReport) fail(name, detail string) { r.add(name, "fail", detail) }
+
+func (r *doctorReport) failed() bool {
+	for _, check := range r.checks {
+		if check.Status == "fail" {
+			return true
 		}
-		if !resp.OK {
-			fmt.Fprintf(os.Stderr, "client metrics error: %s\n", resp.Message)
-			return exitError
+	}
+	return false
+}
+
+// printText writes one "check <name>: OK|WARN|FAIL (detail)" line per check;
+// warnings and failures go to stderr.
+func (r *doctorReport) printText() {
+	for _, check := range r.checks {
+		line := "check " + check.Name + ": " + strings.ToUpper(check.Status)
+		if check.Detail != "" {
+			line += " (" + check.Detail + ")"
 		}
-		for k, v := range resp.Data {
-			fmt.Printf("%s %s\n", k, v)
+		if check.Status == "ok" {
+			fmt.Println(line)
+		} else {
+			fmt.Fprintln(os.Stderr, line)
 		}
-		return exitOK
-	default:
-		fmt.Fprintf(os.Stderr, "unknown metrics target: %s\n", target)
-		return exitUsage
 	}
 }
 
-func runDoctor(args []string) int {
-	target := "client"
-	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
-		target = args[0]
-		args = args[1:]
+// configFailureReport turns a config load or validation error into a report
+// holding a single failed config check, so JSON output is always an array.
+func configFailureReport(report *doctorReport, err error) *doctorReport {
+	if err == nil {
+		return report
 	}
-	switch target {
-	case "client":
-		return runClientDoctor(args)
-	case "agent":
-		return runAgentDoctor(args)
-	default:
-		fmt.Fprintf(os.Stderr, "doctor target must be agent or client")
-		return exitUsage
+	report = &doctorReport{}
+	report.fail("config", err.Error())
+	return report
+}
+
+// finishDoctor renders a doctor run and returns its exit code.
+func finishDoctor(report *doctorReport, err error, jsonOut bool) int {
+	if !jsonOut {
+		if err != nil {
+			return fail(exitError, "%v", err)
+		}
+		report.printText()
+	} else {
+		report = configFailureReport(report, err)
+		out, err := json.MarshalIndent(report.checks, "", "  ")
+		if err != nil {
+			return fail(exitError, "doctor encode failed: %v", err)
+		}
+		fmt.Println(string(out))
+	}
+	if report.failed() {
+		return exitError
 	}
+	return exitOK
 }
 
-func runConfig(args []string) int {
+func runConfig(defaultPath string, args []string) int {
 	if len(args) == 0 {
 		printConfigUsage()
 		return exitUsage
 	}
 	switch args[0] {
 	case "get":
-		return runConfigGet(args[1:])
+		return runConfigGet(defaultPath, args[1:])
 	case "set":
-		return runConfigSet(args[1:])
+		return runConfigSet(defaultPath, args[1:])
 	case "show":
-		return runConfigShow(args[1:])
+		return runConfigShow(defaultPath, args[1:])
+	case "diff":
+		return runConfigDiff(defaultPath, args[1:])
+	case "backoff-preview":
+		return runConfigBackoffPreview(defaultPath, args[1:])
 	default:
-		fmt.Fprintf(os.Stderr, "unknown config subcommand: %s\n", args[0])
-		printConfigUsage()
-		return exitUsage
+		return failUsage(printConfigUsage, "unknown config subcommand: %s", args[0])
+	}
+}
+
+// loadConfig loads path, rejecting unknown keys when strict is set.
+func loadConfig(path string, strict bool) (*config.Config, error) {
+	if strict {
+		return config.LoadStrict(path)
 	}
+	return config.Load(path)
 }
 
-func runConfigShow(args []string) int {
+func runConfigShow(defaultPath string, args []string) int {
 	fs := flag.NewFlagSet("config show", flag.ContinueOnError)
-	configPath := fs.String("config", defaultConfigPath(), "path to config file")
+	configPath := fs.String("config", defaultPath, "path to config file")
+	strict := fs.Bool("strict", false, "fail on config keys that match no field")
 	if err := fs.Parse(args); err != nil {
-		return exitUsage
+		return flagFail(err)
 	}
 
-	cfg, err := config.Load(*configPath)
+	cfg, err := loadConfig(*configPath, *strict)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "config load failed: %v\n", err)
-		return exitError
+		return fail(exitError, "config load failed: %v", err)
 	}
 
 	out, err := yaml.Marshal(cfg)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "config marshal failed: %v\n", err)
-		return exitError
+		return fail(exitError, "config marshal failed: %v", err)
 	}
 	fmt.Print(string(out))
 	return exitOK
 }
 
-func runConfigGet(args []string) int {
+// runConfigDiff prints every key whose loaded value differs from what the
+// built-in defaults alone would give.
+func runConfigDiff(defaultPath string, args []string) int {
+	fs := flag.NewFlagSet("config diff", flag.ContinueOnError)
+	configPath := fs.String("config", defaultPath, "path to config file")
+	if err := fs.Parse(args); err != nil {
+		return flagFail(err)
+	}
+
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		return fail(exitError, "config load failed: %v", err)
+	}
+	defaults := &config.Config{}
+	config.ApplyDefaults(defaults)
+
+	for _, line := range configDiff(defaults, cfg) {
+		fmt.Println(line)
+	}
+	return exitOK
+}
+
+func runConfigBackoffPreview(defaultPath string, args []string) int {
+	target := ""
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		target = args[0]
+		args = args[1:]
+	}
+	if target != "" && target != "agent" && target != "client" {
+		return fail(exitUsage, "backoff-preview target must be agent or client")
+	}
+	fs := flag.NewFlagSet("config backoff-preview", flag.ContinueOnError)
+	configPath := fs.String("config", defaultPath, "path to config file")
+	attempts := fs.Int("attempts", 10, "number of restart delays to show")
+	if err := fs.Parse(args); err != nil {
+		return flagFail(err)
+	}
+	if *attempts <= 0 {
+		return fail(exitUsage, "attempts must be > 0")
+	}
+
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		return fail(exitError, "config load failed: %v", err)
+	}
+	if target == "" || target == "agent" {
+		printBackoffPreview("agent", cfg.Agent.Restart, *attempts)
+	}
+	if target == "" || target == "client" {
+		printBackoffPreview("client", cfg.Client.Restart, *attempts)
+	}
+	return exitOK
+}
+
+// printBackoffPreview lists the delay before each restart attempt with the
+// range jitter can spread it over.
+func printBackoffPreview(label string, rc config.RestartConfig, attempts int) {
+	fmt.Printf("%s: min %s, factor %g, max %s, jitter %g\n", label,
+		time.Duration(rc.MinDelayMs)*time.Millisecond, rc.Factor, time.Duration(rc.MaxDelayMs)*time.Millisecond, rc.Jitter)
+	steps := restart.NewBackoff(rc).Preview(attempts)
+	if len(steps) == 0 {
+		fmt.Println("  no delay (min_delay_ms is 0)")
+		return
+	}
+	var total time.Duration
+	for i, step := range steps {
+		total += step.Base
+		if step.Low == step.High {
+			fmt.Printf("  %d: %s\n", i+1, step.Base)
+			continue
+		}
+		fmt.Printf("  %d: %s (%s - %s)\n", i+1, step.Base, step.Low, step.High)
+	}
+	fmt.Printf("  total: %s\n", total)
+}
+
+// configDiff returns "key: default -> current" for each differing leaf, in
+// struct order. Empty values are shown as (empty).
+func configDiff(defaults, cfg *config.Config) []string {
+	var lines []string
+	for _, key := range configKeys(reflect.TypeOf(*cfg), "") {
+		before, err := getConfigValue(defaults, key)
+		if err != nil {
+			continue
+		}
+		after, err := getConfigValue(cfg, key)
+		if err != nil || before == after {
+			continue
+		}
+		lines = append(lines, fmt.Sprintf("%s: %s -> %s", key, displayConfigValue(before), displayConfigValue(after)))
+	}
+	return lines
+}
+
+func configKeys(t reflect.Type, prefix string) []string {
+	var keys []string
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := strings.Split(field.Tag.Get("yaml"), ",")[0]
+		if tag == "" {
+			tag = strings.ToLower(field.Name)
+		}
+		if tag == "-" {
+			continue
+		}
+		key := prefix + tag
+		if field.Type.Kind() == reflect.Struct {
+			keys = append(keys, configKeys(field.Type, key+".")...)
+			continue
+		}
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+func displayConfigValue(value string) string {
+	if value == "" {
+		return "(empty)"
+	}
+	return value
+}
+
+func runConfigGet(defaultPath string, args []string) int {
 	fs := flag.NewFlagSet("config get", flag.ContinueOnError)
-	configPath := fs.String("config", defaultConfigPath(), "path to config file")
+	configPath := fs.String("config", defaultPath, "path to config file")
 	if err := fs.Parse(args); err != nil {
-		return exitUsage
+		return flagFail(err)
 	}
 	if fs.NArg() != 1 {
 		printConfigUsage()
@@ -1413,23 +3134,21 @@ func runConfigGet(args []string) int {
 
 	cfg, err := config.Load(*configPath)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "config load failed: %v\n", err)
-		return exitError
+		return fail(exitError, "config load failed: %v", err)
 	}
 	value, err := getConfigValue(cfg, key)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "config get failed: %v\n", err)
-		return exitError
+		return fail(exitError, "config get failed: %v", err)
 	}
 	fmt.Println(value)
 	return exitOK
 }
 
-func runConfigSet(args []string) int {
+func runConfigSet(defaultPath string, args []string) int {
 	fs := flag.NewFlagSet("config set", flag.ContinueOnError)
-	configPath := fs.String("config", defaultConfigPath(), "path to config file")
+	configPath := fs.String("config", defaultPath, "path to config file")
 	if err := fs.Parse(args); err != nil {
-		return exitUsage
+		return flagFail(err)
 	}
 	if fs.NArg() != 2 {
 		printConfigUsage()
@@ -1440,18 +3159,15 @@ func runConfigSet(args []string) int {
 
 	cfg, err := config.Load(*configPath)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "config load failed: %v\n", err)
-		return exitError
+		return fail(exitError, "config load failed: %v", err)
 	}
 	if err := setConfigValue(cfg, key, value); err != nil {
-		fmt.Fprintf(os.Stderr, "config set failed: %v\n", err)
-		return exitError
+		return fail(exitError, "config set failed: %v", err)
 	}
 	if err := config.Save(*configPath, cfg); err != nil {
-		fmt.Fprintf(os.Stderr, "config save failed: %v\n", err)
-		return exitError
+		return fail(exitError, "config save failed: %v", err)
 	}
-	fmt.Printf("updated %s\n", key)
+	infof("updated %s\n", key)
 	return exitOK
 }
 
@@ -1468,11 +3184,26 @@ func getConfigValue(cfg *config.Config, key string) (string, error) {
 			return "true", nil
 		}
 		return "false", nil
+	case reflect.Pointer:
+		elem := field.Type().Elem().Kind()
+		if elem != reflect.Bool && elem != reflect.Int {
+			return "", fmt.Errorf("unsupported pointer type for %s", key)
+		}
+		if field.IsNil() {
+			return "", nil
+		}
+		if elem == reflect.Int {
+			return fmt.Sprintf("%d", field.Elem().Int()), nil
+		}
+		return strconv.FormatBool(field.Elem().Bool()), nil
 	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
 		return fmt.Sprintf("%d", field.Int()), nil
 	case reflect.Float32, reflect.Float64:
 		return fmt.Sprintf("%g", field.Float()), nil
 	case reflect.Slice:
+		if forwards, ok := field.Interface().([]config.Forward); ok {
+			return strings.Join(config.ForwardSpecs(forwards), ","), nil
+		}
 		if field.Type().Elem().Kind() == reflect.String {
 			out := make([]string, field.Len())
 			for i := 0; i < field.Len(); i++ {
@@ -1480,6 +3211,16 @@ func getConfigValue(cfg *config.Config, key string) (string, error) {
 			}
 			return strings.Join(out, ","), nil
 		}
+	case reflect.Map:
+		if field.Type().Key().Kind() == reflect.String && field.Type().Elem().Kind() == reflect.String {
+			out := make([]string, 0, field.Len())
+			iter := field.MapRange()
+			for iter.Next() {
+				out = append(out, iter.Key().String()+"="+iter.Value().String())
+			}
+			sort.Strings(out)
+			return strings.Join(out, ","), nil
+		}
 	}
 	return "", fmt.Errorf("unsupported field type for %s", key)
 }
@@ -1503,6 +3244,24 @@ func setConfigValue(cfg *config.Config, key, value string) error {
 		}
 		field.SetBool(parsed)
 		return nil
+	case reflect.Pointer:
+		switch field.Type().Elem().Kind() {
+		case reflect.Bool:
+			parsed, err := strconv.ParseBool(value)
+			if err != nil {
+				return fmt.Errorf("invalid bool for %s", key)
+			}
+			field.Set(reflect.ValueOf(&parsed))
+			return nil
+		case reflect.Int:
+			parsed, err := strconv.Atoi(value)
+			if err != nil {
+				return fmt.Errorf("invalid int for %s", key)
+			}
+			field.Set(reflect.ValueOf(&parsed))
+			return nil
+		}
+		return fmt.Errorf("unsupported pointer type for %s", key)
 	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
 		parsed, err := strconv.ParseInt(value, 10, 64)
 		if err != nil {
@@ -1518,9 +3277,15 @@ func setConfigValue(cfg *config.Config, key, value string) error {
 		field.SetFloat(parsed)
 		return nil
 	case reflect.Slice:
+		if forwards, ok := field.Addr().Interface().(*[]config.Forward); ok {
+			return setForwardsValue(forwards, key, value)
+		}
 		if field.Type().Elem().Kind() != reflect.String {
 			return fmt.Errorf("unsupported slice type for %s", key)
 		}
+		if strings.HasPrefix(value, "+") || strings.HasPrefix(value, "-") {
+			return editStringSlice(field, key, value)
+		}
 		items := splitCSV(value)
 		slice := reflect.MakeSlice(field.Type(), len(items), len(items))
 		for i, item := range items {
@@ -1528,11 +3293,71 @@ func setConfigValue(cfg *config.Config, key, value string) error {
 		}
 		field.Set(slice)
 		return nil
+	case reflect.Map:
+		if field.Type().Key().Kind() != reflect.String || field.Type().Elem().Kind() != reflect.String {
+			return fmt.Errorf("unsupported map type for %s", key)
+		}
+		items := splitCSV(value)
+		m := reflect.MakeMapWithSize(field.Type(), len(items))
+		for _, item := range items {
+			k, v, ok := strings.Cut(item, "=")
+			if !ok || strings.TrimSpace(k) == "" {
+				return fmt.Errorf("invalid entry %q for %s (want KEY=VALUE)", item, key)
+			}
+			m.SetMapIndex(reflect.ValueOf(strings.TrimSpace(k)), reflect.ValueOf(v))
+		}
+		field.Set(m)
+		return nil
 	default:
 		return fmt.Errorf("unsupported field type for %s", key)
 	}
 }
 
+// editStringSlice appends ("+item") or removes ("-item") a single element,
+// leaving the rest of the slice intact. Appending an existing item is a no-op.
+func editStringSlice(field reflect.Value, key, value string) error {
+	item := strings.TrimSpace(value[1:])
+	if item == "" {
+		return fmt.Errorf("missing item after %q for %s", value[:1], key)
+	}
+	current := make([]string, 0, field.Len()+1)
+	found := false
+	for i := 0; i < field.Len(); i++ {
+		existing := field.Index(i).String()
+		if strings.TrimSpace(existing) == item {
+			found = true
+			if value[0] == '-' {
+				continue
+			}
+		}
+		current = append(current, existing)
+	}
+	if value[0] == '+' && !found {
+		current = append(current, item)
+	}
+	slice := reflect.MakeSlice(field.Type(), len(current), len(current))
+	for i, v := range current {
+		slice.Index(i).SetString(v)
+	}
+	field.Set(slice)
+	return nil
+}
+
+// setForwardsValue edits a forward list by spec, keeping the name and comment
+// of every forward that stays.
+func setForwardsValue(forwards *[]config.Forward, key, value string) error {
+	specs := config.ForwardSpecs(*forwards)
+	if strings.HasPrefix(value, "+") || strings.HasPrefix(value, "-") {
+		if err := editStringSlice(reflect.ValueOf(&specs).Elem(), key, value); err != nil {
+			return err
+		}
+	} else {
+		specs = splitCSV(value)
+	}
+	*forwards = config.WithForwardSpecs(*forwards, specs)
+	return nil
+}
+
 func lookupConfigField(cfg *config.Config, key string) (reflect.Value, error) {
 	parts := strings.Split(key, ".")
 	current := reflect.ValueOf(cfg)
@@ -1585,123 +3410,132 @@ func splitCSV(value string) []string {
 	return out
 }
 
-func runAgentDoctor(args []string) int {
+func runAgentDoctor(defaultPath string, args []string) int {
 	fs := flag.NewFlagSet("agent doctor", flag.ContinueOnError)
-	configPath := fs.String("config", defaultConfigPath(), "path to config file")
+	configPath := fs.String("config", defaultPath, "path to config file")
 	remoteForward := fs.String("remote-forward", "", "ssh remote forward spec (optional)")
+	strict := fs.Bool("strict", false, "fail on config keys that match no field")
+	jsonOut := fs.Bool("json", false, "print checks as a JSON array")
 	if err := fs.Parse(args); err != nil {
-		return exitUsage
+		return flagFail(err)
 	}
+	report, err := agentDoctorChecks(*configPath, *remoteForward, *strict)
+	return finishDoctor(report, err, *jsonOut)
+}
 
-	cfg, err := config.Load(*configPath)
+// agentDoctorChecks is the agent side of clientDoctorChecks.
+func agentDoctorChecks(configPath, remoteForward string, strict bool) (*doctorReport, error) {
+	cfg, err := loadConfig(configPath, strict)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "config load failed: %v\n", err)
-		return exitError
+		return nil, fmt.Errorf("config load failed: %v", err)
 	}
-	if strings.TrimSpace(*remoteForward) != "" {
-		config.SetRemoteForwards(cfg, []string{*remoteForward})
+	if strings.TrimSpace(remoteForward) != "" {
+		config.SetRemoteForwards(cfg, []string{remoteForward})
 	}
 
 	if err := config.ValidateAgent(cfg); err != nil {
-		fmt.Fprintf(os.Stderr, "config validation failed: %v\n", err)
-		return exitError
-	}
-
-	ok := true
-	if _, err := exec.LookPath("ssh"); err != nil {
-		fmt.Fprintf(os.Stderr, "check ssh binary: FAIL (%v)\n", err)
-		ok = false
-	} else {
-		fmt.Println("check ssh binary: OK")
-	}
-
-	if cfg.SSH.IdentityFile != "" {
-		path := expandTilde(cfg.SSH.IdentityFile)
-		if _, err := os.Stat(path); err != nil {
-			fmt.Fprintf(os.Stderr, "check identity file: FAIL (%v)\n", err)
-			ok = false
-		} else {
-			fmt.Println("check identity file: OK")
-		}
+		return nil, fmt.Errorf("config validation failed: %v", err)
 	}
 
-	if _, err := net.LookupHost(cfg.SSH.Host); err != nil {
-		fmt.Fprintf(os.Stderr, "check host resolve: FAIL (%v)\n", err)
-		ok = false
-	} else {
-		fmt.Println("check host resolve: OK")
-	}
+	report := &doctorReport{}
+	checkSSHBinary(report)
+	checkMonitors(report)
+	checkDeprecations(report, cfg)
+	checkIdentityFile(report, cfg.SSH.IdentityFile)
+	checkIdentityAgent(report, cfg.SSH.IdentityAgent)
+	checkHostResolve(report, cfg.SSH.Host, lookupHost)
+	checkForwardDirection(report, "remote", config.NormalizeRemoteForwards(cfg))
 
 	forward := firstRemoteForward(cfg)
 	if forward != "" {
 		bindHost, bindPort, err := parseRemoteForward(forward)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "check remote forward: FAIL (%v)\n", err)
-			ok = false
+			report.fail("remote forward", err.Error())
 		} else {
 			if !isLoopbackHost(bindHost) && !isWildcardHost(bindHost) {
-				fmt.Fprintf(os.Stderr, "check remote bind host: WARN (non-local bind %s)\n", bindHost)
+				report.warn("remote bind host", "non-local bind "+bindHost)
 			} else {
-				fmt.Println("check remote bind host: OK")
+				report.ok("remote bind host", "")
 			}
 			if _, err := strconv.Atoi(bindPort); err != nil {
-				fmt.Fprintf(os.Stderr, "check remote bind port: FAIL (%v)\n", err)
-				ok = false
+				report.fail("remote bind port", err.Error())
 			} else {
-				fmt.Println("check remote bind port: OK")
+				report.ok("remote bind port", "")
 			}
 		}
 	}
-
-	if !ok {
-		return exitError
-	}
-	return exitOK
+	return report, nil
 }
 
-func printRecentLogs(cfg *config.Config) int {
+func printRecentLogs(cfg *config.Config, filter logFilter) int {
 	resp, err := ipcclient.Query(cfg, "logs")
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "logs query failed: %v\n", err)
 		fmt.Fprintln(os.Stderr, "falling back to log file")
-		return printLogFileFallback(cfg, "agent")
+		return printLogFileFallback(cfg, "agent", filter)
 	}
 	if !resp.OK {
 		fmt.Fprintf(os.Stderr, "logs error: %s\n", resp.Message)
 		fmt.Fprintln(os.Stderr, "falling back to log file")
-		return printLogFileFallback(cfg, "agent")
+		return printLogFileFallback(cfg, "agent", filter)
 	}
 	if len(resp.Logs) == 0 {
-		return printLogFileFallback(cfg, "agent")
-	}
-	for _, line := range resp.Logs {
-		fmt.Println(line)
+		return printLogFileFallback(cfg, "agent", filter)
 	}
+	filter.print(filter.apply(resp.Logs, time.Now()))
 	return exitOK
 }
 
-func printRecentClientLogs(cfg *config.Config) int {
+func printRecentClientLogs(cfg *config.Config, filter logFilter) int {
 	resp, err := ipcclientlocal.Query(cfg, "logs")
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "client logs query failed: %v\n", err)
 		fmt.Fprintln(os.Stderr, "falling back to client log file")
-		return printLogFileFallback(cfg, "client")
+		return printLogFileFallback(cfg, "client", filter)
 	}
 	if !resp.OK {
 		fmt.Fprintf(os.Stderr, "client logs error: %s\n", resp.Message)
 		fmt.Fprintln(os.Stderr, "falling back to client log file")
-		return printLogFileFallback(cfg, "client")
+		return printLogFileFallback(cfg, "client", filter)
 	}
 	if len(resp.Logs) == 0 {
-		return printLogFileFallback(cfg, "client")
+		return printLogFileFallback(cfg, "client", filter)
 	}
-	for _, line := range resp.Logs {
-		fmt.Println(line)
+	filter.print(filter.apply(resp.Logs, time.Now()))
+	return exitOK
+}
+
+// printSSHStderr prints ssh's own stderr as buffered by the running agent or
+// client. There is no file fallback; the lines are only kept in memory.
+func printSSHStderr(cfg *config.Config, target string, filter logFilter) int {
+	var lines []string
+	switch target {
+	case "agent":
+		resp, err := ipcclient.Query(cfg, "ssh_stderr")
+		if err != nil {
+			return fail(exitError, "ssh stderr query failed: %v", err)
+		}
+		if !resp.OK {
+			return fail(exitError, "ssh stderr error: %s", resp.Message)
+		}
+		lines = resp.Logs
+	case "client":
+		resp, err := ipcclientlocal.Query(cfg, "ssh_stderr")
+		if err != nil {
+			return fail(exitError, "client ssh stderr query failed: %v", err)
+		}
+		if !resp.OK {
+			return fail(exitError, "client ssh stderr error: %s", resp.Message)
+		}
+		lines = resp.Logs
+	default:
+		return fail(exitUsage, "unknown logs target: %s", target)
 	}
+	filter.print(filter.apply(lines, time.Now()))
 	return exitOK
 }
 
-func printLogFileFallback(cfg *config.Config, target string) int {
+func printLogFileFallback(cfg *config.Config, target string, filter logFilter) int {
 	var logPath string
 	var err error
 	switch target {
@@ -1713,28 +3547,40 @@ func printLogFileFallback(cfg *config.Config, target string) int {
 		return exitError
 	}
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "resolve %s log path failed: %v\n", target, err)
-		return exitError
+		return fail(exitError, "resolve %s log path failed: %v", target, err)
+	}
+	now := time.Now()
+	var lines []string
+	if filter.since > 0 {
+		// With a cutoff the time window bounds the output instead of a line count.
+		lines, err = tailSince(logPath, now.Add(-filter.since), func(line string) (time.Time, bool) {
+			return logLineTime(line, filter.timeLayout, filter.timeLocal)
+		})
+	} else {
+		lines, err = tailLines(logPath, 200)
 	}
-	lines, err := tailLines(logPath, 200)
 	if err != nil {
 		if errors.Is(err, os.ErrNotExist) {
 			fmt.Printf("no logs (missing log file: %s)\n", logPath)
 			return exitOK
 		}
-		fmt.Fprintf(os.Stderr, "open log file failed: %v\n", err)
-		return exitError
+		return fail(exitError, "open log file failed: %v", err)
 	}
+	lines = filter.apply(lines, now)
 	if len(lines) == 0 {
 		fmt.Println("no logs")
 		return exitOK
 	}
-	for _, line := range lines {
-		fmt.Println(line)
-	}
+	filter.print(lines)
 	return exitOK
 }
 
+// tailChunkSize is how much the tail readers read per step when seeking backwards.
+const tailChunkSize = 64 * 1024
+
+// tailLines returns the last limit lines of path. It reads backwards from the
+// end in chunks, so the cost follows the size of the tail rather than the
+// whole file.
 func tailLines(path string, limit int) ([]string, error) {
 	f, err := os.Open(path)
 	if err != nil {
@@ -1745,23 +3591,290 @@ func tailLines(path string, limit int) ([]string, error) {
 	if limit <= 0 {
 		return nil, nil
 	}
-	lines := make([]string, 0, limit)
-	scanner := bufio.NewScanner(f)
-	for scanner.Scan() {
-		line := scanner.Text()
-		if len(lines) >= limit {
-			copy(lines, lines[1:])
-			lines[len(lines)-1] = line
-			continue
-		}
-		lines = append(lines, line)
+	seen := 0
+	lines, err := readLinesBackward(f, func(chunk []string) bool {
+		seen += len(chunk)
+		return seen >= limit
+	})
+	if err != nil {
+		return nil, err
 	}
-	if err := scanner.Err(); err != nil {
+	if len(lines) > limit {
+		lines = lines[len(lines)-limit:]
+	}
+	return lines, nil
+}
+
+// tailSince returns the end of path back to the first chunk whose first
+// stamped line is older than cutoff, so rpa logs --since reads only about as
+// much as it prints. Older lines in that chunk are left for logFilter to drop.
+func tailSince(path string, cutoff time.Time, stamp func(line string) (time.Time, bool)) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return readLinesBackward(f, func(chunk []string) bool {
+		for _, line := range chunk {
+			if at, ok := stamp(line); ok {
+				return at.Before(cutoff)
+			}
+		}
+		return false
+	})
+}
+
+// readLinesBackward reads f from the end in tailChunkSize steps and returns
+// its complete lines, oldest first. After each step enough sees the lines
+// that step completed; returning true stops the read. A line longer than a
+// chunk is carried over until its start is found, so there is no line limit.
+func readLinesBackward(f *os.File, enough func(chunk []string) bool) ([]string, error) {
+	info, err := f.Stat()
+	if err != nil {
 		return nil, err
 	}
+	pos := info.Size()
+	atEnd := true
+	// carry is the start of the earliest line seen so far, still missing the
+	// part that lies in the next (earlier) chunk.
+	var carry []byte
+	var lines []string
+	for pos > 0 {
+		step := int64(tailChunkSize)
+		if pos < step {
+			step = pos
+		}
+		pos -= step
+		data := make([]byte, step, step+int64(len(carry)))
+		if _, err := f.ReadAt(data, pos); err != nil && err != io.EOF {
+			return nil, err
+		}
+		data = append(data, carry...)
+		carry = nil
+		if pos > 0 {
+			cut := bytes.IndexByte(data, '\n')
+			if cut < 0 {
+				carry = data
+				continue
+			}
+			carry, data = data[:cut], data[cut+1:]
+		}
+		if atEnd {
+			data = bytes.TrimSuffix(data, []byte("\n"))
+			atEnd = false
+		}
+		if len(data) == 0 && pos > 0 {
+			continue
+		}
+		chunk := strings.Split(string(data), "\n")
+		for i, line := range chunk {
+			chunk[i] = strings.TrimSuffix(line, "\r")
+		}
+		lines = append(chunk, lines...)
+		if enough(chunk) {
+			break
+		}
+	}
 	return lines, nil
 }
 
+// logFilter narrows log output for rpa logs. The zero value keeps every line.
+type logFilter struct {
+	since   time.Duration
+	pattern *regexp.Regexp
+	// timeLayout and timeLocal describe how lines were stamped; the zero
+	// values mean RFC3339 in UTC.
+	timeLayout string
+	timeLocal  bool
+	// count prints a tally per event instead of the lines themselves.
+	count bool
+}
+
+func (f logFilter) print(lines []string) {
+	if f.count {
+		printEventCounts(lines)
+		return
+	}
+	for _, line := range lines {
+		fmt.Println(line)
+	}
+}
+
+// textLevels maps the level tags of text log lines to level names.
+var textLevels = map[string]string{
+	"TRC": "TRACE", "DBG": "DEBUG", "INF": "INFO", "WRN": "WARN", "ERR": "ERROR", "FTL": "FATAL", "PNC": "PANIC",
+}
+
+// logLineEvent returns the event name and upper-case level of a JSON or text
+// log line.
+func logLineEvent(line string) (string, string, bool) {
+	trimmed := strings.TrimSpace(line)
+	if strings.HasPrefix(trimmed, "{") {
+		var entry struct {
+			Level string `json:"level"`
+			Event string `json:"event"`
+		}
+		if err := json.Unmarshal([]byte(trimmed), &entry); err != nil || entry.Event == "" {
+			return "", "", false
+		}
+		return entry.Event, strings.ToUpper(entry.Level), true
+	}
+	event, level := "", ""
+	for _, field := range strings.Fields(trimmed) {
+		if name, ok := textLevels[field]; ok && level == "" {
+			level = name
+		}
+		if value, ok := strings.CutPrefix(field, "event="); ok {
+			event = value
+		}
+	}
+	return event, level, event != ""
+}
+
+// printEventCounts prints one "event: total (LEVEL n, ...)" line per event,
+// most frequent first.
+func printEventCounts(lines []string) {
+	type tally struct {
+		name   string
+		total  int
+		levels map[string]int
+	}
+	byEvent := map[string]*tally{}
+	unparsed := 0
+	for _, line := range lines {
+		event, level, ok := logLineEvent(line)
+		if !ok {
+			unparsed++
+			continue
+		}
+		t := byEvent[event]
+		if t == nil {
+			t = &tally{name: event, levels: map[string]int{}}
+			byEvent[event] = t
+		}
+		t.total++
+		if level == "" {
+			level = "UNKNOWN"
+		}
+		t.levels[level]++
+	}
+	tallies := make([]*tally, 0, len(byEvent))
+	for _, t := range byEvent {
+		tallies = append(tallies, t)
+	}
+	sort.Slice(tallies, func(i, j int) bool {
+		if tallies[i].total != tallies[j].total {
+			return tallies[i].total > tallies[j].total
+		}
+		return tallies[i].name < tallies[j].name
+	})
+	for _, t := range tallies {
+		levels := make([]string, 0, len(t.levels))
+		for level := range t.levels {
+			levels = append(levels, level)
+		}
+		sort.Slice(levels, func(i, j int) bool {
+			if t.levels[levels[i]] != t.levels[levels[j]] {
+				return t.levels[levels[i]] > t.levels[levels[j]]
+			}
+			return levels[i] < levels[j]
+		})
+		parts := make([]string, 0, len(levels))
+		for _, level := range levels {
+			parts = append(parts, fmt.Sprintf("%s %d", level, t.levels[level]))
+		}
+		fmt.Printf("%s: %d (%s)\n", t.name, t.total, strings.Join(parts, ", "))
+	}
+	if unparsed > 0 {
+		fmt.Printf("lines without an event: %d\n", unparsed)
+	}
+}
+
+// apply keeps lines logged within since of now that match pattern. Lines
+// without a parseable timestamp pass the since check so nothing is silently hidden.
+func (f logFilter) apply(lines []string, now time.Time) []string {
+	if f.since <= 0 && f.pattern == nil {
+		return lines
+	}
+	out := make([]string, 0, len(lines))
+	for _, line := range lines {
+		if f.match(line, now) {
+			out = append(out, line)
+		}
+	}
+	return out
+}
+
+func (f logFilter) match(line string, now time.Time) bool {
+	if f.pattern != nil && !f.pattern.MatchString(line) {
+		return false
+	}
+	if f.since > 0 {
+		if at, ok := logLineTime(line, f.timeLayout, f.timeLocal); ok && at.Before(now.Add(-f.since)) {
+			return false
+		}
+	}
+	return true
+}
+
+// logLineTime reads the timestamp from a JSON log line ("time" field) or a
+// text log line (leading timestamp). Layouts without a zone are read in UTC,
+// or in the local zone when local is set (logging.local_time).
+func logLineTime(line, layout string, local bool) (time.Time, bool) {
+	if layout == "" {
+		layout = time.RFC3339
+	}
+	loc := time.UTC
+	if local {
+		loc = time.Local
+	}
+	trimmed := strings.TrimSpace(line)
+	if strings.HasPrefix(trimmed, "{") {
+		var entry struct {
+			Time string `json:"time"`
+		}
+		if err := json.Unmarshal([]byte(trimmed), &entry); err != nil || entry.Time == "" {
+			return time.Time{}, false
+		}
+		at, err := time.ParseInLocation(layout, entry.Time, loc)
+		return at, err == nil
+	}
+	// A text line starts with the stamp, which has as many spaces as the
+	// layout plus one for each "_" padding element that printed as a space.
+	words := strings.Count(layout, " ") + 1
+	parts := strings.Split(trimmed, " ")
+	for n := words; n <= words+strings.Count(layout, "_") && n <= len(parts); n++ {
+		if at, err := time.ParseInLocation(layout, strings.Join(parts[:n], " "), loc); err == nil {
+			return at, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// serviceAnswering reports whether a live instance already serves the IPC
+// socket for cfg. A stale socket file with nobody listening does not count.
+func serviceAnswering(cfg *config.Config, target string) (string, bool) {
+	var socket string
+	var err error
+	answered := false
+	switch target {
+	case "agent":
+		socket, err = config.SocketPath(cfg)
+		if err == nil {
+			resp, qerr := ipcclient.Query(cfg, "status")
+			answered = qerr == nil && resp.OK
+		}
+	case "client":
+		socket, err = config.ClientSocketPath(cfg)
+		if err == nil {
+			resp, qerr := ipcclientlocal.Query(cfg, "status")
+			answered = qerr == nil && resp.OK
+		}
+	}
+	return socket, err == nil && answered
+}
+
 func waitForServiceReady(cfg *config.Config, target string, timeout time.Duration) error {
 	deadline := time.Now().Add(timeout)
 	var lastErr error
@@ -1802,6 +3915,39 @@ func waitForServiceReady(cfg *config.Config, target string, timeout time.Duratio
 	return lastErr
 }
 
+// reconnectPoll is how often --wait polls status; tests shorten it.
+var reconnectPoll = 500 * time.Millisecond
+
+// waitForReconnect polls status until last_success_unix moves past since,
+// which means ssh came back up after the forward change.
+func waitForReconnect(cfg *config.Config, target string, since int64, timeout time.Duration) int {
+	deadline := time.Now().Add(timeout)
+	lastClass := ""
+	for time.Now().Before(deadline) {
+		var lastSuccess time.Time
+		ok := false
+		switch target {
+		case "agent":
+			if st, err := ipcclient.StatusTyped(cfg); err == nil {
+				lastSuccess, lastClass, ok = st.LastSuccess, st.LastClass, true
+			}
+		case "client":
+			if st, err := ipcclientlocal.StatusTyped(cfg); err == nil {
+				lastSuccess, lastClass, ok = st.LastSuccess, st.LastClass, true
+			}
+		}
+		if ok && lastSuccess.Unix() > since {
+			infof("%s reconnected\n", target)
+			return exitOK
+		}
+		time.Sleep(reconnectPoll)
+	}
+	if lastClass != "" && lastClass != "clean" {
+		return fail(exitError, "%s did not reconnect within %s (last_class: %s)", target, timeout, lastClass)
+	}
+	return fail(exitError, "%s did not reconnect within %s", target, timeout)
+}
+
 func printLaunchdSummary(label string) {
 	output, err := launchd.Print(label)
 	if err != nil {
@@ -1814,10 +3960,31 @@ func printLaunchdSummary(label string) {
 	if strings.TrimSpace(output) == "" {
 		return
 	}
+	if summary := launchd.ParsePrint(output).Summary(); summary != "" {
+		fmt.Fprintf(os.Stderr, "launchd status: %s\n", summary)
+		return
+	}
 	fmt.Fprintln(os.Stderr, "launchd status (last 40 lines):")
 	fmt.Fprintln(os.Stderr, tailTextLines(output, 40))
 }
 
+// launchdPID returns the pid launchd reports for the service, or 0 when it
+// is not loaded or launchctl is unavailable.
+func launchdPID(label string, cfg *config.Config) int {
+	service := cfg.Agent.LaunchdLabel
+	if label == "client" {
+		service = cfg.Client.LaunchdLabel
+	}
+	if service == "" {
+		return 0
+	}
+	st, err := launchd.Status(service)
+	if err != nil {
+		return 0
+	}
+	return st.PID
+}
+
 func tailTextLines(text string, limit int) string {
 	if limit <= 0 {
 		return ""
@@ -1832,22 +3999,46 @@ func tailTextLines(text string, limit int) string {
 	return strings.TrimRight(strings.Join(lines, "\n"), "\n")
 }
 
-func followLogs(cfg *config.Config) int {
+// notifyDetach registers ch for the signals that end --attach; tests replace it.
+var notifyDetach = func(ch chan<- os.Signal) { signal.Notify(ch, os.Interrupt, syscall.SIGTERM) }
+
+// attachLogs follows the service log until Ctrl+C. Only this process exits;
+// the launchd job keeps running.
+func attachLogs(cfg *config.Config, target string) int {
+	sigCh := make(chan os.Signal, 1)
+	notifyDetach(sigCh)
+	defer signal.Stop(sigCh)
+
+	done := make(chan int, 1)
+	go func() {
+		if target == "client" {
+			done <- followClientLogs(cfg, logFilter{})
+			return
+		}
+		done <- followLogs(cfg, logFilter{})
+	}()
+	select {
+	case code := <-done:
+		return code
+	case <-sigCh:
+		infof("%s up: detached; the %s keeps running (stop it with rpa %s down)\n", target, target, target)
+		return exitOK
+	}
+}
+
+func followLogs(cfg *config.Config, filter logFilter) int {
 	logPath, err := config.LogPath(cfg)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "resolve log path failed: %v\n", err)
-		return exitError
+		return fail(exitError, "resolve log path failed: %v", err)
 	}
 	f, err := os.Open(logPath)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "open log file failed: %v\n", err)
-		return exitError
+		return fail(exitError, "open log file failed: %v", err)
 	}
 	defer f.Close()
 
 	if _, err := f.Seek(0, 2); err != nil {
-		fmt.Fprintf(os.Stderr, "seek log file failed: %v\n", err)
-		return exitError
+		return fail(exitError, "seek log file failed: %v", err)
 	}
 
 	reader := bufio.NewReader(f)
@@ -1858,29 +4049,28 @@ func followLogs(cfg *config.Config) int {
 				time.Sleep(300 * time.Millisecond)
 				continue
 			}
-			fmt.Fprintf(os.Stderr, "read log file failed: %v\n", err)
-			return exitError
+			return fail(exitError, "read log file failed: %v", err)
+		}
+		if !filter.match(line, time.Now()) {
+			continue
 		}
 		fmt.Print(line)
 	}
 }
 
-func followClientLogs(cfg *config.Config) int {
+func followClientLogs(cfg *config.Config, filter logFilter) int {
 	logPath, err := config.ClientLogPath(cfg)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "resolve client log path failed: %v\n", err)
-		return exitError
+		return fail(exitError, "resolve client log path failed: %v", err)
 	}
 	f, err := os.Open(logPath)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "open client log file failed: %v\n", err)
-		return exitError
+		return fail(exitError, "open client log file failed: %v", err)
 	}
 	defer f.Close()
 
 	if _, err := f.Seek(0, 2); err != nil {
-		fmt.Fprintf(os.Stderr, "seek client log file failed: %v\n", err)
-		return exitError
+		return fail(exitError, "seek client log file failed: %v", err)
 	}
 
 	reader := bufio.NewReader(f)
@@ -1891,8 +4081,10 @@ func followClientLogs(cfg *config.Config) int {
 				time.Sleep(300 * time.Millisecond)
 				continue
 			}
-			fmt.Fprintf(os.Stderr, "read client log file failed: %v\n", err)
-			return exitError
+			return fail(exitError, "read client log file failed: %v", err)
+		}
+		if !filter.match(line, time.Now()) {
+			continue
 		}
 		fmt.Print(line)
 	}
@@ -1905,11 +4097,21 @@ func ensureDir(dir string) error {
 	return os.MkdirAll(dir, 0o700)
 }
 
+func flagWasSet(fs *flag.FlagSet, name string) bool {
+	set := false
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
 func expandTilde(path string) string {
 	if path == "" || path[0] != '~' {
 		return path
 	}
-	home, err := os.UserHomeDir()
+	home, err := config.UserHomeDir()
 	if err != nil {
 		return path
 	}
@@ -1919,6 +4121,21 @@ func expandTilde(path string) string {
 	return filepath.Join(home, path[2:])
 }
 
+// printForwardLabels lists the name and comment of each active forward that
+// has one in the config, under the forwards line of status.
+func printForwardLabels(active string, forwards []config.Forward) {
+	labels := config.ForwardLabels(forwards)
+	if len(labels) == 0 {
+		return
+	}
+	for _, spec := range strings.Split(active, ",") {
+		spec = strings.TrimSpace(spec)
+		if label, ok := labels[spec]; ok {
+			fmt.Printf("    %s: %s\n", spec, label)
+		}
+	}
+}
+
 func firstLocalForward(cfg *config.Config) string {
 	forwards := config.NormalizeLocalForwards(cfg)
 	if len(forwards) == 0 {
@@ -1936,27 +4153,168 @@ func firstRemoteForward(cfg *config.Config) string {
 }
 
 func parseLocalForward(spec string) (string, string, error) {
-	parts := strings.Split(spec, ":")
-	switch len(parts) {
-	case 3:
-		return "127.0.0.1", parts[0], nil
-	case 4:
-		return parts[0], parts[1], nil
-	default:
+	host, port, err := config.ForwardListen(spec)
+	if err != nil {
 		return "", "", fmt.Errorf("invalid local forward: %s", spec)
 	}
+	return host, port, nil
 }
 
 func parseRemoteForward(spec string) (string, string, error) {
-	parts := strings.Split(spec, ":")
-	switch len(parts) {
-	case 3:
-		return "127.0.0.1", parts[0], nil
-	case 4:
-		return parts[0], parts[1], nil
-	default:
+	host, port, err := config.ForwardListen(spec)
+	if err != nil {
 		return "", "", fmt.Errorf("invalid remote forward: %s", spec)
 	}
+	return host, port, nil
+}
+
+func checkSSHBinary(report *doctorReport) {
+	if _, err := exec.LookPath("ssh"); err != nil {
+		report.fail("ssh binary", err.Error())
+		return
+	}
+	report.ok("ssh binary", sshutil.Version())
+}
+
+func checkIdentityFile(report *doctorReport, identityFile string) {
+	if identityFile == "" {
+		return
+	}
+	path := expandTilde(identityFile)
+	if info, err := os.Stat(path); err != nil {
+		report.fail("identity file", err.Error())
+	} else if loose := looseKeyMode(info.Mode()); loose != "" {
+		report.warn("identity file", fmt.Sprintf("%s; ssh ignores keys readable by others, run `chmod 600 %s`", loose, path))
+	} else {
+		report.ok("identity file", "")
+	}
+}
+
+// checkIdentityAgent verifies the configured agent socket exists. The
+// SSH_AUTH_SOCK keyword (as understood by ssh) is resolved from the environment.
+func checkIdentityAgent(report *doctorReport, agent string) {
+	agent = strings.TrimSpace(agent)
+	if agent == "" || strings.EqualFold(agent, "none") {
+		return
+	}
+	path := agent
+	if agent == "SSH_AUTH_SOCK" || agent == "$SSH_AUTH_SOCK" {
+		path = os.Getenv("SSH_AUTH_SOCK")
+		if path == "" {
+			report.fail("identity agent", "SSH_AUTH_SOCK is not set")
+			return
+		}
+	}
+	info, err := os.Stat(expandTilde(path))
+	if err != nil {
+		report.fail("identity agent", err.Error())
+		return
+	}
+	if info.Mode()&os.ModeSocket == 0 {
+		report.fail("identity agent", path+" is not a socket")
+		return
+	}
+	report.ok("identity agent", "")
+}
+
+// slowResolveThreshold is a var so tests can shorten it.
+var slowResolveThreshold = 2 * time.Second
+
+// lookupHost resolves the ssh host for doctor; tests replace it with a stub.
+var lookupHost = net.LookupHost
+
+// checkHostResolve times a host lookup and warns when it is slower than
+// slowResolveThreshold. A slow lookup still counts as a pass.
+func checkHostResolve(report *doctorReport, host string, lookup func(string) ([]string, error)) {
+	start := time.Now()
+	addrs, err := lookup(host)
+	elapsed := time.Since(start).Round(time.Millisecond)
+	if err != nil {
+		report.fail("host resolve", fmt.Sprintf("%v after %s", err, elapsed))
+		return
+	}
+	if elapsed > slowResolveThreshold {
+		report.warn("host resolve", fmt.Sprintf("slow lookup %s -> %s", elapsed, strings.Join(addrs, ", ")))
+		return
+	}
+	report.ok("host resolve", fmt.Sprintf("%s -> %s", elapsed, strings.Join(addrs, ", ")))
+}
+
+// looseKeyMode describes a private key mode that ssh would reject (any group
+// or world bits), or returns "". Windows has no such mode bits.
+func looseKeyMode(mode os.FileMode) string {
+	if runtime.GOOS == "windows" || mode.Perm()&0o077 == 0 {
+		return ""
+	}
+	return fmt.Sprintf("mode %04o is accessible by group/others", mode.Perm())
+}
+
+// checkDeprecations lists renamed keys that Load migrated; they still work.
+func checkDeprecations(report *doctorReport, cfg *config.Config) {
+	for _, note := range cfg.Deprecations {
+		report.warn("config keys", note)
+	}
+}
+
+// logDeprecations records each migrated key once per run so it shows up in
+// the service log, not only in doctor.
+func logDeprecations(logger *logging.Logger, cfg *config.Config) {
+	for _, note := range cfg.Deprecations {
+		logger.Event("WARN", "config_deprecated", map[string]any{"note": note})
+	}
+}
+
+// checkMonitors reports which sleep/network/power monitors this binary uses.
+// The polling fallbacks still work, so a darwin build without cgo only warns.
+func checkMonitors(report *doctorReport) {
+	info := monitor.Info()
+	if info.Fallback() {
+		report.warn("monitors", fmt.Sprintf("%s; rebuild with CGO_ENABLED=1 for event-driven sleep/network detection", info))
+		return
+	}
+	report.ok("monitors", fmt.Sprint(info))
+}
+
+// checkForwardDirection warns about forwards that look like they were put in
+// the wrong list. It never fails doctor; the specs are valid either way.
+func checkForwardDirection(report *doctorReport, kind string, forwards []string) {
+	warned := false
+	for _, spec := range forwards {
+		hint := forwardDirectionHint(kind, spec)
+		if hint == "" {
+			continue
+		}
+		report.warn("forward direction", spec+": "+hint)
+		warned = true
+	}
+	if !warned && len(forwards) > 0 {
+		report.ok("forward direction", "")
+	}
+}
+
+// wellKnownServicePorts are ports usually served by the remote host; a remote
+// forward that exposes the same local port is more often a swapped local forward.
+var wellKnownServicePorts = map[string]bool{
+	"22": true, "80": true, "443": true, "3306": true, "5432": true, "6379": true, "27017": true,
+}
+
+func forwardDirectionHint(kind, spec string) string {
+	forward, err := config.ParseForwardSpec(spec)
+	if err != nil {
+		return ""
+	}
+	bind, listenPort, targetHost, targetPort := forward.Bind, forward.Port, forward.Host, forward.HostPort
+	switch kind {
+	case "local":
+		if isWildcardHost(bind) || bind == "*" {
+			return "local forward listens on all interfaces; remote forwards usually do this"
+		}
+	case "remote":
+		if isLoopbackHost(targetHost) && listenPort == targetPort && wellKnownServicePorts[targetPort] {
+			return "remote forward exposes local port " + targetPort + " on the server; use client.local_forwards to reach the server's port"
+		}
+	}
+	return ""
 }
 
 func isLoopbackHost(host string) bool {
@@ -1991,21 +4349,38 @@ func printClientAdvice(class string) {
 		msg = "connection refused: check remote host/port availability"
 	case "timeout":
 		msg = "connection timed out: check network or firewall settings"
+	case "forward_failed":
+		msg = "forward failed: the listen port may already be in use; check other tunnels or services"
 	default:
 		msg = "connection failed: check logs for details"
 	}
 	fmt.Fprintln(os.Stderr, "hint:", msg)
 }
 
-func defaultConfigPath() string {
+// homeArgs starts a launchd ProgramArgs list, carrying --home so the service
+// uses the same relocated directory as the command that installed it.
+func homeArgs(exe string) []string {
+	args := []string{exe}
+	if dir, err := config.HomeDir(); err == nil && config.HomeOverride() != "" {
+		args = append(args, "--home", dir)
+	}
+	return args
+}
+
+// resolveConfigPath picks the config used when a subcommand has no --config:
+// the global flag, then RPA_CONFIG, then rpa.yaml in the rpa home.
+func resolveConfigPath(globalPath string) string {
+	if globalPath != "" {
+		return globalPath
+	}
 	if fromEnv := strings.TrimSpace(os.Getenv("RPA_CONFIG")); fromEnv != "" {
 		return fromEnv
 	}
-	home, err := os.UserHomeDir()
+	home, err := config.HomeDir()
 	if err != nil {
 		return filepath.Join(".", "rpa.yaml")
 	}
-	return filepath.Join(home, ".rpa", "rpa.yaml")
+	return filepath.Join(home, "rpa.yaml")
 }
 
 func wrapWithCaffeinate(args []string) ([]string, error) {
@@ -2018,20 +4393,13 @@ func wrapWithCaffeinate(args []string) ([]string, error) {
 	return out, nil
 }
 
-func startCaffeinate(logger *logging.Logger, enabled bool) {
+// startCaffeinate keeps caffeinate running while enabled; the returned keeper
+// is nil when prevent_sleep is off or caffeinate could not start.
+func startCaffeinate(logger *logging.Logger, enabled bool) *caffeinate.Keeper {
 	if !enabled {
-		return
-	}
-	cmd := exec.Command("caffeinate", "-dimsu", "-w", fmt.Sprintf("%d", os.Getpid()))
-	if err := cmd.Start(); err != nil {
-		logger.Event("ERROR", "caffeinate_failed", map[string]any{
-			"error": err.Error(),
-		})
-		return
+		return nil
 	}
-	logger.Event("INFO", "caffeinate_started", map[string]any{
-		"pid": fmt.Sprintf("%d", cmd.Process.Pid),
-	})
+	return caffeinate.Start(logger)
 }
 
 func printUsage() {
@@ -2040,14 +4408,19 @@ func printUsage() {
 	fmt.Println("Reverse Proxy Agent for resilient SSH tunnels on macOS.")
 	fmt.Println("")
 	fmt.Println("Usage:")
+	fmt.Println("  rpa [--config path] [--home dir] [--quiet] [--json] <cmd> ...")
 	fmt.Println("  rpa init [flags]             (write config)")
 	fmt.Println("  rpa agent <cmd> [flags]      (remote forwards)")
 	fmt.Println("  rpa client <cmd> [flags]     (local forwards)")
-	fmt.Println("  rpa status                   (agent + client status)")
+	fmt.Println("  rpa status [agent|client]    (status, default: both; --exit-code, --config repeatable, --all-configs GLOB)")
 	fmt.Println("  rpa logs [agent|client]      (logs, default: agent)")
 	fmt.Println("  rpa metrics [agent|client]   (metrics, default: agent)")
-	fmt.Println("  rpa doctor [agent|client]    (pre-flight checks)")
+	fmt.Println("  rpa events [agent|client]    (stream lifecycle events as JSON lines)")
+	fmt.Println("  rpa doctor [agent|client|--all] [--strict] [--json] (pre-flight checks)")
+	fmt.Println("  rpa state [agent|client]     (last known supervisor state)")
 	fmt.Println("  rpa config <cmd>             (get/set/show config)")
+	fmt.Println("  rpa uninstall [--purge]      (remove both launchd jobs; --purge also deletes the rpa home)")
+	fmt.Println("  rpa completion <shell>       (bash|zsh|fish completion script)")
 	fmt.Println("")
 	fmt.Println("Quick help:")
 	fmt.Println("  rpa init --help")
@@ -2057,14 +4430,20 @@ func printUsage() {
 	fmt.Println("")
 	fmt.Println("Environment:")
 	fmt.Println("  RPA_CONFIG overrides the default config path")
+	fmt.Println("  RPA_HOME (or --home) moves sockets, state, default logs, and the default config from ~/.rpa")
 	fmt.Println("  Default config path: ~/.rpa/rpa.yaml")
+	fmt.Println("  Precedence: subcommand --config > global --config/-c > RPA_CONFIG > default")
+	fmt.Println("  --quiet/-q before the command suppresses informational output")
+	fmt.Println("  --json before the command reports errors as JSON on stdout")
 }
 
 func printConfigUsage() {
 	fmt.Println("rpa config")
 	fmt.Println("")
 	fmt.Println("Usage:")
-	fmt.Println("  rpa config show [--config rpa.yaml]")
+	fmt.Println("  rpa config show [--config rpa.yaml] [--strict]")
+	fmt.Println("  rpa config diff [--config rpa.yaml]  (fields that differ from the defaults)")
+	fmt.Println("  rpa config backoff-preview [agent|client] [--attempts 10] [--config rpa.yaml]")
 	fmt.Println("  rpa config get <key> [--config rpa.yaml]")
 	fmt.Println("  rpa config set <key> <value> [--config rpa.yaml]")
 	fmt.Println("")
@@ -2072,6 +4451,9 @@ func printConfigUsage() {
 	fmt.Println("  rpa config get agent.prevent_sleep")
 	fmt.Println("  rpa config set agent.prevent_sleep true")
 	fmt.Println("  rpa config set ssh.options \"ServerAliveInterval=30,ServerAliveCountMax=3\"")
+	fmt.Println("  rpa config set ssh.set_env \"FOO=bar,LANG=C\"")
+	fmt.Println("  rpa config set ssh.remote_forwards +0.0.0.0:2223:localhost:23  (append one)")
+	fmt.Println("  rpa config set ssh.remote_forwards -0.0.0.0:2223:localhost:23  (remove one)")
 }
 
 func printAgentUsage() {
@@ -2080,18 +4462,29 @@ func printAgentUsage() {
 	fmt.Println("Agent manages remote forwards and keeps SSH tunnels alive in the background.")
 	fmt.Println("")
 	fmt.Println("Usage:")
-	fmt.Println("  rpa agent up --config rpa.yaml")
+	fmt.Println("  rpa agent up --config rpa.yaml [--plist-stdout] [--ready-timeout 3s] [--attach]")
 	fmt.Println("  rpa agent down --config rpa.yaml")
-	fmt.Println("  rpa agent run --config rpa.yaml")
-	fmt.Println("  rpa agent add --remote-forward spec --config rpa.yaml")
+	fmt.Println("  rpa agent run --config rpa.yaml [--restart-policy never] [--once] [--once-timeout 30s] [--force] [--ssh-arg ARG]...")
+	fmt.Println("  rpa agent add --remote-forward spec --config rpa.yaml [--ephemeral] [--wait]")
+	fmt.Println("  rpa agent add --replace old-spec new-spec --config rpa.yaml [--ephemeral] [--wait]  (one restart)")
 	fmt.Println("  rpa agent remove --remote-forward spec --config rpa.yaml")
 	fmt.Println("  rpa agent clear --config rpa.yaml")
+	fmt.Println("  rpa agent reconnect --config rpa.yaml")
+	fmt.Println("  rpa agent pause --config rpa.yaml")
+	fmt.Println("  rpa agent resume --config rpa.yaml")
+	fmt.Println("  rpa agent monitors --config rpa.yaml [--sleep-check-sec N] [--sleep-gap-sec N] [--network-poll-sec N] [--power-poll-sec N]")
+	fmt.Println("  rpa agent ping --config rpa.yaml [--count 1]  (IPC round trip)")
 	fmt.Println("")
 	fmt.Println("Notes:")
 	fmt.Println("  up: install & start launchd service (persisted)")
 	fmt.Println("  run: run in foreground for debugging (non-persistent)")
 	fmt.Println("  add/remove: updates config and restarts running agent if active")
+	fmt.Println("  add --ephemeral: applies to the running agent only (config untouched)")
+	fmt.Println("  add --wait: blocks until the agent reconnects (see --wait-timeout)")
 	fmt.Println("  clear: removes all forwards and stops the service")
+	fmt.Println("  reconnect: reconnect immediately, skipping backoff and debounce")
+	fmt.Println("  pause/resume: stop/restart ssh while the agent and launchd job stay up")
+	fmt.Println("  monitors: show or retune polling monitor intervals until the agent restarts")
 	fmt.Println("  sleep prevention is a config flag: agent.prevent_sleep=true")
 	fmt.Println("")
 	fmt.Println("Remote forward spec example:")
@@ -2104,18 +4497,25 @@ func printClientUsage() {
 	fmt.Println("Client manages local forwards and keeps SSH tunnels alive in the background.")
 	fmt.Println("")
 	fmt.Println("Usage:")
-	fmt.Println("  rpa client up --config rpa.yaml [--local-forward spec]")
+	fmt.Println("  rpa client up --config rpa.yaml [--local-forward spec [--ephemeral]] [--plist-stdout] [--ready-timeout 3s] [--attach]")
 	fmt.Println("  rpa client down --config rpa.yaml")
-	fmt.Println("  rpa client run --config rpa.yaml [--local-forward spec]")
-	fmt.Println("  rpa client add --local-forward spec --config rpa.yaml")
-	fmt.Println("  rpa client remove --local-forward spec --config rpa.yaml")
+	fmt.Println("  rpa client run --config rpa.yaml [--local-forward spec] [--restart-policy never] [--force] [--ssh-arg ARG]...")
+	fmt.Println("  rpa client add (--local-forward spec | --dynamic-forward [bind:]port) --config rpa.yaml [--wait]")
+	fmt.Println("  rpa client remove (--local-forward spec | --dynamic-forward [bind:]port) --config rpa.yaml")
 	fmt.Println("  rpa client clear --config rpa.yaml")
+	fmt.Println("  rpa client reconnect --config rpa.yaml")
+	fmt.Println("  rpa client monitors --config rpa.yaml [--sleep-check-sec N] [--sleep-gap-sec N] [--network-poll-sec N] [--power-poll-sec N]")
+	fmt.Println("  rpa client ping --config rpa.yaml [--count 1]  (IPC round trip)")
 	fmt.Println("")
 	fmt.Println("Notes:")
 	fmt.Println("  up: install & start launchd service (persisted)")
 	fmt.Println("  run: run in foreground for debugging (non-persistent)")
 	fmt.Println("  add/remove: updates config and restarts running client if active")
+	fmt.Println("  up --ephemeral: applies --local-forward to the running client only (config untouched)")
+	fmt.Println("  add --wait: blocks until the client reconnects (see --wait-timeout)")
 	fmt.Println("  clear: removes all forwards and stops the service")
+	fmt.Println("  reconnect: reconnect immediately, skipping backoff and debounce")
+	fmt.Println("  monitors: show or retune polling monitor intervals until the client restarts")
 	fmt.Println("  sleep prevention is a config flag: client.prevent_sleep=true")
 	fmt.Println("  logs/metrics/doctor: use top-level commands (rpa logs|metrics|doctor)")
 	fmt.Println("")
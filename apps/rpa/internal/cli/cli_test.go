@@ -0,0 +1,393 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"reverse-proxy-agent/pkg/config"
+)
+
+func TestJSONErrors(t *testing.T) {
+	home := t.TempDir()
+	cfgPath := filepath.Join(home, "rpa.yaml")
+	writeConfig(t, cfgPath, "ssh:\n  user: me\n  host: example.com\n  remote_forwards:\n    - \"0.0.0.0:2222:localhost:22\"\n")
+	cases := []struct {
+		name     string
+		args     []string
+		wantCode int
+		wantMsg  string
+	}{
+		{name: "unknown command", args: []string{"bogus"}, wantCode: exitUsage, wantMsg: "unknown command: bogus"},
+		{name: "missing agent subcommand", args: []string{"agent"}, wantCode: exitUsage, wantMsg: "missing agent subcommand"},
+		{name: "unknown config subcommand", args: []string{"config", "nope"}, wantCode: exitUsage, wantMsg: "unknown config subcommand: nope"},
+		{name: "init missing flags", args: []string{"init", "--config", filepath.Join(home, "new.yaml")}, wantCode: exitUsage, wantMsg: "missing required flags: --ssh-user, --ssh-host"},
+		{name: "bad flag", args: []string{"status", "--no-such-flag"}, wantCode: exitUsage, wantMsg: "flag provided but not defined: -no-such-flag"},
+		{name: "missing config", args: []string{"agent", "down", "--config", filepath.Join(home, "missing.yaml")}, wantCode: exitError, wantMsg: "config load failed"},
+		{name: "ephemeral without a running agent", args: []string{"agent", "add", "--remote-forward", "0.0.0.0:2223:localhost:22", "--ephemeral", "--config", cfgPath}, wantCode: exitError, wantMsg: "agent not running; --ephemeral requires a running agent"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			args := append([]string{"--json", "--home", home}, tc.args...)
+			var code int
+			stdout, _ := captureOutput(t, func() { code = Run(args) })
+			t.Cleanup(resetGlobals)
+			if code != tc.wantCode {
+				t.Fatalf("exit code = %d, want %d", code, tc.wantCode)
+			}
+			var got struct {
+				Error string `json:"error"`
+				Code  int    `json:"code"`
+			}
+			if err := json.Unmarshal([]byte(stdout), &got); err != nil {
+				t.Fatalf("stdout is not one JSON object: %v\n%s", err, stdout)
+			}
+			if got.Code != tc.wantCode || !strings.Contains(got.Error, tc.wantMsg) {
+				t.Fatalf("got %+v, want code %d and error containing %q", got, tc.wantCode, tc.wantMsg)
+			}
+		})
+	}
+}
+
+func TestTextErrors(t *testing.T) {
+	cases := []struct {
+		name       string
+		args       []string
+		wantCode   int
+		wantStderr string
+		wantStdout string
+	}{
+		{name: "unknown command", args: []string{"bogus"}, wantCode: exitUsage, wantStderr: "unknown command: bogus", wantStdout: "Usage"},
+		{name: "missing client subcommand", args: []string{"client"}, wantCode: exitUsage, wantStderr: "missing client subcommand", wantStdout: "client"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			var code int
+			stdout, stderr := captureOutput(t, func() { code = Run(tc.args) })
+			t.Cleanup(resetGlobals)
+			if code != tc.wantCode {
+				t.Fatalf("exit code = %d, want %d", code, tc.wantCode)
+			}
+			if !strings.Contains(stderr, tc.wantStderr) {
+				t.Errorf("stderr %q does not contain %q", stderr, tc.wantStderr)
+			}
+			if !strings.Contains(stdout, tc.wantStdout) {
+				t.Errorf("stdout %q does not contain %q", stdout, tc.wantStdout)
+			}
+			if strings.HasPrefix(strings.TrimSpace(stdout), "{") {
+				t.Errorf("stdout looks like JSON without --json: %q", stdout)
+			}
+		})
+	}
+}
+
+// captureOutput runs fn with os.Stdout and os.Stderr redirected and returns
+// what it wrote to each.
+func captureOutput(t *testing.T, fn func()) (string, string) {
+	t.Helper()
+	read := func(f *os.File, out *string, done chan<- struct{}) {
+		data, _ := io.ReadAll(f)
+		*out = string(data)
+		close(done)
+	}
+	outR, outW, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	errR, errW, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var stdout, stderr string
+	outDone, errDone := make(chan struct{}), make(chan struct{})
+	go read(outR, &stdout, outDone)
+	go read(errR, &stderr, errDone)
+
+	oldOut, oldErr := os.Stdout, os.Stderr
+	os.Stdout, os.Stderr = outW, errW
+	fn()
+	os.Stdout, os.Stderr = oldOut, oldErr
+	outW.Close()
+	errW.Close()
+	<-outDone
+	<-errDone
+	return stdout, stderr
+}
+
+// resetGlobals undoes the global flags a Run call set.
+func resetGlobals() {
+	jsonErrors = false
+	quiet = false
+	config.SetHomeDir("")
+}
+
+func writeConfig(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// ipcRequest is one request a fakeIPC server received.
+type ipcRequest struct {
+	Command string            `json:"command"`
+	Args    map[string]string `json:"args,omitempty"`
+}
+
+// ipcReply is what a fakeIPC handler answers; it matches the wire shape of
+// both IPC servers.
+type ipcReply struct {
+	OK      bool              `json:"ok"`
+	Message string            `json:"message,omitempty"`
+	Data    map[string]string `json:"data,omitempty"`
+	Logs    []string          `json:"logs,omitempty"`
+}
+
+// fakeIPC serves socketPath with handle and returns the requests it got so
+// far. The listener closes with the test.
+func fakeIPC(t *testing.T, socketPath string, handle func(req ipcRequest) ipcReply) func() []ipcRequest {
+	t.Helper()
+	ln, err := net.Listen("unix", socketPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	var mu sync.Mutex
+	var got []ipcRequest
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				var req ipcRequest
+				if err := json.NewDecoder(conn).Decode(&req); err != nil {
+					return
+				}
+				mu.Lock()
+				got = append(got, req)
+				mu.Unlock()
+				_ = json.NewEncoder(conn).Encode(handle(req))
+			}()
+		}
+	}()
+	return func() []ipcRequest {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]ipcRequest(nil), got...)
+	}
+}
+
+// shortHome returns a temp rpa home short enough for unix socket paths,
+// which t.TempDir names can exceed.
+func shortHome(t *testing.T) string {
+	t.Helper()
+	home, err := os.MkdirTemp("", "rpa")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(home) })
+	return home
+}
+
+func TestExtractGlobalFlags(t *testing.T) {
+	cases := []struct {
+		name     string
+		args     []string
+		want     globalFlags
+		wantRest []string
+		wantErr  bool
+	}{
+		{name: "none", args: []string{"status"}, wantRest: []string{"status"}},
+		{name: "config before command", args: []string{"--config", "a.yaml", "status"}, want: globalFlags{configPath: "a.yaml"}, wantRest: []string{"status"}},
+		{name: "short config with equals", args: []string{"-c=a.yaml", "status", "agent"}, want: globalFlags{configPath: "a.yaml"}, wantRest: []string{"status", "agent"}},
+		{name: "all globals", args: []string{"-q", "--json", "--home", "/h", "-c", "a.yaml", "logs"}, want: globalFlags{configPath: "a.yaml", home: "/h", quiet: true, json: true}, wantRest: []string{"logs"}},
+		{name: "flags after the command are left alone", args: []string{"status", "--config", "b.yaml"}, wantRest: []string{"status", "--config", "b.yaml"}},
+		{name: "missing config path", args: []string{"--config"}, wantErr: true},
+		{name: "empty config path", args: []string{"--config=", "status"}, wantErr: true},
+		{name: "missing home", args: []string{"--home", " "}, wantErr: true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, rest, err := extractGlobalFlags(tc.args)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("extractGlobalFlags(%q) succeeded, want an error", tc.args)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("extractGlobalFlags: %v", err)
+			}
+			if got != tc.want || !equalStrings(rest, tc.wantRest) {
+				t.Fatalf("got %+v %q, want %+v %q", got, rest, tc.want, tc.wantRest)
+			}
+		})
+	}
+}
+
+func TestConfigPathPrecedence(t *testing.T) {
+	home := t.TempDir()
+	for _, name := range []string{"home", "env", "global", "local"} {
+		writeConfig(t, filepath.Join(home, name+".yaml"), "ssh:\n  user: me\n  host: "+name+".example.com\n  remote_forwards:\n    - \"0.0.0.0:2222:localhost:22\"\n")
+	}
+	if err := os.Rename(filepath.Join(home, "home.yaml"), filepath.Join(home, "rpa.yaml")); err != nil {
+		t.Fatal(err)
+	}
+	path := func(name string) string { return filepath.Join(home, name+".yaml") }
+	cases := []struct {
+		name    string
+		env     string
+		rpaHome bool
+		args    []string
+		want    string
+	}{
+		{name: "home default", args: []string{"config", "get", "ssh.host"}, want: "home"},
+		{name: "RPA_HOME default", rpaHome: true, args: []string{"config", "get", "ssh.host"}, want: "home"},
+		{name: "RPA_CONFIG over the home default", env: path("env"), args: []string{"config", "get", "ssh.host"}, want: "env"},
+		{name: "global flag over RPA_CONFIG", env: path("env"), args: []string{"--config", path("global"), "config", "get", "ssh.host"}, want: "global"},
+		{name: "command flag over the global flag", env: path("env"), args: []string{"-c", path("global"), "config", "get", "--config", path("local"), "ssh.host"}, want: "local"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Setenv("RPA_CONFIG", tc.env)
+			args := append([]string{"--home", home}, tc.args...)
+			if tc.rpaHome {
+				t.Setenv("RPA_HOME", home)
+				args = tc.args
+			}
+			var code int
+			stdout, stderr := captureOutput(t, func() { code = Run(args) })
+			t.Cleanup(resetGlobals)
+			if code != exitOK {
+				t.Fatalf("exit code = %d, stderr %q", code, stderr)
+			}
+			if got := strings.TrimSpace(stdout); got != tc.want+".example.com" {
+				t.Fatalf("ssh.host = %q, want %s.example.com", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestResolveConfigPath(t *testing.T) {
+	home := t.TempDir()
+	cases := []struct {
+		name   string
+		global string
+		env    string
+		want   string
+	}{
+		{name: "home default", want: filepath.Join(home, "rpa.yaml")},
+		{name: "RPA_CONFIG", env: "/etc/rpa/env.yaml", want: "/etc/rpa/env.yaml"},
+		{name: "blank RPA_CONFIG", env: "  ", want: filepath.Join(home, "rpa.yaml")},
+		{name: "global flag over RPA_CONFIG", global: "/tmp/global.yaml", env: "/etc/rpa/env.yaml", want: "/tmp/global.yaml"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Setenv("RPA_HOME", home)
+			t.Setenv("RPA_CONFIG", tc.env)
+			if got := resolveConfigPath(tc.global); got != tc.want {
+				t.Fatalf("resolveConfigPath(%q) = %q, want %q", tc.global, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestConfigPathNotCarriedOver(t *testing.T) {
+	home := t.TempDir()
+	for _, name := range []string{"rpa", "global"} {
+		writeConfig(t, filepath.Join(home, name+".yaml"), "ssh:\n  user: me\n  host: "+name+".example.com\n  remote_forwards:\n    - \"0.0.0.0:2222:localhost:22\"\n")
+	}
+	t.Setenv("RPA_HOME", home)
+	t.Setenv("RPA_CONFIG", "")
+	t.Cleanup(resetGlobals)
+	// Back-to-back runs with no reset: a global --config only covers its own run.
+	runs := []struct {
+		args []string
+		want string
+	}{
+		{args: []string{"-c", filepath.Join(home, "global.yaml"), "config", "get", "ssh.host"}, want: "global.example.com"},
+		{args: []string{"config", "get", "ssh.host"}, want: "rpa.example.com"},
+	}
+	for i, run := range runs {
+		var code int
+		stdout, stderr := captureOutput(t, func() { code = Run(run.args) })
+		if code != exitOK {
+			t.Fatalf("run %d: exit code = %d, stderr %q", i, code, stderr)
+		}
+		if got := strings.TrimSpace(stdout); got != run.want {
+			t.Fatalf("run %d: ssh.host = %q, want %q", i, got, run.want)
+		}
+	}
+}
+
+func TestQuietSuppressesInfo(t *testing.T) {
+	cases := []struct {
+		name   string
+		args   func(home, cfgPath string) []string
+		socket string
+		// wantQuiet is what a quiet run still prints: real output, not
+		// progress.
+		wantQuiet string
+	}{
+		{
+			name: "agent add to a running agent",
+			args: func(home, cfgPath string) []string {
+				return []string{"agent", "add", "--remote-forward", "0.0.0.0:8080:localhost:80", "--config", cfgPath}
+			},
+			socket: "agent.sock",
+		},
+		{
+			name: "config set",
+			args: func(home, cfgPath string) []string {
+				return []string{"config", "set", "--config", cfgPath, "ssh.port", "2222"}
+			},
+		},
+		{
+			name: "init",
+			args: func(home, cfgPath string) []string {
+				return initArgs(home, filepath.Join(home, "new.yaml"), filepath.Join(home, "id_ed25519"))[2:]
+			},
+			wantQuiet: "ssh-ed25519 AAAAtest rpa\n",
+		},
+	}
+	for _, tc := range cases {
+		for _, q := range []bool{false, true} {
+			t.Run(fmt.Sprintf("%s quiet=%v", tc.name, q), func(t *testing.T) {
+				t.Cleanup(resetGlobals)
+				stubKeygen(t, keygenOK)
+				home := shortHome(t)
+				cfgPath := filepath.Join(home, "rpa.yaml")
+				writeConfig(t, cfgPath, addTestConfig)
+				if tc.socket != "" {
+					fakeIPC(t, filepath.Join(home, tc.socket), func(ipcRequest) ipcReply {
+						return ipcReply{OK: true, Message: "remote forward added", Data: map[string]string{"added": "true", "applied": "restart"}}
+					})
+				}
+				args := []string{"--home", home}
+				if q {
+					args = append(args, "--quiet")
+				}
+				var code int
+				stdout, stderr := captureOutput(t, func() { code = Run(append(args, tc.args(home, cfgPath)...)) })
+				if code != exitOK {
+					t.Fatalf("exit code = %d, want %d (stderr %q)", code, exitOK, stderr)
+				}
+				if q && stdout != tc.wantQuiet {
+					t.Fatalf("quiet run printed %q, want %q", stdout, tc.wantQuiet)
+				}
+				if !q && stdout == tc.wantQuiet {
+					t.Fatal("normal run printed nothing; the case does not exercise quiet")
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,135 @@
+// Package cli renders static shell completion scripts for the hand-rolled CLI.
+// The command table below must be kept in sync with Run and its subcommand routers.
+
+package cli
+
+import (
+	"fmt"
+	"strings"
+)
+
+type completionCommand struct {
+	name  string
+	subs  []string
+	flags []string
+}
+
+var completionCommands = []completionCommand{
+	{name: "init", flags: []string{
+		"--config", "--ssh-user", "--ssh-host", "--ssh-port", "--ssh-identity-file", "--ssh-identity-agent", "--ssh-option",
+		"--remote-forward", "--local-forward", "--remote-forward-file", "--local-forward-file",
+		"--agent-name", "--launchd-label", "--restart-policy", "--periodic-restart-sec",
+		"--log-level", "--log-path", "--agent-prevent-sleep", "--client-prevent-sleep", "--force", "--generate-key",
+	}},
+	{name: "agent", subs: []string{"up", "down", "run", "add", "remove", "clear", "reconnect", "pause", "resume", "monitors", "ping", "help"}, flags: []string{"--config", "--remote-forward", "--ephemeral", "--wait", "--wait-timeout", "--once", "--once-timeout", "--restart-policy", "--plist-stdout", "--ready-timeout", "--force", "--attach", "--replace", "--ssh-arg", "--count", "--sleep-check-sec", "--sleep-gap-sec", "--network-poll-sec", "--power-poll-sec"}},
+	{name: "client", subs: []string{"up", "down", "run", "add", "remove", "clear", "reconnect", "monitors", "ping", "help"}, flags: []string{"--config", "--local-forward", "--dynamic-forward", "--ephemeral", "--wait", "--wait-timeout", "--restart-policy", "--plist-stdout", "--ready-timeout", "--force", "--attach", "--ssh-arg", "--count", "--sleep-check-sec", "--sleep-gap-sec", "--network-poll-sec", "--power-poll-sec"}},
+	{name: "status", subs: []string{"agent", "client"}, flags: []string{"--config", "--all-configs", "--exit-code"}},
+	{name: "logs", subs: []string{"agent", "client"}, flags: []string{"--config", "--follow", "-f", "--since", "--grep", "--ssh-stderr", "--count"}},
+	{name: "events", subs: []string{"agent", "client"}, flags: []string{"--config"}},
+	{name: "metrics", subs: []string{"agent", "client"}, flags: []string{"--config", "--format", "--watch", "--interval"}},
+	{name: "state", subs: []string{"agent", "client"}, flags: []string{"--config", "--json"}},
+	{name: "doctor", subs: []string{"agent", "client", "all"}, flags: []string{"--config", "--remote-forward", "--local-forward", "--all", "--strict", "--json"}},
+	{name: "config", subs: []string{"get", "set", "show", "diff", "backoff-preview"}, flags: []string{"--config", "--strict", "--attempts"}},
+	{name: "uninstall", flags: []string{"--config", "--purge"}},
+	{name: "completion", subs: []string{"bash", "zsh", "fish"}},
+	{name: "help", subs: []string{"agent", "client"}},
+}
+
+func runCompletion(args []string) int {
+	if len(args) != 1 {
+		return fail(exitUsage, "usage: rpa completion <bash|zsh|fish>")
+	}
+	switch args[0] {
+	case "bash":
+		fmt.Print(bashCompletion())
+	case "zsh":
+		fmt.Print(zshCompletion())
+	case "fish":
+		fmt.Print(fishCompletion())
+	default:
+		return fail(exitUsage, "unsupported shell: %s (use bash, zsh, or fish)", args[0])
+	}
+	return exitOK
+}
+
+func completionNames() []string {
+	names := make([]string, 0, len(completionCommands))
+	for _, cmd := range completionCommands {
+		names = append(names, cmd.name)
+	}
+	return names
+}
+
+func bashCompletion() string {
+	var b strings.Builder
+	b.WriteString("# bash completion for rpa\n")
+	b.WriteString("# usage: source <(rpa completion bash)\n")
+	b.WriteString("_rpa() {\n")
+	b.WriteString("  local cur=\"${COMP_WORDS[COMP_CWORD]}\"\n")
+	b.WriteString("  if [ \"$COMP_CWORD\" -eq 1 ]; then\n")
+	fmt.Fprintf(&b, "    COMPREPLY=( $(compgen -W %q -- \"$cur\") )\n", strings.Join(completionNames(), " "))
+	b.WriteString("    return\n")
+	b.WriteString("  fi\n")
+	b.WriteString("  local subs=\"\" flags=\"\"\n")
+	b.WriteString("  case \"${COMP_WORDS[1]}\" in\n")
+	for _, cmd := range completionCommands {
+		fmt.Fprintf(&b, "    %s) subs=%q; flags=%q ;;\n", cmd.name, strings.Join(cmd.subs, " "), strings.Join(cmd.flags, " "))
+	}
+	b.WriteString("  esac\n")
+	b.WriteString("  if [ \"$COMP_CWORD\" -eq 2 ] && [ -n \"$subs\" ] && [[ \"$cur\" != -* ]]; then\n")
+	b.WriteString("    COMPREPLY=( $(compgen -W \"$subs\" -- \"$cur\") )\n")
+	b.WriteString("    return\n")
+	b.WriteString("  fi\n")
+	b.WriteString("  COMPREPLY=( $(compgen -W \"$flags\" -- \"$cur\") )\n")
+	b.WriteString("}\n")
+	b.WriteString("complete -o default -F _rpa rpa\n")
+	return b.String()
+}
+
+func zshCompletion() string {
+	var b strings.Builder
+	b.WriteString("#compdef rpa\n")
+	b.WriteString("# usage: rpa completion zsh > \"${fpath[1]}/_rpa\"\n")
+	b.WriteString("_rpa() {\n")
+	b.WriteString("  if (( CURRENT == 2 )); then\n")
+	fmt.Fprintf(&b, "    compadd -- %s\n", strings.Join(completionNames(), " "))
+	b.WriteString("    return\n")
+	b.WriteString("  fi\n")
+	b.WriteString("  local -a subs flags\n")
+	b.WriteString("  case $words[2] in\n")
+	for _, cmd := range completionCommands {
+		fmt.Fprintf(&b, "    %s) subs=(%s); flags=(%s) ;;\n", cmd.name, strings.Join(cmd.subs, " "), strings.Join(cmd.flags, " "))
+	}
+	b.WriteString("  esac\n")
+	b.WriteString("  if (( CURRENT == 3 )) && (( ${#subs} )) && [[ $PREFIX != -* ]]; then\n")
+	b.WriteString("    compadd -- $subs\n")
+	b.WriteString("    return\n")
+	b.WriteString("  fi\n")
+	b.WriteString("  compadd -- $flags\n")
+	b.WriteString("  _files\n")
+	b.WriteString("}\n")
+	b.WriteString("compdef _rpa rpa\n")
+	return b.String()
+}
+
+func fishCompletion() string {
+	var b strings.Builder
+	b.WriteString("# fish completion for rpa\n")
+	b.WriteString("# usage: rpa completion fish > ~/.config/fish/completions/rpa.fish\n")
+	fmt.Fprintf(&b, "complete -c rpa -n __fish_use_subcommand -f -a %q\n", strings.Join(completionNames(), " "))
+	for _, cmd := range completionCommands {
+		cond := "__fish_seen_subcommand_from " + cmd.name
+		if len(cmd.subs) > 0 {
+			fmt.Fprintf(&b, "complete -c rpa -n %q -f -a %q\n", cond, strings.Join(cmd.subs, " "))
+		}
+		for _, flag := range cmd.flags {
+			switch {
+			case strings.HasPrefix(flag, "--"):
+				fmt.Fprintf(&b, "complete -c rpa -n %q -l %s\n", cond, strings.TrimPrefix(flag, "--"))
+			case strings.HasPrefix(flag, "-"):
+				fmt.Fprintf(&b, "complete -c rpa -n %q -s %s\n", cond, strings.TrimPrefix(flag, "-"))
+			}
+		}
+	}
+	return b.String()
+}
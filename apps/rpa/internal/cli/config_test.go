@@ -0,0 +1,234 @@
+package cli
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"reverse-proxy-agent/pkg/config"
+)
+
+func TestConfigSetSliceEdits(t *testing.T) {
+	cases := []struct {
+		name    string
+		key     string
+		start   []string
+		value   string
+		want    []string
+		wantErr bool
+	}{
+		{name: "append forward", key: "ssh.remote_forwards", start: []string{"0.0.0.0:2222:localhost:22"}, value: "+0.0.0.0:8080:localhost:80", want: []string{"0.0.0.0:2222:localhost:22", "0.0.0.0:8080:localhost:80"}},
+		{name: "append present forward is a no-op", key: "ssh.remote_forwards", start: []string{"0.0.0.0:2222:localhost:22"}, value: "+0.0.0.0:2222:localhost:22", want: []string{"0.0.0.0:2222:localhost:22"}},
+		{name: "remove present forward", key: "ssh.remote_forwards", start: []string{"0.0.0.0:2222:localhost:22", "0.0.0.0:8080:localhost:80"}, value: "-0.0.0.0:2222:localhost:22", want: []string{"0.0.0.0:8080:localhost:80"}},
+		{name: "remove absent forward is a no-op", key: "ssh.remote_forwards", start: []string{"0.0.0.0:2222:localhost:22"}, value: "-0.0.0.0:9999:localhost:99", want: []string{"0.0.0.0:2222:localhost:22"}},
+		{name: "csv replaces forwards", key: "ssh.remote_forwards", start: []string{"0.0.0.0:2222:localhost:22"}, value: "0.0.0.0:1:localhost:1,0.0.0.0:2:localhost:2", want: []string{"0.0.0.0:1:localhost:1", "0.0.0.0:2:localhost:2"}},
+		{name: "append option", key: "ssh.options", start: []string{"BatchMode=yes"}, value: "+Compression=yes", want: []string{"BatchMode=yes", "Compression=yes"}},
+		{name: "remove present option", key: "ssh.options", start: []string{"BatchMode=yes", "Compression=yes"}, value: "-BatchMode=yes", want: []string{"Compression=yes"}},
+		{name: "remove absent option is a no-op", key: "ssh.options", start: []string{"BatchMode=yes"}, value: "-Compression=yes", want: []string{"BatchMode=yes"}},
+		{name: "csv replaces options", key: "ssh.options", start: []string{"BatchMode=yes"}, value: "A=1, B=2", want: []string{"A=1", "B=2"}},
+		{name: "bare prefix is an error", key: "ssh.options", start: []string{"BatchMode=yes"}, value: "+", wantErr: true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := &config.Config{}
+			if tc.key == "ssh.options" {
+				cfg.SSH.Options = tc.start
+			} else {
+				for _, spec := range tc.start {
+					cfg.SSH.RemoteForwards = append(cfg.SSH.RemoteForwards, config.Forward{Spec: spec})
+				}
+			}
+			err := setConfigValue(cfg, tc.key, tc.value)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatal("setConfigValue succeeded, want an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("setConfigValue: %v", err)
+			}
+			got := cfg.SSH.Options
+			if tc.key != "ssh.options" {
+				got = config.ForwardSpecs(cfg.SSH.RemoteForwards)
+			}
+			if !equalStrings(got, tc.want) {
+				t.Fatalf("%s = %q, want %q", tc.key, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestConfigSetRemoveFromCLI(t *testing.T) {
+	home := t.TempDir()
+	cfgPath := filepath.Join(home, "rpa.yaml")
+	writeConfig(t, cfgPath, "ssh:\n  user: me\n  host: example.com\n  remote_forwards:\n    - \"0.0.0.0:2222:localhost:22\"\n    - \"0.0.0.0:8080:localhost:80\"\n")
+
+	// A leading "-" is the value, not a flag.
+	if code := quietRun(t, []string{"--home", home, "config", "set", "--config", cfgPath, "ssh.remote_forwards", "-0.0.0.0:2222:localhost:22"}); code != exitOK {
+		t.Fatalf("config set = %d, want %d", code, exitOK)
+	}
+	cfg, err := config.Load(cfgPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := config.NormalizeRemoteForwards(cfg), []string{"0.0.0.0:8080:localhost:80"}; !equalStrings(got, want) {
+		t.Fatalf("remote forwards = %q, want %q", got, want)
+	}
+}
+
+func TestConfigSetKeepsComments(t *testing.T) {
+	cases := []struct {
+		key   string
+		value string
+		want  string
+	}{
+		{key: "ssh.port", value: "2200", want: "port: 2200 # office firewall"},
+		{key: "ssh.user", value: "ops", want: "user: ops"},
+		{key: "ssh.remote_forwards", value: "+0.0.0.0:8080:localhost:80", want: "0.0.0.0:8080:localhost:80"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.key, func(t *testing.T) {
+			home := t.TempDir()
+			cfgPath := filepath.Join(home, "rpa.yaml")
+			writeConfig(t, cfgPath, "# tunnel to the office\nssh:\n  # bastion\n  host: example.com\n  user: me\n  port: 22 # office firewall\n  remote_forwards:\n    - \"0.0.0.0:2222:localhost:22\"\n")
+			if code := quietRun(t, []string{"--home", home, "config", "set", "--config", cfgPath, tc.key, tc.value}); code != exitOK {
+				t.Fatalf("config set = %d, want %d", code, exitOK)
+			}
+			data, err := os.ReadFile(cfgPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, want := range []string{"# tunnel to the office", "# bastion", tc.want} {
+				if !strings.Contains(string(data), want) {
+					t.Fatalf("config lacks %q after set:\n%s", want, data)
+				}
+			}
+		})
+	}
+}
+
+func TestConfigDiff(t *testing.T) {
+	cases := []struct {
+		name     string
+		yaml     string
+		wantCode int
+		want     []string
+	}{
+		{
+			name: "a couple of overrides",
+			yaml: "ssh:\n  user: me\n  host: example.com\n  port: 2222\n  remote_forwards:\n    - \"0.0.0.0:2222:localhost:22\"\nagent:\n  restart:\n    max_delay_ms: 60000\n",
+			want: []string{
+				"agent.restart.max_delay_ms: 30000 -> 60000",
+				"ssh.user: (empty) -> me",
+				"ssh.host: (empty) -> example.com",
+				"ssh.port: 22 -> 2222",
+				"ssh.remote_forwards: (empty) -> 0.0.0.0:2222:localhost:22",
+			},
+		},
+		{name: "default spelled out is no difference", yaml: "ssh:\n  port: 22\nagent:\n  restart:\n    max_delay_ms: 30000\n"},
+		{name: "empty file", yaml: ""},
+		{name: "missing file", wantCode: exitError},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Cleanup(resetGlobals)
+			home := t.TempDir()
+			cfgPath := filepath.Join(home, "rpa.yaml")
+			if tc.wantCode == exitOK {
+				writeConfig(t, cfgPath, tc.yaml)
+			}
+			var code int
+			stdout, stderr := captureOutput(t, func() { code = Run([]string{"--home", home, "config", "diff", "--config", cfgPath}) })
+			if code != tc.wantCode {
+				t.Fatalf("config diff = %d, want %d\nstderr:\n%s", code, tc.wantCode, stderr)
+			}
+			if tc.wantCode != exitOK {
+				return
+			}
+			got := []string{}
+			if out := strings.TrimSpace(stdout); out != "" {
+				got = strings.Split(out, "\n")
+			}
+			want := tc.want
+			if want == nil {
+				want = []string{}
+			}
+			if !equalStrings(got, want) {
+				t.Fatalf("config diff printed %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestStrictFlag(t *testing.T) {
+	const typo = "ssh:\n  user: me\n  host: example.com\n  remote_forwards:\n    - \"0.0.0.0:2222:localhost:22\"\nagnet:\n  restart_policy: always\n"
+	cases := []struct {
+		name       string
+		args       []string
+		wantCode   int
+		wantStderr string
+	}{
+		{name: "config show ignores typos", args: []string{"config", "show"}, wantCode: exitOK},
+		{name: "config show strict", args: []string{"config", "show", "--strict"}, wantCode: exitError, wantStderr: "unknown config keys: agnet"},
+		{name: "agent doctor strict", args: []string{"doctor", "agent", "--strict"}, wantCode: exitError, wantStderr: "unknown config keys: agnet"},
+		{name: "client doctor strict", args: []string{"doctor", "client", "--strict"}, wantCode: exitError, wantStderr: "unknown config keys: agnet"},
+		{name: "doctor all strict", args: []string{"doctor", "--all", "--strict"}, wantCode: exitError, wantStderr: "unknown config keys: agnet"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Cleanup(resetGlobals)
+			home := t.TempDir()
+			cfgPath := filepath.Join(home, "rpa.yaml")
+			writeConfig(t, cfgPath, typo)
+			var code int
+			_, stderr := captureOutput(t, func() { code = Run(append(append([]string{"--home", home}, tc.args...), "--config", cfgPath)) })
+			if code != tc.wantCode {
+				t.Fatalf("exit code = %d, want %d\nstderr:\n%s", code, tc.wantCode, stderr)
+			}
+			if !strings.Contains(stderr, tc.wantStderr) {
+				t.Fatalf("stderr %q lacks %q", stderr, tc.wantStderr)
+			}
+		})
+	}
+}
+
+func TestConfigBackoffPreview(t *testing.T) {
+	const restartConfig = "agent:\n  restart:\n    min_delay_ms: 1000\n    max_delay_ms: 4000\n    factor: 2\nclient:\n  restart:\n    min_delay_ms: 500\n    max_delay_ms: 600\n    factor: 3\n    jitter: 0.5\n"
+	agentBlock := "agent: min 1s, factor 2, max 4s, jitter 0.2\n  1: 1s (800ms - 1.2s)\n  2: 2s (1.6s - 2.4s)\n  3: 4s (3.2s - 4.8s)\n  total: 7s\n"
+	clientBlock := "client: min 500ms, factor 3, max 600ms, jitter 0.5\n  1: 500ms (250ms - 750ms)\n  2: 600ms (300ms - 900ms)\n  3: 600ms (300ms - 900ms)\n  total: 1.7s\n"
+	cases := []struct {
+		name       string
+		args       []string
+		wantCode   int
+		wantStdout string
+		wantStderr string
+	}{
+		{name: "both", args: []string{"--attempts", "3"}, wantStdout: agentBlock + clientBlock},
+		{name: "agent only", args: []string{"agent", "--attempts", "3"}, wantStdout: agentBlock},
+		{name: "client only", args: []string{"client", "--attempts", "3"}, wantStdout: clientBlock},
+		{name: "bad target", args: []string{"proxy"}, wantCode: exitUsage, wantStderr: "backoff-preview target must be agent or client"},
+		{name: "bad attempts", args: []string{"--attempts", "0"}, wantCode: exitUsage, wantStderr: "attempts must be > 0"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Cleanup(resetGlobals)
+			home := t.TempDir()
+			cfgPath := filepath.Join(home, "rpa.yaml")
+			writeConfig(t, cfgPath, restartConfig)
+			args := append(append([]string{"--home", home, "config", "backoff-preview"}, tc.args...), "--config", cfgPath)
+			var code int
+			stdout, stderr := captureOutput(t, func() { code = Run(args) })
+			if code != tc.wantCode {
+				t.Fatalf("exit code = %d, want %d\nstderr:\n%s", code, tc.wantCode, stderr)
+			}
+			if tc.wantCode == exitOK && stdout != tc.wantStdout {
+				t.Fatalf("stdout:\n%s\nwant:\n%s", stdout, tc.wantStdout)
+			}
+			if !strings.Contains(stderr, tc.wantStderr) {
+				t.Fatalf("stderr %q lacks %q", stderr, tc.wantStderr)
+			}
+		})
+	}
+}
@@ -0,0 +1,454 @@
+package cli
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"reverse-proxy-agent/pkg/monitor"
+)
+
+func TestCheckIdentityFile(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("no unix mode bits on windows")
+	}
+	cases := []struct {
+		name       string
+		mode       os.FileMode
+		missing    bool
+		unset      bool
+		wantStatus string
+	}{
+		{name: "owner read write", mode: 0o600, wantStatus: "ok"},
+		{name: "owner read only", mode: 0o400, wantStatus: "ok"},
+		{name: "owner all", mode: 0o700, wantStatus: "ok"},
+		{name: "group readable", mode: 0o640, wantStatus: "warn"},
+		{name: "group writable", mode: 0o620, wantStatus: "warn"},
+		{name: "world readable", mode: 0o604, wantStatus: "warn"},
+		{name: "default umask", mode: 0o644, wantStatus: "warn"},
+		{name: "missing file", missing: true, wantStatus: "fail"},
+		{name: "not configured", unset: true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "id_ed25519")
+			if !tc.missing && !tc.unset {
+				writeTestFile(t, path)
+				if err := os.Chmod(path, tc.mode); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if tc.unset {
+				path = ""
+			}
+			report := &doctorReport{}
+			checkIdentityFile(report, path)
+			if tc.unset {
+				if len(report.checks) != 0 {
+					t.Fatalf("checks = %+v, want none", report.checks)
+				}
+				return
+			}
+			if len(report.checks) != 1 {
+				t.Fatalf("checks = %+v, want one", report.checks)
+			}
+			check := report.checks[0]
+			if check.Status != tc.wantStatus {
+				t.Fatalf("status = %q (%s), want %q", check.Status, check.Detail, tc.wantStatus)
+			}
+			if tc.wantStatus == "warn" && !strings.Contains(check.Detail, "chmod 600 "+path) {
+				t.Fatalf("warning %q does not suggest chmod 600", check.Detail)
+			}
+		})
+	}
+}
+
+func TestCheckHostResolve(t *testing.T) {
+	old := slowResolveThreshold
+	slowResolveThreshold = 50 * time.Millisecond
+	t.Cleanup(func() { slowResolveThreshold = old })
+
+	cases := []struct {
+		name       string
+		delay      time.Duration
+		addrs      []string
+		err        error
+		wantStatus string
+		wantDetail string
+	}{
+		{name: "fast", addrs: []string{"10.0.0.1", "10.0.0.2"}, wantStatus: "ok", wantDetail: "-> 10.0.0.1, 10.0.0.2"},
+		{name: "slow", delay: 80 * time.Millisecond, addrs: []string{"10.0.0.1"}, wantStatus: "warn", wantDetail: "slow lookup"},
+		{name: "fails", err: errors.New("no such host"), wantStatus: "fail", wantDetail: "no such host after"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			var asked string
+			lookup := func(host string) ([]string, error) {
+				asked = host
+				time.Sleep(tc.delay)
+				return tc.addrs, tc.err
+			}
+			report := &doctorReport{}
+			checkHostResolve(report, "bastion.example.com", lookup)
+			if asked != "bastion.example.com" {
+				t.Fatalf("looked up %q", asked)
+			}
+			if len(report.checks) != 1 {
+				t.Fatalf("checks = %+v, want one", report.checks)
+			}
+			check := report.checks[0]
+			if check.Status != tc.wantStatus || !strings.Contains(check.Detail, tc.wantDetail) {
+				t.Fatalf("check = %+v, want %s containing %q", check, tc.wantStatus, tc.wantDetail)
+			}
+		})
+	}
+}
+
+func TestCheckIdentityAgent(t *testing.T) {
+	dir, err := os.MkdirTemp("", "rpa")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	sock := filepath.Join(dir, "agent.sock")
+	listener, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Skipf("unix sockets unavailable: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	plain := filepath.Join(dir, "plain")
+	writeTestFile(t, plain)
+
+	cases := []struct {
+		name       string
+		agent      string
+		authSock   string
+		wantStatus string
+		wantDetail string
+	}{
+		{name: "not configured"},
+		{name: "disabled", agent: "none"},
+		{name: "socket", agent: sock, wantStatus: "ok"},
+		{name: "missing", agent: filepath.Join(dir, "gone.sock"), wantStatus: "fail", wantDetail: "no such file"},
+		{name: "regular file", agent: plain, wantStatus: "fail", wantDetail: "is not a socket"},
+		{name: "from environment", agent: "SSH_AUTH_SOCK", authSock: sock, wantStatus: "ok"},
+		{name: "from environment with dollar", agent: "$SSH_AUTH_SOCK", authSock: sock, wantStatus: "ok"},
+		{name: "environment unset", agent: "SSH_AUTH_SOCK", wantStatus: "fail", wantDetail: "SSH_AUTH_SOCK is not set"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Setenv("SSH_AUTH_SOCK", tc.authSock)
+			report := &doctorReport{}
+			checkIdentityAgent(report, tc.agent)
+			if tc.wantStatus == "" {
+				if len(report.checks) != 0 {
+					t.Fatalf("checks = %+v, want none", report.checks)
+				}
+				return
+			}
+			if len(report.checks) != 1 {
+				t.Fatalf("checks = %+v, want one", report.checks)
+			}
+			check := report.checks[0]
+			if check.Status != tc.wantStatus || !strings.Contains(check.Detail, tc.wantDetail) {
+				t.Fatalf("check = %+v, want %s containing %q", check, tc.wantStatus, tc.wantDetail)
+			}
+		})
+	}
+}
+
+func TestDoctorAllExitCode(t *testing.T) {
+	const (
+		agentPart  = "  remote_forwards:\n    - \"0.0.0.0:2222:localhost:22\"\n"
+		clientPart = "client:\n  local_forwards:\n    - \"127.0.0.1:5432:db.internal:5432\"\n"
+	)
+	cases := []struct {
+		name        string
+		forwards    string
+		resolveErr  error
+		json        bool
+		wantCode    int
+		wantSummary string
+	}{
+		{name: "both pass", forwards: agentPart + clientPart, wantCode: exitOK, wantSummary: "summary: agent OK, client OK"},
+		{name: "client fails", forwards: agentPart, wantCode: exitError, wantSummary: "summary: agent OK, client FAIL"},
+		{name: "agent fails", forwards: clientPart, wantCode: exitError, wantSummary: "summary: agent FAIL, client OK"},
+		{name: "both fail", forwards: agentPart + clientPart, resolveErr: errors.New("no such host"), wantCode: exitError, wantSummary: "summary: agent FAIL, client FAIL"},
+		{name: "json pass", forwards: agentPart + clientPart, json: true, wantCode: exitOK},
+		{name: "json client fails", forwards: agentPart, json: true, wantCode: exitError},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Cleanup(resetGlobals)
+			dir := t.TempDir()
+			fakeSSH(t, dir)
+			oldLookup := lookupHost
+			lookupHost = func(string) ([]string, error) {
+				if tc.resolveErr != nil {
+					return nil, tc.resolveErr
+				}
+				return []string{"192.0.2.10"}, nil
+			}
+			t.Cleanup(func() { lookupHost = oldLookup })
+
+			keyPath := filepath.Join(dir, "id_ed25519")
+			writeTestFile(t, keyPath)
+			cfgPath := filepath.Join(dir, "rpa.yaml")
+			writeConfig(t, cfgPath, "ssh:\n  user: me\n  host: example.com\n  identity_file: "+keyPath+"\n"+tc.forwards)
+
+			args := []string{"--home", dir, "doctor", "--all", "--config", cfgPath}
+			if tc.json {
+				args = append(args, "--json")
+			}
+			var code int
+			stdout, stderr := captureOutput(t, func() { code = Run(args) })
+			if code != tc.wantCode {
+				t.Fatalf("exit code = %d, want %d\nstdout:\n%s\nstderr:\n%s", code, tc.wantCode, stdout, stderr)
+			}
+			if tc.json {
+				var out map[string][]doctorCheck
+				if err := json.Unmarshal([]byte(stdout), &out); err != nil {
+					t.Fatalf("doctor --all --json output %q: %v", stdout, err)
+				}
+				if len(out["agent"]) == 0 || len(out["client"]) == 0 {
+					t.Fatalf("json output %v lacks a target", out)
+				}
+				return
+			}
+			if !strings.Contains(stdout, tc.wantSummary) {
+				t.Fatalf("stdout %q lacks %q", stdout, tc.wantSummary)
+			}
+		})
+	}
+}
+
+// fakeSSH puts a stub ssh first on PATH so doctor's binary check does not
+// depend on the machine.
+func fakeSSH(t *testing.T, dir string) {
+	t.Helper()
+	stubSSH(t, dir, "exit 0")
+}
+
+// stubSSH puts an ssh on PATH that answers -V with a version and otherwise
+// runs script.
+func stubSSH(t *testing.T, dir, script string) {
+	t.Helper()
+	bin := filepath.Join(dir, "bin")
+	if err := os.MkdirAll(bin, 0o700); err != nil {
+		t.Fatal(err)
+	}
+	body := "#!/bin/sh\nif [ \"$1\" = -V ]; then echo 'OpenSSH_9.6p1, LibreSSL 3.3.6' >&2; exit 0; fi\n" + script + "\n"
+	if err := os.WriteFile(filepath.Join(bin, "ssh"), []byte(body), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+}
+
+func TestForwardDirectionHint(t *testing.T) {
+	cases := []struct {
+		name     string
+		kind     string
+		spec     string
+		wantHint string
+	}{
+		{name: "local on loopback", kind: "local", spec: "5432:db.internal:5432"},
+		{name: "local on explicit loopback", kind: "local", spec: "127.0.0.1:5432:db.internal:5432"},
+		{name: "local on all interfaces", kind: "local", spec: "0.0.0.0:5432:db.internal:5432", wantHint: "local forward listens on all interfaces"},
+		{name: "local on star", kind: "local", spec: "*:8080:localhost:80", wantHint: "local forward listens on all interfaces"},
+		{name: "local on ipv6 wildcard", kind: "local", spec: ":::8080:localhost:80"},
+		{name: "remote exposing a local app", kind: "remote", spec: "0.0.0.0:2222:localhost:22"},
+		{name: "remote dev server", kind: "remote", spec: "8080:localhost:3000"},
+		{name: "remote mirroring a service port", kind: "remote", spec: "5432:localhost:5432", wantHint: "remote forward exposes local port 5432"},
+		{name: "remote mirroring with bind", kind: "remote", spec: "0.0.0.0:443:127.0.0.1:443", wantHint: "use client.local_forwards"},
+		{name: "remote mirroring an uncommon port", kind: "remote", spec: "9000:localhost:9000"},
+		{name: "remote mirroring to another host", kind: "remote", spec: "5432:db.lan:5432"},
+		{name: "dynamic style spec", kind: "local", spec: "1080"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			hint := forwardDirectionHint(tc.kind, tc.spec)
+			if tc.wantHint == "" {
+				if hint != "" {
+					t.Fatalf("hint = %q, want none", hint)
+				}
+				return
+			}
+			if !strings.Contains(hint, tc.wantHint) {
+				t.Fatalf("hint = %q, want it to contain %q", hint, tc.wantHint)
+			}
+		})
+	}
+}
+
+func TestCheckForwardDirection(t *testing.T) {
+	cases := []struct {
+		name     string
+		kind     string
+		forwards []string
+		want     []string
+	}{
+		{name: "no forwards", kind: "remote"},
+		{name: "all fine", kind: "local", forwards: []string{"5432:db.internal:5432"}, want: []string{"ok"}},
+		{name: "one suspicious", kind: "remote", forwards: []string{"8080:localhost:3000", "6379:localhost:6379"}, want: []string{"warn"}},
+		{name: "each suspicious spec warns", kind: "local", forwards: []string{"0.0.0.0:1:a:1", "*:2:b:2"}, want: []string{"warn", "warn"}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			report := &doctorReport{}
+			checkForwardDirection(report, tc.kind, tc.forwards)
+			var got []string
+			for _, check := range report.checks {
+				got = append(got, check.Status)
+			}
+			if !equalStrings(got, tc.want) {
+				t.Fatalf("statuses = %v, want %v (%+v)", got, tc.want, report.checks)
+			}
+			if report.failed() {
+				t.Fatal("forward direction failed doctor; it must only warn")
+			}
+		})
+	}
+}
+
+func TestCheckMonitors(t *testing.T) {
+	info := monitor.Info()
+	want := "ok"
+	if info.Fallback() {
+		want = "warn"
+	}
+	report := &doctorReport{}
+	checkMonitors(report)
+	if len(report.checks) != 1 {
+		t.Fatalf("checks = %+v, want one", report.checks)
+	}
+	check := report.checks[0]
+	if check.Name != "monitors" || check.Status != want || !strings.Contains(check.Detail, info.String()) {
+		t.Fatalf("check = %+v, want monitors %s with %q", check, want, info)
+	}
+	if report.failed() {
+		t.Fatal("monitor check failed doctor; polling fallbacks must only warn")
+	}
+}
+
+func TestDoctorJSON(t *testing.T) {
+	const forwards = "  remote_forwards:\n    - \"0.0.0.0:2222:localhost:22\"\nclient:\n  local_forwards:\n    - \"127.0.0.1:5432:db.internal:5432\"\n"
+	cases := []struct {
+		name       string
+		target     string
+		resolveErr error
+		noConfig   bool
+		wantCode   int
+		wantFail   string
+	}{
+		{name: "agent pass", target: "agent", wantCode: exitOK},
+		{name: "client pass", target: "client", wantCode: exitOK},
+		{name: "agent host fails", target: "agent", resolveErr: errors.New("no such host"), wantCode: exitError, wantFail: "host resolve"},
+		{name: "client host fails", target: "client", resolveErr: errors.New("no such host"), wantCode: exitError, wantFail: "host resolve"},
+		{name: "missing config", target: "agent", noConfig: true, wantCode: exitError, wantFail: "config"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Cleanup(resetGlobals)
+			dir := t.TempDir()
+			fakeSSH(t, dir)
+			oldLookup := lookupHost
+			lookupHost = func(string) ([]string, error) {
+				if tc.resolveErr != nil {
+					return nil, tc.resolveErr
+				}
+				return []string{"192.0.2.10"}, nil
+			}
+			t.Cleanup(func() { lookupHost = oldLookup })
+
+			keyPath := filepath.Join(dir, "id_ed25519")
+			writeTestFile(t, keyPath)
+			cfgPath := filepath.Join(dir, "rpa.yaml")
+			if !tc.noConfig {
+				writeConfig(t, cfgPath, "ssh:\n  user: me\n  host: example.com\n  identity_file: "+keyPath+"\n"+forwards)
+			}
+
+			args := []string{"--home", dir, "doctor", tc.target, "--config", cfgPath}
+			var code int
+			stdout, stderr := captureOutput(t, func() { code = Run(append(args, "--json")) })
+			if code != tc.wantCode {
+				t.Fatalf("exit code = %d, want %d\nstdout:\n%s\nstderr:\n%s", code, tc.wantCode, stdout, stderr)
+			}
+			var checks []doctorCheck
+			if err := json.Unmarshal([]byte(stdout), &checks); err != nil {
+				t.Fatalf("doctor --json output %q: %v", stdout, err)
+			}
+			if len(checks) == 0 {
+				t.Fatal("doctor --json printed no checks")
+			}
+			failed := ""
+			for _, check := range checks {
+				switch check.Status {
+				case "ok", "warn":
+				case "fail":
+					if failed == "" {
+						failed = check.Name
+					}
+				default:
+					t.Fatalf("check %+v has status %q, want ok, warn or fail", check, check.Status)
+				}
+			}
+			if failed != tc.wantFail {
+				t.Fatalf("first failed check = %q, want %q in %+v", failed, tc.wantFail, checks)
+			}
+			if tc.noConfig {
+				return
+			}
+
+			// The text run reports the same checks in the same order.
+			var textCode int
+			textOut, textErr := captureOutput(t, func() { textCode = Run(args) })
+			if textCode != code {
+				t.Fatalf("text exit code = %d, json exit code = %d", textCode, code)
+			}
+			for _, check := range checks {
+				line := "check " + check.Name + ": " + strings.ToUpper(check.Status)
+				stream := textOut
+				if check.Status != "ok" {
+					stream = textErr
+				}
+				if !strings.Contains(stream, line) {
+					t.Fatalf("text output lacks %q\nstdout:\n%s\nstderr:\n%s", line, textOut, textErr)
+				}
+			}
+		})
+	}
+}
+
+func TestDoctorReport(t *testing.T) {
+	cases := []struct {
+		name       string
+		build      func(r *doctorReport)
+		wantFailed bool
+		wantStdout string
+		wantStderr string
+	}{
+		{name: "empty", build: func(*doctorReport) {}},
+		{name: "ok only", build: func(r *doctorReport) { r.ok("ssh", "OpenSSH_9.6") }, wantStdout: "check ssh: OK (OpenSSH_9.6)\n"},
+		{name: "warn does not fail", build: func(r *doctorReport) { r.ok("ssh", ""); r.warn("agent", "no keys") }, wantStdout: "check ssh: OK\n", wantStderr: "check agent: WARN (no keys)\n"},
+		{name: "fail", build: func(r *doctorReport) { r.fail("host", "no such host"); r.ok("ssh", "") }, wantFailed: true, wantStdout: "check ssh: OK\n", wantStderr: "check host: FAIL (no such host)\n"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			report := &doctorReport{}
+			tc.build(report)
+			if got := report.failed(); got != tc.wantFailed {
+				t.Fatalf("failed() = %v, want %v", got, tc.wantFailed)
+			}
+			stdout, stderr := captureOutput(t, report.printText)
+			if stdout != tc.wantStdout || stderr != tc.wantStderr {
+				t.Fatalf("printText stdout %q, stderr %q; want %q, %q", stdout, stderr, tc.wantStdout, tc.wantStderr)
+			}
+		})
+	}
+}
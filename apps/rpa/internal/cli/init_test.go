@@ -0,0 +1,241 @@
+package cli
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"reverse-proxy-agent/pkg/config"
+)
+
+// stubKeygen replaces ssh-keygen with a shell script run as `sh -c script sh
+// <path>`.
+func stubKeygen(t *testing.T, script string) {
+	t.Helper()
+	old := keygenCommand
+	keygenCommand = func(path string) *exec.Cmd {
+		return exec.Command("sh", "-c", script, "sh", path)
+	}
+	t.Cleanup(func() { keygenCommand = old })
+}
+
+const (
+	keygenOK   = `printf 'PRIVATE\n' > "$1" && printf 'ssh-ed25519 AAAAtest rpa\n' > "$1.pub"`
+	keygenFail = `printf 'PRIVATE\n' > "$1"; echo 'Saving key failed' >&2; exit 1`
+)
+
+func TestInitGenerateKey(t *testing.T) {
+	cases := []struct {
+		name string
+		// setup may create files in dir and returns the config path to use.
+		setup      func(t *testing.T, dir string) string
+		keygen     string
+		force      bool
+		wantCode   int
+		wantConfig bool
+		wantKey    bool
+		wantStdout string
+	}{
+		{
+			name:       "key and config written",
+			keygen:     keygenOK,
+			wantCode:   exitOK,
+			wantConfig: true,
+			wantKey:    true,
+			wantStdout: "ssh-ed25519 AAAAtest rpa",
+		},
+		{
+			name:     "failed keygen leaves nothing",
+			keygen:   keygenFail,
+			wantCode: exitError,
+		},
+		{
+			name:   "failed config write removes the key",
+			keygen: keygenOK,
+			// A directory in place of the config passes --force but cannot
+			// be written.
+			setup: func(t *testing.T, dir string) string {
+				cfgDir := filepath.Join(dir, "conf.d")
+				if err := os.MkdirAll(cfgDir, 0o700); err != nil {
+					t.Fatal(err)
+				}
+				return cfgDir
+			},
+			force:      true,
+			wantCode:   exitError,
+			wantConfig: true,
+		},
+		{
+			name:   "existing public key is not overwritten",
+			keygen: keygenOK,
+			setup: func(t *testing.T, dir string) string {
+				writeTestFile(t, filepath.Join(dir, "keys", "id_rpa.pub"))
+				return ""
+			},
+			wantCode: exitError,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			cfgPath := filepath.Join(dir, "rpa.yaml")
+			if tc.setup != nil {
+				if p := tc.setup(t, dir); p != "" {
+					cfgPath = p
+				}
+			}
+			keyPath := filepath.Join(dir, "keys", "id_rpa")
+			_, hadPub := os.Stat(keyPath + ".pub")
+			stubKeygen(t, tc.keygen)
+
+			var code int
+			args := initArgs(dir, cfgPath, keyPath)
+			if tc.force {
+				args = append(args, "--force")
+			}
+			stdout, stderr := captureOutput(t, func() { code = Run(args) })
+			t.Cleanup(resetGlobals)
+			if code != tc.wantCode {
+				t.Fatalf("exit code = %d, want %d (stderr %q)", code, tc.wantCode, stderr)
+			}
+			if got := exists(cfgPath); got != tc.wantConfig {
+				t.Errorf("config exists = %v, want %v", got, tc.wantConfig)
+			}
+			if got := exists(keyPath); got != tc.wantKey {
+				t.Errorf("private key exists = %v, want %v", got, tc.wantKey)
+			}
+			if got, want := exists(keyPath+".pub"), tc.wantKey || hadPub == nil; got != want {
+				t.Errorf("public key exists = %v, want %v", got, want)
+			}
+			if !strings.Contains(stdout, tc.wantStdout) {
+				t.Errorf("stdout %q does not contain %q", stdout, tc.wantStdout)
+			}
+		})
+	}
+}
+
+func TestInitGenerateKeyRerunAfterFailure(t *testing.T) {
+	dir := t.TempDir()
+	cfgPath := filepath.Join(dir, "rpa.yaml")
+	keyPath := filepath.Join(dir, "keys", "id_rpa")
+	t.Cleanup(resetGlobals)
+
+	stubKeygen(t, keygenFail)
+	if code := quietRun(t, initArgs(dir, cfgPath, keyPath)); code != exitError {
+		t.Fatalf("first run = %d, want %d", code, exitError)
+	}
+	stubKeygen(t, keygenOK)
+	if code := quietRun(t, initArgs(dir, cfgPath, keyPath)); code != exitOK {
+		t.Fatalf("rerun = %d, want %d", code, exitOK)
+	}
+	if !exists(cfgPath) || !exists(keyPath) {
+		t.Fatal("rerun did not write the config and key")
+	}
+}
+
+func initArgs(home, cfgPath, keyPath string) []string {
+	return []string{
+		"--home", home, "init",
+		"--config", cfgPath,
+		"--ssh-user", "me",
+		"--ssh-host", "example.com",
+		"--remote-forward", "0.0.0.0:2222:localhost:22",
+		"--ssh-identity-file", keyPath,
+		"--generate-key",
+	}
+}
+
+func quietRun(t *testing.T, args []string) int {
+	t.Helper()
+	var code int
+	captureOutput(t, func() { code = Run(args) })
+	return code
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+func TestReadForwardFile(t *testing.T) {
+	cases := []struct {
+		name    string
+		content string
+		want    []string
+		wantErr string
+	}{
+		{
+			name:    "comments and blanks skipped",
+			content: "# web\n0.0.0.0:8080:localhost:80\n\n   \n  # indented comment\n  0.0.0.0:2222:localhost:22  \n",
+			want:    []string{"0.0.0.0:8080:localhost:80", "0.0.0.0:2222:localhost:22"},
+		},
+		{
+			name:    "crlf line endings",
+			content: "0.0.0.0:8080:localhost:80\r\n# note\r\n",
+			want:    []string{"0.0.0.0:8080:localhost:80"},
+		},
+		{name: "only comments", content: "# nothing yet\n\n", want: nil},
+		{
+			name:    "bad spec names its line",
+			content: "# header\n0.0.0.0:8080:localhost:80\n\nnot-a-spec\n",
+			wantErr: "line 4:",
+		},
+		{
+			name:    "bad port names its line",
+			content: "0.0.0.0:99999:localhost:80\n",
+			wantErr: "line 1:",
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "forwards.txt")
+			if err := os.WriteFile(path, []byte(tc.content), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			got, err := readForwardFile(path)
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) || !strings.Contains(err.Error(), path) {
+					t.Fatalf("err = %v, want it to name %s and %q", err, path, tc.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("readForwardFile: %v", err)
+			}
+			if !equalStrings(got, tc.want) {
+				t.Fatalf("specs = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestInitForwardFileMerges(t *testing.T) {
+	home := t.TempDir()
+	cfgPath := filepath.Join(home, "rpa.yaml")
+	file := filepath.Join(home, "forwards.txt")
+	if err := os.WriteFile(file, []byte("# from the file\n0.0.0.0:8080:localhost:80\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	args := []string{
+		"--home", home, "init",
+		"--config", cfgPath,
+		"--ssh-user", "me",
+		"--ssh-host", "example.com",
+		"--ssh-identity-file", filepath.Join(home, "id_ed25519"),
+		"--remote-forward", "0.0.0.0:2222:localhost:22",
+		"--remote-forward-file", file,
+	}
+	if code := quietRun(t, args); code != exitOK {
+		t.Fatalf("init = %d, want %d", code, exitOK)
+	}
+	cfg, err := config.Load(cfgPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"0.0.0.0:2222:localhost:22", "0.0.0.0:8080:localhost:80"}
+	if got := config.NormalizeRemoteForwards(cfg); !equalStrings(got, want) {
+		t.Fatalf("remote forwards = %q, want %q", got, want)
+	}
+}
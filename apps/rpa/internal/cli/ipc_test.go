@@ -0,0 +1,132 @@
+package cli
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseIPCArg(t *testing.T) {
+	cases := []struct {
+		value   string
+		key     string
+		val     string
+		wantErr bool
+	}{
+		{value: "remote_forward=9090:localhost:90", key: "remote_forward", val: "9090:localhost:90"},
+		{value: " name =value", key: "name", val: "value"},
+		{value: "empty=", key: "empty", val: ""},
+		{value: "expr=a=b", key: "expr", val: "a=b"},
+		{value: "novalue", wantErr: true},
+		{value: "=value", wantErr: true},
+		{value: " =value", wantErr: true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.value, func(t *testing.T) {
+			key, val, err := parseIPCArg(tc.value)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("parseIPCArg error = %v, want error %v", err, tc.wantErr)
+			}
+			if key != tc.key || val != tc.val {
+				t.Fatalf("parseIPCArg = %q, %q; want %q, %q", key, val, tc.key, tc.val)
+			}
+		})
+	}
+}
+
+func TestIPCCommand(t *testing.T) {
+	cases := []struct {
+		name       string
+		args       []string
+		serve      string
+		reply      ipcReply
+		wantCode   int
+		wantReq    *ipcRequest
+		wantStdout ipcReply
+		wantStderr string
+	}{
+		{
+			name:       "agent with args",
+			args:       []string{"agent", "add_forward", "--arg", "remote_forward=9090:localhost:90", "--experimental"},
+			serve:      "agent",
+			reply:      ipcReply{OK: true, Message: "forward added"},
+			wantReq:    &ipcRequest{Command: "add_forward", Args: map[string]string{"remote_forward": "9090:localhost:90"}},
+			wantStdout: ipcReply{OK: true, Message: "forward added"},
+		},
+		{
+			name:       "client without args",
+			args:       []string{"client", "status", "--experimental"},
+			serve:      "client",
+			reply:      ipcReply{OK: true, Data: map[string]string{"state": "CONNECTED"}},
+			wantReq:    &ipcRequest{Command: "status"},
+			wantStdout: ipcReply{OK: true, Data: map[string]string{"state": "CONNECTED"}},
+		},
+		{
+			name:       "not ok reply",
+			args:       []string{"agent", "bogus", "--experimental"},
+			serve:      "agent",
+			reply:      ipcReply{Message: "unknown command"},
+			wantCode:   exitError,
+			wantReq:    &ipcRequest{Command: "bogus"},
+			wantStdout: ipcReply{Message: "unknown command"},
+		},
+		{name: "needs experimental", args: []string{"agent", "status"}, serve: "agent", wantCode: exitUsage, wantStderr: "pass --experimental"},
+		{name: "bad arg", args: []string{"agent", "status", "--arg", "novalue", "--experimental"}, serve: "agent", wantCode: exitUsage, wantStderr: "--arg must be key=value"},
+		{name: "missing command", args: []string{"agent", "--experimental"}, wantCode: exitUsage, wantStderr: "usage: rpa ipc"},
+		{name: "unknown target", args: []string{"proxy", "status", "--experimental"}, wantCode: exitUsage, wantStderr: "unknown ipc target: proxy"},
+		{name: "agent not running", args: []string{"agent", "status", "--experimental"}, wantCode: exitError, wantStderr: "ipc status failed"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Cleanup(resetGlobals)
+			home := shortHome(t)
+			cfgPath := filepath.Join(home, "rpa.yaml")
+			writeConfig(t, cfgPath, addTestConfig)
+			requests := func() []ipcRequest { return nil }
+			if tc.serve != "" {
+				requests = fakeIPC(t, filepath.Join(home, tc.serve+".sock"), func(ipcRequest) ipcReply { return tc.reply })
+			}
+
+			var code int
+			stdout, stderr := captureOutput(t, func() {
+				code = Run(append([]string{"--home", home, "ipc"}, append(tc.args, "--config", cfgPath)...))
+			})
+			if code != tc.wantCode {
+				t.Fatalf("exit code = %d, want %d (stderr %q)", code, tc.wantCode, stderr)
+			}
+			if !strings.Contains(stderr, tc.wantStderr) {
+				t.Fatalf("stderr %q lacks %q", stderr, tc.wantStderr)
+			}
+			got := requests()
+			if tc.wantReq == nil {
+				if len(got) != 0 {
+					t.Fatalf("requests = %+v, want none", got)
+				}
+				return
+			}
+			if len(got) != 1 || got[0].Command != tc.wantReq.Command || !equalArgs(got[0].Args, tc.wantReq.Args) {
+				t.Fatalf("requests = %+v, want %+v", got, *tc.wantReq)
+			}
+			var printed ipcReply
+			if err := json.Unmarshal([]byte(stdout), &printed); err != nil {
+				t.Fatalf("stdout is not JSON: %v\n%s", err, stdout)
+			}
+			if printed.OK != tc.wantStdout.OK || printed.Message != tc.wantStdout.Message || !equalArgs(printed.Data, tc.wantStdout.Data) {
+				t.Fatalf("printed %+v, want %+v", printed, tc.wantStdout)
+			}
+		})
+	}
+}
+
+func equalArgs(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if bv, ok := b[k]; !ok || bv != v {
+			return false
+		}
+	}
+	return true
+}
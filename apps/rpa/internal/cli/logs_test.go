@@ -0,0 +1,579 @@
+package cli
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"reverse-proxy-agent/pkg/config"
+	"reverse-proxy-agent/pkg/logging"
+)
+
+func stampedLine(event string, at time.Time) string {
+	return fmt.Sprintf(`{"level":"info","event":%q,"time":%q}`, event, at.UTC().Format(time.RFC3339))
+}
+
+func TestLogsSinceMixedAges(t *testing.T) {
+	now := time.Date(2026, 5, 1, 12, 0, 0, 0, time.UTC)
+	var old []string
+	// Enough old lines to span several read chunks.
+	for i := 0; len(strings.Join(old, "\n")) < 4*tailChunkSize; i++ {
+		old = append(old, stampedLine(fmt.Sprintf("old_%d", i), now.Add(-2*time.Hour)))
+	}
+	cases := []struct {
+		name  string
+		since time.Duration
+		tail  []string
+		want  []string
+	}{
+		{
+			name:  "recent lines only",
+			since: 10 * time.Minute,
+			tail:  []string{stampedLine("a", now.Add(-20*time.Minute)), stampedLine("b", now.Add(-5*time.Minute)), stampedLine("c", now.Add(-time.Minute))},
+			want:  []string{"b", "c"},
+		},
+		{
+			name:  "unstamped lines are kept",
+			since: 10 * time.Minute,
+			tail:  []string{stampedLine("a", now.Add(-20*time.Minute)), "panic: not a log line", stampedLine("b", now.Add(-time.Minute)), "goroutine 1 [running]:"},
+			want:  []string{"panic: not a log line", "b", "goroutine 1 [running]:"},
+		},
+		{
+			name:  "nothing recent",
+			since: time.Minute,
+			tail:  []string{stampedLine("a", now.Add(-20*time.Minute))},
+			want:  nil,
+		},
+		{
+			name:  "wide window reaches the old lines",
+			since: 3 * time.Hour,
+			tail:  []string{stampedLine("b", now.Add(-time.Minute))},
+			want:  append(eventsOf(old), "b"),
+		},
+		{
+			name:  "long line has no length limit",
+			since: 10 * time.Minute,
+			tail:  []string{stampedLine(strings.Repeat("x", 3*tailChunkSize), now.Add(-time.Minute))},
+			want:  []string{strings.Repeat("x", 3*tailChunkSize)},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			all := append(append([]string{}, old...), tc.tail...)
+			path := writeLogFile(t, all)
+			filter := logFilter{since: tc.since}
+			lines, err := tailSince(path, now.Add(-tc.since), func(line string) (time.Time, bool) {
+				return logLineTime(line, filter.timeLayout, filter.timeLocal)
+			})
+			if err != nil {
+				t.Fatalf("tailSince: %v", err)
+			}
+			if tc.since < time.Hour && len(lines) >= len(all) {
+				t.Errorf("read all %d lines; expected to stop at the old ones", len(all))
+			}
+			got := eventsOf(filter.apply(lines, now))
+			if !equalStrings(got, tc.want) {
+				t.Fatalf("got %d lines %.200q, want %d %.200q", len(got), got, len(tc.want), tc.want)
+			}
+		})
+	}
+}
+
+func TestLogLineTime(t *testing.T) {
+	saved := time.Local
+	time.Local = time.FixedZone("TEST", 5*3600+1800)
+	t.Cleanup(func() { time.Local = saved })
+	utc := time.Date(2026, 3, 4, 5, 6, 7, 0, time.UTC)
+	local := time.Date(2026, 3, 4, 5, 6, 7, 0, time.Local)
+
+	cases := []struct {
+		name   string
+		line   string
+		layout string
+		local  bool
+		want   time.Time
+		wantOK bool
+	}{
+		{name: "json default", line: `{"event":"a","time":"2026-03-04T05:06:07Z"}`, want: utc, wantOK: true},
+		{name: "text default", line: "2026-03-04T05:06:07Z INF event=a", want: utc, wantOK: true},
+		{name: "json custom utc", line: `{"event":"a","time":"2026-03-04 05:06:07"}`, layout: "2006-01-02 15:04:05", want: utc, wantOK: true},
+		{name: "json custom local", line: `{"event":"a","time":"2026-03-04 05:06:07"}`, layout: "2006-01-02 15:04:05", local: true, want: local, wantOK: true},
+		{name: "text custom", line: "2026-03-04 05:06:07 INF event=a", layout: "2006-01-02 15:04:05", want: utc, wantOK: true},
+		{name: "text padded day", line: "Mar  4 05:06:07 INF event=a", layout: "Jan _2 15:04:05", want: time.Date(0, 3, 4, 5, 6, 7, 0, time.UTC), wantOK: true},
+		{name: "text unpadded day", line: "Mar 14 05:06:07 INF event=a", layout: "Jan _2 15:04:05", want: time.Date(0, 3, 14, 5, 6, 7, 0, time.UTC), wantOK: true},
+		{name: "zone in stamp wins over local", line: "2026-03-04T05:06:07Z INF event=a", local: true, want: utc, wantOK: true},
+		{name: "old rfc3339 line under a custom layout", line: "2026-03-04T05:06:07Z INF event=a", layout: "2006-01-02 15:04:05"},
+		{name: "json without time", line: `{"event":"a"}`},
+		{name: "text without stamp", line: "INF event=a"},
+		{name: "short line", line: "2026-03-04", layout: "2006-01-02 15:04:05"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			at, ok := logLineTime(tc.line, tc.layout, tc.local)
+			if ok != tc.wantOK {
+				t.Fatalf("logLineTime(%q) ok = %v, want %v", tc.line, ok, tc.wantOK)
+			}
+			if ok && !at.Equal(tc.want) {
+				t.Fatalf("logLineTime(%q) = %s, want %s", tc.line, at, tc.want)
+			}
+		})
+	}
+}
+
+func TestLogsSinceTimeFormat(t *testing.T) {
+	home := t.TempDir()
+	cfgPath := filepath.Join(home, "rpa.yaml")
+	writeConfig(t, cfgPath, "ssh:\n  user: me\n  host: example.com\n  remote_forwards:\n    - \"0.0.0.0:2222:localhost:22\"\nlogging:\n  format: text\n  time_format: \"2006-01-02 15:04:05\"\n  local_time: true\n")
+	useHome(t, home)
+	cfg, err := config.Load(cfgPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logPath, err := config.LogPath(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, logPath)
+	old := time.Now().Add(-2 * time.Hour).Format("2006-01-02 15:04:05")
+	if err := os.WriteFile(logPath, []byte(old+" INF event=old_event\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	logger, err := logging.NewLogger(cfg, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger.Event("INFO", "ssh_started", nil)
+
+	var code int
+	stdout, stderr := captureOutput(t, func() {
+		code = Run([]string{"--home", home, "logs", "--since", "1h", "--config", cfgPath})
+	})
+	t.Cleanup(resetGlobals)
+	if code != exitOK {
+		t.Fatalf("exit code = %d, stderr %q", code, stderr)
+	}
+	if !strings.Contains(stdout, "event=ssh_started") || strings.Contains(stdout, "old_event") {
+		t.Fatalf("stdout %q, want ssh_started without old_event", stdout)
+	}
+}
+
+func TestLogLineEvent(t *testing.T) {
+	cases := []struct {
+		line      string
+		wantEvent string
+		wantLevel string
+		wantOK    bool
+	}{
+		{line: `{"level":"error","event":"ssh_exited","time":"2026-03-04T05:06:07Z"}`, wantEvent: "ssh_exited", wantLevel: "ERROR", wantOK: true},
+		{line: `{"event":"ssh_started"}`, wantEvent: "ssh_started", wantOK: true},
+		{line: `{"level":"info","message":"no event"}`},
+		{line: `{"level":"info",`},
+		{line: "2026-03-04T05:06:07Z WRN event=tcp_check_failed addr=db:5432", wantEvent: "tcp_check_failed", wantLevel: "WARN", wantOK: true},
+		{line: "2026-03-04 05:06:07 ERR event=ssh_exited class=INF", wantEvent: "ssh_exited", wantLevel: "ERROR", wantOK: true},
+		{line: "2026-03-04T05:06:07Z event=ssh_started", wantEvent: "ssh_started", wantOK: true},
+		{line: "debug1: Connecting to example.com"},
+		{line: ""},
+	}
+	for _, tc := range cases {
+		t.Run(tc.line, func(t *testing.T) {
+			event, level, ok := logLineEvent(tc.line)
+			if event != tc.wantEvent || level != tc.wantLevel || ok != tc.wantOK {
+				t.Fatalf("logLineEvent = %q, %q, %v; want %q, %q, %v", event, level, ok, tc.wantEvent, tc.wantLevel, tc.wantOK)
+			}
+		})
+	}
+}
+
+func TestPrintEventCounts(t *testing.T) {
+	line := func(level, event string) string {
+		return fmt.Sprintf(`{"level":%q,"event":%q,"time":"2026-03-04T05:06:07Z"}`, level, event)
+	}
+	cases := []struct {
+		name  string
+		lines []string
+		want  string
+	}{
+		{name: "no lines", want: ""},
+		{
+			name: "mixed levels, most frequent first",
+			lines: []string{
+				line("error", "ssh_exited"), line("info", "ssh_started"), line("error", "ssh_exited"),
+				line("info", "ssh_exited"), line("error", "ssh_exited"), line("info", "ssh_started"),
+				"2026-03-04T05:06:07Z WRN event=tcp_check_failed",
+			},
+			want: "ssh_exited: 4 (ERROR 3, INFO 1)\nssh_started: 2 (INFO 2)\ntcp_check_failed: 1 (WARN 1)\n",
+		},
+		{
+			name:  "ties sort by name",
+			lines: []string{line("warn", "b_event"), line("warn", "a_event"), line("info", "a_event"), line("warn", "b_event")},
+			want:  "a_event: 2 (INFO 1, WARN 1)\nb_event: 2 (WARN 2)\n",
+		},
+		{
+			name:  "missing level and unparsed lines",
+			lines: []string{`{"event":"start"}`, "debug1: ssh chatter", "no logs here"},
+			want:  "start: 1 (UNKNOWN 1)\nlines without an event: 2\n",
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			stdout, _ := captureOutput(t, func() { printEventCounts(tc.lines) })
+			if stdout != tc.want {
+				t.Fatalf("printEventCounts output:\n%s\nwant:\n%s", stdout, tc.want)
+			}
+		})
+	}
+}
+
+func TestLogsCountFlags(t *testing.T) {
+	cases := []struct {
+		name string
+		args []string
+	}{
+		{name: "follow", args: []string{"--count", "--follow"}},
+		{name: "follow shorthand", args: []string{"-f", "--count"}},
+		{name: "ssh stderr", args: []string{"--count", "--ssh-stderr"}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Cleanup(resetGlobals)
+			var code int
+			_, stderr := captureOutput(t, func() { code = Run(append([]string{"logs", "agent"}, tc.args...)) })
+			if code != exitUsage || !strings.Contains(stderr, "--count cannot be combined") {
+				t.Fatalf("exit code = %d, stderr %q; want usage error", code, stderr)
+			}
+		})
+	}
+}
+
+func TestTailLinesLongLine(t *testing.T) {
+	long := strings.Repeat("y", 2*tailChunkSize+17)
+	path := writeLogFile(t, []string{"first", long, "last"})
+	cases := []struct {
+		limit int
+		want  []string
+	}{
+		{limit: 1, want: []string{"last"}},
+		{limit: 2, want: []string{long, "last"}},
+		{limit: 5, want: []string{"first", long, "last"}},
+	}
+	for _, tc := range cases {
+		t.Run(fmt.Sprint(tc.limit), func(t *testing.T) {
+			got, err := tailLines(path, tc.limit)
+			if err != nil {
+				t.Fatalf("tailLines: %v", err)
+			}
+			if !equalStrings(got, tc.want) {
+				t.Fatalf("tailLines(%d) returned %d lines, want %d", tc.limit, len(got), len(tc.want))
+			}
+		})
+	}
+}
+
+// eventsOf maps JSON log lines to their event and leaves other lines as-is.
+func eventsOf(lines []string) []string {
+	var out []string
+	for _, line := range lines {
+		if event, _, ok := logLineEvent(line); ok {
+			out = append(out, event)
+			continue
+		}
+		out = append(out, line)
+	}
+	return out
+}
+
+func writeLogFile(t *testing.T, lines []string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "agent.log")
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// naiveTail is the whole-file tail tailLines must agree with; like
+// bufio.Scanner, "\n" is one empty line.
+func naiveTail(t testing.TB, path string, limit int) []string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) == 0 || limit <= 0 {
+		return nil
+	}
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimSuffix(line, "\r")
+	}
+	if len(lines) > limit {
+		lines = lines[len(lines)-limit:]
+	}
+	return lines
+}
+
+func TestTailLinesMatchesNaive(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	mixed := make([]byte, 0, 6*tailChunkSize)
+	for len(mixed) < 5*tailChunkSize {
+		// Line lengths around the chunk size so lines land on every kind of
+		// boundary, plus some empty ones.
+		n := rng.Intn(tailChunkSize + tailChunkSize/2)
+		if rng.Intn(8) == 0 {
+			n = 0
+		}
+		mixed = append(mixed, strings.Repeat(string(rune('a'+rng.Intn(26))), n)...)
+		mixed = append(mixed, '\n')
+	}
+	cases := []struct {
+		name    string
+		content string
+	}{
+		{name: "empty", content: ""},
+		{name: "single newline", content: "\n"},
+		{name: "no trailing newline", content: "one\ntwo\nthree"},
+		{name: "smaller than a chunk", content: "one\ntwo\nthree\n"},
+		{name: "crlf", content: "one\r\ntwo\r\nthree\r\n"},
+		{name: "blank lines", content: "one\n\n\ntwo\n\n"},
+		{name: "exactly one chunk", content: strings.Repeat("x", tailChunkSize-1) + "\n"},
+		{name: "newline on the chunk edge", content: "head\n" + strings.Repeat("x", tailChunkSize-1) + "\n"},
+		{name: "mixed lengths over several chunks", content: string(mixed)},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "agent.log")
+			if err := os.WriteFile(path, []byte(tc.content), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			total := len(naiveTail(t, path, 1<<30))
+			for _, limit := range []int{0, 1, 2, 3, total / 2, total - 1, total, total + 5} {
+				got, err := tailLines(path, limit)
+				if err != nil {
+					t.Fatalf("tailLines(%d): %v", limit, err)
+				}
+				if want := naiveTail(t, path, limit); !equalStrings(got, want) {
+					t.Fatalf("tailLines(%d) = %d lines, naive tail %d", limit, len(got), len(want))
+				}
+			}
+		})
+	}
+}
+
+func benchmarkLog(b *testing.B) string {
+	b.Helper()
+	path := filepath.Join(b.TempDir(), "agent.log")
+	line := stampedLine("ssh_started", time.Date(2026, 5, 1, 12, 0, 0, 0, time.UTC)) + "\n"
+	data := []byte(strings.Repeat(line, (64<<20)/len(line)))
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		b.Fatal(err)
+	}
+	return path
+}
+
+func BenchmarkTailLines(b *testing.B) {
+	path := benchmarkLog(b)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := tailLines(path, 200); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkTailLinesNaive(b *testing.B) {
+	path := benchmarkLog(b)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		naiveTail(b, path, 200)
+	}
+}
+
+func TestLogsTextFormat(t *testing.T) {
+	home := t.TempDir()
+	cfgPath := filepath.Join(home, "rpa.yaml")
+	writeConfig(t, cfgPath, "ssh:\n  user: me\n  host: example.com\n  remote_forwards:\n    - \"0.0.0.0:2222:localhost:22\"\nlogging:\n  format: text\n")
+	useHome(t, home)
+	cfg, err := config.Load(cfgPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logPath, err := config.LogPath(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, logPath)
+	if err := os.WriteFile(logPath, []byte("2020-01-01T00:00:00Z INF event=old_event\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	logger, err := logging.NewLogger(cfg, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger.Event("INFO", "ssh_started", nil)
+	logger.Event("ERROR", "ssh_exited", map[string]any{"class": "network"})
+	logger.Event("ERROR", "ssh_exited", map[string]any{"class": "network"})
+
+	cases := []struct {
+		name    string
+		global  []string
+		args    []string
+		want    []string
+		dropped string
+	}{
+		{name: "plain", args: []string{"logs"}, want: []string{"event=old_event", "event=ssh_started", "event=ssh_exited"}},
+		{name: "since drops old text lines", args: []string{"logs", "--since", "1h"}, want: []string{"event=ssh_started", "event=ssh_exited"}, dropped: "old_event"},
+		{name: "count", args: []string{"logs", "--count"}, want: []string{"ssh_exited: 2 (ERROR 2)", "ssh_started: 1 (INFO 1)"}},
+		{name: "count with since", args: []string{"logs", "--count", "--since", "1h"}, want: []string{"ssh_exited: 2 (ERROR 2)", "ssh_started: 1 (INFO 1)"}, dropped: "old_event"},
+		{name: "json errors flag", global: []string{"--json"}, args: []string{"logs", "--count"}, want: []string{"ssh_exited: 2 (ERROR 2)"}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			args := append(append([]string{}, tc.global...), "--home", home)
+			args = append(append(args, tc.args...), "--config", cfgPath)
+			var code int
+			stdout, stderr := captureOutput(t, func() { code = Run(args) })
+			t.Cleanup(resetGlobals)
+			if code != exitOK {
+				t.Fatalf("exit code = %d, stderr %q", code, stderr)
+			}
+			for _, want := range tc.want {
+				if !strings.Contains(stdout, want) {
+					t.Fatalf("stdout %q is missing %q", stdout, want)
+				}
+			}
+			if tc.dropped != "" && strings.Contains(stdout, tc.dropped) {
+				t.Fatalf("stdout %q still has %q", stdout, tc.dropped)
+			}
+		})
+	}
+}
+
+func TestLogsGrep(t *testing.T) {
+	now := time.Now().UTC()
+	lines := []string{
+		stampedLine("hostkey_changed", now.Add(-2*time.Hour)),
+		stampedLine("ssh_started", now.Add(-5*time.Minute)),
+		stampedLine("auth_failed", now.Add(-time.Minute)),
+		stampedLine("hostkey_changed", now.Add(-time.Minute)),
+	}
+	cases := []struct {
+		name     string
+		args     []string
+		viaIPC   bool
+		wantCode int
+		want     []string
+	}{
+		{name: "file match", args: []string{"--grep", "hostkey|auth"}, want: []string{"hostkey_changed", "auth_failed", "hostkey_changed"}},
+		{name: "file no match", args: []string{"--grep", "tcp_check"}, want: []string{"no logs"}},
+		{name: "file with since", args: []string{"--grep", "hostkey|auth", "--since", "1h"}, want: []string{"auth_failed", "hostkey_changed"}},
+		{name: "ipc match", args: []string{"--grep", "^.*ssh_"}, viaIPC: true, want: []string{"ssh_started"}},
+		{name: "ipc no match", args: []string{"--grep", "tcp_check"}, viaIPC: true},
+		{name: "invalid pattern", args: []string{"--grep", "hostkey("}, viaIPC: true, wantCode: exitUsage},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Cleanup(resetGlobals)
+			home := shortHome(t)
+			cfgPath := filepath.Join(home, "rpa.yaml")
+			writeConfig(t, cfgPath, addTestConfig)
+			useHome(t, home)
+			requests := func() []ipcRequest { return nil }
+			if tc.viaIPC {
+				requests = fakeIPC(t, filepath.Join(home, "agent.sock"), func(ipcRequest) ipcReply {
+					return ipcReply{OK: true, Logs: lines}
+				})
+			} else {
+				cfg, err := config.Load(cfgPath)
+				if err != nil {
+					t.Fatal(err)
+				}
+				logPath, err := config.LogPath(cfg)
+				if err != nil {
+					t.Fatal(err)
+				}
+				writeTestFile(t, logPath)
+				if err := os.WriteFile(logPath, []byte(strings.Join(lines, "\n")+"\n"), 0o600); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			var code int
+			stdout, stderr := captureOutput(t, func() {
+				code = Run(append(append([]string{"--home", home, "logs", "agent"}, tc.args...), "--config", cfgPath))
+			})
+			if code != tc.wantCode {
+				t.Fatalf("exit code = %d, want %d (stderr %q)", code, tc.wantCode, stderr)
+			}
+			if tc.wantCode != exitOK {
+				if got := requests(); len(got) != 0 {
+					t.Fatalf("invalid pattern still queried the agent: %+v", got)
+				}
+				return
+			}
+			var printed []string
+			if out := strings.TrimSpace(stdout); out != "" {
+				printed = strings.Split(out, "\n")
+			}
+			if got := eventsOf(printed); !equalStrings(got, tc.want) {
+				t.Fatalf("events = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestLogsSSHStderr(t *testing.T) {
+	stderrLines := []string{"debug1: Connecting to bastion port 22.", "Permission denied (publickey)."}
+	cases := []struct {
+		name       string
+		target     string
+		args       []string
+		serve      bool
+		wantCode   int
+		want       []string
+		wantStderr string
+	}{
+		{name: "agent lines", target: "agent", serve: true, want: stderrLines},
+		{name: "client lines", target: "client", serve: true, want: stderrLines},
+		{name: "grep applies", target: "agent", args: []string{"--grep", "denied"}, serve: true, want: stderrLines[1:]},
+		{name: "agent not running", target: "agent", wantCode: exitError, wantStderr: "ssh stderr query failed"},
+		{name: "with follow", target: "agent", args: []string{"--follow"}, wantCode: exitUsage, wantStderr: "--ssh-stderr cannot be combined with --since or --follow"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Cleanup(resetGlobals)
+			home := shortHome(t)
+			cfgPath := filepath.Join(home, "rpa.yaml")
+			writeConfig(t, cfgPath, addTestConfig)
+			requests := func() []ipcRequest { return nil }
+			if tc.serve {
+				requests = fakeIPC(t, filepath.Join(home, tc.target+".sock"), func(ipcRequest) ipcReply {
+					return ipcReply{OK: true, Logs: stderrLines}
+				})
+			}
+
+			var code int
+			stdout, stderr := captureOutput(t, func() {
+				code = Run(append([]string{"--home", home, "logs", tc.target, "--ssh-stderr", "--config", cfgPath}, tc.args...))
+			})
+			if code != tc.wantCode {
+				t.Fatalf("exit code = %d, want %d (stderr %q)", code, tc.wantCode, stderr)
+			}
+			if !strings.Contains(stderr, tc.wantStderr) {
+				t.Fatalf("stderr %q lacks %q", stderr, tc.wantStderr)
+			}
+			if !tc.serve {
+				return
+			}
+			if got := requests(); len(got) != 1 || got[0].Command != "ssh_stderr" {
+				t.Fatalf("requests = %+v, want one ssh_stderr", got)
+			}
+			if got := strings.Split(strings.TrimSpace(stdout), "\n"); !equalStrings(got, tc.want) {
+				t.Fatalf("stdout lines = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,180 @@
+package cli
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var testMetrics = map[string]string{
+	"rpa_agent_restart_total":                  "3",
+	"rpa_agent_state":                          "CONNECTED",
+	"rpa_agent_trigger_total{reason=\"wake\"}": "2",
+	"rpa_agent_exit_class{class=\"network\"}":  "last",
+	"rpa_agent_probe_rtt_ms":                   "12.5",
+	"rpa_agent_backoff_ms":                     "0",
+}
+
+func TestPrintMetrics(t *testing.T) {
+	cases := []struct {
+		format string
+		want   string
+	}{
+		{
+			format: "kv",
+			want: `rpa_agent_backoff_ms 0
+rpa_agent_exit_class{class="network"} last
+rpa_agent_probe_rtt_ms 12.5
+rpa_agent_restart_total 3
+rpa_agent_state CONNECTED
+rpa_agent_trigger_total{reason="wake"} 2
+`,
+		},
+		{
+			format: "prom",
+			want: `rpa_agent_backoff_ms 0
+rpa_agent_exit_class{class="network",value="last"} 1
+rpa_agent_probe_rtt_ms 12.5
+rpa_agent_restart_total 3
+rpa_agent_state{value="CONNECTED"} 1
+rpa_agent_trigger_total{reason="wake"} 2
+`,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.format, func(t *testing.T) {
+			// Map order changes between runs; the output must not.
+			for i := 0; i < 20; i++ {
+				var code int
+				stdout, _ := captureOutput(t, func() { code = printMetrics(testMetrics, tc.format) })
+				if code != exitOK {
+					t.Fatalf("printMetrics = %d", code)
+				}
+				if stdout != tc.want {
+					t.Fatalf("run %d printed:\n%s\nwant:\n%s", i, stdout, tc.want)
+				}
+			}
+		})
+	}
+}
+
+func TestPrintMetricsJSON(t *testing.T) {
+	stdout, _ := captureOutput(t, func() { printMetrics(testMetrics, "json") })
+	var got map[string]string
+	if err := json.Unmarshal([]byte(stdout), &got); err != nil {
+		t.Fatalf("json output %q: %v", stdout, err)
+	}
+	if len(got) != len(testMetrics) {
+		t.Fatalf("json has %d keys, want %d", len(got), len(testMetrics))
+	}
+	for k, v := range testMetrics {
+		if got[k] != v {
+			t.Fatalf("json %s = %q, want %q", k, got[k], v)
+		}
+	}
+	// encoding/json sorts map keys, so the text is stable too.
+	if again, _ := captureOutput(t, func() { printMetrics(testMetrics, "json") }); again != stdout {
+		t.Fatalf("json output changed between runs")
+	}
+}
+
+func TestMetricsFormatFlag(t *testing.T) {
+	cases := []struct {
+		name     string
+		args     []string
+		wantCode int
+		want     string
+	}{
+		{name: "default kv", wantCode: exitOK, want: "rpa_agent_state CONNECTED\n"},
+		{name: "prom", args: []string{"--format", "prom"}, wantCode: exitOK, want: "rpa_agent_state{value=\"CONNECTED\"} 1\n"},
+		{name: "json", args: []string{"--format", "json"}, wantCode: exitOK, want: "\"rpa_agent_state\": \"CONNECTED\""},
+		{name: "unknown format", args: []string{"--format", "xml"}, wantCode: exitUsage},
+		{name: "watch needs kv", args: []string{"--format", "json", "--watch"}, wantCode: exitUsage},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Cleanup(resetGlobals)
+			home := shortHome(t)
+			cfgPath := filepath.Join(home, "rpa.yaml")
+			writeConfig(t, cfgPath, addTestConfig)
+			requests := fakeIPC(t, filepath.Join(home, "agent.sock"), func(ipcRequest) ipcReply {
+				return ipcReply{OK: true, Data: map[string]string{"rpa_agent_state": "CONNECTED", "rpa_agent_restart_total": "3"}}
+			})
+			var code int
+			stdout, stderr := captureOutput(t, func() {
+				code = Run(append(append([]string{"--home", home, "metrics", "agent"}, tc.args...), "--config", cfgPath))
+			})
+			if code != tc.wantCode {
+				t.Fatalf("exit code = %d, want %d (stderr %q)", code, tc.wantCode, stderr)
+			}
+			if tc.wantCode != exitOK {
+				if got := requests(); len(got) != 0 {
+					t.Fatalf("bad flags still queried the agent: %+v", got)
+				}
+				return
+			}
+			if !strings.Contains(stdout, tc.want) {
+				t.Fatalf("stdout %q lacks %q", stdout, tc.want)
+			}
+		})
+	}
+}
+
+func TestMetricsFrame(t *testing.T) {
+	frames := []struct {
+		data map[string]string
+		want []string
+	}{
+		{
+			data: map[string]string{"rpa_agent_restart_total": "12", "rpa_agent_state": "CONNECTED", "rpa_agent_rtt_ms": "40", `rpa_agent_trigger_total{reason="wake"}`: "2"},
+			want: []string{"rpa_agent_restart_total 12", "rpa_agent_rtt_ms 40", "rpa_agent_state CONNECTED", `rpa_agent_trigger_total{reason="wake"} 2`},
+		},
+		{
+			data: map[string]string{"rpa_agent_restart_total": "13", "rpa_agent_state": "BACKOFF", "rpa_agent_rtt_ms": "55", `rpa_agent_trigger_total{reason="wake"}`: "5", `rpa_agent_trigger_total{reason="sleep"}`: "1"},
+			want: []string{"rpa_agent_restart_total 13 (+1)", "rpa_agent_rtt_ms 55", "rpa_agent_state BACKOFF", `rpa_agent_trigger_total{reason="sleep"} 1`, `rpa_agent_trigger_total{reason="wake"} 5 (+3)`},
+		},
+		{
+			data: map[string]string{"rpa_agent_restart_total": "13", "rpa_agent_state": "CONNECTED", "rpa_agent_rtt_ms": "41", `rpa_agent_trigger_total{reason="wake"}`: "5", `rpa_agent_trigger_total{reason="sleep"}`: "2"},
+			want: []string{"rpa_agent_restart_total 13", "rpa_agent_rtt_ms 41", "rpa_agent_state CONNECTED", `rpa_agent_trigger_total{reason="sleep"} 2 (+1)`, `rpa_agent_trigger_total{reason="wake"} 5`},
+		},
+		{
+			// A counter that went backwards (the agent restarted) shows it.
+			data: map[string]string{"rpa_agent_restart_total": "0"},
+			want: []string{"rpa_agent_restart_total 0 (-13)"},
+		},
+		{
+			data: map[string]string{"rpa_agent_restart_total": "n/a"},
+			want: []string{"rpa_agent_restart_total n/a"},
+		},
+	}
+	var prev map[string]float64
+	for i, frame := range frames {
+		got := metricsFrame(frame.data, prev)
+		if !equalStrings(got, frame.want) {
+			t.Fatalf("frame %d = %q, want %q", i+1, got, frame.want)
+		}
+		prev = numericMetrics(frame.data)
+	}
+}
+
+func TestMetricsWatchFlags(t *testing.T) {
+	cases := []struct {
+		name       string
+		args       []string
+		wantStderr string
+	}{
+		{name: "json", args: []string{"--watch", "--format", "json"}, wantStderr: "--watch only supports the kv format"},
+		{name: "zero interval", args: []string{"--watch", "--interval", "0s"}, wantStderr: "--interval must be a positive duration"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Cleanup(resetGlobals)
+			var code int
+			_, stderr := captureOutput(t, func() { code = Run(append([]string{"metrics", "agent"}, tc.args...)) })
+			if code != exitUsage || !strings.Contains(stderr, tc.wantStderr) {
+				t.Fatalf("exit %d stderr %q, want %d with %q", code, stderr, exitUsage, tc.wantStderr)
+			}
+		})
+	}
+}
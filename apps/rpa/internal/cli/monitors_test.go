@@ -0,0 +1,87 @@
+package cli
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMonitors(t *testing.T) {
+	intervals := map[string]string{
+		"sleep_check_sec":  "5",
+		"sleep_gap_sec":    "30",
+		"network_poll_sec": "2",
+		"power_poll_sec":   "10",
+		"monitors":         "sleep=polling network=polling power=unsupported",
+	}
+	listing := "sleep_check_sec: 5\nsleep_gap_sec: 30\nnetwork_poll_sec: 2\npower_poll_sec: 10\nmonitors: sleep=polling network=polling power=unsupported\n"
+	cases := []struct {
+		name       string
+		role       string
+		args       []string
+		serve      bool
+		reply      ipcReply
+		wantArgs   map[string]string
+		wantCode   int
+		wantStdout string
+		wantStderr string
+	}{
+		{name: "agent read", role: "agent", serve: true, reply: ipcReply{OK: true, Message: "monitor intervals", Data: intervals}, wantArgs: map[string]string{}, wantStdout: listing},
+		{
+			name:       "client set",
+			role:       "client",
+			args:       []string{"--network-poll-sec", "2", "--sleep-gap-sec", "30"},
+			serve:      true,
+			reply:      ipcReply{OK: true, Message: "monitor intervals updated; the config file is unchanged", Data: intervals},
+			wantArgs:   map[string]string{"network_poll_sec": "2", "sleep_gap_sec": "30"},
+			wantStdout: "monitor intervals updated; the config file is unchanged\n" + listing,
+		},
+		{name: "not ok reply", role: "agent", args: []string{"--power-poll-sec", "5"}, serve: true, reply: ipcReply{Message: "power monitor is unsupported and has no poll interval"}, wantArgs: map[string]string{"power_poll_sec": "5"}, wantCode: exitError, wantStderr: "agent monitors error: power monitor is unsupported"},
+		{name: "not running", role: "client", wantCode: exitError, wantStderr: "client monitors failed"},
+		{name: "bad flag value", role: "agent", args: []string{"--sleep-check-sec", "soon"}, wantCode: exitUsage, wantStderr: "invalid value"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Cleanup(resetGlobals)
+			home := shortHome(t)
+			cfgPath := filepath.Join(home, "rpa.yaml")
+			writeConfig(t, cfgPath, addTestConfig)
+			requests := func() []ipcRequest { return nil }
+			if tc.serve {
+				requests = fakeIPC(t, filepath.Join(home, tc.role+".sock"), func(ipcRequest) ipcReply { return tc.reply })
+			}
+
+			var code int
+			stdout, stderr := captureOutput(t, func() {
+				code = Run(append([]string{"--home", home, tc.role, "monitors", "--config", cfgPath}, tc.args...))
+			})
+			if code != tc.wantCode {
+				t.Fatalf("exit code = %d, want %d (stderr %q)", code, tc.wantCode, stderr)
+			}
+			if !strings.Contains(stderr, tc.wantStderr) {
+				t.Fatalf("stderr %q lacks %q", stderr, tc.wantStderr)
+			}
+			if stdout != tc.wantStdout {
+				t.Fatalf("stdout = %q, want %q", stdout, tc.wantStdout)
+			}
+			got := requests()
+			if tc.wantArgs == nil {
+				if len(got) != 0 {
+					t.Fatalf("sent %+v, want no request", got)
+				}
+				return
+			}
+			if len(got) != 1 || got[0].Command != "monitor_intervals" {
+				t.Fatalf("requests = %+v, want one monitor_intervals", got)
+			}
+			if len(got[0].Args) != len(tc.wantArgs) {
+				t.Fatalf("args = %v, want %v", got[0].Args, tc.wantArgs)
+			}
+			for key, want := range tc.wantArgs {
+				if got[0].Args[key] != want {
+					t.Fatalf("args = %v, want %v", got[0].Args, tc.wantArgs)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,51 @@
+package cli
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAgentPauseResume(t *testing.T) {
+	cases := []struct {
+		name       string
+		command    string
+		serve      bool
+		reply      ipcReply
+		wantCode   int
+		wantStdout string
+		wantStderr string
+	}{
+		{name: "pause", command: "pause", serve: true, reply: ipcReply{OK: true, Message: "agent paused; ssh stopped until `rpa agent resume`"}, wantStdout: "agent paused"},
+		{name: "resume", command: "resume", serve: true, reply: ipcReply{OK: true, Message: "agent resumed; connecting"}, wantStdout: "agent resumed"},
+		{name: "not ok reply", command: "pause", serve: true, reply: ipcReply{Message: "unknown command"}, wantCode: exitError, wantStderr: "agent pause error: unknown command"},
+		{name: "not running", command: "resume", wantCode: exitError, wantStderr: "agent resume failed"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Cleanup(resetGlobals)
+			home := shortHome(t)
+			cfgPath := filepath.Join(home, "rpa.yaml")
+			writeConfig(t, cfgPath, addTestConfig)
+			requests := func() []ipcRequest { return nil }
+			if tc.serve {
+				requests = fakeIPC(t, filepath.Join(home, "agent.sock"), func(ipcRequest) ipcReply { return tc.reply })
+			}
+
+			var code int
+			stdout, stderr := captureOutput(t, func() {
+				code = Run([]string{"--home", home, "agent", tc.command, "--config", cfgPath})
+			})
+			if code != tc.wantCode {
+				t.Fatalf("exit code = %d, want %d (stderr %q)", code, tc.wantCode, stderr)
+			}
+			if !strings.Contains(stdout, tc.wantStdout) || !strings.Contains(stderr, tc.wantStderr) {
+				t.Fatalf("stdout %q, stderr %q; want %q and %q", stdout, stderr, tc.wantStdout, tc.wantStderr)
+			}
+			got := requests()
+			if tc.serve && (len(got) != 1 || got[0].Command != tc.command) {
+				t.Fatalf("requests = %+v, want one %s", got, tc.command)
+			}
+		})
+	}
+}
@@ -0,0 +1,79 @@
+package cli
+
+import (
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestPing(t *testing.T) {
+	pong := ipcReply{OK: true, Message: "pong", Data: map[string]string{"server_unix_ms": "1700000000000"}}
+	cases := []struct {
+		name       string
+		role       string
+		args       []string
+		serve      bool
+		reply      ipcReply
+		wantCode   int
+		wantLines  int
+		wantStdout string
+		wantStderr string
+	}{
+		{name: "agent pong", role: "agent", serve: true, reply: pong, wantLines: 1, wantStdout: `^pong from agent: rtt=\S+ server_time=2023-11-14T22:13:20\.000Z$`},
+		{name: "client pong", role: "client", serve: true, reply: pong, wantLines: 1, wantStdout: `^pong from client: rtt=\S+ server_time=2023-11-14T22:13:20\.000Z$`},
+		{name: "count repeats", role: "agent", args: []string{"--count", "3"}, serve: true, reply: pong, wantLines: 3, wantStdout: `^pong from agent: rtt=\S+ server_time=`},
+		{name: "no server time", role: "client", serve: true, reply: ipcReply{OK: true, Message: "pong"}, wantLines: 1, wantStdout: `^pong from client: rtt=\S+$`},
+		{name: "not ok reply", role: "agent", serve: true, reply: ipcReply{Message: "busy"}, wantCode: exitError, wantLines: 1, wantStderr: "agent ping failed: busy"},
+		{name: "not running", role: "client", wantCode: exitError, wantStderr: "client ping failed"},
+		{name: "zero count", role: "agent", args: []string{"--count", "0"}, serve: true, reply: pong, wantCode: exitUsage, wantStderr: "count must be > 0"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Cleanup(resetGlobals)
+			home := shortHome(t)
+			cfgPath := filepath.Join(home, "rpa.yaml")
+			writeConfig(t, cfgPath, addTestConfig)
+			requests := func() []ipcRequest { return nil }
+			if tc.serve {
+				requests = fakeIPC(t, filepath.Join(home, tc.role+".sock"), func(ipcRequest) ipcReply { return tc.reply })
+			}
+
+			var code int
+			stdout, stderr := captureOutput(t, func() {
+				code = Run(append([]string{"--home", home, tc.role, "ping", "--config", cfgPath}, tc.args...))
+			})
+			if code != tc.wantCode {
+				t.Fatalf("exit code = %d, want %d (stderr %q)", code, tc.wantCode, stderr)
+			}
+			if !strings.Contains(stderr, tc.wantStderr) {
+				t.Fatalf("stderr %q lacks %q", stderr, tc.wantStderr)
+			}
+			got := requests()
+			if len(got) != tc.wantLines {
+				t.Fatalf("sent %d requests, want %d", len(got), tc.wantLines)
+			}
+			for _, req := range got {
+				if req.Command != "ping" {
+					t.Fatalf("request = %+v, want ping", req)
+				}
+			}
+			if tc.wantStdout == "" {
+				if stdout != "" {
+					t.Fatalf("stdout = %q, want none", stdout)
+				}
+				return
+			}
+			lines := strings.Split(strings.TrimRight(stdout, "\n"), "\n")
+			if len(lines) != tc.wantLines {
+				t.Fatalf("printed %d lines, want %d:\n%s", len(lines), tc.wantLines, stdout)
+			}
+			re := regexp.MustCompile(tc.wantStdout)
+			for _, line := range lines {
+				if !re.MatchString(line) {
+					t.Fatalf("line %q does not match %s", line, tc.wantStdout)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,218 @@
+package cli
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestAgentRunOnce(t *testing.T) {
+	cases := []struct {
+		name       string
+		script     string
+		args       []string
+		successMs  int
+		wantCode   int
+		wantStdout string
+		wantStderr string
+	}{
+		{name: "connects", script: "exec sleep 30", successMs: 100, wantCode: exitOK, wantStdout: "agent run: connected in"},
+		{name: "ssh fails", script: "echo 'Permission denied (publickey).' >&2; exit 255", successMs: 100, wantCode: exitError, wantStderr: "no successful connection within 5s (last_class: auth)"},
+		{name: "times out", script: "exec sleep 30", successMs: 10000, args: []string{"--once-timeout", "300ms"}, wantCode: exitError, wantStderr: "no successful connection within 300ms"},
+		{name: "bad timeout", script: "exit 0", args: []string{"--once-timeout", "0s"}, wantCode: exitUsage, wantStderr: "once-timeout must be positive"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Cleanup(resetGlobals)
+			home := shortHome(t)
+			stubSSH(t, home, tc.script)
+			keyPath := filepath.Join(home, "id_ed25519")
+			writeTestFile(t, keyPath)
+			cfgPath := filepath.Join(home, "rpa.yaml")
+			writeConfig(t, cfgPath, "ssh:\n  user: me\n  host: example.com\n  identity_file: "+keyPath+"\n  remote_forwards:\n    - \"0.0.0.0:2222:localhost:22\"\nagent:\n  restart:\n    success_after_ms: "+strconv.Itoa(tc.successMs)+"\n")
+
+			args := []string{"--home", home, "agent", "run", "--config", cfgPath, "--once"}
+			if tc.args == nil {
+				args = append(args, "--once-timeout", "5s")
+			}
+			args = append(args, tc.args...)
+			var code int
+			stdout, stderr := captureOutput(t, func() { code = Run(args) })
+			if code != tc.wantCode {
+				t.Fatalf("exit code = %d, want %d\nstdout:\n%s\nstderr:\n%s", code, tc.wantCode, stdout, stderr)
+			}
+			if !strings.Contains(stdout, tc.wantStdout) {
+				t.Fatalf("stdout %q lacks %q", stdout, tc.wantStdout)
+			}
+			if !strings.Contains(stderr, tc.wantStderr) {
+				t.Fatalf("stderr %q lacks %q", stderr, tc.wantStderr)
+			}
+		})
+	}
+}
+
+func TestRunRestartPolicy(t *testing.T) {
+	cases := []struct {
+		name       string
+		role       string
+		config     string
+		script     string
+		policy     string
+		extra      []string
+		wantCode   int
+		wantRuns   int
+		wantStderr string
+	}{
+		{name: "agent never stops after a failure", role: "agent", config: "always", script: "exit 1", policy: "never", wantCode: exitOK, wantRuns: 1},
+		{name: "agent on-failure stops after a clean exit", role: "agent", config: "always", script: "exit 0", policy: "on-failure", wantCode: exitOK, wantRuns: 1},
+		{name: "client never stops after a failure", role: "client", config: "always", script: "exit 1", policy: "never", wantCode: exitOK, wantRuns: 1},
+		{name: "client on-failure stops after a clean exit", role: "client", config: "always", script: "exit 0", policy: "on-failure", wantCode: exitOK, wantRuns: 1},
+		{name: "agent rejects an unknown policy", role: "agent", script: "exit 0", policy: "sometimes", wantCode: exitError, wantStderr: `agent.restart_policy must be always, on-failure, or never (got "sometimes")`},
+		{name: "client rejects an unknown policy", role: "client", script: "exit 0", policy: "sometimes", wantCode: exitError, wantStderr: `client.restart_policy must be always, on-failure, or never (got "sometimes")`},
+		{name: "agent once rejects another policy", role: "agent", script: "exit 0", policy: "always", extra: []string{"--once"}, wantCode: exitUsage, wantStderr: "--once always runs with restart policy never"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Cleanup(resetGlobals)
+			home := shortHome(t)
+			runs := filepath.Join(home, "runs")
+			stubSSH(t, home, "echo run >> "+runs+"; "+tc.script)
+			keyPath := filepath.Join(home, "id_ed25519")
+			writeTestFile(t, keyPath)
+			cfgPath := filepath.Join(home, "rpa.yaml")
+			body := "ssh:\n  user: me\n  host: example.com\n  identity_file: " + keyPath + "\n  remote_forwards:\n    - \"0.0.0.0:2222:localhost:22\"\n" + tc.role + ":\n"
+			if tc.role == "client" {
+				body += "  local_forwards:\n    - \"5432:db.internal:5432\"\n"
+			}
+			if tc.config != "" {
+				body += "  restart_policy: " + tc.config + "\n"
+			}
+			writeConfig(t, cfgPath, body)
+
+			args := append([]string{"--home", home, tc.role, "run", "--config", cfgPath, "--restart-policy", tc.policy}, tc.extra...)
+			var code int
+			stdout, stderr := captureOutput(t, func() { code = Run(args) })
+			if code != tc.wantCode {
+				t.Fatalf("exit code = %d, want %d\nstdout:\n%s\nstderr:\n%s", code, tc.wantCode, stdout, stderr)
+			}
+			if !strings.Contains(stderr, tc.wantStderr) {
+				t.Fatalf("stderr %q lacks %q", stderr, tc.wantStderr)
+			}
+			data, _ := os.ReadFile(runs)
+			if got := strings.Count(string(data), "run\n"); got != tc.wantRuns {
+				t.Fatalf("ssh ran %d times, want %d", got, tc.wantRuns)
+			}
+		})
+	}
+}
+
+func TestRunRefusesSecondInstance(t *testing.T) {
+	cases := []struct {
+		name       string
+		role       string
+		answering  bool
+		force      bool
+		wantCode   int
+		wantRuns   int
+		wantStderr string
+	}{
+		{name: "agent already running", role: "agent", answering: true, wantCode: exitError, wantStderr: "another agent is already running on"},
+		{name: "client already running", role: "client", answering: true, wantCode: exitError, wantStderr: "another client is already running on"},
+		{name: "agent forced", role: "agent", answering: true, force: true, wantCode: exitOK, wantRuns: 1},
+		{name: "client forced", role: "client", answering: true, force: true, wantCode: exitOK, wantRuns: 1},
+		{name: "nothing answering", role: "agent", wantCode: exitOK, wantRuns: 1},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Cleanup(resetGlobals)
+			home := shortHome(t)
+			runs := filepath.Join(home, "runs")
+			// ssh exits cleanly, so with --restart-policy never the run ends after one attempt.
+			stubSSH(t, home, "echo run >> "+runs+"; exit 0")
+			keyPath := filepath.Join(home, "id_ed25519")
+			writeTestFile(t, keyPath)
+			cfgPath := filepath.Join(home, "rpa.yaml")
+			writeConfig(t, cfgPath, "ssh:\n  user: me\n  host: example.com\n  identity_file: "+keyPath+"\n  remote_forwards:\n    - \"0.0.0.0:2222:localhost:22\"\nclient:\n  local_forwards:\n    - \"5432:db.internal:5432\"\n")
+			var requests func() []ipcRequest
+			if tc.answering {
+				requests = fakeIPC(t, filepath.Join(home, tc.role+".sock"), func(ipcRequest) ipcReply {
+					return ipcReply{OK: true, Data: map[string]string{"state": "CONNECTED"}}
+				})
+			}
+
+			args := []string{"--home", home, tc.role, "run", "--config", cfgPath, "--restart-policy", "never"}
+			if tc.force {
+				args = append(args, "--force")
+			}
+			var code int
+			stdout, stderr := captureOutput(t, func() { code = Run(args) })
+			if code != tc.wantCode {
+				t.Fatalf("exit code = %d, want %d\nstdout:\n%s\nstderr:\n%s", code, tc.wantCode, stdout, stderr)
+			}
+			if !strings.Contains(stderr, tc.wantStderr) {
+				t.Fatalf("stderr %q lacks %q", stderr, tc.wantStderr)
+			}
+			if tc.wantStderr != "" && !strings.Contains(stderr, "--force") {
+				t.Fatalf("refusal %q does not mention --force", stderr)
+			}
+			data, _ := os.ReadFile(runs)
+			if got := strings.Count(string(data), "run\n"); got != tc.wantRuns {
+				t.Fatalf("ssh ran %d times, want %d", got, tc.wantRuns)
+			}
+			if tc.force && len(requests()) != 0 {
+				t.Fatalf("--force still probed the socket: %+v", requests())
+			}
+		})
+	}
+}
+
+func TestRunSSHArgs(t *testing.T) {
+	cases := []struct {
+		name     string
+		role     string
+		args     []string
+		wantTail string
+		wantNote string
+	}{
+		{name: "agent", role: "agent", args: []string{"--ssh-arg", "-v", "--ssh-arg", "-o", "--ssh-arg", "Foo=bar"}, wantTail: "-p 22 -v -o Foo=bar me@example.com", wantNote: "note: extra ssh args: -v -o Foo=bar"},
+		{name: "client", role: "client", args: []string{"--ssh-arg", "-vv"}, wantTail: "-p 22 -vv me@example.com", wantNote: "note: extra ssh args: -vv"},
+		{name: "none", role: "agent", wantTail: "-p 22 me@example.com"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Cleanup(resetGlobals)
+			home := shortHome(t)
+			argv := filepath.Join(home, "argv")
+			stubSSH(t, home, "echo \"$*\" >> "+argv+"; exit 0")
+			keyPath := filepath.Join(home, "id_ed25519")
+			writeTestFile(t, keyPath)
+			cfgPath := filepath.Join(home, "rpa.yaml")
+			writeConfig(t, cfgPath, "ssh:\n  user: me\n  host: example.com\n  identity_file: "+keyPath+"\n  options:\n    - Compression=yes\n  remote_forwards:\n    - \"0.0.0.0:2222:localhost:22\"\nclient:\n  local_forwards:\n    - \"5432:db.internal:5432\"\n")
+
+			args := append([]string{"--home", home, tc.role, "run", "--config", cfgPath, "--restart-policy", "never"}, tc.args...)
+			var code int
+			stdout, stderr := captureOutput(t, func() { code = Run(args) })
+			if code != exitOK {
+				t.Fatalf("exit code = %d\nstdout:\n%s\nstderr:\n%s", code, stdout, stderr)
+			}
+			data, err := os.ReadFile(argv)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := strings.TrimSpace(string(data)); !strings.HasSuffix(got, tc.wantTail) {
+				t.Fatalf("ssh argv %q does not end with %q", got, tc.wantTail)
+			}
+			if tc.wantNote == "" {
+				if strings.Contains(stdout, "extra ssh args") {
+					t.Fatalf("stdout notes extra args with none given:\n%s", stdout)
+				}
+				return
+			}
+			if !strings.Contains(stdout, tc.wantNote) {
+				t.Fatalf("stdout lacks %q:\n%s", tc.wantNote, stdout)
+			}
+		})
+	}
+}
@@ -0,0 +1,13 @@
+//go:build !windows
+
+// Package cli maps platform signals used by foreground run commands.
+// SIGUSR1 asks a running agent or client to reopen its log file.
+
+package cli
+
+import (
+	"os"
+	"syscall"
+)
+
+var reopenSignals = []os.Signal{syscall.SIGUSR1}
@@ -0,0 +1,45 @@
+//go:build !windows
+
+package cli
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"reverse-proxy-agent/pkg/logging"
+)
+
+func TestWatchLogReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "agent.log")
+	logger, err := logging.NewLoggerWithPath(path, logging.NewLogBuffer())
+	if err != nil {
+		t.Fatal(err)
+	}
+	watchLogReopen(logger)
+	logger.Event("INFO", "before_rotate", nil)
+	if err := os.Rename(path, path+".1"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := syscall.Kill(os.Getpid(), syscall.SIGUSR1); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		data, _ := os.ReadFile(path)
+		if strings.Contains(string(data), "log_reopened") {
+			if strings.Contains(string(data), "before_rotate") {
+				t.Fatalf("reopened log holds old lines: %q", data)
+			}
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("no log_reopened line in %s after SIGUSR1: %q", path, data)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,10 @@
+//go:build windows
+
+// Package cli maps platform signals used by foreground run commands.
+// Windows has no SIGUSR1, so log reopen is not signal driven there.
+
+package cli
+
+import "os"
+
+var reopenSignals []os.Signal
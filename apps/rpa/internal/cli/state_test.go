@@ -0,0 +1,116 @@
+package cli
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"reverse-proxy-agent/pkg/config"
+	"reverse-proxy-agent/pkg/statefile"
+)
+
+func TestStateCommand(t *testing.T) {
+	snap := statefile.Snapshot{
+		LastExit:        "exit status 255",
+		LastClass:       "network",
+		LastTrigger:     "wake",
+		LastSuccessUnix: 1777636800,
+		Version:         "1.4.0",
+		SSHVersion:      "OpenSSH_9.6p1",
+	}
+	cases := []struct {
+		name       string
+		target     string
+		content    string
+		json       bool
+		wantCode   int
+		wantStdout []string
+		wantStderr string
+		// wantExact is the whole stdout of a --json run of raw content.
+		wantExact string
+	}{
+		{name: "agent missing", target: "agent", wantCode: exitError, wantStderr: "no agent state yet"},
+		{name: "client missing", target: "client", wantCode: exitError, wantStderr: "no client state yet"},
+		{
+			name:       "agent populated",
+			target:     "agent",
+			content:    "snapshot",
+			wantCode:   exitOK,
+			wantStdout: []string{"agent state (", "last_exit: exit status 255", "last_class: network", "last_trigger: wake", "last_success_utc: 2026-05-01T12:00:00Z", "last_success_unix: 1777636800", "updated_utc: ", "written_by: rpa 1.4.0", "ssh_version: OpenSSH_9.6p1"},
+		},
+		{name: "client populated", target: "client", content: "snapshot", wantCode: exitOK, wantStdout: []string{"client state (", "last_trigger: wake"}},
+		{name: "json", target: "agent", content: "snapshot", json: true, wantCode: exitOK, wantStdout: []string{`"last_success_unix": 1777636800`, `"ssh_version": "OpenSSH_9.6p1"`}},
+		{name: "corrupt", target: "agent", content: "{not json", wantCode: exitError, wantStderr: "parse state"},
+		{name: "json corrupt", target: "agent", content: "{not json", json: true, wantCode: exitError, wantStderr: "parse state"},
+		{
+			name:      "json as stored",
+			target:    "client",
+			content:   `{"updated_unix":5,"zz_added_later":{"b":2,"a":1},"last_exit":"signal: killed"}` + "\n",
+			json:      true,
+			wantCode:  exitOK,
+			wantExact: "{\n  \"updated_unix\": 5,\n  \"zz_added_later\": {\n    \"b\": 2,\n    \"a\": 1\n  },\n  \"last_exit\": \"signal: killed\"\n}\n",
+		},
+		{name: "unknown target", target: "proxy", wantCode: exitUsage, wantStderr: "state target must be agent or client"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Cleanup(resetGlobals)
+			home := t.TempDir()
+			cfgPath := filepath.Join(home, "rpa.yaml")
+			writeConfig(t, cfgPath, addTestConfig)
+			useHome(t, home)
+			cfg := testConfig()
+			path, err := config.AgentStatePath(cfg)
+			if tc.target == "client" {
+				path, err = config.ClientStatePath(cfg)
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			switch tc.content {
+			case "":
+			case "snapshot":
+				if err := statefile.Write(path, snap); err != nil {
+					t.Fatal(err)
+				}
+			default:
+				if err := os.WriteFile(path, []byte(tc.content), 0o600); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			args := []string{"--home", home, "state", tc.target, "--config", cfgPath}
+			if tc.json {
+				args = append(args, "--json")
+			}
+			var code int
+			stdout, stderr := captureOutput(t, func() { code = Run(args) })
+			if code != tc.wantCode {
+				t.Fatalf("exit code = %d, want %d (stderr %q)", code, tc.wantCode, stderr)
+			}
+			for _, want := range tc.wantStdout {
+				if !strings.Contains(stdout, want) {
+					t.Fatalf("stdout %q lacks %q", stdout, want)
+				}
+			}
+			if !strings.Contains(stderr, tc.wantStderr) {
+				t.Fatalf("stderr %q lacks %q", stderr, tc.wantStderr)
+			}
+			if tc.wantExact != "" {
+				if stdout != tc.wantExact {
+					t.Fatalf("--json stdout = %q, want %q", stdout, tc.wantExact)
+				}
+			} else if tc.json && tc.wantCode == exitOK {
+				var got statefile.Snapshot
+				if err := json.Unmarshal([]byte(stdout), &got); err != nil {
+					t.Fatalf("--json output %q: %v", stdout, err)
+				}
+				if got.LastExit != snap.LastExit || got.UpdatedUnix == 0 {
+					t.Fatalf("--json snapshot = %+v", got)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,465 @@
+package cli
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStatusExitCode(t *testing.T) {
+	const (
+		running    = "RUNNING"
+		connecting = "CONNECTING"
+		stopped    = "STOPPED"
+		paused     = "PAUSED"
+	)
+	cases := []struct {
+		name        string
+		showAgent   bool
+		agentState  string
+		showClient  bool
+		clientState string
+		want        int
+	}{
+		{name: "both running", showAgent: true, agentState: running, showClient: true, clientState: running, want: exitOK},
+		{name: "agent down", showAgent: true, agentState: stopped, showClient: true, clientState: running, want: exitError},
+		{name: "client connecting", showAgent: true, agentState: running, showClient: true, clientState: connecting, want: exitError},
+		{name: "both down", showAgent: true, agentState: stopped, showClient: true, clientState: stopped, want: exitError},
+		{name: "agent only running", showAgent: true, agentState: running, clientState: stopped, want: exitOK},
+		{name: "agent only paused", showAgent: true, agentState: paused, clientState: running, want: exitError},
+		{name: "client only running", agentState: stopped, showClient: true, clientState: running, want: exitOK},
+		{name: "client only unreachable", agentState: running, showClient: true, clientState: "", want: exitError},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := statusExitCode(tc.showAgent, tc.agentState, tc.showClient, tc.clientState)
+			if got != tc.want {
+				t.Fatalf("statusExitCode = %d, want %d", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestStatusCode(t *testing.T) {
+	cases := []struct {
+		name      string
+		exitCode  bool
+		shown     bool
+		connected bool
+		want      int
+	}{
+		{name: "plain status shown", shown: true, want: exitOK},
+		{name: "plain status shown but down", shown: true, connected: false, want: exitOK},
+		{name: "plain status nothing shown", want: exitError},
+		{name: "exit code connected", exitCode: true, shown: true, connected: true, want: exitOK},
+		{name: "exit code shown but down", exitCode: true, shown: true, want: exitError},
+		{name: "exit code nothing shown", exitCode: true, want: exitError},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := statusCode(tc.exitCode, tc.shown, tc.connected); got != tc.want {
+				t.Fatalf("statusCode = %d, want %d", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestStatusExitClasses(t *testing.T) {
+	cases := []struct {
+		name    string
+		classes string
+		want    string
+	}{
+		{name: "counted", classes: "clean=12 network=3 timeout=1", want: "  exits: clean=12 network=3 timeout=1\n"},
+		{name: "none yet"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Cleanup(resetGlobals)
+			home := shortHome(t)
+			cfgPath := filepath.Join(home, "rpa.yaml")
+			writeConfig(t, cfgPath, addTestConfig)
+			fakeIPC(t, filepath.Join(home, "agent.sock"), func(ipcRequest) ipcReply {
+				data := map[string]string{"state": "CONNECTED"}
+				if tc.classes != "" {
+					data["exit_classes"] = tc.classes
+				}
+				return ipcReply{OK: true, Data: data}
+			})
+
+			var code int
+			stdout, stderr := captureOutput(t, func() { code = Run([]string{"--home", home, "--config", cfgPath, "status", "agent"}) })
+			if code != exitOK {
+				t.Fatalf("exit code = %d, stderr %q", code, stderr)
+			}
+			if tc.want == "" {
+				if strings.Contains(stdout, "exits:") {
+					t.Fatalf("stdout has an exits line with no counts:\n%s", stdout)
+				}
+				return
+			}
+			if !strings.Contains(stdout, tc.want) {
+				t.Fatalf("stdout lacks %q:\n%s", tc.want, stdout)
+			}
+		})
+	}
+}
+
+func TestFormatUnixAge(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	cases := []struct {
+		name string
+		raw  string
+		want string
+	}{
+		{name: "minutes", raw: "1699999850", want: "2m30s ago"},
+		{name: "hours", raw: "1699992800", want: "2h0m0s ago"},
+		{name: "just now", raw: "1700000000", want: "0s ago"},
+		{name: "future reads as now", raw: "1700000090", want: "0s ago"},
+		{name: "padded", raw: " 1699999990 ", want: "10s ago"},
+		{name: "not a number", raw: "soon", want: ""},
+		{name: "empty", raw: "", want: ""},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := formatUnixAge(tc.raw, now); got != tc.want {
+				t.Fatalf("formatUnixAge(%q) = %q, want %q", tc.raw, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestStatusLastSuccessAge(t *testing.T) {
+	cases := []struct {
+		name        string
+		lastSuccess string
+		want        string
+	}{
+		{name: "connected a while ago", lastSuccess: "1699999850", want: "  last_success_age: 2m30s ago\n"},
+		{name: "never connected"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Cleanup(resetGlobals)
+			statusNow = func() time.Time { return time.Unix(1700000000, 0) }
+			t.Cleanup(func() { statusNow = time.Now })
+			home := shortHome(t)
+			cfgPath := filepath.Join(home, "rpa.yaml")
+			writeConfig(t, cfgPath, addTestConfig)
+			fakeIPC(t, filepath.Join(home, "agent.sock"), func(ipcRequest) ipcReply {
+				data := map[string]string{"state": "CONNECTED"}
+				if tc.lastSuccess != "" {
+					data["last_success_unix"] = tc.lastSuccess
+				}
+				return ipcReply{OK: true, Data: data}
+			})
+
+			var code int
+			stdout, stderr := captureOutput(t, func() { code = Run([]string{"--home", home, "--config", cfgPath, "status", "agent"}) })
+			if code != exitOK {
+				t.Fatalf("exit code = %d, stderr %q", code, stderr)
+			}
+			if tc.want == "" {
+				if strings.Contains(stdout, "last_success_age") {
+					t.Fatalf("stdout has an age with no last success:\n%s", stdout)
+				}
+				return
+			}
+			if !strings.Contains(stdout, tc.want) {
+				t.Fatalf("stdout lacks %q:\n%s", tc.want, stdout)
+			}
+		})
+	}
+}
+
+func TestStatusForwardLabels(t *testing.T) {
+	const labeledConfig = "ssh:\n  user: me\n  host: example.com\n  remote_forwards:\n    - spec: \"0.0.0.0:2222:localhost:22\"\n      name: sshd\n      comment: office laptop\n    - \"0.0.0.0:8080:localhost:80\"\nclient:\n  local_forwards:\n    - spec: \"127.0.0.1:5432:db.internal:5432\"\n      comment: primary db\n"
+	cases := []struct {
+		name     string
+		target   string
+		data     map[string]string
+		want     []string
+		wantGone []string
+	}{
+		{
+			name:     "agent forwards from the running agent",
+			target:   "agent",
+			data:     map[string]string{"remote_forwards": "0.0.0.0:2222:localhost:22, 0.0.0.0:8080:localhost:80"},
+			want:     []string{"  remote_forwards: 0.0.0.0:2222:localhost:22, 0.0.0.0:8080:localhost:80\n", "    0.0.0.0:2222:localhost:22: sshd - office laptop\n"},
+			wantGone: []string{"    0.0.0.0:8080:localhost:80:"},
+		},
+		{
+			name:     "inactive forward has no label line",
+			target:   "agent",
+			data:     map[string]string{"remote_forwards": "0.0.0.0:8080:localhost:80"},
+			wantGone: []string{"sshd"},
+		},
+		{
+			name:   "client forwards from config",
+			target: "client",
+			want:   []string{"  local_forwards: 127.0.0.1:5432:db.internal:5432\n", "    127.0.0.1:5432:db.internal:5432: primary db\n"},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Cleanup(resetGlobals)
+			home := shortHome(t)
+			cfgPath := filepath.Join(home, "rpa.yaml")
+			writeConfig(t, cfgPath, labeledConfig)
+			fakeIPC(t, filepath.Join(home, tc.target+".sock"), func(ipcRequest) ipcReply {
+				data := map[string]string{"state": "CONNECTED"}
+				for k, v := range tc.data {
+					data[k] = v
+				}
+				return ipcReply{OK: true, Data: data}
+			})
+
+			var code int
+			stdout, stderr := captureOutput(t, func() { code = Run([]string{"--home", home, "--config", cfgPath, "status", tc.target}) })
+			if code != exitOK {
+				t.Fatalf("exit code = %d, stderr %q", code, stderr)
+			}
+			for _, want := range tc.want {
+				if !strings.Contains(stdout, want) {
+					t.Fatalf("stdout lacks %q:\n%s", want, stdout)
+				}
+			}
+			for _, gone := range tc.wantGone {
+				if strings.Contains(stdout, gone) {
+					t.Fatalf("stdout has %q:\n%s", gone, stdout)
+				}
+			}
+		})
+	}
+}
+
+func TestStatusMonitors(t *testing.T) {
+	cases := []struct {
+		name     string
+		monitors string
+		want     string
+	}{
+		{name: "reported", monitors: "sleep=polling network=polling power=unsupported", want: "  monitors: sleep=polling network=polling power=unsupported\n"},
+		{name: "older agent without the field"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Cleanup(resetGlobals)
+			home := shortHome(t)
+			cfgPath := filepath.Join(home, "rpa.yaml")
+			writeConfig(t, cfgPath, addTestConfig)
+			fakeIPC(t, filepath.Join(home, "agent.sock"), func(ipcRequest) ipcReply {
+				data := map[string]string{"state": "CONNECTED"}
+				if tc.monitors != "" {
+					data["monitors"] = tc.monitors
+				}
+				return ipcReply{OK: true, Data: data}
+			})
+
+			var code int
+			stdout, stderr := captureOutput(t, func() { code = Run([]string{"--home", home, "--config", cfgPath, "status", "agent"}) })
+			if code != exitOK {
+				t.Fatalf("exit code = %d, stderr %q", code, stderr)
+			}
+			if tc.want == "" {
+				if strings.Contains(stdout, "monitors:") {
+					t.Fatalf("stdout has a monitors line with no data:\n%s", stdout)
+				}
+				return
+			}
+			if !strings.Contains(stdout, tc.want) {
+				t.Fatalf("stdout lacks %q:\n%s", tc.want, stdout)
+			}
+		})
+	}
+}
+
+func TestStatusHealth(t *testing.T) {
+	cases := []struct {
+		name   string
+		target string
+		data   map[string]string
+		want   string
+	}{
+		{name: "healthy", target: "agent", data: map[string]string{"state": "RUNNING", "health": "healthy", "tcp_check": "ok"}, want: "  health: healthy\n"},
+		{name: "degraded names the check error", target: "agent", data: map[string]string{"state": "RUNNING", "health": "degraded", "tcp_check": "failed", "tcp_check_error": "connection refused"}, want: "  health: degraded (tcp check: connection refused)\n"},
+		{name: "degraded without an error", target: "client", data: map[string]string{"state": "RUNNING", "health": "degraded", "tcp_check": "failed"}, want: "  health: degraded\n"},
+		{name: "paused", target: "agent", data: map[string]string{"state": "PAUSED", "health": "paused"}, want: "  health: paused\n"},
+		{name: "down", target: "client", data: map[string]string{"state": "STOPPED", "health": "down"}, want: "  health: down\n"},
+		{name: "older service without the field", target: "agent", data: map[string]string{"state": "RUNNING"}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Cleanup(resetGlobals)
+			home := shortHome(t)
+			cfgPath := filepath.Join(home, "rpa.yaml")
+			writeConfig(t, cfgPath, addTestConfig)
+			fakeIPC(t, filepath.Join(home, tc.target+".sock"), func(ipcRequest) ipcReply {
+				return ipcReply{OK: true, Data: tc.data}
+			})
+
+			var code int
+			stdout, stderr := captureOutput(t, func() { code = Run([]string{"--home", home, "--config", cfgPath, "status", tc.target}) })
+			if code != exitOK {
+				t.Fatalf("exit code = %d, stderr %q", code, stderr)
+			}
+			if tc.want == "" {
+				if strings.Contains(stdout, "health:") {
+					t.Fatalf("stdout has a health line with no data:\n%s", stdout)
+				}
+				return
+			}
+			if !strings.Contains(stdout, tc.want) {
+				t.Fatalf("stdout lacks %q:\n%s", tc.want, stdout)
+			}
+		})
+	}
+}
+
+func TestStatusMultipleConfigs(t *testing.T) {
+	cases := []struct {
+		name       string
+		args       func(dir string) []string
+		missing    bool
+		state      string
+		wantCode   int
+		wantStdout []string
+		wantStderr string
+		wantQuery  int
+	}{
+		{
+			name: "repeated config",
+			args: func(dir string) []string {
+				return []string{"--config", filepath.Join(dir, "a.yaml"), "--config", filepath.Join(dir, "b.yaml")}
+			},
+			state:      "RUNNING",
+			wantStdout: []string{"config: A\nagent:\n", "\n\nconfig: B\n  note: same rpa home as A; the services below are shared\nagent:\n"},
+			wantQuery:  2,
+		},
+		{
+			name: "all configs glob",
+			args: func(dir string) []string {
+				return []string{"--all-configs", filepath.Join(dir, "*.yaml"), "--exit-code"}
+			},
+			state:      "RUNNING",
+			wantStdout: []string{"config: A\n", "config: B\n"},
+			wantQuery:  2,
+		},
+		{
+			name: "glob and config add up",
+			args: func(dir string) []string {
+				return []string{"--config", filepath.Join(dir, "b.yaml"), "--all-configs", filepath.Join(dir, "a*.yaml")}
+			},
+			state:      "RUNNING",
+			wantStdout: []string{"config: B\n", "config: A\n"},
+			wantQuery:  2,
+		},
+		{
+			name: "exit code needs every service connected",
+			args: func(dir string) []string {
+				return []string{"--config", filepath.Join(dir, "a.yaml"), "--config", filepath.Join(dir, "b.yaml"), "--exit-code"}
+			},
+			state:      "CONNECTING",
+			wantCode:   exitError,
+			wantStdout: []string{"config: A\n", "config: B\n"},
+			wantQuery:  2,
+		},
+		{
+			name: "missing config is reported in place",
+			args: func(dir string) []string {
+				return []string{"--config", filepath.Join(dir, "missing.yaml"), "--config", filepath.Join(dir, "a.yaml")}
+			},
+			state:      "RUNNING",
+			wantCode:   exitError,
+			wantStdout: []string{"config: MISSING\n  error: config load failed", "config: A\nagent:\n"},
+			wantQuery:  1,
+		},
+		{
+			name:       "glob matches nothing",
+			args:       func(dir string) []string { return []string{"--all-configs", filepath.Join(dir, "*.yml")} },
+			wantCode:   exitError,
+			wantStderr: "no config files match",
+		},
+		{
+			name:       "bad glob",
+			args:       func(dir string) []string { return []string{"--all-configs", filepath.Join(dir, "[")} },
+			wantCode:   exitUsage,
+			wantStderr: "invalid --all-configs pattern",
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Cleanup(resetGlobals)
+			home := shortHome(t)
+			writeConfig(t, filepath.Join(home, "a.yaml"), addTestConfig)
+			writeConfig(t, filepath.Join(home, "b.yaml"), addTestConfig)
+			requests := fakeIPC(t, filepath.Join(home, "agent.sock"), func(ipcRequest) ipcReply {
+				return ipcReply{OK: true, Data: map[string]string{"state": tc.state}}
+			})
+
+			var code int
+			stdout, stderr := captureOutput(t, func() {
+				code = Run(append([]string{"--home", home, "status", "agent"}, tc.args(home)...))
+			})
+			if code != tc.wantCode {
+				t.Fatalf("exit code = %d, want %d\nstdout:\n%s\nstderr:\n%s", code, tc.wantCode, stdout, stderr)
+			}
+			if !strings.Contains(stderr, tc.wantStderr) {
+				t.Fatalf("stderr %q lacks %q", stderr, tc.wantStderr)
+			}
+			names := strings.NewReplacer("A", filepath.Join(home, "a.yaml"), "B", filepath.Join(home, "b.yaml"), "MISSING", filepath.Join(home, "missing.yaml"))
+			for _, want := range tc.wantStdout {
+				if want = names.Replace(want); !strings.Contains(stdout, want) {
+					t.Fatalf("stdout lacks %q:\n%s", want, stdout)
+				}
+			}
+			if got := len(requests()); got != tc.wantQuery {
+				t.Fatalf("status queries = %d, want %d", got, tc.wantQuery)
+			}
+		})
+	}
+}
+
+func TestStatusArgOrder(t *testing.T) {
+	cases := []struct {
+		name       string
+		args       []string
+		wantCode   int
+		wantStderr string
+	}{
+		{name: "target first", args: []string{"agent", "--config", "CFG", "--exit-code"}, wantCode: exitError},
+		{name: "flags first", args: []string{"--config", "CFG", "--exit-code", "agent"}, wantCode: exitError},
+		{name: "flags around the target", args: []string{"--config", "CFG", "agent", "--exit-code"}, wantCode: exitError},
+		{name: "no exit code", args: []string{"--config", "CFG", "agent"}, wantCode: exitOK},
+		{name: "extra argument", args: []string{"--config", "CFG", "agent", "client"}, wantCode: exitUsage, wantStderr: `unexpected argument "client"`},
+		{name: "bad flag after the target", args: []string{"--config", "CFG", "agent", "--bogus"}, wantCode: exitUsage},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Cleanup(resetGlobals)
+			home := shortHome(t)
+			cfgPath := filepath.Join(home, "rpa.yaml")
+			writeConfig(t, cfgPath, addTestConfig)
+			fakeIPC(t, filepath.Join(home, "agent.sock"), func(ipcRequest) ipcReply {
+				return ipcReply{OK: true, Data: map[string]string{"state": "STOPPED", "health": "down"}}
+			})
+			args := []string{"--home", home, "status"}
+			for _, arg := range tc.args {
+				args = append(args, strings.ReplaceAll(arg, "CFG", cfgPath))
+			}
+
+			var code int
+			stdout, stderr := captureOutput(t, func() { code = Run(args) })
+			if code != tc.wantCode {
+				t.Fatalf("exit code = %d, want %d (stderr %q)", code, tc.wantCode, stderr)
+			}
+			if !strings.Contains(stderr, tc.wantStderr) {
+				t.Fatalf("stderr %q lacks %q", stderr, tc.wantStderr)
+			}
+			if tc.wantCode != exitUsage && (!strings.Contains(stdout, "agent:") || strings.Contains(stdout, "client:")) {
+				t.Fatalf("stdout should show only the agent:\n%s", stdout)
+			}
+		})
+	}
+}
@@ -0,0 +1,163 @@
+package cli
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"reverse-proxy-agent/pkg/config"
+)
+
+func TestPurgeHome(t *testing.T) {
+	rpaFiles := []string{"rpa.yaml", "agent.sock", "client.pid", "agent.ssh.ctl", "agent.state.json", "logs/agent.log", "logs/client.log.1"}
+	cases := []struct {
+		name     string
+		dir      string
+		files    []string
+		wantGone bool
+		wantLeft []string
+	}{
+		{name: "dot rpa goes whole", dir: ".rpa", files: append([]string{"notes.txt"}, rpaFiles...), wantGone: true},
+		{name: "only rpa files goes whole", dir: "custom", files: rpaFiles, wantGone: true},
+		{name: "empty custom home goes whole", dir: "custom", wantGone: true},
+		{name: "foreign file keeps the rest", dir: "custom", files: append([]string{"notes.txt"}, rpaFiles...), wantLeft: []string{"notes.txt"}},
+		{name: "foreign log dir entry kept", dir: "custom", files: append([]string{"logs/keep.txt"}, rpaFiles...), wantLeft: []string{"logs", "logs/keep.txt"}},
+		{name: "foreign dir kept", dir: "custom", files: append([]string{"src/main.go"}, rpaFiles...), wantLeft: []string{"src", "src/main.go"}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			home := filepath.Join(t.TempDir(), tc.dir)
+			if err := os.MkdirAll(home, 0o700); err != nil {
+				t.Fatal(err)
+			}
+			for _, name := range tc.files {
+				writeTestFile(t, filepath.Join(home, name))
+			}
+			useHome(t, home)
+
+			if code := purgeHome(testConfig(), 0); code != exitOK {
+				t.Fatalf("purgeHome = %d, want %d", code, exitOK)
+			}
+			left := listTree(t, home)
+			if tc.wantGone {
+				if left != nil {
+					t.Fatalf("home still exists with %v", left)
+				}
+				return
+			}
+			if !equalStrings(left, tc.wantLeft) {
+				t.Fatalf("left %v, want %v", left, tc.wantLeft)
+			}
+
+			// A second purge finds nothing of rpa's and changes nothing.
+			if code := purgeHome(testConfig(), 0); code != exitOK {
+				t.Fatalf("second purgeHome = %d, want %d", code, exitOK)
+			}
+			if again := listTree(t, home); !equalStrings(again, tc.wantLeft) {
+				t.Fatalf("second purge left %v, want %v", again, tc.wantLeft)
+			}
+		})
+	}
+}
+
+func TestPurgeHomeIdempotent(t *testing.T) {
+	home := filepath.Join(t.TempDir(), ".rpa")
+	writeTestFile(t, filepath.Join(home, "rpa.yaml"))
+	useHome(t, home)
+
+	for i := 0; i < 2; i++ {
+		if code := purgeHome(testConfig(), 0); code != exitOK {
+			t.Fatalf("purge %d = %d, want %d", i+1, code, exitOK)
+		}
+	}
+	if _, err := os.Lstat(home); !os.IsNotExist(err) {
+		t.Fatalf("home still exists: %v", err)
+	}
+}
+
+func TestPurgeHomeRefuses(t *testing.T) {
+	cases := []struct {
+		name string
+		home func(user string) string
+	}{
+		{name: "user home", home: func(user string) string { return user }},
+		{name: "parent of user home", home: filepath.Dir},
+		{name: "filesystem root", home: func(string) string { return string(filepath.Separator) }},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			user := filepath.Join(t.TempDir(), "user")
+			writeTestFile(t, filepath.Join(user, "rpa.yaml"))
+			t.Setenv("HOME", user)
+			home := tc.home(user)
+			useHome(t, home)
+
+			if code := purgeHome(testConfig(), 0); code != exitError {
+				t.Fatalf("purgeHome(%s) = %d, want %d", home, code, exitError)
+			}
+			if _, err := os.Stat(filepath.Join(user, "rpa.yaml")); err != nil {
+				t.Fatalf("refused purge still removed files: %v", err)
+			}
+		})
+	}
+}
+
+func useHome(t *testing.T, home string) {
+	t.Helper()
+	config.SetHomeDir(home)
+	t.Cleanup(func() { config.SetHomeDir("") })
+}
+
+func testConfig() *config.Config {
+	cfg := &config.Config{}
+	config.ApplyDefaults(cfg)
+	return cfg
+}
+
+func writeTestFile(t *testing.T, path string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte("x\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// listTree returns the paths under root relative to it, or nil when root is
+// gone.
+func listTree(t *testing.T, root string) []string {
+	t.Helper()
+	if _, err := os.Lstat(root); os.IsNotExist(err) {
+		return nil
+	}
+	paths := []string{}
+	err := filepath.Walk(root, func(path string, _ os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if path != root {
+			rel, _ := filepath.Rel(root, path)
+			paths = append(paths, filepath.ToSlash(rel))
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
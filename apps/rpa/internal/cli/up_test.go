@@ -0,0 +1,243 @@
+package cli
+
+import (
+	"encoding/xml"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"reverse-proxy-agent/pkg/config"
+)
+
+func TestUpPlistStdout(t *testing.T) {
+	cases := []struct {
+		name       string
+		role       string
+		extra      []string
+		wantCode   int
+		wantArgs   []string
+		wantStderr string
+	}{
+		{name: "agent", role: "agent", wantCode: exitOK, wantArgs: []string{"agent", "run", "--config"}},
+		{name: "client", role: "client", wantCode: exitOK, wantArgs: []string{"client", "run", "--config"}},
+		{name: "agent with attach", role: "agent", extra: []string{"--attach"}, wantCode: exitUsage, wantStderr: "--attach cannot be combined with --plist-stdout"},
+		{name: "client with attach", role: "client", extra: []string{"--attach"}, wantCode: exitUsage, wantStderr: "--attach cannot be combined with --plist-stdout or --ephemeral"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Cleanup(resetGlobals)
+			user := t.TempDir()
+			t.Setenv("HOME", user)
+			home := filepath.Join(user, "rpa")
+			cfgPath := filepath.Join(user, "rpa.yaml")
+			writeConfig(t, cfgPath, "ssh:\n  user: me\n  host: example.com\n  identity_file: ~/.ssh/id_ed25519\n  remote_forwards:\n    - \"0.0.0.0:2222:localhost:22\"\nclient:\n  local_forwards:\n    - \"5432:db.internal:5432\"\n")
+
+			args := append([]string{"--home", home, tc.role, "up", "--config", cfgPath, "--plist-stdout"}, tc.extra...)
+			var code int
+			stdout, stderr := captureOutput(t, func() { code = Run(args) })
+			if code != tc.wantCode {
+				t.Fatalf("exit code = %d, want %d\nstderr:\n%s", code, tc.wantCode, stderr)
+			}
+			if !strings.Contains(stderr, tc.wantStderr) {
+				t.Fatalf("stderr %q lacks %q", stderr, tc.wantStderr)
+			}
+			if tc.wantCode != exitOK {
+				return
+			}
+
+			got := plistStrings(t, stdout)
+			want := append([]string{"--home", home}, append(tc.wantArgs, cfgPath)...)
+			if !strings.Contains(strings.Join(got, "\x00"), strings.Join(want, "\x00")) {
+				t.Fatalf("plist strings %q lack ProgramArguments %q", got, want)
+			}
+			// Printing installs nothing and creates no log directory.
+			for _, dir := range []string{filepath.Join(user, "Library"), filepath.Join(home, "logs")} {
+				if _, err := os.Stat(dir); !os.IsNotExist(err) {
+					t.Fatalf("%s exists after --plist-stdout: %v", dir, err)
+				}
+			}
+		})
+	}
+}
+
+// plistStrings returns every <string> value in the plist, failing the test
+// when out is not well-formed XML.
+func plistStrings(t *testing.T, out string) []string {
+	t.Helper()
+	dec := xml.NewDecoder(strings.NewReader(out))
+	var values []string
+	var text string
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			return values
+		}
+		if err != nil {
+			t.Fatalf("plist is not valid XML: %v\n%s", err, out)
+		}
+		switch tok := tok.(type) {
+		case xml.StartElement:
+			text = ""
+		case xml.CharData:
+			text += string(tok)
+		case xml.EndElement:
+			if tok.Name.Local == "string" {
+				values = append(values, text)
+			}
+		}
+	}
+}
+
+func TestWaitForServiceReady(t *testing.T) {
+	cases := []struct {
+		name     string
+		target   string
+		delay    time.Duration
+		reply    ipcReply
+		serve    bool
+		timeout  time.Duration
+		wantErr  string
+		maxTaken time.Duration
+	}{
+		{name: "agent ready", target: "agent", serve: true, reply: ipcReply{OK: true}, timeout: time.Second, maxTaken: 500 * time.Millisecond},
+		{name: "client ready", target: "client", serve: true, reply: ipcReply{OK: true}, timeout: time.Second, maxTaken: 500 * time.Millisecond},
+		{name: "ready within a longer timeout", target: "agent", serve: true, delay: 600 * time.Millisecond, reply: ipcReply{OK: true}, timeout: 5 * time.Second, maxTaken: 3 * time.Second},
+		{name: "not running", target: "agent", timeout: 300 * time.Millisecond, wantErr: "agent", maxTaken: 2 * time.Second},
+		{name: "not ready reply", target: "client", serve: true, reply: ipcReply{Message: "starting"}, timeout: 300 * time.Millisecond, wantErr: "starting", maxTaken: 2 * time.Second},
+		{name: "unknown target", target: "proxy", timeout: time.Second, wantErr: "unknown target", maxTaken: 100 * time.Millisecond},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			home := shortHome(t)
+			useHome(t, home)
+			if tc.serve {
+				socket := filepath.Join(home, tc.target+".sock")
+				if tc.delay == 0 {
+					fakeIPC(t, socket, func(ipcRequest) ipcReply { return tc.reply })
+				} else {
+					timer := time.AfterFunc(tc.delay, func() { fakeIPC(t, socket, func(ipcRequest) ipcReply { return tc.reply }) })
+					t.Cleanup(func() { timer.Stop() })
+				}
+			}
+
+			started := time.Now()
+			err := waitForServiceReady(testConfig(), tc.target, tc.timeout)
+			if taken := time.Since(started); taken > tc.maxTaken {
+				t.Fatalf("waited %s, want at most %s", taken, tc.maxTaken)
+			}
+			if tc.wantErr == "" {
+				if err != nil {
+					t.Fatalf("waitForServiceReady: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Fatalf("waitForServiceReady error = %v, want %q", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestUpReadyTimeoutFlag(t *testing.T) {
+	for _, role := range []string{"agent", "client"} {
+		t.Run(role, func(t *testing.T) {
+			t.Cleanup(resetGlobals)
+			var code int
+			_, stderr := captureOutput(t, func() { code = Run([]string{role, "up", "--ready-timeout", "0s"}) })
+			if code != exitUsage || !strings.Contains(stderr, "--ready-timeout must be a positive duration") {
+				t.Fatalf("exit %d stderr %q, want a usage error", code, stderr)
+			}
+		})
+	}
+}
+
+func TestAttachLogsDetach(t *testing.T) {
+	cases := []struct {
+		name     string
+		target   string
+		logPath  func(*config.Config) (string, error)
+		noLog    bool
+		wantCode int
+		line     string
+		wantOut  string
+		wantErr  string
+	}{
+		{name: "agent", target: "agent", logPath: config.LogPath, wantCode: exitOK, line: "agent line after attach", wantOut: "detached; the agent keeps running"},
+		{name: "client", target: "client", logPath: config.ClientLogPath, wantCode: exitOK, line: "client line after attach", wantOut: "detached; the client keeps running"},
+		{name: "missing log", target: "agent", logPath: config.LogPath, noLog: true, wantCode: exitError, wantErr: "open log file failed"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Cleanup(resetGlobals)
+			home := shortHome(t)
+			useHome(t, home)
+			cfg := testConfig()
+			requests := fakeIPC(t, filepath.Join(home, tc.target+".sock"), func(ipcRequest) ipcReply { return ipcReply{OK: true} })
+			logPath, err := tc.logPath(cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !tc.noLog {
+				if err := os.MkdirAll(filepath.Dir(logPath), 0o700); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(logPath, []byte("line before attach\n"), 0o600); err != nil {
+					t.Fatal(err)
+				}
+			}
+			signals := make(chan chan<- os.Signal, 1)
+			notifyDetach = func(ch chan<- os.Signal) { signals <- ch }
+			t.Cleanup(func() { notifyDetach = func(ch chan<- os.Signal) { signal.Notify(ch, os.Interrupt, syscall.SIGTERM) } })
+
+			var code int
+			stdout, stderr := captureOutput(t, func() {
+				done := make(chan int, 1)
+				go func() { done <- attachLogs(cfg, tc.target) }()
+				sigCh := <-signals
+				if tc.noLog {
+					code = <-done
+					return
+				}
+				// Give the follower time to open the log and reach its end.
+				time.Sleep(200 * time.Millisecond)
+				f, err := os.OpenFile(logPath, os.O_APPEND|os.O_WRONLY, 0)
+				if err != nil {
+					t.Error(err)
+				} else {
+					f.WriteString(tc.line + "\n")
+					f.Close()
+				}
+				time.Sleep(700 * time.Millisecond)
+				sigCh <- os.Interrupt
+				select {
+				case code = <-done:
+				case <-time.After(5 * time.Second):
+					t.Error("attach did not return after Ctrl+C")
+				}
+			})
+			if code != tc.wantCode {
+				t.Fatalf("attachLogs = %d, want %d\nstderr:\n%s", code, tc.wantCode, stderr)
+			}
+			if !strings.Contains(stderr, tc.wantErr) {
+				t.Fatalf("stderr %q lacks %q", stderr, tc.wantErr)
+			}
+			for _, want := range []string{tc.line, tc.wantOut} {
+				if !strings.Contains(stdout, want) {
+					t.Fatalf("stdout %q lacks %q", stdout, want)
+				}
+			}
+			if strings.Contains(stdout, "line before attach") {
+				t.Fatalf("attach replayed old lines: %q", stdout)
+			}
+			// Detaching sends the service nothing, so it keeps running.
+			if got := requests(); len(got) != 0 {
+				t.Fatalf("attach sent the service %+v", got)
+			}
+		})
+	}
+}
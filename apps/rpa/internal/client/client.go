@@ -4,6 +4,7 @@
 package client
 
 import (
+	"context"
 	"fmt"
 	"net"
 	"os/exec"
@@ -18,6 +19,7 @@ import (
 	"reverse-proxy-agent/pkg/logging"
 	"reverse-proxy-agent/pkg/monitor"
 	"reverse-proxy-agent/pkg/restart"
+	"reverse-proxy-agent/pkg/sshutil"
 	"reverse-proxy-agent/pkg/state"
 	"reverse-proxy-agent/pkg/statefile"
 )
@@ -26,7 +28,10 @@ type Client struct {
 	cfg    *config.Config
 	runner *supervisor.Runner
 
-	localMu sync.Mutex
+	localMu         sync.Mutex
+	forwardsVersion int
+
+	extraSSHArgs []string
 }
 
 func New(cfg *config.Config) *Client {
@@ -37,6 +42,8 @@ func New(cfg *config.Config) *Client {
 	runner := supervisor.New(restart.ParsePolicy(cfg.Client.RestartPolicy), restart.NewBackoff(cfg.Client.Restart))
 	if path != "" {
 		runner.SetStateWriter(func(snap statefile.Snapshot) {
+			snap.Version = buildinfo.Current().Version
+			snap.SSHVersion = sshutil.Version()
 			_ = statefile.Write(path, snap)
 		})
 	}
@@ -46,10 +53,16 @@ func New(cfg *config.Config) *Client {
 	}
 }
 
+// SetExtraSSHArgs sets raw ssh arguments from `client run --ssh-arg`; call it
+// before the client starts.
+func (c *Client) SetExtraSSHArgs(args []string) {
+	c.extraSSHArgs = append([]string(nil), args...)
+}
+
 func (c *Client) Start() error {
 	return c.runner.Start(func() (*exec.Cmd, error) {
-		return buildSSHCommand(c.cfg, c.currentLocalForwards())
-	})
+		return buildSSHCommand(c.cfg, c.currentLocalForwards(), c.currentDynamicForwards(), c.extraSSHArgs)
+	}, c.cfg.ClientLogging.SSHStderrLines)
 }
 
 func (c *Client) Stop() error {
@@ -62,18 +75,17 @@ func (c *Client) State() state.State {
 
 func (c *Client) ConfigSummary() string {
 	forwards := config.NormalizeLocalForwards(c.cfg)
-	forward := ""
-	if len(forwards) > 0 {
-		forward = forwards[0]
-	}
 	host := c.cfg.SSH.Host
 	if c.cfg.SSH.User != "" {
 		host = fmt.Sprintf("%s@%s", c.cfg.SSH.User, c.cfg.SSH.Host)
 	}
-	if forward == "" {
-		return fmt.Sprintf("%s:%d", host, c.cfg.SSH.Port)
+	if len(forwards) > 0 {
+		return fmt.Sprintf("%s:%d (local=%s)", host, c.cfg.SSH.Port, forwards[0])
+	}
+	if dynamic := config.NormalizeDynamicForwards(c.cfg); len(dynamic) > 0 {
+		return fmt.Sprintf("%s:%d (dynamic=%s)", host, c.cfg.SSH.Port, dynamic[0])
 	}
-	return fmt.Sprintf("%s:%d (local=%s)", host, c.cfg.SSH.Port, forward)
+	return fmt.Sprintf("%s:%d", host, c.cfg.SSH.Port)
 }
 
 func (c *Client) RunWithLogger(logger *logging.Logger) error {
@@ -85,17 +97,100 @@ func (c *Client) RunWithLogger(logger *logging.Logger) error {
 			SleepCheckSec:  c.cfg.Client.SleepCheckSec,
 			SleepGapSec:    c.cfg.Client.SleepGapSec,
 			NetworkPollSec: c.cfg.Client.NetworkPollSec,
+			PowerPollSec:   c.cfg.Client.PowerPollSec,
 		},
-		PeriodicRestartSec: c.cfg.Client.PeriodicRestartSec,
-		DebounceMs:         c.cfg.Client.Restart.DebounceMs,
-		TCPCheckSec:        c.cfg.SSH.CheckSec,
-		TCPCheckAddr:       net.JoinHostPort(c.cfg.SSH.Host, strconv.Itoa(c.cfg.SSH.Port)),
+		PeriodicRestartSec:  c.cfg.Client.PeriodicRestartSec,
+		PeriodicRestartCron: c.cfg.Client.PeriodicRestartCron,
+		DebounceMs:          c.cfg.Client.Restart.DebounceMs,
+		SuccessAfterMs:      config.SuccessAfterMs(c.cfg.Client.Restart),
+		RapidFailureLimit:   c.cfg.Client.Restart.RapidFailureLimit,
+		TCPCheckSec:         c.cfg.SSH.TCPCheckSec,
+		TCPCheckAddr:        c.tcpCheckAddr,
+		ProbeRTT:            c.cfg.SSH.ProbeRTT,
+		TCPCheckJitter:      c.cfg.SSH.CheckJitter,
+		TCPCheckFailures:    c.cfg.SSH.TCPCheckFailures,
+		TCPCheckRestart:     c.cfg.SSH.TCPCheckRestart,
+		OnConnect:           c.cfg.Hooks.OnConnect,
+		OnDisconnect:        c.cfg.Hooks.OnDisconnect,
+		HookTimeoutMs:       c.cfg.Hooks.TimeoutMs,
+		NotifyOnReconnect:   c.cfg.Hooks.NotifyOnReconnect,
+		RestartOnStderr:     c.cfg.Client.Restart.RestartOnStderr,
+		SSHStderrLines:      c.cfg.ClientLogging.SSHStderrLines,
 	}
 	return c.runner.RunWithLogger(logger, func() (*exec.Cmd, error) {
-		return buildSSHCommand(c.cfg, c.currentLocalForwards())
+		forwards := c.currentLocalForwards()
+		if c.cfg.Client.CheckLocalPorts {
+			if addr, err := busyLocalPort(forwards); err != nil {
+				logger.Event("WARN", "local_port_busy", map[string]any{
+					"addr":  addr,
+					"error": err.Error(),
+				})
+				return nil, fmt.Errorf("local port busy: %s", addr)
+			}
+		}
+		return buildSSHCommand(c.cfg, forwards, c.currentDynamicForwards(), c.extraSSHArgs)
 	}, opts)
 }
 
+// busyLocalPort tries to listen on every local forward bind and returns the
+// first address that is already taken, so a start can back off without
+// launching ssh just to watch it fail.
+func busyLocalPort(forwards []string) (string, error) {
+	for _, forward := range forwards {
+		host, port, err := config.ForwardListen(forward)
+		if err != nil {
+			continue
+		}
+		if host == "*" {
+			host = ""
+		}
+		addr := net.JoinHostPort(host, port)
+		ln, err := net.Listen("tcp", addr)
+		if err != nil {
+			return addr, err
+		}
+		_ = ln.Close()
+	}
+	return "", nil
+}
+
+// tcpCheckAddr prefers ssh.check_addr, then (with ssh.check_local_forward) the
+// first current local forward bind, and finally the ssh host. It runs on every
+// check, so forwards added or removed at runtime are followed. Probing a bind
+// opens a tunnel channel to the remote service each time, hence the opt-in.
+func (c *Client) tcpCheckAddr() string {
+	if addr := strings.TrimSpace(c.cfg.SSH.CheckAddr); addr != "" {
+		return addr
+	}
+	if c.cfg.SSH.CheckLocalForward {
+		for _, forward := range c.currentLocalForwards() {
+			if addr, ok := localForwardBindAddr(forward); ok {
+				return addr
+			}
+		}
+	}
+	return net.JoinHostPort(c.cfg.SSH.Host, strconv.Itoa(c.cfg.SSH.Port))
+}
+
+// localForwardBindAddr returns the dialable host:port a local forward listens on.
+// Wildcard and empty binds are probed through loopback.
+func localForwardBindAddr(forward string) (string, bool) {
+	host, port, err := config.ForwardListen(forward)
+	if err != nil {
+		return "", false
+	}
+	if n, err := strconv.Atoi(port); err != nil || n <= 0 || n > 65535 {
+		return "", false
+	}
+	switch host {
+	case "", "*", "0.0.0.0", "localhost":
+		host = "127.0.0.1"
+	case "::":
+		host = "::1"
+	}
+	return net.JoinHostPort(host, port), true
+}
+
 func (c *Client) RequestStop() {
 	c.runner.RequestStop()
 }
@@ -104,6 +199,16 @@ func (c *Client) RequestRestart(reason string) {
 	c.runner.RequestRestart(reason, c.cfg.Client.Restart.DebounceMs)
 }
 
+func (c *Client) Reconnect() {
+	c.runner.Reconnect("reconnect")
+}
+
+// SetMonitorIntervals retunes the running sleep/network/power monitors; an
+// empty changes map only reports the current settings.
+func (c *Client) SetMonitorIntervals(changes map[string]int) (monitor.Config, error) {
+	return c.runner.SetMonitorIntervals(changes)
+}
+
 func (c *Client) RestartCount() int {
 	return c.runner.RestartCount()
 }
@@ -116,6 +221,14 @@ func (c *Client) LastSuccess() time.Time {
 	return c.runner.LastSuccess()
 }
 
+func (c *Client) FirstSuccess() time.Time {
+	return c.runner.FirstSuccess()
+}
+
+func (c *Client) StartupConnect() (time.Duration, bool) {
+	return c.runner.StartupConnect()
+}
+
 func (c *Client) LastClass() string {
 	return c.runner.LastClass()
 }
@@ -124,10 +237,56 @@ func (c *Client) LastTriggerReason() string {
 	return c.runner.LastTriggerReason()
 }
 
+func (c *Client) LastTriggerAt() time.Time {
+	return c.runner.LastTriggerAt()
+}
+
+func (c *Client) SSHStderr() []string {
+	return c.runner.SSHStderr()
+}
+
+func (c *Client) Subscribe() (<-chan supervisor.Event, func()) {
+	return c.runner.Subscribe()
+}
+
+func (c *Client) ProbeRTT() (time.Duration, time.Duration, bool) {
+	return c.runner.ProbeRTT()
+}
+
+func (c *Client) TriggerCounts() map[string]int {
+	return c.runner.TriggerCounts()
+}
+
+func (c *Client) StateTransitions() int {
+	return c.runner.StateTransitions()
+}
+
+func (c *Client) ExitClassCounts() map[string]int {
+	return c.runner.ExitClassCounts()
+}
+
 func (c *Client) TCPCheckStatus() (string, string, time.Time) {
 	return c.runner.TCPCheckStatus()
 }
 
+// Health is state.Health for the current state and tcp check.
+func (c *Client) Health() string {
+	status, _, _ := c.runner.TCPCheckStatus()
+	return state.Health(c.State(), status)
+}
+
+func (c *Client) TCPCheckFailures() int {
+	return c.runner.TCPCheckFailures()
+}
+
+func (c *Client) ConnectAttempts() int {
+	return c.runner.ConnectAttempts()
+}
+
+func (c *Client) FlapCount() int {
+	return c.runner.FlapCount()
+}
+
 func (c *Client) StartSuccessCount() int {
 	return c.runner.StartSuccessCount()
 }
@@ -148,6 +307,24 @@ func (c *Client) CurrentBackoff() time.Duration {
 	return c.runner.CurrentBackoff()
 }
 
+// forwardsChangedLocked bumps the forwards version and logs the change.
+// Callers must hold localMu.
+func (c *Client) forwardsChangedLocked(action, spec string, count int) {
+	c.forwardsVersion++
+	c.runner.LogEvent("INFO", "forwards_changed", map[string]any{
+		"action":  action,
+		"forward": spec,
+		"count":   count,
+		"version": c.forwardsVersion,
+	})
+}
+
+func (c *Client) ForwardsVersion() int {
+	c.localMu.Lock()
+	defer c.localMu.Unlock()
+	return c.forwardsVersion
+}
+
 func (c *Client) currentLocalForwards() []string {
 	c.localMu.Lock()
 	defer c.localMu.Unlock()
@@ -179,6 +356,7 @@ func (c *Client) EnsureLocalForward(forward string) bool {
 	}
 	current = append(current, trimmed)
 	config.SetLocalForwards(c.cfg, current)
+	c.forwardsChangedLocked("added", trimmed, len(current))
 	return true
 }
 
@@ -202,14 +380,64 @@ func (c *Client) RemoveLocalForward(forward string) (bool, error) {
 	if !removed {
 		return false, nil
 	}
-	if len(next) == 0 {
-		return false, fmt.Errorf("at least one local forward is required")
+	if len(next) == 0 && len(config.NormalizeDynamicForwards(c.cfg)) == 0 {
+		return false, fmt.Errorf("at least one local or dynamic forward is required")
 	}
 	config.SetLocalForwards(c.cfg, next)
-	c.RequestRestart("local forward removed")
+	c.forwardsChangedLocked("removed", trimmed, len(next))
 	return true, nil
 }
 
+// ApplyForward pushes a forward change to the running session through the
+// ssh ControlMaster socket when ssh.control_master is on, so other forwards
+// stay up. It falls back to a restart and reports whether the live path worked.
+func (c *Client) ApplyForward(op, forward, reason string) bool {
+	return c.applyForward(op, "-L", forward, reason)
+}
+
+// ApplyDynamicForward is ApplyForward for a -D forward.
+func (c *Client) ApplyDynamicForward(op, forward, reason string) bool {
+	return c.applyForward(op, "-D", forward, reason)
+}
+
+func (c *Client) applyForward(op, flag, forward, reason string) bool {
+	if c.cfg.SSH.ControlMaster && c.State() == state.StateConnected {
+		err := c.controlForward(op, flag, forward)
+		if err == nil {
+			c.runner.LogEvent("INFO", "forward_applied", map[string]any{
+				"op":      op,
+				"forward": forward,
+				"method":  "control",
+			})
+			return true
+		}
+		c.runner.LogEvent("WARN", "control_forward_failed", map[string]any{
+			"op":      op,
+			"forward": forward,
+			"error":   err.Error(),
+		})
+	}
+	c.RequestRestart(reason)
+	return false
+}
+
+func (c *Client) controlForward(op, flag, forward string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), controlTimeout)
+	defer cancel()
+	cmd, err := buildControlCommand(ctx, c.cfg, op, flag, forward)
+	if err != nil {
+		return err
+	}
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		if msg := strings.TrimSpace(string(out)); msg != "" {
+			return fmt.Errorf("%w: %s", err, msg)
+		}
+		return err
+	}
+	return nil
+}
+
 func (c *Client) ClearLocalForwards() bool {
 	c.localMu.Lock()
 	defer c.localMu.Unlock()
@@ -218,6 +446,68 @@ func (c *Client) ClearLocalForwards() bool {
 		return false
 	}
 	config.SetLocalForwards(c.cfg, nil)
+	c.forwardsChangedLocked("cleared", strings.Join(current, ","), 0)
+	if len(config.NormalizeDynamicForwards(c.cfg)) > 0 {
+		c.RequestRestart("local forwards cleared")
+		return true
+	}
 	c.RequestStop()
 	return true
 }
+
+func (c *Client) currentDynamicForwards() []string {
+	c.localMu.Lock()
+	defer c.localMu.Unlock()
+	return config.NormalizeDynamicForwards(c.cfg)
+}
+
+func (c *Client) DynamicForwards() []string {
+	return c.currentDynamicForwards()
+}
+
+func (c *Client) EnsureDynamicForward(forward string) bool {
+	trimmed := strings.TrimSpace(forward)
+	if trimmed == "" {
+		return false
+	}
+	c.localMu.Lock()
+	defer c.localMu.Unlock()
+	current := config.NormalizeDynamicForwards(c.cfg)
+	for _, existing := range current {
+		if existing == trimmed {
+			return false
+		}
+	}
+	current = append(current, trimmed)
+	config.SetDynamicForwards(c.cfg, current)
+	c.forwardsChangedLocked("added", trimmed, len(current))
+	return true
+}
+
+func (c *Client) RemoveDynamicForward(forward string) (bool, error) {
+	trimmed := strings.TrimSpace(forward)
+	if trimmed == "" {
+		return false, fmt.Errorf("dynamic forward is required")
+	}
+	c.localMu.Lock()
+	defer c.localMu.Unlock()
+	current := config.NormalizeDynamicForwards(c.cfg)
+	next := make([]string, 0, len(current))
+	removed := false
+	for _, existing := range current {
+		if existing == trimmed {
+			removed = true
+			continue
+		}
+		next = append(next, existing)
+	}
+	if !removed {
+		return false, nil
+	}
+	if len(next) == 0 && len(config.NormalizeLocalForwards(c.cfg)) == 0 {
+		return false, fmt.Errorf("at least one local or dynamic forward is required")
+	}
+	config.SetDynamicForwards(c.cfg, next)
+	c.forwardsChangedLocked("removed", trimmed, len(next))
+	return true, nil
+}
@@ -0,0 +1,244 @@
+package client
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"reverse-proxy-agent/pkg/config"
+	"reverse-proxy-agent/pkg/logging"
+)
+
+func TestForwardsVersion(t *testing.T) {
+	cases := []struct {
+		name        string
+		dynamic     bool
+		change      func(c *Client) (bool, error)
+		wantChanged bool
+		wantVersion int
+	}{
+		{name: "add local", change: func(c *Client) (bool, error) { return c.EnsureLocalForward("6379:cache.internal:6379"), nil }, wantChanged: true, wantVersion: 1},
+		{name: "add local duplicate", change: func(c *Client) (bool, error) { return c.EnsureLocalForward("5432:db.internal:5432"), nil }},
+		{name: "remove local", change: func(c *Client) (bool, error) {
+			c.EnsureLocalForward("6379:cache.internal:6379")
+			return c.RemoveLocalForward("6379:cache.internal:6379")
+		}, wantChanged: true, wantVersion: 2},
+		{name: "remove missing local", change: func(c *Client) (bool, error) { return c.RemoveLocalForward("6379:cache.internal:6379") }},
+		{name: "add dynamic", change: func(c *Client) (bool, error) { return c.EnsureDynamicForward("1080"), nil }, wantChanged: true, wantVersion: 1},
+		{name: "remove dynamic", dynamic: true, change: func(c *Client) (bool, error) { return c.RemoveDynamicForward("1080") }, wantChanged: true, wantVersion: 1},
+		{name: "clear local", change: func(c *Client) (bool, error) { return c.ClearLocalForwards(), nil }, wantChanged: true, wantVersion: 1},
+		{name: "clear local keeps dynamic", dynamic: true, change: func(c *Client) (bool, error) { return c.ClearLocalForwards(), nil }, wantChanged: true, wantVersion: 1},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			c := New(testSSHConfig(func(cfg *config.Config) {
+				if tc.dynamic {
+					cfg.Client.DynamicForwards = []string{"1080"}
+				}
+			}))
+			if v := c.ForwardsVersion(); v != 0 {
+				t.Fatalf("initial ForwardsVersion = %d, want 0", v)
+			}
+			changed, err := tc.change(c)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if changed != tc.wantChanged {
+				t.Fatalf("changed = %v, want %v", changed, tc.wantChanged)
+			}
+			if v := c.ForwardsVersion(); v != tc.wantVersion {
+				t.Fatalf("ForwardsVersion = %d, want %d", v, tc.wantVersion)
+			}
+		})
+	}
+}
+
+func TestLocalForwardBindAddr(t *testing.T) {
+	cases := []struct {
+		forward string
+		want    string
+		wantOK  bool
+	}{
+		{forward: "5432:db.internal:5432", want: "127.0.0.1:5432", wantOK: true},
+		{forward: " 5432:db.internal:5432 ", want: "127.0.0.1:5432", wantOK: true},
+		{forward: "127.0.0.1:6379:cache:6379", want: "127.0.0.1:6379", wantOK: true},
+		{forward: "10.0.0.5:8080:web:80", want: "10.0.0.5:8080", wantOK: true},
+		{forward: "0.0.0.0:8080:web:80", want: "127.0.0.1:8080", wantOK: true},
+		{forward: "*:8080:web:80", want: "127.0.0.1:8080", wantOK: true},
+		{forward: ":8080:web:80", want: "127.0.0.1:8080", wantOK: true},
+		{forward: "localhost:8080:web:80", want: "127.0.0.1:8080", wantOK: true},
+		{forward: "[::1]:8080:web:80", want: "[::1]:8080", wantOK: true},
+		{forward: "[::]:8080:[::1]:80", want: "[::1]:8080", wantOK: true},
+		{forward: "web:80"},
+		{forward: "abc:web:80"},
+		{forward: "0:web:80"},
+		{forward: "70000:web:80"},
+		{forward: "/tmp/app.sock:/run/app.sock"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.forward, func(t *testing.T) {
+			got, ok := localForwardBindAddr(tc.forward)
+			if got != tc.want || ok != tc.wantOK {
+				t.Fatalf("localForwardBindAddr(%q) = %q, %v, want %q, %v", tc.forward, got, ok, tc.want, tc.wantOK)
+			}
+		})
+	}
+}
+
+func TestTCPCheckAddr(t *testing.T) {
+	cases := []struct {
+		name         string
+		checkAddr    string
+		checkForward bool
+		forwards     []string
+		// changed replaces the forwards after New, as client add/remove does.
+		changed []string
+		want    string
+	}{
+		{name: "ssh host by default", forwards: []string{"5432:db.internal:5432"}, want: "bastion.example.com:22"},
+		{name: "first local forward", checkForward: true, forwards: []string{"5432:db.internal:5432", "6379:cache:6379"}, want: "127.0.0.1:5432"},
+		{name: "first usable forward", checkForward: true, forwards: []string{"/tmp/app.sock:/run/app.sock", "10.0.0.5:6379:cache:6379"}, want: "10.0.0.5:6379"},
+		{name: "explicit check addr wins", checkAddr: "db.internal:5432", checkForward: true, forwards: []string{"6379:cache:6379"}, want: "db.internal:5432"},
+		{name: "ssh host without a usable forward", checkForward: true, forwards: []string{"/tmp/app.sock:/run/app.sock"}, want: "bastion.example.com:22"},
+		{name: "follows a replaced forward", checkForward: true, forwards: []string{"5432:db.internal:5432"}, changed: []string{"6379:cache:6379"}, want: "127.0.0.1:6379"},
+		{name: "ssh host after the forwards are cleared", checkForward: true, forwards: []string{"5432:db.internal:5432"}, changed: []string{}, want: "bastion.example.com:22"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			c := New(testSSHConfig(func(cfg *config.Config) {
+				cfg.SSH.CheckAddr = tc.checkAddr
+				cfg.SSH.CheckLocalForward = tc.checkForward
+				cfg.Client.LocalForwards = nil
+				for _, spec := range tc.forwards {
+					cfg.Client.LocalForwards = append(cfg.Client.LocalForwards, config.Forward{Spec: spec})
+				}
+			}))
+			if tc.changed != nil {
+				c.SetLocalForwards(tc.changed)
+			}
+			if got := c.tcpCheckAddr(); got != tc.want {
+				t.Fatalf("tcpCheckAddr = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestBusyLocalPort(t *testing.T) {
+	busy := listenLocal(t)
+	free := freePort(t)
+	cases := []struct {
+		name     string
+		forwards []string
+		wantAddr string
+	}{
+		{name: "no forwards"},
+		{name: "free port", forwards: []string{free + ":db.internal:5432"}},
+		{name: "busy port", forwards: []string{busy + ":db.internal:5432"}, wantAddr: "127.0.0.1:" + busy},
+		{name: "busy port with bind", forwards: []string{"127.0.0.1:" + busy + ":db.internal:5432"}, wantAddr: "127.0.0.1:" + busy},
+		{name: "first busy reported", forwards: []string{free + ":a:1", busy + ":b:2"}, wantAddr: "127.0.0.1:" + busy},
+		{name: "unparsable spec skipped", forwards: []string{"1080", " "}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			addr, err := busyLocalPort(tc.forwards)
+			if addr != tc.wantAddr || (err != nil) != (tc.wantAddr != "") {
+				t.Fatalf("busyLocalPort = %q, %v; want %q", addr, err, tc.wantAddr)
+			}
+		})
+	}
+}
+
+func TestCheckLocalPorts(t *testing.T) {
+	cases := []struct {
+		name      string
+		check     bool
+		wantEvent string
+		wantSSH   bool
+	}{
+		{name: "busy port skips ssh", check: true, wantEvent: "local_port_busy"},
+		{name: "check off launches ssh", wantEvent: "ssh_started", wantSSH: true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			marker := filepath.Join(dir, "ssh-ran")
+			if err := os.WriteFile(filepath.Join(dir, "ssh"), []byte("#!/bin/sh\n[ \"$1\" = -V ] && exit 0\ntouch "+marker+"\nexec sleep 30\n"), 0o700); err != nil {
+				t.Fatal(err)
+			}
+			t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+			config.SetHomeDir(dir)
+			t.Cleanup(func() { config.SetHomeDir("") })
+
+			busy := listenLocal(t)
+			c := New(testSSHConfig(func(cfg *config.Config) {
+				cfg.Client.LocalForwards = []config.Forward{{Spec: busy + ":db.internal:5432"}}
+				cfg.Client.CheckLocalPorts = tc.check
+			}))
+			ring := logging.NewLogBuffer()
+			logger, err := logging.NewLoggerWithPath(filepath.Join(dir, "client.log"), ring)
+			if err != nil {
+				t.Fatal(err)
+			}
+			done := make(chan error, 1)
+			go func() { done <- c.RunWithLogger(logger) }()
+
+			deadline := time.Now().Add(5 * time.Second)
+			for !ringContains(ring, tc.wantEvent) {
+				if time.Now().After(deadline) {
+					t.Fatalf("no %s event: %q", tc.wantEvent, ring.List())
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+			if !tc.wantSSH {
+				// Give a wrongly launched ssh time to leave its marker.
+				time.Sleep(100 * time.Millisecond)
+			}
+			c.RequestStop()
+			select {
+			case <-done:
+			case <-time.After(10 * time.Second):
+				t.Fatal("client did not stop")
+			}
+			_, statErr := os.Stat(marker)
+			if ran := statErr == nil; ran != tc.wantSSH {
+				t.Fatalf("ssh ran = %v, want %v", ran, tc.wantSSH)
+			}
+		})
+	}
+}
+
+// listenLocal holds a loopback port for the test and returns it.
+func listenLocal(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+	return port
+}
+
+// freePort returns a loopback port that was free a moment ago.
+func freePort(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+	return port
+}
+
+func ringContains(ring *logging.LogBuffer, substr string) bool {
+	for _, line := range ring.List() {
+		if strings.Contains(line, substr) {
+			return true
+		}
+	}
+	return false
+}
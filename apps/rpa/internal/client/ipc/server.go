@@ -7,16 +7,21 @@ import (
 	"bufio"
 	"encoding/json"
 	"fmt"
+	"io"
 	"net"
 	"os"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
 	"reverse-proxy-agent/internal/client"
+	"reverse-proxy-agent/pkg/caffeinate"
 	"reverse-proxy-agent/pkg/config"
 	"reverse-proxy-agent/pkg/logging"
+	"reverse-proxy-agent/pkg/monitor"
 )
 
 type Server struct {
@@ -24,6 +29,13 @@ type Server struct {
 	client     *client.Client
 	logs       *logging.LogBuffer
 	startedAt  time.Time
+	socketMode os.FileMode
+	conns      chan struct{}
+	streams    chan struct{}
+	readLimit  time.Duration
+
+	preventSleep bool
+	keeper       *caffeinate.Keeper
 
 	mu       sync.Mutex
 	listener net.Listener
@@ -46,14 +58,30 @@ func NewServer(cfg *config.Config, clientInstance *client.Client, logs *logging.
 	if err != nil {
 		return nil, err
 	}
+	socketMode, err := config.ParseSocketMode(cfg.IPC.SocketMode)
+	if err != nil {
+		return nil, err
+	}
 	return &Server{
 		socketPath: socketPath,
-		client:     clientInstance,
-		logs:       logs,
-		startedAt:  time.Now(),
+		socketMode: socketMode,
+		conns:      make(chan struct{}, maxConns(cfg)),
+		streams:    make(chan struct{}, maxConns(cfg)),
+		readLimit:  readTimeout(cfg),
+
+		preventSleep: cfg.Client.PreventSleep,
+		client:       clientInstance,
+		logs:         logs,
+		startedAt:    time.Now(),
 	}, nil
 }
 
+// SetSleepKeeper lets status report whether caffeinate is holding its
+// assertion. Call it before Start.
+func (s *Server) SetSleepKeeper(keeper *caffeinate.Keeper) {
+	s.keeper = keeper
+}
+
 func (s *Server) Start() error {
 	if err := os.MkdirAll(filepath.Dir(s.socketPath), 0o755); err != nil {
 		return fmt.Errorf("create socket dir: %w", err)
@@ -63,7 +91,7 @@ func (s *Server) Start() error {
 	if err != nil {
 		return fmt.Errorf("listen on socket: %w", err)
 	}
-	if err := os.Chmod(s.socketPath, 0o600); err != nil {
+	if err := os.Chmod(s.socketPath, s.socketMode); err != nil {
 		_ = lis.Close()
 		return fmt.Errorf("chmod socket: %w", err)
 	}
@@ -99,13 +127,49 @@ func (s *Server) acceptLoop() {
 		if err != nil {
 			return
 		}
-		go s.handleConn(conn)
+		select {
+		case s.conns <- struct{}{}:
+			go func() {
+				var once sync.Once
+				release := func() { once.Do(func() { <-s.conns }) }
+				defer release()
+				s.handleConn(conn, release)
+			}()
+		default:
+			rejectConn(conn)
+		}
 	}
 }
 
-func (s *Server) handleConn(conn net.Conn) {
+// rejectConn answers a connection over the ipc.max_conns limit without
+// spawning a handler.
+func rejectConn(conn net.Conn) {
+	_ = conn.SetWriteDeadline(time.Now().Add(100 * time.Millisecond))
+	writeResponse(conn, response{OK: false, Message: "too many connections"})
+	_ = conn.Close()
+}
+
+func maxConns(cfg *config.Config) int {
+	if cfg.IPC.MaxConns > 0 {
+		return cfg.IPC.MaxConns
+	}
+	return 16
+}
+
+func readTimeout(cfg *config.Config) time.Duration {
+	if cfg.IPC.ReadTimeoutMs > 0 {
+		return time.Duration(cfg.IPC.ReadTimeoutMs) * time.Millisecond
+	}
+	return 5 * time.Second
+}
+
+// handleConn serves one request. release frees the connection's max_conns
+// slot early; a long-lived stream calls it so it does not lock out requests.
+func (s *Server) handleConn(conn net.Conn, release func()) {
 	defer conn.Close()
 
+	// A client that connects and never sends a request is dropped.
+	_ = conn.SetReadDeadline(time.Now().Add(s.readLimit))
 	scanner := bufio.NewScanner(conn)
 	if !scanner.Scan() {
 		return
@@ -117,20 +181,34 @@ func (s *Server) handleConn(conn net.Conn) {
 	}
 
 	switch req.Command {
+	case "ping":
+		s.handlePing(conn)
 	case "status":
 		s.handleStatus(conn)
 	case "metrics":
 		s.handleMetrics(conn)
+	case "events":
+		s.handleEvents(conn, release)
 	case "logs":
 		s.handleLogs(conn)
+	case "ssh_stderr":
+		s.handleSSHStderr(conn)
 	case "stop":
 		s.handleStop(conn)
+	case "reconnect":
+		s.handleReconnect(conn)
 	case "add_local_forward":
 		s.handleAddLocalForward(conn, req.Args)
 	case "remove_local_forward":
 		s.handleRemoveLocalForward(conn, req.Args)
 	case "clear_local_forwards":
 		s.handleClearLocalForwards(conn)
+	case "add_dynamic_forward":
+		s.handleAddDynamicForward(conn, req.Args)
+	case "remove_dynamic_forward":
+		s.handleRemoveDynamicForward(conn, req.Args)
+	case "monitor_intervals":
+		s.handleMonitorIntervals(conn, req.Args)
 	default:
 		writeResponse(conn, response{OK: false, Message: "unknown command"})
 	}
@@ -149,9 +227,18 @@ func (s *Server) handleStatus(conn net.Conn) {
 		"last_trigger": s.client.LastTriggerReason(),
 	}
 	data["local_forwards"] = strings.Join(s.client.LocalForwards(), ",")
+	data["dynamic_forwards"] = strings.Join(s.client.DynamicForwards(), ",")
+	data["forwards_version"] = fmt.Sprintf("%d", s.client.ForwardsVersion())
+	if at := s.client.LastTriggerAt(); !at.IsZero() {
+		data["last_trigger_unix"] = fmt.Sprintf("%d", at.Unix())
+	}
 	if !s.client.LastSuccess().IsZero() {
 		data["last_success_unix"] = fmt.Sprintf("%d", s.client.LastSuccess().Unix())
 	}
+	if took, ok := s.client.StartupConnect(); ok {
+		data["startup_connect_sec"] = fmt.Sprintf("%.3f", took.Seconds())
+	}
+	data["health"] = s.client.Health()
 	if status, errMsg, at := s.client.TCPCheckStatus(); status != "" {
 		data["tcp_check"] = status
 		if errMsg != "" {
@@ -160,7 +247,17 @@ func (s *Server) handleStatus(conn net.Conn) {
 		if !at.IsZero() {
 			data["tcp_check_unix"] = fmt.Sprintf("%d", at.Unix())
 		}
+		if failures := s.client.TCPCheckFailures(); failures > 0 {
+			data["tcp_check_failures"] = fmt.Sprintf("%d", failures)
+		}
+	}
+	if s.preventSleep {
+		data["prevent_sleep_active"] = fmt.Sprintf("%t", s.keeper.Active())
+	}
+	if counts := formatCounts(s.client.ExitClassCounts()); counts != "" {
+		data["exit_classes"] = counts
 	}
+	data["monitors"] = monitor.Info().String()
 	if backoff := s.client.CurrentBackoff(); backoff > 0 {
 		data["backoff_ms"] = fmt.Sprintf("%d", backoff.Milliseconds())
 	}
@@ -169,33 +266,120 @@ func (s *Server) handleStatus(conn net.Conn) {
 
 func (s *Server) handleMetrics(conn net.Conn) {
 	data := map[string]string{
-		"rpa_client_state":               fmt.Sprintf("%d", s.client.State()),
-		"rpa_client_restart_total":       fmt.Sprintf("%d", s.client.RestartCount()),
-		"rpa_client_uptime_sec":          fmt.Sprintf("%d", int(time.Since(s.startedAt).Seconds())),
-		"rpa_client_start_success_total": fmt.Sprintf("%d", s.client.StartSuccessCount()),
-		"rpa_client_start_failure_total": fmt.Sprintf("%d", s.client.StartFailureCount()),
-		"rpa_client_exit_success_total":  fmt.Sprintf("%d", s.client.ExitSuccessCount()),
-		"rpa_client_exit_failure_total":  fmt.Sprintf("%d", s.client.ExitFailureCount()),
-		"rpa_client_last_trigger":        s.client.LastTriggerReason(),
+		"rpa_client_state":                   fmt.Sprintf("%d", s.client.State()),
+		"rpa_client_state_name":              s.client.State().String(),
+		"rpa_client_state_transitions_total": fmt.Sprintf("%d", s.client.StateTransitions()),
+		"rpa_client_restart_total":           fmt.Sprintf("%d", s.client.RestartCount()),
+		"rpa_client_uptime_sec":              fmt.Sprintf("%d", int(time.Since(s.startedAt).Seconds())),
+		"rpa_client_connect_attempts_total":  fmt.Sprintf("%d", s.client.ConnectAttempts()),
+		"rpa_client_flap_rate":               fmt.Sprintf("%d", s.client.FlapCount()),
+		"rpa_client_start_success_total":     fmt.Sprintf("%d", s.client.StartSuccessCount()),
+		"rpa_client_start_failure_total":     fmt.Sprintf("%d", s.client.StartFailureCount()),
+		"rpa_client_exit_success_total":      fmt.Sprintf("%d", s.client.ExitSuccessCount()),
+		"rpa_client_exit_failure_total":      fmt.Sprintf("%d", s.client.ExitFailureCount()),
+		"rpa_client_last_trigger":            s.client.LastTriggerReason(),
+	}
+	if at := s.client.LastTriggerAt(); !at.IsZero() {
+		data["rpa_client_last_trigger_unix"] = fmt.Sprintf("%d", at.Unix())
 	}
 	if !s.client.LastSuccess().IsZero() {
 		data["rpa_client_last_success_unix"] = fmt.Sprintf("%d", s.client.LastSuccess().Unix())
 	}
+	if took, ok := s.client.StartupConnect(); ok {
+		data["rpa_client_startup_connect_seconds"] = fmt.Sprintf("%.3f", took.Seconds())
+	}
+	for reason, count := range s.client.TriggerCounts() {
+		data[fmt.Sprintf("rpa_client_trigger_total{reason=%q}", reason)] = fmt.Sprintf("%d", count)
+	}
+	for class, count := range s.client.ExitClassCounts() {
+		data[fmt.Sprintf("rpa_client_exit_class_total{class=%q}", class)] = fmt.Sprintf("%d", count)
+	}
+	if last, avg, ok := s.client.ProbeRTT(); ok {
+		data["rpa_client_probe_rtt_ms"] = fmt.Sprintf("%.1f", float64(last.Microseconds())/1000)
+		data["rpa_client_probe_rtt_avg_ms"] = fmt.Sprintf("%.1f", float64(avg.Microseconds())/1000)
+	}
+	if s.preventSleep {
+		active := 0
+		if s.keeper.Active() {
+			active = 1
+		}
+		data["rpa_client_prevent_sleep_active"] = fmt.Sprintf("%d", active)
+		data["rpa_client_caffeinate_restart_total"] = fmt.Sprintf("%d", s.keeper.Restarts())
+	}
 	if backoff := s.client.CurrentBackoff(); backoff > 0 {
 		data["rpa_client_backoff_ms"] = fmt.Sprintf("%d", backoff.Milliseconds())
 	}
 	writeResponse(conn, response{OK: true, Data: data})
 }
 
+// handleEvents streams lifecycle events as JSON lines until the client
+// disconnects or a write fails.
+// handleEvents streams events until the caller hangs up. The stream gives its
+// max_conns slot back and is counted against a separate limit of the same
+// size, so open streams cannot starve status or stop.
+func (s *Server) handleEvents(conn net.Conn, release func()) {
+	select {
+	case s.streams <- struct{}{}:
+		defer func() { <-s.streams }()
+	default:
+		writeResponse(conn, response{OK: false, Message: "too many event streams"})
+		return
+	}
+	release()
+	events, cancel := s.client.Subscribe()
+	defer cancel()
+	_ = conn.SetReadDeadline(time.Time{})
+	writeResponse(conn, response{OK: true, Message: "subscribed"})
+
+	gone := make(chan struct{})
+	go func() {
+		_, _ = io.Copy(io.Discard, conn)
+		close(gone)
+	}()
+	enc := json.NewEncoder(conn)
+	for {
+		select {
+		case <-gone:
+			return
+		case ev, ok := <-events:
+			if !ok {
+				return
+			}
+			if err := enc.Encode(ev); err != nil {
+				return
+			}
+		}
+	}
+}
+
 func (s *Server) handleLogs(conn net.Conn) {
 	writeResponse(conn, response{OK: true, Logs: s.logs.List()})
 }
 
+func (s *Server) handleSSHStderr(conn net.Conn) {
+	writeResponse(conn, response{OK: true, Logs: s.client.SSHStderr()})
+}
+
 func (s *Server) handleStop(conn net.Conn) {
 	writeResponse(conn, response{OK: true, Message: "stopping"})
 	go s.client.RequestStop()
 }
 
+// handlePing answers at once without touching the client, so it measures only
+// the IPC path.
+func (s *Server) handlePing(conn net.Conn) {
+	writeResponse(conn, response{
+		OK:      true,
+		Message: "pong",
+		Data:    map[string]string{"server_unix_ms": fmt.Sprintf("%d", time.Now().UnixMilli())},
+	})
+}
+
+func (s *Server) handleReconnect(conn net.Conn) {
+	s.client.Reconnect()
+	writeResponse(conn, response{OK: true, Message: "reconnecting"})
+}
+
 func (s *Server) handleAddLocalForward(conn net.Conn, args map[string]string) {
 	forward := ""
 	if args != nil {
@@ -208,14 +392,18 @@ func (s *Server) handleAddLocalForward(conn net.Conn, args map[string]string) {
 	}
 	added := s.client.EnsureLocalForward(forward)
 	msg := "local forward already present"
+	data := map[string]string{"added": fmt.Sprintf("%t", added)}
 	if added {
 		msg = "local forward added"
-		s.client.RequestRestart("client_add")
+		data["applied"] = applyMethod(s.client.ApplyForward("forward", forward, "client_add"))
+		if data["applied"] == "live" {
+			msg = "local forward added to the running session"
+		}
 	}
 	writeResponse(conn, response{
 		OK:      true,
 		Message: msg,
-		Data:    map[string]string{"added": fmt.Sprintf("%t", added)},
+		Data:    data,
 	})
 }
 
@@ -230,13 +418,18 @@ func (s *Server) handleRemoveLocalForward(conn net.Conn, args map[string]string)
 		return
 	}
 	msg := "local forward not found"
+	data := map[string]string{"removed": fmt.Sprintf("%t", removed)}
 	if removed {
 		msg = "local forward removed"
+		data["applied"] = applyMethod(s.client.ApplyForward("cancel", strings.TrimSpace(forward), "local forward removed"))
+		if data["applied"] == "live" {
+			msg = "local forward removed from the running session"
+		}
 	}
 	writeResponse(conn, response{
 		OK:      true,
 		Message: msg,
-		Data:    map[string]string{"removed": fmt.Sprintf("%t", removed)},
+		Data:    data,
 	})
 }
 
@@ -245,6 +438,9 @@ func (s *Server) handleClearLocalForwards(conn net.Conn) {
 	msg := "no local forwards to clear"
 	if cleared {
 		msg = "local forwards cleared; stopping client"
+		if len(s.client.DynamicForwards()) > 0 {
+			msg = "local forwards cleared; restarting with dynamic forwards"
+		}
 	}
 	writeResponse(conn, response{
 		OK:      true,
@@ -253,7 +449,121 @@ func (s *Server) handleClearLocalForwards(conn net.Conn) {
 	})
 }
 
+func (s *Server) handleAddDynamicForward(conn net.Conn, args map[string]string) {
+	forward := ""
+	if args != nil {
+		forward = args["dynamic_forward"]
+	}
+	forward = strings.TrimSpace(forward)
+	if forward == "" {
+		writeResponse(conn, response{OK: false, Message: "dynamic_forward is required"})
+		return
+	}
+	if err := config.ValidateDynamicForwardSpec(forward); err != nil {
+		writeResponse(conn, response{OK: false, Message: err.Error()})
+		return
+	}
+	added := s.client.EnsureDynamicForward(forward)
+	msg := "dynamic forward already present"
+	data := map[string]string{"added": fmt.Sprintf("%t", added)}
+	if added {
+		msg = "dynamic forward added"
+		data["applied"] = applyMethod(s.client.ApplyDynamicForward("forward", forward, "client_add"))
+		if data["applied"] == "live" {
+			msg = "dynamic forward added to the running session"
+		}
+	}
+	writeResponse(conn, response{
+		OK:      true,
+		Message: msg,
+		Data:    data,
+	})
+}
+
+func (s *Server) handleRemoveDynamicForward(conn net.Conn, args map[string]string) {
+	forward := ""
+	if args != nil {
+		forward = args["dynamic_forward"]
+	}
+	removed, err := s.client.RemoveDynamicForward(forward)
+	if err != nil {
+		writeResponse(conn, response{OK: false, Message: err.Error()})
+		return
+	}
+	msg := "dynamic forward not found"
+	data := map[string]string{"removed": fmt.Sprintf("%t", removed)}
+	if removed {
+		msg = "dynamic forward removed"
+		data["applied"] = applyMethod(s.client.ApplyDynamicForward("cancel", strings.TrimSpace(forward), "dynamic forward removed"))
+		if data["applied"] == "live" {
+			msg = "dynamic forward removed from the running session"
+		}
+	}
+	writeResponse(conn, response{
+		OK:      true,
+		Message: msg,
+		Data:    data,
+	})
+}
+
+// applyMethod reports how a forward change reached ssh: "live" through the
+// ControlMaster socket or "restart".
+func applyMethod(live bool) string {
+	if live {
+		return "live"
+	}
+	return "restart"
+}
+
 func writeResponse(conn net.Conn, resp response) {
 	enc := json.NewEncoder(conn)
 	_ = enc.Encode(resp)
 }
+
+// formatCounts renders counts as "a=1 b=2", sorted by key.
+func formatCounts(counts map[string]int) string {
+	keys := make([]string, 0, len(counts))
+	for key := range counts {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	parts := make([]string, 0, len(keys))
+	for _, key := range keys {
+		parts = append(parts, fmt.Sprintf("%s=%d", key, counts[key]))
+	}
+	return strings.Join(parts, " ")
+}
+
+// handleMonitorIntervals applies any sleep_check_sec, sleep_gap_sec,
+// network_poll_sec or power_poll_sec args and reports the current settings.
+func (s *Server) handleMonitorIntervals(conn net.Conn, args map[string]string) {
+	changes := make(map[string]int, len(args))
+	for key, raw := range args {
+		sec, err := strconv.Atoi(strings.TrimSpace(raw))
+		if err != nil {
+			writeResponse(conn, response{OK: false, Message: fmt.Sprintf("%s must be a whole number of seconds (got %q)", key, raw)})
+			return
+		}
+		changes[key] = sec
+	}
+	cfg, err := s.client.SetMonitorIntervals(changes)
+	if err != nil {
+		writeResponse(conn, response{OK: false, Message: err.Error()})
+		return
+	}
+	msg := "monitor intervals"
+	if len(changes) > 0 {
+		msg = "monitor intervals updated; the config file is unchanged"
+	}
+	writeResponse(conn, response{
+		OK:      true,
+		Message: msg,
+		Data: map[string]string{
+			"sleep_check_sec":  strconv.Itoa(cfg.SleepCheckSec),
+			"sleep_gap_sec":    strconv.Itoa(cfg.SleepGapSec),
+			"network_poll_sec": strconv.Itoa(cfg.NetworkPollSec),
+			"power_poll_sec":   strconv.Itoa(cfg.PowerPollSec),
+			"monitors":         monitor.Info().String(),
+		},
+	})
+}
@@ -0,0 +1,402 @@
+package client
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+	"unicode"
+
+	"reverse-proxy-agent/pkg/config"
+)
+
+func TestBuildSSHCommandExitOnForwardFailure(t *testing.T) {
+	no := false
+	yes := true
+	cases := []struct {
+		name    string
+		setting *bool
+		options []string
+		want    []string
+	}{
+		{name: "on by default", want: []string{"ExitOnForwardFailure=yes"}},
+		{name: "explicitly on", setting: &yes, want: []string{"ExitOnForwardFailure=yes"}},
+		{name: "turned off", setting: &no, want: nil},
+		{name: "user option wins", options: []string{"exitonforwardfailure=no"}, want: []string{"exitonforwardfailure=no"}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := testSSHConfig(func(cfg *config.Config) {
+				cfg.SSH.ExitOnForwardFailure = tc.setting
+				cfg.SSH.Options = tc.options
+			})
+			cmd, err := buildSSHCommand(cfg, config.NormalizeLocalForwards(cfg), nil, nil)
+			if err != nil {
+				t.Fatalf("buildSSHCommand: %v", err)
+			}
+			got := sshOptions(cmd.Args, "ExitOnForwardFailure")
+			if strings.Join(got, ",") != strings.Join(tc.want, ",") {
+				t.Fatalf("ExitOnForwardFailure options = %v, want %v (argv %q)", got, tc.want, cmd.Args)
+			}
+		})
+	}
+}
+
+func TestBuildSSHCommandOptionOverrides(t *testing.T) {
+	cases := []struct {
+		name    string
+		options []string
+		disable bool
+		key     string
+		want    []string
+	}{
+		{name: "default host key policy", key: "StrictHostKeyChecking", want: []string{"StrictHostKeyChecking=accept-new"}},
+		{name: "user host key policy survives", options: []string{"StrictHostKeyChecking=ask"}, key: "StrictHostKeyChecking", want: []string{"StrictHostKeyChecking=ask"}},
+		{name: "key match ignores case", options: []string{"stricthostkeychecking=yes"}, key: "StrictHostKeyChecking", want: []string{"stricthostkeychecking=yes"}},
+		{name: "space separated value", options: []string{"ServerAliveInterval 10"}, key: "ServerAliveInterval", want: []string{"ServerAliveInterval 10"}},
+		{name: "user batch mode survives", options: []string{"BatchMode=no"}, key: "BatchMode", want: []string{"BatchMode=no"}},
+		{name: "defaults disabled", disable: true, key: "StrictHostKeyChecking", want: nil},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := testSSHConfig(func(cfg *config.Config) {
+				cfg.SSH.Options = tc.options
+				cfg.SSH.DisableDefaultOptions = tc.disable
+			})
+			cmd, err := buildSSHCommand(cfg, config.NormalizeLocalForwards(cfg), nil, nil)
+			if err != nil {
+				t.Fatalf("buildSSHCommand: %v", err)
+			}
+			got := sshOptions(cmd.Args, tc.key)
+			if strings.Join(got, ",") != strings.Join(tc.want, ",") {
+				t.Fatalf("%s options = %v, want %v (argv %q)", tc.key, got, tc.want, cmd.Args)
+			}
+		})
+	}
+}
+
+// testSSHConfig returns a valid client config. setup runs before
+// ApplyDefaults, the same order Load uses.
+func testSSHConfig(setup func(cfg *config.Config)) *config.Config {
+	cfg := &config.Config{}
+	cfg.SSH.User = "deploy"
+	cfg.SSH.Host = "bastion.example.com"
+	cfg.SSH.IdentityFile = "/keys/id_ed25519"
+	cfg.Client.LocalForwards = []config.Forward{{Spec: "5432:db.internal:5432"}}
+	if setup != nil {
+		setup(cfg)
+	}
+	config.ApplyDefaults(cfg)
+	return cfg
+}
+
+// sshOptions returns every -o value in argv whose key is key, in order.
+func sshOptions(argv []string, key string) []string {
+	var out []string
+	for i := 0; i+1 < len(argv); i++ {
+		if argv[i] != "-o" {
+			continue
+		}
+		k := strings.FieldsFunc(argv[i+1], func(r rune) bool { return r == '=' || unicode.IsSpace(r) })
+		if len(k) > 0 && strings.EqualFold(k[0], key) {
+			out = append(out, argv[i+1])
+		}
+	}
+	return out
+}
+
+func TestBuildSSHCommandLogLevel(t *testing.T) {
+	cases := []struct {
+		name    string
+		level   string
+		options []string
+		want    []string
+	}{
+		{name: "unset", want: nil},
+		{name: "verbose", level: "VERBOSE", want: []string{"LogLevel=VERBOSE"}},
+		{name: "lower case is upper cased", level: " debug1 ", want: []string{"LogLevel=DEBUG1"}},
+		{name: "user option wins", level: "DEBUG3", options: []string{"LogLevel=ERROR"}, want: []string{"LogLevel=ERROR"}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := testSSHConfig(func(cfg *config.Config) {
+				cfg.SSH.LogLevel = tc.level
+				cfg.SSH.Options = tc.options
+			})
+			cmd, err := buildSSHCommand(cfg, config.NormalizeLocalForwards(cfg), nil, nil)
+			if err != nil {
+				t.Fatalf("buildSSHCommand: %v", err)
+			}
+			got := sshOptions(cmd.Args, "LogLevel")
+			if strings.Join(got, ",") != strings.Join(tc.want, ",") {
+				t.Fatalf("LogLevel options = %v, want %v (argv %q)", got, tc.want, cmd.Args)
+			}
+		})
+	}
+}
+
+func TestBuildSSHCommandControlMaster(t *testing.T) {
+	cases := []struct {
+		name    string
+		enabled bool
+		want    []string
+	}{
+		{name: "off", want: nil},
+		{name: "on", enabled: true, want: []string{"ControlMaster=yes", "ControlPath=CTL"}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			home := t.TempDir()
+			config.SetHomeDir(home)
+			t.Cleanup(func() { config.SetHomeDir("") })
+			cfg := testSSHConfig(func(cfg *config.Config) { cfg.SSH.ControlMaster = tc.enabled })
+			cmd, err := buildSSHCommand(cfg, config.NormalizeLocalForwards(cfg), nil, nil)
+			if err != nil {
+				t.Fatalf("buildSSHCommand: %v", err)
+			}
+			got := append(sshOptions(cmd.Args, "ControlMaster"), sshOptions(cmd.Args, "ControlPath")...)
+			want := strings.ReplaceAll(strings.Join(tc.want, ","), "CTL", filepath.Join(home, "client.ssh.ctl"))
+			if strings.Join(got, ",") != want {
+				t.Fatalf("control options = %v, want %s (argv %q)", got, want, cmd.Args)
+			}
+		})
+	}
+}
+
+func TestBuildControlCommand(t *testing.T) {
+	cases := []struct {
+		name    string
+		enabled bool
+		op      string
+		flag    string
+		forward string
+		want    string
+		wantErr string
+	}{
+		{name: "off", op: "forward", flag: "-L", forward: "6379:cache:6379", wantErr: "ssh.control_master is off"},
+		{name: "local forward", enabled: true, op: "forward", flag: "-L", forward: "6379:cache:6379", want: "ssh -S CTL -O forward -L 6379:cache:6379 -p 22 deploy@bastion.example.com"},
+		{name: "dynamic cancel", enabled: true, op: "cancel", flag: "-D", forward: "1080", want: "ssh -S CTL -O cancel -D 1080 -p 22 deploy@bastion.example.com"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			home := t.TempDir()
+			config.SetHomeDir(home)
+			t.Cleanup(func() { config.SetHomeDir("") })
+			cfg := testSSHConfig(func(cfg *config.Config) { cfg.SSH.ControlMaster = tc.enabled })
+			cmd, err := buildControlCommand(context.Background(), cfg, tc.op, tc.flag, tc.forward)
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("buildControlCommand error = %v, want %q", err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("buildControlCommand: %v", err)
+			}
+			want := strings.ReplaceAll(tc.want, "CTL", filepath.Join(home, "client.ssh.ctl"))
+			if got := strings.Join(cmd.Args, " "); got != want {
+				t.Fatalf("argv = %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestBuildSSHCommandRequestTTY(t *testing.T) {
+	cases := []struct {
+		name    string
+		tty     string
+		options []string
+		want    []string
+		wantT   bool
+	}{
+		{name: "default is no", want: []string{"RequestTTY=no"}, wantT: true},
+		{name: "explicit no", tty: "no", want: []string{"RequestTTY=no"}, wantT: true},
+		{name: "yes", tty: "Yes", want: []string{"RequestTTY=yes"}},
+		{name: "force", tty: " force ", want: []string{"RequestTTY=force"}},
+		{name: "user option wins", tty: "force", options: []string{"RequestTTY auto"}, want: []string{"RequestTTY auto"}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := testSSHConfig(func(cfg *config.Config) {
+				cfg.SSH.RequestTTY = tc.tty
+				cfg.SSH.Options = tc.options
+			})
+			cmd, err := buildSSHCommand(cfg, config.NormalizeLocalForwards(cfg), nil, nil)
+			if err != nil {
+				t.Fatalf("buildSSHCommand: %v", err)
+			}
+			got := sshOptions(cmd.Args, "RequestTTY")
+			if strings.Join(got, ",") != strings.Join(tc.want, ",") {
+				t.Fatalf("RequestTTY options = %v, want %v (argv %q)", got, tc.want, cmd.Args)
+			}
+			hasT := false
+			for _, arg := range cmd.Args {
+				hasT = hasT || arg == "-T"
+			}
+			if hasT != tc.wantT {
+				t.Fatalf("-T present = %v, want %v (argv %q)", hasT, tc.wantT, cmd.Args)
+			}
+		})
+	}
+}
+
+func TestBuildSSHCommandAgentForwarding(t *testing.T) {
+	cases := []struct {
+		name         string
+		forwardAgent bool
+		addKeys      string
+		options      []string
+		wantA        bool
+		wantAddKeys  []string
+	}{
+		{name: "off by default"},
+		{name: "forward agent", forwardAgent: true, wantA: true},
+		{name: "add keys", addKeys: " Confirm ", wantAddKeys: []string{"AddKeysToAgent=confirm"}},
+		{name: "add keys interval", addKeys: "1h30m", wantAddKeys: []string{"AddKeysToAgent=1h30m"}},
+		{name: "both", forwardAgent: true, addKeys: "yes", wantA: true, wantAddKeys: []string{"AddKeysToAgent=yes"}},
+		{name: "user option wins", addKeys: "yes", options: []string{"AddKeysToAgent=ask"}, wantAddKeys: []string{"AddKeysToAgent=ask"}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := testSSHConfig(func(cfg *config.Config) {
+				cfg.SSH.ForwardAgent = tc.forwardAgent
+				cfg.SSH.AddKeysToAgent = tc.addKeys
+				cfg.SSH.Options = tc.options
+			})
+			cmd, err := buildSSHCommand(cfg, config.NormalizeLocalForwards(cfg), nil, nil)
+			if err != nil {
+				t.Fatalf("buildSSHCommand: %v", err)
+			}
+			if got := sshOptions(cmd.Args, "AddKeysToAgent"); strings.Join(got, ",") != strings.Join(tc.wantAddKeys, ",") {
+				t.Fatalf("AddKeysToAgent options = %v, want %v (argv %q)", got, tc.wantAddKeys, cmd.Args)
+			}
+			flagAt, destAt := -1, -1
+			for i, arg := range cmd.Args {
+				switch arg {
+				case "-A":
+					flagAt = i
+				case "deploy@bastion.example.com":
+					destAt = i
+				}
+			}
+			if (flagAt >= 0) != tc.wantA {
+				t.Fatalf("-A present = %v, want %v (argv %q)", flagAt >= 0, tc.wantA, cmd.Args)
+			}
+			if flagAt > destAt {
+				t.Fatalf("-A comes after the destination (argv %q)", cmd.Args)
+			}
+		})
+	}
+}
+
+func TestBuildSSHCommandForwardMetadata(t *testing.T) {
+	cfg := testSSHConfig(func(cfg *config.Config) {
+		cfg.Client.LocalForwards = []config.Forward{
+			{Spec: "8080:localhost:80", Name: "web", Comment: "staging dashboard"},
+			{Spec: "9090:localhost:90"},
+		}
+	})
+	cmd, err := buildSSHCommand(cfg, config.NormalizeLocalForwards(cfg), nil, nil)
+	if err != nil {
+		t.Fatalf("buildSSHCommand: %v", err)
+	}
+	var specs []string
+	for i := 0; i+1 < len(cmd.Args); i++ {
+		if cmd.Args[i] == "-L" {
+			specs = append(specs, cmd.Args[i+1])
+		}
+	}
+	if got, want := strings.Join(specs, ","), "8080:localhost:80,9090:localhost:90"; got != want {
+		t.Fatalf("-L specs = %q, want %q (argv %q)", got, want, cmd.Args)
+	}
+	for _, arg := range cmd.Args {
+		if strings.Contains(arg, "web") || strings.Contains(arg, "staging") {
+			t.Fatalf("forward metadata leaked into argv %q", cmd.Args)
+		}
+	}
+}
+
+func TestBuildSSHCommandExtraArgs(t *testing.T) {
+	cases := []struct {
+		name  string
+		extra []string
+	}{
+		{name: "none"},
+		{name: "verbose", extra: []string{"-v"}},
+		{name: "option pair in order", extra: []string{"-v", "-o", "Foo=bar"}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := testSSHConfig(func(cfg *config.Config) {
+				cfg.SSH.Options = []string{"Compression=yes"}
+			})
+			cmd, err := buildSSHCommand(cfg, config.NormalizeLocalForwards(cfg), nil, tc.extra)
+			if err != nil {
+				t.Fatalf("buildSSHCommand: %v", err)
+			}
+			args := cmd.Args
+			if args[len(args)-1] != "deploy@bastion.example.com" {
+				t.Fatalf("argv %q does not end with the destination", args)
+			}
+			// Extras sit after every managed option, right before the destination.
+			tail := args[len(args)-1-len(tc.extra) : len(args)-1]
+			if strings.Join(tail, "\x00") != strings.Join(tc.extra, "\x00") {
+				t.Fatalf("argv %q, want %q right before the destination", args, tc.extra)
+			}
+			if !strings.Contains(strings.Join(args[:len(args)-1-len(tc.extra)], " "), "Compression=yes") {
+				t.Fatalf("managed options missing before the extras: %q", args)
+			}
+		})
+	}
+}
+
+func TestBuildSSHCommandDynamicForwards(t *testing.T) {
+	cases := []struct {
+		name        string
+		local       []config.Forward
+		dynamic     []string
+		wantLocal   string
+		wantDynamic string
+	}{
+		{name: "local only", local: []config.Forward{{Spec: "5432:db.internal:5432"}}, wantLocal: "5432:db.internal:5432"},
+		{name: "dynamic only", dynamic: []string{"127.0.0.1:1080"}, wantDynamic: "127.0.0.1:1080"},
+		{name: "both", local: []config.Forward{{Spec: "5432:db.internal:5432"}}, dynamic: []string{"1080", "[::1]:1081"}, wantLocal: "5432:db.internal:5432", wantDynamic: "1080,[::1]:1081"},
+		{name: "blank dynamic skipped", local: []config.Forward{{Spec: "5432:db.internal:5432"}}, dynamic: []string{" ", "1080"}, wantLocal: "5432:db.internal:5432", wantDynamic: "1080"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := testSSHConfig(func(cfg *config.Config) {
+				cfg.Client.LocalForwards = tc.local
+				cfg.Client.DynamicForwards = tc.dynamic
+			})
+			cmd, err := buildSSHCommand(cfg, config.NormalizeLocalForwards(cfg), tc.dynamic, nil)
+			if err != nil {
+				t.Fatalf("buildSSHCommand: %v", err)
+			}
+			if got := strings.Join(flagValues(cmd.Args, "-L"), ","); got != tc.wantLocal {
+				t.Fatalf("-L specs = %q, want %q (argv %q)", got, tc.wantLocal, cmd.Args)
+			}
+			if got := strings.Join(flagValues(cmd.Args, "-D"), ","); got != tc.wantDynamic {
+				t.Fatalf("-D specs = %q, want %q (argv %q)", got, tc.wantDynamic, cmd.Args)
+			}
+		})
+	}
+}
+
+func TestBuildSSHCommandRejectsBadDynamicForward(t *testing.T) {
+	cfg := testSSHConfig(func(cfg *config.Config) { cfg.Client.DynamicForwards = []string{"socks"} })
+	if _, err := buildSSHCommand(cfg, config.NormalizeLocalForwards(cfg), cfg.Client.DynamicForwards, nil); err == nil || !strings.Contains(err.Error(), "client.dynamic_forwards") {
+		t.Fatalf("buildSSHCommand error = %v, want the dynamic_forwards validation error", err)
+	}
+}
+
+// flagValues returns the value after every occurrence of flag in argv.
+func flagValues(argv []string, flag string) []string {
+	var out []string
+	for i := 0; i+1 < len(argv); i++ {
+		if argv[i] == flag {
+			out = append(out, argv[i+1])
+		}
+	}
+	return out
+}
@@ -0,0 +1,64 @@
+// Package supervisor fans out lifecycle events to IPC subscribers.
+// Publishing never blocks the loop: a subscriber that falls behind loses events.
+
+package supervisor
+
+import (
+	"sync"
+	"time"
+)
+
+const eventBuffer = 64
+
+// Event is one entry of the events stream, e.g. state_change or ssh_exited.
+type Event struct {
+	Type   string         `json:"type"`
+	Time   time.Time      `json:"time"`
+	Fields map[string]any `json:"fields,omitempty"`
+}
+
+type broker struct {
+	mu   sync.Mutex
+	next int
+	subs map[int]chan Event
+}
+
+func newBroker() *broker {
+	return &broker{subs: make(map[int]chan Event)}
+}
+
+func (b *broker) subscribe() (<-chan Event, func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	id := b.next
+	b.next++
+	ch := make(chan Event, eventBuffer)
+	b.subs[id] = ch
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			b.mu.Lock()
+			delete(b.subs, id)
+			b.mu.Unlock()
+			close(ch)
+		})
+	}
+}
+
+func (b *broker) publish(eventType string, fields map[string]any) {
+	ev := Event{Type: eventType, Time: time.Now().UTC(), Fields: fields}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, ch := range b.subs {
+		select {
+		case ch <- ev:
+		default:
+		}
+	}
+}
+
+// Subscribe returns a channel of lifecycle events and a cancel func that
+// must be called when the subscriber is done.
+func (r *Runner) Subscribe() (<-chan Event, func()) {
+	return r.events.subscribe()
+}
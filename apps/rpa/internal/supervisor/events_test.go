@@ -0,0 +1,88 @@
+package supervisor
+
+import (
+	"testing"
+	"time"
+
+	"reverse-proxy-agent/pkg/restart"
+	"reverse-proxy-agent/pkg/state"
+)
+
+func TestBroker(t *testing.T) {
+	b := newBroker()
+	first, cancelFirst := b.subscribe()
+	second, cancelSecond := b.subscribe()
+	defer cancelSecond()
+
+	b.publish("ssh_exited", map[string]any{"class": "auth"})
+	for name, ch := range map[string]<-chan Event{"first": first, "second": second} {
+		select {
+		case ev := <-ch:
+			if ev.Type != "ssh_exited" || ev.Fields["class"] != "auth" || ev.Time.IsZero() {
+				t.Fatalf("%s got %+v", name, ev)
+			}
+		default:
+			t.Fatalf("%s subscriber got nothing", name)
+		}
+	}
+
+	// Cancel closes the channel, is safe to repeat, and stops delivery.
+	cancelFirst()
+	cancelFirst()
+	if _, ok := <-first; ok {
+		t.Fatal("cancelled channel still open")
+	}
+	b.publish("paused", nil)
+	if ev := <-second; ev.Type != "paused" {
+		t.Fatalf("second got %+v after the first cancelled", ev)
+	}
+}
+
+func TestBrokerSlowSubscriber(t *testing.T) {
+	b := newBroker()
+	ch, cancel := b.subscribe()
+	defer cancel()
+
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < eventBuffer*3; i++ {
+			b.publish("state_change", map[string]any{"n": i})
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("publish blocked on a subscriber that never reads")
+	}
+	if got := len(ch); got != eventBuffer {
+		t.Fatalf("buffered %d events, want %d with the rest dropped", got, eventBuffer)
+	}
+	if ev := <-ch; ev.Fields["n"] != 0 {
+		t.Fatalf("first kept event = %+v, want the oldest", ev)
+	}
+}
+
+func TestRunnerPublishesTransitions(t *testing.T) {
+	r := New(restart.PolicyAlways, testBackoff())
+	events, cancel := r.Subscribe()
+	defer cancel()
+
+	connect(t, r)
+	r.Reconnect("wake")
+	want := []struct{ typ, key, value string }{
+		{"state_change", "to", state.StateConnecting.String()},
+		{"state_change", "to", state.StateConnected.String()},
+		{"restart_triggered", "reason", "wake"},
+	}
+	for _, w := range want {
+		select {
+		case ev := <-events:
+			if ev.Type != w.typ || ev.Fields[w.key] != w.value {
+				t.Fatalf("event = %+v, want %s with %s=%s", ev, w.typ, w.key, w.value)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("no %s event", w.typ)
+		}
+	}
+}
@@ -0,0 +1,187 @@
+// Package supervisor runs the hooks.on_connect and hooks.on_disconnect commands
+// and the hooks.notify_on_reconnect desktop notification.
+// Hooks run in the background with a timeout so a slow or failing hook never blocks the restart loop.
+
+package supervisor
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+
+	"reverse-proxy-agent/pkg/logging"
+)
+
+const defaultHookTimeout = 10 * time.Second
+
+// notifyMinDowntime is how long a tunnel must have been down before a
+// reconnect is worth a notification; short blips stay quiet.
+const notifyMinDowntime = time.Minute
+
+type hookSet struct {
+	kind         string
+	onConnect    string
+	onDisconnect string
+	timeout      time.Duration
+	notify       bool
+}
+
+func (r *Runner) setHooks(opts Options) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.hooks = hookSet{
+		kind:         opts.Kind,
+		onConnect:    strings.TrimSpace(opts.OnConnect),
+		onDisconnect: strings.TrimSpace(opts.OnDisconnect),
+		timeout:      time.Duration(opts.HookTimeoutMs) * time.Millisecond,
+		notify:       opts.NotifyOnReconnect,
+	}
+}
+
+// fireHook starts the named hook in the background. env is appended to the
+// process environment as RPA_* variables. RunWithLogger waits for running
+// hooks before it returns.
+func (r *Runner) fireHook(name string, env map[string]string) {
+	r.mu.Lock()
+	hooks := r.hooks
+	logger := r.logger
+	r.mu.Unlock()
+
+	command := hooks.onConnect
+	if name == "on_disconnect" {
+		command = hooks.onDisconnect
+	}
+	if command == "" {
+		return
+	}
+	timeout := hooks.timeout
+	if timeout <= 0 {
+		timeout = defaultHookTimeout
+	}
+	vars := []string{"RPA_HOOK=" + name, "RPA_KIND=" + hooks.kind}
+	for k, v := range env {
+		vars = append(vars, k+"="+v)
+	}
+	r.hookWG.Add(1)
+	go func() {
+		defer r.hookWG.Done()
+		runHook(logger, name, command, vars, timeout)
+	}()
+}
+
+func runHook(logger *logging.Logger, name, command string, env []string, timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	cmd := hookCommand(ctx, command)
+	cmd.Env = append(os.Environ(), env...)
+	started := time.Now()
+	out, err := cmd.CombinedOutput()
+	if logger == nil {
+		return
+	}
+	fields := map[string]any{
+		"hook":       name,
+		"elapsed_ms": time.Since(started).Milliseconds(),
+	}
+	if summary := strings.TrimSpace(string(out)); summary != "" {
+		if len(summary) > 200 {
+			summary = summary[:200] + "..."
+		}
+		fields["output"] = summary
+	}
+	switch {
+	case ctx.Err() == context.DeadlineExceeded:
+		fields["error"] = "timed out after " + timeout.String()
+		logger.Event("WARN", "hook_failed", fields)
+	case err != nil:
+		fields["error"] = err.Error()
+		logger.Event("WARN", "hook_failed", fields)
+	default:
+		logger.Event("INFO", "hook_ran", fields)
+	}
+}
+
+// hookCommand runs command through the shell. The timeout kills only the
+// shell, so WaitDelay stops a child that inherited its output pipe from
+// holding runHook past the deadline.
+func hookCommand(ctx context.Context, command string) *exec.Cmd {
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.CommandContext(ctx, "cmd", "/C", command)
+	} else {
+		cmd = exec.CommandContext(ctx, "/bin/sh", "-c", command)
+	}
+	cmd.WaitDelay = time.Second
+	return cmd
+}
+
+// notifyReconnect posts a desktop notification in the background when
+// hooks.notify_on_reconnect is set and the tunnel was down for at least
+// notifyMinDowntime. Failures are logged and otherwise ignored.
+func (r *Runner) notifyReconnect(downtime time.Duration) {
+	r.mu.Lock()
+	hooks := r.hooks
+	logger := r.logger
+	r.mu.Unlock()
+	if !hooks.notify || downtime < notifyMinDowntime {
+		return
+	}
+	message := fmt.Sprintf("%s reconnected after %s down", hooks.kind, downtime.Round(time.Second))
+	r.hookWG.Add(1)
+	go func() {
+		defer r.hookWG.Done()
+		runNotify(logger, "rpa", message, downtime)
+	}()
+}
+
+func runNotify(logger *logging.Logger, title, message string, downtime time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultHookTimeout)
+	defer cancel()
+
+	fields := map[string]any{"downtime_ms": downtime.Milliseconds()}
+	cmd := notifyCommand(ctx, title, message)
+	if cmd == nil {
+		fields["error"] = "notifications are only supported on darwin"
+		if logger != nil {
+			logger.Event("WARN", "notify_failed", fields)
+		}
+		return
+	}
+	out, err := cmd.CombinedOutput()
+	if logger == nil {
+		return
+	}
+	if err != nil {
+		fields["error"] = err.Error()
+		if summary := strings.TrimSpace(string(out)); summary != "" {
+			fields["output"] = summary
+		}
+		logger.Event("WARN", "notify_failed", fields)
+		return
+	}
+	logger.Event("INFO", "notify_sent", fields)
+}
+
+// notifyCommand builds the osascript call for a notification, or returns nil
+// where there is no notification center to post to. Tests swap it out.
+var notifyCommand = func(ctx context.Context, title, message string) *exec.Cmd {
+	if runtime.GOOS != "darwin" {
+		return nil
+	}
+	return exec.CommandContext(ctx, "osascript", "-e", notifyScript(title, message))
+}
+
+func notifyScript(title, message string) string {
+	return "display notification " + appleScriptString(message) + " with title " + appleScriptString(title)
+}
+
+func appleScriptString(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, `"`, `\"`)
+	return `"` + s + `"`
+}
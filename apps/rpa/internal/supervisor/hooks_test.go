@@ -0,0 +1,90 @@
+package supervisor
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"reverse-proxy-agent/pkg/restart"
+)
+
+func TestHookLifecycle(t *testing.T) {
+	cases := []struct {
+		name      string
+		script    string
+		successMs int
+		want      []string
+	}{
+		{name: "connect then disconnect", script: "sleep 0.3; exit 1", successMs: 50, want: []string{"on_connect agent", "on_disconnect agent unknown"}},
+		{name: "exit before success fires nothing", script: "exit 1", successMs: 200},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := New(restart.PolicyNever, testBackoff())
+			logger, _ := testLogger(t)
+			out := filepath.Join(t.TempDir(), "hooks")
+			record := `echo "$RPA_HOOK $RPA_KIND $RPA_EXIT_CLASS" >> ` + out
+			opts := Options{Kind: "agent", SuccessAfterMs: tc.successMs, OnConnect: record, OnDisconnect: record}
+			if err := runWithTimeout(t, r, logger, shellBuild(tc.script), opts, 10*time.Second); err != nil {
+				t.Fatalf("run returned %v", err)
+			}
+
+			// RunWithLogger waits for hooks, so every line is written by now.
+			data, _ := os.ReadFile(out)
+			var got []string
+			for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+				if line = strings.TrimSpace(line); line != "" {
+					got = append(got, line)
+				}
+			}
+			if strings.Join(got, "|") != strings.Join(tc.want, "|") {
+				t.Fatalf("hooks ran %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestRunHook(t *testing.T) {
+	cases := []struct {
+		name      string
+		command   string
+		timeout   time.Duration
+		wantEvent string
+		wantText  string
+	}{
+		{name: "success", command: "echo warmed", timeout: time.Second, wantEvent: "hook_ran", wantText: "warmed"},
+		{name: "env passed", command: `echo "$RPA_HOOK/$RPA_KIND"`, timeout: time.Second, wantEvent: "hook_ran", wantText: "on_connect/client"},
+		{name: "failure", command: "echo broken >&2; exit 3", timeout: time.Second, wantEvent: "hook_failed", wantText: "exit status 3"},
+		{name: "timeout", command: "sleep 5; true", timeout: 100 * time.Millisecond, wantEvent: "hook_failed", wantText: "timed out after 100ms"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			logger, ring := testLogger(t)
+			started := time.Now()
+			runHook(logger, "on_connect", tc.command, []string{"RPA_HOOK=on_connect", "RPA_KIND=client"}, tc.timeout)
+			if elapsed := time.Since(started); elapsed > 3*time.Second {
+				t.Fatalf("runHook took %s with a %s timeout", elapsed, tc.timeout)
+			}
+			if !ringHas(ring, tc.wantEvent) || !ringHas(ring, tc.wantText) {
+				t.Fatalf("log lacks %s with %q: %q", tc.wantEvent, tc.wantText, ring.List())
+			}
+		})
+	}
+}
+
+func TestFailingHookKeepsLoop(t *testing.T) {
+	r := New(restart.PolicyAlways, testBackoff())
+	logger, ring := testLogger(t)
+	opts := Options{Kind: "agent", SuccessAfterMs: 20, OnConnect: "exit 7", OnDisconnect: "exit 7"}
+	done := startRun(r, logger, shellBuild("sleep 0.1; exit 1"), opts)
+	waitFor(t, func() bool { return r.RestartCount() >= 2 })
+	r.RequestStop()
+	if err := waitRun(t, done, 10*time.Second); err != nil {
+		t.Fatalf("run returned %v", err)
+	}
+	if !ringHas(ring, "hook_failed") {
+		t.Fatalf("failing hook not logged: %q", ring.List())
+	}
+}
@@ -0,0 +1,76 @@
+package supervisor
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"reverse-proxy-agent/pkg/config"
+	"reverse-proxy-agent/pkg/monitor"
+	"reverse-proxy-agent/pkg/restart"
+)
+
+func TestSetMonitorIntervals(t *testing.T) {
+	// Retune whichever monitor polls in this build.
+	info := monitor.Info()
+	key, value := "", 0
+	switch {
+	case strings.Contains(info.Network, "polling"):
+		key, value = "network_poll_sec", 2
+	case strings.Contains(info.Power, "polling"):
+		key, value = "power_poll_sec", 20
+	default:
+		t.Skipf("no polling monitor in this build (%s)", info)
+	}
+
+	r := New(restart.PolicyNever, restart.NewBackoff(config.RestartConfig{MinDelayMs: 10, MaxDelayMs: 10, Factor: 1}))
+	if _, ok := r.MonitorIntervals(); ok {
+		t.Fatal("MonitorIntervals reported running before the run")
+	}
+	if _, err := r.SetMonitorIntervals(map[string]int{key: value}); err == nil || err.Error() != "monitors are not running" {
+		t.Fatalf("SetMonitorIntervals before the run = %v", err)
+	}
+
+	started := monitor.Config{SleepCheckSec: 5, SleepGapSec: 30, NetworkPollSec: 5, PowerPollSec: 10}
+	logger, ring := testLogger(t)
+	done := startRun(r, logger, shellBuild("exec sleep 30"), Options{MonitorConfig: started})
+	defer func() {
+		r.RequestStop()
+		if err := waitRun(t, done, 10*time.Second); err != nil {
+			t.Errorf("run returned %v", err)
+		}
+	}()
+	waitStarted(t, r, 1)
+
+	cases := []struct {
+		name    string
+		changes map[string]int
+		wantErr string
+		check   func(monitor.Config) bool
+	}{
+		{name: "no changes", check: func(cfg monitor.Config) bool { return cfg == started }},
+		{name: "bad value", changes: map[string]int{key: 0}, wantErr: key + " must be >= 1", check: func(cfg monitor.Config) bool { return cfg == started }},
+		{name: "retune", changes: map[string]int{key: value}, check: func(cfg monitor.Config) bool {
+			return cfg.NetworkPollSec == value || cfg.PowerPollSec == value
+		}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := r.SetMonitorIntervals(tc.changes)
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("SetMonitorIntervals = %v, want %q", err, tc.wantErr)
+				}
+			} else if err != nil {
+				t.Fatalf("SetMonitorIntervals: %v", err)
+			}
+			cfg, ok := r.MonitorIntervals()
+			if !ok || !tc.check(cfg) {
+				t.Fatalf("MonitorIntervals = %+v, %v", cfg, ok)
+			}
+		})
+	}
+	if !ringHas(ring, "monitor_intervals_set") {
+		t.Fatal("retune was not logged")
+	}
+}
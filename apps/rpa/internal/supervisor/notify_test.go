@@ -0,0 +1,126 @@
+package supervisor
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"reverse-proxy-agent/pkg/restart"
+)
+
+func TestNotifyScript(t *testing.T) {
+	cases := []struct {
+		title   string
+		message string
+		want    string
+	}{
+		{title: "rpa", message: "agent reconnected after 2m0s down", want: `display notification "agent reconnected after 2m0s down" with title "rpa"`},
+		{title: `say "hi"`, message: `back\slash`, want: `display notification "back\\slash" with title "say \"hi\""`},
+	}
+	for _, tc := range cases {
+		t.Run(tc.message, func(t *testing.T) {
+			if got := notifyScript(tc.title, tc.message); got != tc.want {
+				t.Fatalf("notifyScript = %s, want %s", got, tc.want)
+			}
+		})
+	}
+}
+
+// stubNotify replaces notifyCommand with one that runs script and records
+// each title and message it was asked to post.
+func stubNotify(t *testing.T, script string, unsupported bool) func() []string {
+	t.Helper()
+	var mu sync.Mutex
+	var calls []string
+	saved := notifyCommand
+	notifyCommand = func(ctx context.Context, title, message string) *exec.Cmd {
+		mu.Lock()
+		calls = append(calls, title+": "+message)
+		mu.Unlock()
+		if unsupported {
+			return nil
+		}
+		return exec.CommandContext(ctx, "sh", "-c", script)
+	}
+	t.Cleanup(func() { notifyCommand = saved })
+	return func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), calls...)
+	}
+}
+
+func TestNotifyReconnect(t *testing.T) {
+	cases := []struct {
+		name        string
+		notify      bool
+		downtime    time.Duration
+		script      string
+		unsupported bool
+		wantCalls   []string
+		wantEvent   string
+	}{
+		{name: "off", downtime: 5 * time.Minute},
+		{name: "short blip stays quiet", notify: true, downtime: 30 * time.Second},
+		{name: "first connect has no downtime", notify: true},
+		{name: "long downtime notifies", notify: true, downtime: 2*time.Minute + 400*time.Millisecond, script: "exit 0", wantCalls: []string{"rpa: agent reconnected after 2m0s down"}, wantEvent: `"notify_sent"`},
+		{name: "failure is logged", notify: true, downtime: time.Hour, script: "echo denied >&2; exit 1", wantCalls: []string{"rpa: agent reconnected after 1h0m0s down"}, wantEvent: `"output":"denied"`},
+		{name: "unsupported platform is logged", notify: true, downtime: time.Hour, unsupported: true, wantCalls: []string{"rpa: agent reconnected after 1h0m0s down"}, wantEvent: "only supported on darwin"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			calls := stubNotify(t, tc.script, tc.unsupported)
+			r := New(restart.PolicyNever, testBackoff())
+			logger, ring := testLogger(t)
+			r.mu.Lock()
+			r.logger = logger
+			r.mu.Unlock()
+			r.setHooks(Options{Kind: "agent", NotifyOnReconnect: tc.notify})
+
+			r.notifyReconnect(tc.downtime)
+			r.hookWG.Wait()
+			if got := calls(); !equalLines(got, tc.wantCalls) {
+				t.Fatalf("notifications = %q, want %q", got, tc.wantCalls)
+			}
+			if tc.wantEvent != "" && !ringHas(ring, tc.wantEvent) {
+				t.Fatalf("log lacks %s: %q", tc.wantEvent, ring.List())
+			}
+			if tc.wantCalls == nil && (ringHas(ring, "notify_sent") || ringHas(ring, "notify_failed")) {
+				t.Fatalf("skipped notification still logged: %q", ring.List())
+			}
+		})
+	}
+}
+
+func TestNotifyAfterDowntime(t *testing.T) {
+	calls := stubNotify(t, "exit 0", false)
+	r := New(restart.PolicyNever, testBackoff())
+	logger, ring := testLogger(t)
+	// As if the previous session dropped five minutes ago.
+	r.downSince = time.Now().Add(-5 * time.Minute)
+	marker := filepath.Join(t.TempDir(), "connected")
+	opts := Options{Kind: "client", SuccessAfterMs: 20, NotifyOnReconnect: true, OnConnect: "touch " + marker}
+	if err := runWithTimeout(t, r, logger, shellBuild("sleep 0.3; exit 1"), opts, 10*time.Second); err != nil {
+		t.Fatalf("run returned %v", err)
+	}
+	if _, err := os.Stat(marker); err != nil {
+		t.Fatalf("session never marked connected: %v", err)
+	}
+	got := calls()
+	if len(got) != 1 || !strings.HasPrefix(got[0], "rpa: client reconnected after 5m") {
+		t.Fatalf("notifications = %q, want one for the 5m downtime", got)
+	}
+	if !ringHas(ring, "notify_sent") {
+		t.Fatalf("log lacks notify_sent: %q", ring.List())
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.downSince.IsZero() {
+		t.Fatal("the disconnect did not start a new downtime")
+	}
+}
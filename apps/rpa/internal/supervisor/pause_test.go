@@ -0,0 +1,114 @@
+package supervisor
+
+import (
+	"os/exec"
+	"sync"
+	"testing"
+	"time"
+
+	"reverse-proxy-agent/pkg/config"
+	"reverse-proxy-agent/pkg/restart"
+	"reverse-proxy-agent/pkg/state"
+)
+
+func TestPauseResume(t *testing.T) {
+	cases := []struct {
+		name   string
+		script string
+		// exits marks a script that ends on its own, so the pause lands in
+		// the backoff sleep rather than on a live process.
+		exits bool
+	}{
+		{name: "pause stops a live session", script: "exec sleep 30"},
+		{name: "pause cuts the backoff sleep short", script: "exit 1", exits: true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := New(restart.PolicyAlways, restart.NewBackoff(config.RestartConfig{MinDelayMs: 60000, MaxDelayMs: 60000, Factor: 1}))
+			logger, ring := testLogger(t)
+			done := startRun(r, logger, shellBuild(tc.script), Options{})
+			defer func() {
+				r.RequestStop()
+				if err := waitRun(t, done, 10*time.Second); err != nil {
+					t.Errorf("run returned %v", err)
+				}
+			}()
+
+			if tc.exits {
+				waitFor(t, func() bool { return ringHas(ring, "restart_scheduled") })
+			} else {
+				waitStarted(t, r, 1)
+			}
+			if r.Resume("early") {
+				t.Fatal("Resume on a running runner reported true")
+			}
+			if !r.Pause("test") {
+				t.Fatal("first Pause reported false")
+			}
+			if r.Pause("again") {
+				t.Fatal("second Pause reported true")
+			}
+			waitFor(t, func() bool { return r.State() == state.StatePaused })
+			r.mu.Lock()
+			cmd := r.cmd
+			r.mu.Unlock()
+			if cmd != nil {
+				t.Fatal("ssh process still tracked while paused")
+			}
+			before := r.ConnectAttempts()
+			time.Sleep(50 * time.Millisecond)
+			if got := r.ConnectAttempts(); got != before || !r.Paused() {
+				t.Fatalf("paused runner started ssh: attempts %d -> %d", before, got)
+			}
+			if !ringHas(ring, "supervision_paused") {
+				t.Fatalf("no supervision_paused event in %q", ring.List())
+			}
+
+			if !r.Resume("test") {
+				t.Fatal("first Resume reported false")
+			}
+			if r.Resume("again") {
+				t.Fatal("second Resume reported true")
+			}
+			waitFor(t, func() bool { return r.ConnectAttempts() > before })
+			if !ringHas(ring, "supervision_resumed") {
+				t.Fatalf("no supervision_resumed event in %q", ring.List())
+			}
+		})
+	}
+}
+
+func TestPauseLeavesNoStaleWake(t *testing.T) {
+	// The first session runs until paused; every later one fails at once, so
+	// after the resume the loop must sit in its long backoff.
+	var mu sync.Mutex
+	builds := 0
+	build := func() (*exec.Cmd, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		builds++
+		if builds == 1 {
+			return exec.Command("sh", "-c", "exec sleep 30"), nil
+		}
+		return exec.Command("sh", "-c", "exit 1"), nil
+	}
+	r := New(restart.PolicyAlways, restart.NewBackoff(config.RestartConfig{MinDelayMs: 60000, MaxDelayMs: 60000, Factor: 1}))
+	logger, ring := testLogger(t)
+	done := startRun(r, logger, build, Options{})
+	defer func() {
+		r.RequestStop()
+		if err := waitRun(t, done, 10*time.Second); err != nil {
+			t.Errorf("run returned %v", err)
+		}
+	}()
+
+	waitStarted(t, r, 1)
+	r.Pause("test")
+	waitFor(t, func() bool { return r.State() == state.StatePaused })
+	r.Resume("test")
+	waitFor(t, func() bool { return ringHas(ring, "restart_scheduled") })
+	time.Sleep(100 * time.Millisecond)
+	if got := r.ConnectAttempts(); got != 2 {
+		t.Fatalf("connect attempts = %d, want 2: the backoff was cut short (%q)", got, ring.List())
+	}
+}
@@ -0,0 +1,49 @@
+// Package supervisor restarts ssh when its stderr matches restart.restart_on_stderr.
+// Some failures (a forward whose channel keeps failing to open) leave ssh running, so exit classification never sees them.
+
+package supervisor
+
+import (
+	"regexp"
+)
+
+type stderrRestart struct {
+	patterns   []*regexp.Regexp
+	debounceMs int
+}
+
+func (r *Runner) setStderrRestart(opts Options) {
+	var patterns []*regexp.Regexp
+	for _, raw := range opts.RestartOnStderr {
+		// Config validation already rejected bad patterns.
+		if re, err := regexp.Compile(raw); err == nil {
+			patterns = append(patterns, re)
+		}
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.stderrRestart = stderrRestart{patterns: patterns, debounceMs: opts.DebounceMs}
+}
+
+// checkStderrLine triggers a restart when line matches a configured pattern
+// and reports whether it did.
+func (r *Runner) checkStderrLine(line string) bool {
+	r.mu.Lock()
+	cfg := r.stderrRestart
+	logger := r.logger
+	r.mu.Unlock()
+	for _, re := range cfg.patterns {
+		if !re.MatchString(line) {
+			continue
+		}
+		if logger != nil {
+			logger.Event("WARN", "stderr_match", map[string]any{
+				"pattern": re.String(),
+				"line":    line,
+			})
+		}
+		r.triggerRestart(logger, "stderr match", cfg.debounceMs)
+		return true
+	}
+	return false
+}
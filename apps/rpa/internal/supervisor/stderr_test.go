@@ -0,0 +1,94 @@
+package supervisor
+
+import (
+	"testing"
+	"time"
+
+	"reverse-proxy-agent/pkg/restart"
+)
+
+func TestRestartOnStderr(t *testing.T) {
+	// The warning comes after a short pause so the runner is CONNECTED by
+	// then; restarts triggered before that are ignored.
+	const warn = "sleep 0.2; echo 'channel 3: open failed: connect failed: Connection refused' >&2; exec sleep 30"
+	cases := []struct {
+		name        string
+		patterns    []string
+		script      string
+		wantRestart bool
+	}{
+		{name: "substring match", patterns: []string{"open failed"}, script: warn, wantRestart: true},
+		{name: "regex match", patterns: []string{`^channel \d+: open failed`}, script: warn, wantRestart: true},
+		{name: "second pattern matches", patterns: []string{"remote port forwarding failed", "Connection refused$"}, script: warn, wantRestart: true},
+		{name: "no match", patterns: []string{"remote port forwarding failed"}, script: warn},
+		{name: "no patterns", script: warn},
+		{name: "stdout ignored", patterns: []string{"open failed"}, script: "sleep 0.2; echo 'channel 3: open failed'; exec sleep 30"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := New(restart.PolicyAlways, testBackoff())
+			logger, ring := testLogger(t)
+			done := startRun(r, logger, shellBuild(tc.script), Options{RestartOnStderr: tc.patterns})
+			defer func() {
+				r.RequestStop()
+				if err := waitRun(t, done, 10*time.Second); err != nil {
+					t.Errorf("run returned %v", err)
+				}
+			}()
+
+			waitStarted(t, r, 1)
+			if tc.wantRestart {
+				waitFor(t, func() bool { return r.ConnectAttempts() >= 2 })
+				if got := r.TriggerCounts()["stderr match"]; got < 1 {
+					t.Fatalf("stderr match triggers = %d, want at least 1", got)
+				}
+				if !ringHas(ring, "stderr_match") {
+					t.Fatalf("no stderr_match event in %q", ring.List())
+				}
+				return
+			}
+			time.Sleep(500 * time.Millisecond)
+			if got := r.ConnectAttempts(); got != 1 {
+				t.Fatalf("connect attempts = %d, want 1", got)
+			}
+			if got := r.TriggerCounts(); len(got) != 0 {
+				t.Fatalf("TriggerCounts = %v, want none", got)
+			}
+		})
+	}
+}
+
+func TestCheckStderrLine(t *testing.T) {
+	cases := []struct {
+		name     string
+		patterns []string
+		line     string
+		want     bool
+	}{
+		{name: "match", patterns: []string{"open failed"}, line: "channel 3: open failed", want: true},
+		{name: "no match", patterns: []string{"open failed"}, line: "debug1: channel 3: new"},
+		{name: "no patterns", line: "channel 3: open failed"},
+		{name: "anchored regex", patterns: []string{"^Warning:"}, line: "ssh: Warning: remote port forwarding failed"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := New(restart.PolicyAlways, testBackoff())
+			logger, _ := testLogger(t)
+			r.mu.Lock()
+			r.logger = logger
+			r.mu.Unlock()
+			r.setStderrRestart(Options{RestartOnStderr: tc.patterns})
+			connect(t, r)
+			if got := r.checkStderrLine(tc.line); got != tc.want {
+				t.Fatalf("checkStderrLine(%q) = %v, want %v", tc.line, got, tc.want)
+			}
+			want := 0
+			if tc.want {
+				want = 1
+			}
+			if got := r.TriggerCounts()["stderr match"]; got != want {
+				t.Fatalf("stderr match triggers = %d, want %d", got, want)
+			}
+		})
+	}
+}
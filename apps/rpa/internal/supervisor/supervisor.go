@@ -6,8 +6,10 @@ package supervisor
 import (
 	"bufio"
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"math/rand"
 	"net"
 	"os"
 	"os/exec"
@@ -16,6 +18,7 @@ import (
 	"syscall"
 	"time"
 
+	"reverse-proxy-agent/pkg/cron"
 	"reverse-proxy-agent/pkg/logging"
 	"reverse-proxy-agent/pkg/monitor"
 	"reverse-proxy-agent/pkg/restart"
@@ -29,10 +32,25 @@ type Options struct {
 	Summary            func() string
 	MonitorConfig      monitor.Config
 	PeriodicRestartSec int
-	DebounceMs         int
-	BuildInfo          map[string]any
-	TCPCheckSec        int
-	TCPCheckAddr       string
+	// PeriodicRestartCron, when set, schedules restarts in local time
+	// instead of every PeriodicRestartSec.
+	PeriodicRestartCron string
+	DebounceMs          int
+	SuccessAfterMs      int
+	RapidFailureLimit   int
+	BuildInfo           map[string]any
+	TCPCheckSec         int
+	TCPCheckAddr        func() string
+	ProbeRTT            bool
+	TCPCheckJitter      bool
+	TCPCheckFailures    int
+	TCPCheckRestart     bool
+	OnConnect           string
+	OnDisconnect        string
+	HookTimeoutMs       int
+	NotifyOnReconnect   bool
+	SSHStderrLines      int
+	RestartOnStderr     []string
 }
 
 type Runner struct {
@@ -46,6 +64,11 @@ type Runner struct {
 
 	stopCh   chan struct{}
 	stopOnce sync.Once
+	wakeCh   chan struct{}
+	resumeCh chan struct{}
+
+	reconnectPending bool
+	paused           bool
 
 	restartCount int
 	lastExit     string
@@ -55,77 +78,146 @@ type Runner struct {
 
 	errLines *sshutil.LineBuffer
 
-	lastSuccess time.Time
-	lastClass   string
-	lastTrigger time.Time
+	lastSuccess  time.Time
+	firstSuccess time.Time
+	firstSpawn   time.Time
+	successAfter time.Duration
+	lastClass    string
+	lastTrigger  time.Time
 
+	connectAttempts   int
 	startSuccessCount int
 	startFailureCount int
 	exitSuccessCount  int
 	exitFailureCount  int
 	lastTriggerReason string
-
-	tcpCheckStatus string
-	tcpCheckError  string
-	lastTCPCheck   time.Time
+	lastTriggerAt     time.Time
+	triggerCounts     map[string]int
+	exitClassCounts   map[string]int
+	recentFailures    *failureWindow
+	terminateAsked    bool
+
+	tcpCheckStatus   string
+	tcpCheckError    string
+	lastTCPCheck     time.Time
+	tcpCheckFailures int
+	probeRTT         *rttWindow
+
+	hooks         hookSet
+	monitors      *monitor.Intervals
+	stderrRestart stderrRestart
+	events        *broker
+	hookWG        sync.WaitGroup
+	sessionMarked bool
+	downSince     time.Time
+	rapidFailures int
 
 	stateWriter func(statefile.Snapshot)
+
+	// now stamps the first spawn and each success mark; tests replace it.
+	now func() time.Time
 }
 
 const successGracePeriod = 2 * time.Second
 const tcpCheckTimeout = 3 * time.Second
+const flapWindow = 60 * time.Second
+const rttSamples = 10
+const tcpCheckJitter = 0.2
+const periodicPoll = time.Minute
 
 func New(policy restart.Policy, backoff *restart.Backoff) *Runner {
-	return &Runner{
-		sm:             state.NewStateMachine(),
-		stopCh:         make(chan struct{}),
-		policy:         policy,
-		backoff:        backoff,
-		tcpCheckStatus: "unknown",
+	r := &Runner{
+		sm:              state.NewStateMachine(),
+		stopCh:          make(chan struct{}),
+		wakeCh:          make(chan struct{}, 1),
+		resumeCh:        make(chan struct{}, 1),
+		policy:          policy,
+		backoff:         backoff,
+		tcpCheckStatus:  "unknown",
+		recentFailures:  newFailureWindow(flapWindow),
+		triggerCounts:   map[string]int{},
+		exitClassCounts: map[string]int{},
+		probeRTT:        newRTTWindow(rttSamples),
+		events:          newBroker(),
+		now:             time.Now,
 	}
+	r.sm.OnChange(func(from, to state.State) {
+		r.events.publish("state_change", map[string]any{
+			"from": from.String(),
+			"to":   to.String(),
+		})
+	})
+	return r
 }
 
-func (r *Runner) Start(build func() (*exec.Cmd, error)) error {
-	if err := r.sm.Transition(state.StateConnecting); err != nil {
+// defaultStderrLines is used when Start is given a non-positive buffer size.
+const defaultStderrLines = 10
+
+// stderrWaitDelay is how long Wait keeps copying stderr after ssh exits.
+const stderrWaitDelay = 2 * time.Second
+
+// Start launches one ssh process, keeping the last stderrLines lines of its
+// stderr for exit classification and the ssh_stderr IPC command.
+func (r *Runner) Start(build func() (*exec.Cmd, error), stderrLines int) error {
+	if err := r.transition(state.StateConnecting); err != nil {
 		return err
 	}
+	r.mu.Lock()
+	r.connectAttempts++
+	r.mu.Unlock()
 
 	cmd, err := build()
 	if err != nil {
-		_ = r.sm.Transition(state.StateStopped)
+		_ = r.transition(state.StateStopped)
 		r.recordStartFailure()
 		return err
 	}
 
 	stdout, err := cmd.StdoutPipe()
 	if err != nil {
-		_ = r.sm.Transition(state.StateStopped)
+		_ = r.transition(state.StateStopped)
 		r.recordStartFailure()
 		return err
 	}
-	stderr, err := cmd.StderrPipe()
-	if err != nil {
-		_ = r.sm.Transition(state.StateStopped)
-		r.recordStartFailure()
-		return err
+	// stderr goes through an io.Pipe rather than StderrPipe: Wait closes a
+	// StderrPipe as soon as ssh exits, dropping lines drain has not read yet,
+	// while with a writer Wait returns only after exec copied all of it.
+	stderr, stderrW := io.Pipe()
+	cmd.Stderr = stderrW
+	if cmd.WaitDelay == 0 {
+		// Bounds Wait when a ProxyCommand child keeps stderr open.
+		cmd.WaitDelay = stderrWaitDelay
 	}
 
 	if err := cmd.Start(); err != nil {
-		_ = r.sm.Transition(state.StateStopped)
+		_ = stderrW.Close()
+		_ = r.transition(state.StateStopped)
 		r.recordStartFailure()
 		return err
 	}
 
+	if stderrLines <= 0 {
+		stderrLines = defaultStderrLines
+	}
+	errLines := sshutil.NewLineBuffer(stderrLines)
 	r.mu.Lock()
+	if r.firstSpawn.IsZero() {
+		r.firstSpawn = r.now()
+	}
 	r.cmd = cmd
 	r.waitDone = make(chan struct{})
 	r.waitErr = nil
-	r.errLines = sshutil.NewLineBuffer(10)
+	r.terminateAsked = false
+	r.errLines = errLines
 	waitDone := r.waitDone
 	r.mu.Unlock()
 
+	drained := make(chan struct{})
 	go func() {
 		err := cmd.Wait()
+		_ = stderrW.Close()
+		// Exit classification reads errLines once waitDone is closed.
+		<-drained
 		r.mu.Lock()
 		if r.cmd == cmd && r.waitDone == waitDone {
 			r.waitErr = err
@@ -134,10 +226,20 @@ func (r *Runner) Start(build func() (*exec.Cmd, error)) error {
 		close(waitDone)
 	}()
 
-	go drain(stdout, nil)
-	go drain(stderr, r.errLines)
+	go drain(stdout, nil, nil)
+	// Only the first match per process restarts; the rest of the output is
+	// still buffered.
+	matched := false
+	go func() {
+		defer close(drained)
+		drain(stderr, errLines, func(line string) {
+			if !matched {
+				matched = r.checkStderrLine(line)
+			}
+		})
+	}()
 
-	if err := r.sm.Transition(state.StateConnected); err != nil {
+	if err := r.transition(state.StateConnected); err != nil {
 		r.terminateProcess()
 		select {
 		case <-waitDone:
@@ -182,7 +284,7 @@ func (r *Runner) Stop() error {
 			}
 		}
 	}
-	if err := r.sm.Transition(state.StateStopped); err != nil {
+	if err := r.transition(state.StateStopped); err != nil {
 		return err
 	}
 	return nil
@@ -212,7 +314,15 @@ func (r *Runner) RunWithLogger(logger *logging.Logger, build func() (*exec.Cmd,
 
 	r.setLogger(logger)
 	defer r.setLogger(nil)
+	r.setStderrRestart(opts)
+	r.setSuccessAfter(time.Duration(opts.SuccessAfterMs) * time.Millisecond)
+	r.setHooks(opts)
+	defer r.hookWG.Wait()
 
+	intervals := monitor.NewIntervals(opts.MonitorConfig)
+	r.mu.Lock()
+	r.monitors = intervals
+	r.mu.Unlock()
 	monitorCtx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
@@ -220,29 +330,36 @@ func (r *Runner) RunWithLogger(logger *logging.Logger, build func() (*exec.Cmd,
 	eventWG.Add(1)
 	go func() {
 		defer eventWG.Done()
-		monitor.StartSleepMonitor(monitorCtx, opts.MonitorConfig, logger, func(reason string) {
+		monitor.StartSleepMonitor(monitorCtx, intervals, logger, func(reason string) {
 			r.triggerRestart(logger, reason, opts.DebounceMs)
 		})
 	}()
 	eventWG.Add(1)
 	go func() {
 		defer eventWG.Done()
-		monitor.StartNetworkMonitor(monitorCtx, opts.MonitorConfig, logger, func(reason string) {
+		monitor.StartNetworkMonitor(monitorCtx, intervals, logger, func(reason string) {
 			r.triggerRestart(logger, reason, opts.DebounceMs)
 		})
 	}()
-	if opts.TCPCheckSec > 0 && strings.TrimSpace(opts.TCPCheckAddr) != "" {
+	eventWG.Add(1)
+	go func() {
+		defer eventWG.Done()
+		monitor.StartPowerMonitor(monitorCtx, intervals, logger, func(reason string) {
+			r.triggerRestart(logger, reason, opts.DebounceMs)
+		})
+	}()
+	if opts.TCPCheckSec > 0 && opts.TCPCheckAddr != nil {
 		eventWG.Add(1)
 		go func() {
 			defer eventWG.Done()
-			r.tcpCheckLoop(monitorCtx, time.Duration(opts.TCPCheckSec)*time.Second, opts.TCPCheckAddr)
+			r.tcpCheckLoop(monitorCtx, logger, opts)
 		}()
 	}
 
 	var periodicStop chan struct{}
-	if opts.PeriodicRestartSec > 0 {
+	if next := periodicSchedule(logger, opts); next != nil {
 		periodicStop = make(chan struct{})
-		go r.periodicRestartLoop(logger, time.Duration(opts.PeriodicRestartSec)*time.Second, opts.DebounceMs, periodicStop)
+		go r.periodicRestartLoop(logger, next, opts.DebounceMs, periodicStop)
 	}
 	defer func() {
 		cancel()
@@ -265,7 +382,12 @@ func (r *Runner) RunWithLogger(logger *logging.Logger, build func() (*exec.Cmd,
 		default:
 		}
 
-		if err := r.Start(build); err != nil {
+		if r.Paused() {
+			r.waitResume()
+			continue
+		}
+
+		if err := r.Start(build, opts.SSHStderrLines); err != nil {
 			r.recordExit(fmt.Sprintf("start failed: %v", err))
 			r.setLastTriggerReason("start failed")
 			logger.Event("ERROR", "ssh_start_failed", map[string]any{
@@ -286,13 +408,18 @@ func (r *Runner) RunWithLogger(logger *logging.Logger, build func() (*exec.Cmd,
 		r.mu.Lock()
 		cmd := r.cmd
 		waitDone := r.waitDone
+		errLines := r.errLines
 		r.mu.Unlock()
+		// Pause may have landed between the check above and Start.
+		if r.Paused() {
+			r.terminateProcess()
+		}
 		if cmd == nil || waitDone == nil {
 			r.recordExit("ssh command not started")
 			logger.Event("ERROR", "ssh_start_failed", map[string]any{
 				"error": "ssh command not started",
 			})
-			_ = r.sm.Transition(state.StateStopped)
+			_ = r.transition(state.StateStopped)
 			time.Sleep(2 * time.Second)
 			continue
 		}
@@ -312,7 +439,7 @@ func (r *Runner) RunWithLogger(logger *logging.Logger, build func() (*exec.Cmd,
 		} else {
 			r.recordExitSuccess()
 		}
-		class := sshutil.ClassifyExit(r.errLines, exitCode, err)
+		class := sshutil.ClassifyExit(errLines, exitCode, err)
 		r.setLastClass(class)
 		exitMsg := sshutil.FormatExit(exitCode, err)
 		if class != "clean" {
@@ -320,7 +447,7 @@ func (r *Runner) RunWithLogger(logger *logging.Logger, build func() (*exec.Cmd,
 		}
 		r.recordExit(exitMsg)
 		if err != nil {
-			if summary := stderrSummary(r.errLines); summary != "" {
+			if summary := stderrSummary(errLines); summary != "" {
 				logger.Event("ERROR", "ssh_exited", map[string]any{
 					"exit":   exitMsg,
 					"class":  class,
@@ -339,22 +466,37 @@ func (r *Runner) RunWithLogger(logger *logging.Logger, build func() (*exec.Cmd,
 			})
 		}
 
-		_ = r.sm.Transition(state.StateStopped)
+		r.events.publish("ssh_exited", map[string]any{"exit": exitMsg, "class": class})
+		_ = r.transition(state.StateStopped)
 
 		r.mu.Lock()
 		r.cmd = nil
 		r.waitDone = nil
 		r.waitErr = nil
+		marked := r.sessionMarked
+		r.sessionMarked = false
+		if marked {
+			r.downSince = time.Now()
+		}
 		r.mu.Unlock()
+		if marked {
+			r.fireHook("on_disconnect", map[string]string{"RPA_EXIT_CLASS": class})
+		}
+		if r.Paused() {
+			continue
+		}
 
-		if !r.shouldRestart(exitCode, err, class) {
+		// A requested reconnect restarts whatever the policy, exit class, or
+		// failure count say; sleepWithBackoff consumes it and skips the delay.
+		reconnect := r.reconnectRequested()
+		if !reconnect && !r.shouldRestart(exitCode, err, class) {
 			logger.Event("INFO", "restart_policy_stop", map[string]any{
 				"policy": r.policy.Name(),
 				"class":  class,
 			})
 			return nil
 		}
-		if class == "auth" || class == "hostkey" {
+		if !reconnect && (class == "auth" || class == "hostkey") {
 			logger.Event("ERROR", "restart_policy_stop", map[string]any{
 				"policy": r.policy.Name(),
 				"class":  class,
@@ -362,6 +504,15 @@ func (r *Runner) RunWithLogger(logger *logging.Logger, build func() (*exec.Cmd,
 			})
 			return nil
 		}
+		if n := r.noteRapidFailure(marked, err, class); !reconnect && opts.RapidFailureLimit > 0 && n >= opts.RapidFailureLimit {
+			logger.Event("ERROR", "restart_policy_stop", map[string]any{
+				"policy":   r.policy.Name(),
+				"class":    class,
+				"reason":   "rapid_failure",
+				"failures": n,
+			})
+			return nil
+		}
 		if err == nil {
 			r.backoff.Reset()
 		}
@@ -382,12 +533,44 @@ func (r *Runner) shouldRestart(exitCode int, err error, class string) bool {
 	switch r.policy {
 	case restart.PolicyOnFailure:
 		return err != nil || exitCode != 0
+	case restart.PolicyNever:
+		return false
 	default:
 		return true
 	}
 }
 
+// noteRapidFailure counts consecutive failed runs that never reached the
+// success mark. Transient network classes and any successful session reset it;
+// an exit rpa asked for (restart trigger, reconnect) leaves it alone, as in
+// recordExitFailure.
+func (r *Runner) noteRapidFailure(marked bool, err error, class string) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	switch {
+	case r.terminateAsked:
+	case marked, err == nil:
+		r.rapidFailures = 0
+	case class == "network", class == "timeout", class == "dns", class == "refused":
+		r.rapidFailures = 0
+	default:
+		r.rapidFailures++
+	}
+	return r.rapidFailures
+}
+
 func (r *Runner) sleepWithBackoff(logger *logging.Logger) error {
+	if r.takeReconnect() {
+		select {
+		case <-r.wakeCh:
+		default:
+		}
+		r.backoff.Reset()
+		logger.Event("INFO", "restart_immediate", map[string]any{
+			"reason": "reconnect",
+		})
+		return nil
+	}
 	delay := r.backoff.Next()
 	if delay <= 0 {
 		return nil
@@ -401,6 +584,17 @@ func (r *Runner) sleepWithBackoff(logger *logging.Logger) error {
 	case <-r.stopCh:
 		logger.Event("INFO", "stop_during_backoff", nil)
 		return r.Stop()
+	case <-r.wakeCh:
+		reason := "reconnect"
+		if r.Paused() {
+			reason = "pause"
+		}
+		r.takeReconnect()
+		r.backoff.Reset()
+		logger.Event("INFO", "backoff_interrupted", map[string]any{
+			"reason": reason,
+		})
+		return nil
 	case <-timer.C:
 		return nil
 	}
@@ -415,6 +609,16 @@ func (r *Runner) RequestStop() {
 	})
 }
 
+// LogEvent writes an event through the run logger, if one is attached.
+func (r *Runner) LogEvent(level, event string, fields map[string]any) {
+	r.mu.Lock()
+	logger := r.logger
+	r.mu.Unlock()
+	if logger != nil {
+		logger.Event(level, event, fields)
+	}
+}
+
 func (r *Runner) RequestRestart(reason string, debounceMs int) {
 	r.mu.Lock()
 	logger := r.logger
@@ -422,6 +626,164 @@ func (r *Runner) RequestRestart(reason string, debounceMs int) {
 	r.triggerRestart(logger, reason, debounceMs)
 }
 
+// Reconnect forces an immediate reconnect attempt. Unlike RequestRestart it
+// ignores the debounce window, resets backoff, and cuts short any backoff sleep.
+func (r *Runner) Reconnect(reason string) {
+	r.mu.Lock()
+	r.lastTrigger = time.Now()
+	r.lastTriggerReason = reason
+	r.lastTriggerAt = r.lastTrigger
+	r.reconnectPending = true
+	logger := r.logger
+	writer := r.stateWriter
+	snap := r.snapshotLocked()
+	r.mu.Unlock()
+	r.writeSnapshot(writer, snap)
+
+	if logger != nil {
+		logger.Event("INFO", "reconnect_requested", map[string]any{
+			"reason": reason,
+		})
+	}
+	r.events.publish("restart_triggered", map[string]any{"reason": reason, "immediate": true})
+	select {
+	case r.wakeCh <- struct{}{}:
+	default:
+	}
+	r.terminateProcess()
+}
+
+// Pause stops the ssh process and keeps the loop from starting a new one until
+// Resume. The run loop itself, and so the launchd job, stays alive. It reports
+// false when the runner was already paused.
+func (r *Runner) Pause(reason string) bool {
+	r.mu.Lock()
+	if r.paused {
+		r.mu.Unlock()
+		return false
+	}
+	r.paused = true
+	logger := r.logger
+	r.mu.Unlock()
+
+	if logger != nil {
+		logger.Event("INFO", "supervision_paused", map[string]any{
+			"reason": reason,
+		})
+	}
+	r.events.publish("paused", map[string]any{"reason": reason})
+	select {
+	case r.wakeCh <- struct{}{}:
+	default:
+	}
+	r.terminateProcess()
+	return true
+}
+
+// Resume lets a paused loop start ssh again right away, with backoff reset.
+// It reports false when the runner was not paused.
+func (r *Runner) Resume(reason string) bool {
+	r.mu.Lock()
+	if !r.paused {
+		r.mu.Unlock()
+		return false
+	}
+	r.paused = false
+	logger := r.logger
+	r.mu.Unlock()
+
+	r.backoff.Reset()
+	if logger != nil {
+		logger.Event("INFO", "supervision_resumed", map[string]any{
+			"reason": reason,
+		})
+	}
+	r.events.publish("resumed", map[string]any{"reason": reason})
+	select {
+	case r.resumeCh <- struct{}{}:
+	default:
+	}
+	return true
+}
+
+func (r *Runner) Paused() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.paused
+}
+
+// MonitorIntervals returns the poll settings of the running monitors, or
+// false before RunWithLogger has started them.
+func (r *Runner) MonitorIntervals() (monitor.Config, bool) {
+	r.mu.Lock()
+	intervals := r.monitors
+	r.mu.Unlock()
+	if intervals == nil {
+		return monitor.Config{}, false
+	}
+	return intervals.Config(), true
+}
+
+// SetMonitorIntervals retunes the running monitors; the config file is left
+// alone, so the next start uses it again.
+func (r *Runner) SetMonitorIntervals(changes map[string]int) (monitor.Config, error) {
+	r.mu.Lock()
+	intervals := r.monitors
+	logger := r.logger
+	r.mu.Unlock()
+	if intervals == nil {
+		return monitor.Config{}, errors.New("monitors are not running")
+	}
+	cfg, err := intervals.Apply(changes)
+	if err != nil {
+		return cfg, err
+	}
+	if logger != nil && len(changes) > 0 {
+		fields := make(map[string]any, len(changes))
+		for key, sec := range changes {
+			fields[key] = sec
+		}
+		logger.Event("INFO", "monitor_intervals_set", fields)
+	}
+	return cfg, nil
+}
+
+// waitResume parks the loop in StatePaused until Resume or a stop request;
+// the loop handles the stop itself. A wake left by Pause (or anything else
+// while paused) is dropped so it cannot cut the next backoff short.
+func (r *Runner) waitResume() {
+	_ = r.transition(state.StatePaused)
+	for {
+		select {
+		case <-r.stopCh:
+			return
+		case <-r.resumeCh:
+			if !r.Paused() {
+				select {
+				case <-r.wakeCh:
+				default:
+				}
+				return
+			}
+		}
+	}
+}
+
+// reconnectRequested reports a pending Reconnect without consuming it.
+func (r *Runner) reconnectRequested() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.reconnectPending
+}
+
+func (r *Runner) takeReconnect() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	pending := r.reconnectPending
+	r.reconnectPending = false
+	return pending
+}
+
 func (r *Runner) triggerRestart(logger *logging.Logger, reason string, debounceMs int) {
 	if r.State() != state.StateConnected {
 		return
@@ -436,44 +798,86 @@ func (r *Runner) triggerRestart(logger *logging.Logger, reason string, debounceM
 		}
 		return
 	}
+	r.countTrigger(reason)
 	if logger != nil {
 		logger.Event("INFO", "restart_triggered", map[string]any{
 			"reason": reason,
 		})
 	}
+	r.events.publish("restart_triggered", map[string]any{"reason": reason})
 	r.terminateProcess()
 }
 
-func (r *Runner) periodicRestartLoop(logger *logging.Logger, interval time.Duration, debounceMs int, stop <-chan struct{}) {
-	if interval <= 0 {
-		return
-	}
-	ticker := time.NewTicker(interval)
-	defer ticker.Stop()
-
-	for {
+// sleepUntil waits for the wall clock to reach at, re-checking every
+// periodicPoll so a clock jump after sleep is noticed. It reports false when
+// stopped first.
+func (r *Runner) sleepUntil(at time.Time, stop <-chan struct{}) bool {
+	for time.Now().Before(at) {
+		timer := time.NewTimer(min(time.Until(at), periodicPoll))
 		select {
 		case <-stop:
-			return
+			timer.Stop()
+			return false
 		case <-r.stopCh:
+			timer.Stop()
+			return false
+		case <-timer.C:
+		}
+	}
+	return true
+}
+
+// periodicSchedule returns the next-restart function for opts, or nil when
+// periodic restarts are off.
+func periodicSchedule(logger *logging.Logger, opts Options) func(time.Time) time.Time {
+	if expr := strings.TrimSpace(opts.PeriodicRestartCron); expr != "" {
+		schedule, err := cron.Parse(expr)
+		if err != nil {
+			logger.Event("WARN", "periodic_restart_disabled", map[string]any{
+				"error": err.Error(),
+			})
+			return nil
+		}
+		return schedule.Next
+	}
+	if opts.PeriodicRestartSec <= 0 {
+		return nil
+	}
+	interval := time.Duration(opts.PeriodicRestartSec) * time.Second
+	return func(now time.Time) time.Time {
+		return now.Add(interval)
+	}
+}
+
+// periodicRestartLoop restarts ssh at each time next returns. Waits are
+// checked against the wall clock at least every periodicPoll, because timers
+// do not advance while the machine sleeps; a slot slept through is skipped
+// since the wake trigger already restarts ssh.
+func (r *Runner) periodicRestartLoop(logger *logging.Logger, next func(time.Time) time.Time, debounceMs int, stop <-chan struct{}) {
+	for {
+		at := next(time.Now())
+		if at.IsZero() || !r.sleepUntil(at, stop) {
 			return
-		case <-ticker.C:
-			if r.State() != state.StateConnected {
-				continue
-			}
-			if !r.allowTrigger(time.Duration(debounceMs) * time.Millisecond) {
-				logger.Event("INFO", "restart_skipped", map[string]any{
-					"reason": "periodic",
-					"detail": "debounced",
-				})
-				continue
-			}
-			r.setLastTriggerReason("periodic")
-			logger.Event("INFO", "restart_triggered", map[string]any{
+		}
+		if time.Since(at) > periodicPoll {
+			continue
+		}
+		if r.State() != state.StateConnected {
+			continue
+		}
+		if !r.allowTrigger(time.Duration(debounceMs) * time.Millisecond) {
+			logger.Event("INFO", "restart_skipped", map[string]any{
 				"reason": "periodic",
+				"detail": "debounced",
 			})
-			r.terminateProcess()
+			continue
 		}
+		r.setLastTriggerReason("periodic")
+		r.countTrigger("periodic")
+		logger.Event("INFO", "restart_triggered", map[string]any{
+			"reason": "periodic",
+		})
+		r.terminateProcess()
 	}
 }
 
@@ -494,6 +898,9 @@ func (r *Runner) allowTrigger(window time.Duration) bool {
 func (r *Runner) terminateProcess() {
 	r.mu.Lock()
 	cmd := r.cmd
+	if cmd != nil {
+		r.terminateAsked = true
+	}
 	r.mu.Unlock()
 	if cmd != nil && cmd.Process != nil {
 		_ = cmd.Process.Signal(syscall.SIGTERM)
@@ -504,6 +911,30 @@ func (r *Runner) State() state.State {
 	return r.sm.State()
 }
 
+// transition moves the state machine and logs rejected moves as
+// invalid_transition so they are not lost at call sites that ignore the error.
+func (r *Runner) transition(next state.State) error {
+	from := r.sm.State()
+	err := r.sm.Transition(next)
+	if err != nil {
+		r.mu.Lock()
+		logger := r.logger
+		r.mu.Unlock()
+		if logger != nil {
+			logger.Event("WARN", "invalid_transition", map[string]any{
+				"from": from.String(),
+				"to":   next.String(),
+			})
+		}
+	}
+	return err
+}
+
+// StateTransitions returns how many state changes happened since start.
+func (r *Runner) StateTransitions() int {
+	return r.sm.Transitions()
+}
+
 func (r *Runner) RestartCount() int {
 	r.mu.Lock()
 	defer r.mu.Unlock()
@@ -522,6 +953,24 @@ func (r *Runner) LastSuccess() time.Time {
 	return r.lastSuccess
 }
 
+// FirstSuccess returns when the first connection of this runner was marked successful.
+func (r *Runner) FirstSuccess() time.Time {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.firstSuccess
+}
+
+// StartupConnect returns how long after the first ssh spawn the first
+// connection was marked successful; ok is false until that happens.
+func (r *Runner) StartupConnect() (time.Duration, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.firstSuccess.IsZero() || r.firstSpawn.IsZero() {
+		return 0, false
+	}
+	return r.firstSuccess.Sub(r.firstSpawn), true
+}
+
 func (r *Runner) LastClass() string {
 	r.mu.Lock()
 	defer r.mu.Unlock()
@@ -534,12 +983,72 @@ func (r *Runner) LastTriggerReason() string {
 	return r.lastTriggerReason
 }
 
+// LastTriggerAt reports when the last trigger reason was recorded.
+func (r *Runner) LastTriggerAt() time.Time {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.lastTriggerAt
+}
+
+// SSHStderr returns the buffered ssh stderr lines from the current or most
+// recent run, oldest first.
+func (r *Runner) SSHStderr() []string {
+	r.mu.Lock()
+	lines := r.errLines
+	r.mu.Unlock()
+	if lines == nil {
+		return nil
+	}
+	return lines.Lines()
+}
+
+func (r *Runner) countTrigger(reason string) {
+	r.mu.Lock()
+	r.triggerCounts[reason]++
+	r.mu.Unlock()
+}
+
+// TriggerCounts returns how many restarts fired for each trigger reason.
+func (r *Runner) TriggerCounts() map[string]int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	out := make(map[string]int, len(r.triggerCounts))
+	for reason, count := range r.triggerCounts {
+		out[reason] = count
+	}
+	return out
+}
+
+// ExitClassCounts returns how many ssh exits fell into each exit class.
+func (r *Runner) ExitClassCounts() map[string]int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	out := make(map[string]int, len(r.exitClassCounts))
+	for class, count := range r.exitClassCounts {
+		out[class] = count
+	}
+	return out
+}
+
 func (r *Runner) TCPCheckStatus() (string, string, time.Time) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 	return r.tcpCheckStatus, r.tcpCheckError, r.lastTCPCheck
 }
 
+func (r *Runner) ConnectAttempts() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.connectAttempts
+}
+
+// FlapCount returns the number of start/exit failures within the last flap window.
+func (r *Runner) FlapCount() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.recentFailures.Count(time.Now())
+}
+
 func (r *Runner) StartSuccessCount() int {
 	r.mu.Lock()
 	defer r.mu.Unlock()
@@ -577,25 +1086,51 @@ func (r *Runner) recordExit(reason string) {
 	r.writeSnapshot(writer, snap)
 }
 
+func (r *Runner) setSuccessAfter(grace time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.successAfter = grace
+}
+
+// scheduleSuccessMark records a success once cmd has stayed connected for the
+// grace period (restart.success_after_ms, successGracePeriod when unset).
 func (r *Runner) scheduleSuccessMark(cmd *exec.Cmd) {
+	r.mu.Lock()
+	grace := r.successAfter
+	r.mu.Unlock()
+	if grace <= 0 {
+		grace = successGracePeriod
+	}
 	go func() {
-		time.Sleep(successGracePeriod)
+		time.Sleep(grace)
 		r.mu.Lock()
 		if r.cmd != cmd || r.sm.State() != state.StateConnected {
 			r.mu.Unlock()
 			return
 		}
-		r.lastSuccess = time.Now()
+		r.lastSuccess = r.now()
+		if r.firstSuccess.IsZero() {
+			r.firstSuccess = r.lastSuccess
+		}
+		r.sessionMarked = true
+		var downtime time.Duration
+		if !r.downSince.IsZero() {
+			downtime = r.lastSuccess.Sub(r.downSince)
+			r.downSince = time.Time{}
+		}
 		writer := r.stateWriter
 		snap := r.snapshotLocked()
 		r.mu.Unlock()
 		r.writeSnapshot(writer, snap)
+		r.fireHook("on_connect", nil)
+		r.notifyReconnect(downtime)
 	}()
 }
 
 func (r *Runner) setLastClass(class string) {
 	r.mu.Lock()
 	r.lastClass = class
+	r.exitClassCounts[class]++
 	writer := r.stateWriter
 	snap := r.snapshotLocked()
 	r.mu.Unlock()
@@ -605,45 +1140,122 @@ func (r *Runner) setLastClass(class string) {
 func (r *Runner) setLastTriggerReason(reason string) {
 	r.mu.Lock()
 	r.lastTriggerReason = reason
+	r.lastTriggerAt = time.Now()
 	writer := r.stateWriter
 	snap := r.snapshotLocked()
 	r.mu.Unlock()
 	r.writeSnapshot(writer, snap)
 }
 
-func (r *Runner) tcpCheckLoop(ctx context.Context, interval time.Duration, addr string) {
+func (r *Runner) tcpCheckLoop(ctx context.Context, logger *logging.Logger, opts Options) {
+	interval := time.Duration(opts.TCPCheckSec) * time.Second
 	if interval <= 0 {
 		return
 	}
-	ticker := time.NewTicker(interval)
-	defer ticker.Stop()
+	var rng *rand.Rand
+	if opts.TCPCheckJitter {
+		rng = rand.New(rand.NewSource(time.Now().UnixNano()))
+	}
+	timer := time.NewTimer(checkInterval(interval, rng))
+	defer timer.Stop()
 
 	for {
 		select {
 		case <-ctx.Done():
 			return
-		case <-ticker.C:
+		case <-timer.C:
 		}
+		timer.Reset(checkInterval(interval, rng))
 		if r.State() != state.StateConnected {
+			r.resetTCPCheckFailures()
+			continue
+		}
+		// The target follows forward changes made while running.
+		addr := strings.TrimSpace(opts.TCPCheckAddr())
+		if addr == "" {
+			r.resetTCPCheckFailures()
 			continue
 		}
-		r.recordTCPCheck(tcpCheck(addr))
+		start := time.Now()
+		err := tcpCheck(addr)
+		if failures, tripped := r.recordTCPCheck(err, opts.TCPCheckFailures); tripped {
+			logger.Event("WARN", "tcp_check_failed", map[string]any{
+				"addr":     addr,
+				"failures": failures,
+				"error":    err.Error(),
+			})
+			if opts.TCPCheckRestart {
+				r.triggerRestart(logger, "tcp check failed", opts.DebounceMs)
+			}
+		}
+		if opts.ProbeRTT && err == nil {
+			r.recordProbeRTT(time.Since(start))
+		}
 	}
 }
 
-func (r *Runner) recordTCPCheck(err error) {
+// checkInterval spreads base by ±tcpCheckJitter when rng is set, so many
+// instances probing one target do not stay in step.
+func checkInterval(base time.Duration, rng *rand.Rand) time.Duration {
+	if rng == nil {
+		return base
+	}
+	spread := (rng.Float64()*2 - 1) * tcpCheckJitter
+	return time.Duration(float64(base) * (1 + spread))
+}
+
+func (r *Runner) recordProbeRTT(rtt time.Duration) {
+	r.mu.Lock()
+	r.probeRTT.Add(rtt)
+	r.mu.Unlock()
+}
+
+// ProbeRTT returns the latest and rolling average connect round-trip time.
+// ok is false until at least one probe has succeeded.
+func (r *Runner) ProbeRTT() (last, avg time.Duration, ok bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.probeRTT.Stats()
+}
+
+// recordTCPCheck counts consecutive failures and only marks the check failed
+// once threshold is reached, so one dropped probe does not flip the status.
+// tripped is true on the check that reaches the threshold.
+func (r *Runner) recordTCPCheck(err error, threshold int) (failures int, tripped bool) {
+	if threshold < 1 {
+		threshold = 1
+	}
 	r.mu.Lock()
+	defer r.mu.Unlock()
 	r.lastTCPCheck = time.Now()
-	if err != nil {
-		r.tcpCheckStatus = "failed"
-		r.tcpCheckError = err.Error()
-	} else {
+	if err == nil {
 		r.tcpCheckStatus = "ok"
 		r.tcpCheckError = ""
+		r.tcpCheckFailures = 0
+		return 0, false
 	}
+	r.tcpCheckFailures++
+	r.tcpCheckError = err.Error()
+	if r.tcpCheckFailures >= threshold {
+		r.tcpCheckStatus = "failed"
+	}
+	return r.tcpCheckFailures, r.tcpCheckFailures == threshold
+}
+
+// resetTCPCheckFailures starts the count over for the next session.
+func (r *Runner) resetTCPCheckFailures() {
+	r.mu.Lock()
+	r.tcpCheckFailures = 0
 	r.mu.Unlock()
 }
 
+// TCPCheckFailures returns the current run of consecutive failed checks.
+func (r *Runner) TCPCheckFailures() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.tcpCheckFailures
+}
+
 func tcpCheck(addr string) error {
 	conn, err := net.DialTimeout("tcp", addr, tcpCheckTimeout)
 	if err != nil {
@@ -687,6 +1299,7 @@ func (r *Runner) recordStartFailure() {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 	r.startFailureCount++
+	r.recentFailures.Add(time.Now())
 }
 
 func (r *Runner) recordExitSuccess() {
@@ -699,6 +1312,9 @@ func (r *Runner) recordExitFailure() {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 	r.exitFailureCount++
+	if !r.terminateAsked {
+		r.recentFailures.Add(time.Now())
+	}
 }
 
 func (r *Runner) setLogger(logger *logging.Logger) {
@@ -707,13 +1323,77 @@ func (r *Runner) setLogger(logger *logging.Logger) {
 	r.logger = logger
 }
 
-func drain(r io.Reader, lines *sshutil.LineBuffer) {
+// failureWindow counts events that happened within a sliding time window.
+type failureWindow struct {
+	window time.Duration
+	events []time.Time
+}
+
+func newFailureWindow(window time.Duration) *failureWindow {
+	return &failureWindow{window: window}
+}
+
+func (w *failureWindow) Add(at time.Time) {
+	w.prune(at)
+	w.events = append(w.events, at)
+}
+
+func (w *failureWindow) Count(now time.Time) int {
+	w.prune(now)
+	return len(w.events)
+}
+
+func (w *failureWindow) prune(now time.Time) {
+	cutoff := now.Add(-w.window)
+	idx := 0
+	for idx < len(w.events) && !w.events[idx].After(cutoff) {
+		idx++
+	}
+	if idx > 0 {
+		w.events = append(w.events[:0], w.events[idx:]...)
+	}
+}
+
+// rttWindow keeps the most recent round-trip samples for a rolling average.
+type rttWindow struct {
+	size    int
+	samples []time.Duration
+}
+
+func newRTTWindow(size int) *rttWindow {
+	return &rttWindow{size: size}
+}
+
+func (w *rttWindow) Add(rtt time.Duration) {
+	w.samples = append(w.samples, rtt)
+	if len(w.samples) > w.size {
+		w.samples = append(w.samples[:0], w.samples[len(w.samples)-w.size:]...)
+	}
+}
+
+func (w *rttWindow) Stats() (last, avg time.Duration, ok bool) {
+	if len(w.samples) == 0 {
+		return 0, 0, false
+	}
+	var total time.Duration
+	for _, sample := range w.samples {
+		total += sample
+	}
+	return w.samples[len(w.samples)-1], total / time.Duration(len(w.samples)), true
+}
+
+func drain(r io.Reader, lines *sshutil.LineBuffer, onLine func(string)) {
 	scanner := bufio.NewScanner(r)
 	for scanner.Scan() {
 		if lines != nil {
 			lines.Add(scanner.Text())
 		}
+		if onLine != nil {
+			onLine(scanner.Text())
+		}
 	}
+	// Keep reading after an over-long line so ssh never blocks on a full pipe.
+	_, _ = io.Copy(io.Discard, r)
 }
 
 func stderrSummary(lines *sshutil.LineBuffer) string {
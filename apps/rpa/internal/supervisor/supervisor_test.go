@@ -0,0 +1,862 @@
+package supervisor
+
+import (
+	"errors"
+	"math/rand"
+	"net"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"reverse-proxy-agent/pkg/config"
+	"reverse-proxy-agent/pkg/logging"
+	"reverse-proxy-agent/pkg/restart"
+	"reverse-proxy-agent/pkg/state"
+)
+
+func TestNoteRapidFailure(t *testing.T) {
+	failed := errors.New("exit status 255")
+	type exit struct {
+		marked    bool
+		err       error
+		class     string
+		terminate bool
+	}
+	cases := []struct {
+		name  string
+		exits []exit
+		want  []int
+	}{
+		{
+			name:  "config errors count up",
+			exits: []exit{{err: failed, class: "unknown"}, {err: failed, class: "unknown"}, {err: failed, class: "forward_failed"}},
+			want:  []int{1, 2, 3},
+		},
+		{
+			name:  "network classes reset",
+			exits: []exit{{err: failed, class: "unknown"}, {err: failed, class: "network"}, {err: failed, class: "unknown"}},
+			want:  []int{1, 0, 1},
+		},
+		{
+			name:  "marked session resets",
+			exits: []exit{{err: failed, class: "unknown"}, {marked: true, err: failed, class: "unknown"}},
+			want:  []int{1, 0},
+		},
+		{
+			name:  "clean exit resets",
+			exits: []exit{{err: failed, class: "unknown"}, {class: "clean"}},
+			want:  []int{1, 0},
+		},
+		{
+			name:  "requested exits are skipped",
+			exits: []exit{{err: failed, class: "unknown"}, {err: failed, class: "unknown", terminate: true}, {err: failed, class: "unknown", terminate: true}, {err: failed, class: "unknown"}},
+			want:  []int{1, 1, 1, 2},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := New(restart.PolicyOnFailure, testBackoff())
+			for i, e := range tc.exits {
+				r.mu.Lock()
+				r.terminateAsked = e.terminate
+				r.mu.Unlock()
+				if got := r.noteRapidFailure(e.marked, e.err, e.class); got != tc.want[i] {
+					t.Fatalf("exit %d: count = %d, want %d", i, got, tc.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestRapidFailureStopsRun(t *testing.T) {
+	r := New(restart.PolicyOnFailure, testBackoff())
+	logger, ring := testLogger(t)
+	build := shellBuild("echo 'Bad configuration option: Foo' >&2; exit 255")
+
+	err := runWithTimeout(t, r, logger, build, Options{RapidFailureLimit: 3}, 10*time.Second)
+	if err != nil {
+		t.Fatalf("run returned %v", err)
+	}
+	if got := r.ConnectAttempts(); got != 3 {
+		t.Fatalf("connect attempts = %d, want 3", got)
+	}
+	if !ringHas(ring, `"reason":"rapid_failure"`) {
+		t.Fatalf("no rapid_failure stop in log: %q", ring.List())
+	}
+}
+
+func TestRapidFailureIgnoresRequestedExits(t *testing.T) {
+	r := New(restart.PolicyOnFailure, testBackoff())
+	logger, ring := testLogger(t)
+	done := startRun(r, logger, shellBuild("exec sleep 30"), Options{RapidFailureLimit: 2})
+
+	for i := 1; i <= 3; i++ {
+		waitStarted(t, r, i)
+		r.Reconnect("test")
+	}
+	waitStarted(t, r, 4)
+	r.RequestStop()
+	if err := waitRun(t, done, 10*time.Second); err != nil {
+		t.Fatalf("run returned %v", err)
+	}
+	if ringHas(ring, "rapid_failure") {
+		t.Fatalf("reconnects counted as rapid failures: %q", ring.List())
+	}
+}
+
+func testBackoff() *restart.Backoff {
+	return restart.NewBackoff(config.RestartConfig{MinDelayMs: 1, MaxDelayMs: 5, Factor: 2})
+}
+
+func testLogger(t *testing.T) (*logging.Logger, *logging.LogBuffer) {
+	t.Helper()
+	ring := logging.NewLogBuffer()
+	logger, err := logging.NewLoggerWithPath(filepath.Join(t.TempDir(), "test.log"), ring)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return logger, ring
+}
+
+func shellBuild(script string) func() (*exec.Cmd, error) {
+	return func() (*exec.Cmd, error) {
+		return exec.Command("sh", "-c", script), nil
+	}
+}
+
+func startRun(r *Runner, logger *logging.Logger, build func() (*exec.Cmd, error), opts Options) <-chan error {
+	if opts.Summary == nil {
+		opts.Summary = func() string { return "test" }
+	}
+	done := make(chan error, 1)
+	go func() { done <- r.RunWithLogger(logger, build, opts) }()
+	return done
+}
+
+func waitRun(t *testing.T, done <-chan error, timeout time.Duration) error {
+	t.Helper()
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(timeout):
+		t.Fatalf("run did not return within %s", timeout)
+		return nil
+	}
+}
+
+func runWithTimeout(t *testing.T, r *Runner, logger *logging.Logger, build func() (*exec.Cmd, error), opts Options, timeout time.Duration) error {
+	t.Helper()
+	return waitRun(t, startRun(r, logger, build, opts), timeout)
+}
+
+// waitStarted waits until the runner has a live process from its n-th start.
+func waitStarted(t *testing.T, r *Runner, n int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		r.mu.Lock()
+		ready := r.connectAttempts >= n && r.cmd != nil
+		r.mu.Unlock()
+		if ready {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("start %d did not happen", n)
+}
+
+func ringHas(ring *logging.LogBuffer, substr string) bool {
+	for _, line := range ring.List() {
+		if strings.Contains(line, substr) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestReconnectBypass(t *testing.T) {
+	cases := []struct {
+		name   string
+		policy restart.Policy
+		// backoff is the restart delay; a long one must be cut short.
+		backoff config.RestartConfig
+		script  string
+		// exits marks a script that ends on its own, so there is no live
+		// process to wait for.
+		exits bool
+		// prepare runs after the first start, before the reconnect.
+		prepare func(t *testing.T, r *Runner, ring *logging.LogBuffer)
+	}{
+		{
+			name:    "restart policy never still reconnects",
+			policy:  restart.PolicyNever,
+			backoff: config.RestartConfig{MinDelayMs: 1, MaxDelayMs: 1, Factor: 1},
+			script:  "exec sleep 30",
+		},
+		{
+			name:    "reconnect cuts the backoff sleep short",
+			policy:  restart.PolicyAlways,
+			backoff: config.RestartConfig{MinDelayMs: 60000, MaxDelayMs: 60000, Factor: 1},
+			script:  "exit 1",
+			exits:   true,
+			prepare: func(t *testing.T, r *Runner, ring *logging.LogBuffer) {
+				waitFor(t, func() bool { return ringHas(ring, "restart_scheduled") })
+			},
+		},
+		{
+			name:    "reconnect ignores the debounce window",
+			policy:  restart.PolicyAlways,
+			backoff: config.RestartConfig{MinDelayMs: 1, MaxDelayMs: 1, Factor: 1},
+			script:  "exec sleep 30",
+			prepare: func(t *testing.T, r *Runner, ring *logging.LogBuffer) {
+				r.Reconnect("first")
+				waitStarted(t, r, 2)
+				waitFor(t, func() bool { return r.State() == state.StateConnected })
+				r.RequestRestart("monitor", 60000)
+				if !ringHas(ring, "debounced") {
+					t.Fatalf("restart right after a reconnect was not debounced: %q", ring.List())
+				}
+			},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := New(tc.policy, restart.NewBackoff(tc.backoff))
+			logger, ring := testLogger(t)
+			done := startRun(r, logger, shellBuild(tc.script), Options{})
+			defer func() {
+				r.RequestStop()
+				if err := waitRun(t, done, 10*time.Second); err != nil {
+					t.Errorf("run returned %v", err)
+				}
+			}()
+
+			if tc.exits {
+				waitFor(t, func() bool { return r.ConnectAttempts() >= 1 })
+			} else {
+				waitStarted(t, r, 1)
+			}
+			if tc.prepare != nil {
+				tc.prepare(t, r, ring)
+			}
+			before := r.ConnectAttempts()
+			r.Reconnect("test")
+			waitFor(t, func() bool { return r.ConnectAttempts() > before })
+		})
+	}
+}
+
+// waitFor polls cond for a couple of seconds, far below any backoff or
+// debounce the tests use.
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met in time")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestRecordTCPCheck(t *testing.T) {
+	down := errors.New("connection refused")
+	type step struct {
+		err         error
+		wantStatus  string
+		wantTripped bool
+	}
+	cases := []struct {
+		name      string
+		threshold int
+		steps     []step
+	}{
+		{
+			name:      "default threshold fails at once",
+			threshold: 1,
+			steps:     []step{{err: down, wantStatus: "failed", wantTripped: true}, {err: down, wantStatus: "failed"}, {wantStatus: "ok"}},
+		},
+		{
+			name:      "unset threshold acts as one",
+			threshold: 0,
+			steps:     []step{{err: down, wantStatus: "failed", wantTripped: true}},
+		},
+		{
+			name:      "threshold waits for a run of failures",
+			threshold: 3,
+			steps: []step{
+				{err: down, wantStatus: "unknown"},
+				{err: down, wantStatus: "unknown"},
+				{err: down, wantStatus: "failed", wantTripped: true},
+				{err: down, wantStatus: "failed"},
+			},
+		},
+		{
+			name:      "a success starts the count over",
+			threshold: 2,
+			steps: []step{
+				{err: down, wantStatus: "unknown"},
+				{wantStatus: "ok"},
+				{err: down, wantStatus: "ok"},
+				{err: down, wantStatus: "failed", wantTripped: true},
+			},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := New(restart.PolicyAlways, testBackoff())
+			for i, s := range tc.steps {
+				_, tripped := r.recordTCPCheck(s.err, tc.threshold)
+				r.mu.Lock()
+				status := r.tcpCheckStatus
+				r.mu.Unlock()
+				if status != s.wantStatus || tripped != s.wantTripped {
+					t.Fatalf("step %d: status %q tripped %v, want %q %v", i, status, tripped, s.wantStatus, s.wantTripped)
+				}
+			}
+		})
+	}
+}
+
+func TestSuccessGrace(t *testing.T) {
+	cases := []struct {
+		name       string
+		script     string
+		wantMarked bool
+	}{
+		{name: "exit before the grace is not a success", script: "sleep 0.05; exit 1"},
+		{name: "staying past the grace is a success", script: "exec sleep 30", wantMarked: true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := New(restart.PolicyNever, testBackoff())
+			logger, _ := testLogger(t)
+			done := startRun(r, logger, shellBuild(tc.script), Options{SuccessAfterMs: 200})
+			defer func() {
+				r.RequestStop()
+				if err := waitRun(t, done, 10*time.Second); err != nil {
+					t.Errorf("run returned %v", err)
+				}
+			}()
+
+			// Look well after the grace so a pending mark has had its chance.
+			time.Sleep(500 * time.Millisecond)
+			if marked := !r.FirstSuccess().IsZero(); marked != tc.wantMarked {
+				t.Fatalf("marked success = %v, want %v", marked, tc.wantMarked)
+			}
+		})
+	}
+}
+
+// TestRapidRestartStderr restarts ssh as fast as the backoff allows while
+// other goroutines read the stderr buffer, so `go test -race` catches an
+// exit being classified from a newer run's lines.
+func TestRapidRestartStderr(t *testing.T) {
+	r := New(restart.PolicyAlways, testBackoff())
+	logger, ring := testLogger(t)
+	build := shellBuild("echo 'ssh: connect to host bastion port 22: No route to host' >&2; exit 255")
+	done := startRun(r, logger, build, Options{SSHStderrLines: 2})
+
+	stop := make(chan struct{})
+	readers := make(chan struct{})
+	go func() {
+		defer close(readers)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				_ = r.SSHStderr()
+				_ = r.State()
+			}
+		}
+	}()
+	waitFor(t, func() bool { return r.ConnectAttempts() >= 20 })
+	close(stop)
+	<-readers
+	r.RequestStop()
+	if err := waitRun(t, done, 10*time.Second); err != nil {
+		t.Fatalf("run returned %v", err)
+	}
+	for _, line := range ring.List() {
+		if strings.Contains(line, `"ssh_exited"`) && !strings.Contains(line, `"class":"network"`) && !strings.Contains(line, "terminated") {
+			t.Fatalf("exit lost its own stderr: %s", line)
+		}
+	}
+}
+
+func TestInvalidTransitionLogged(t *testing.T) {
+	r := New(restart.PolicyAlways, testBackoff())
+	logger, ring := testLogger(t)
+	r.mu.Lock()
+	r.logger = logger
+	r.mu.Unlock()
+
+	// A stopped runner cannot jump straight to connected.
+	if err := r.transition(state.StateConnected); err == nil {
+		t.Fatal("STOPPED -> RUNNING was allowed")
+	}
+	if !ringHas(ring, `"event":"invalid_transition"`) || !ringHas(ring, `"from":"STOPPED"`) {
+		t.Fatalf("no invalid_transition event: %q", ring.List())
+	}
+	if got := r.StateTransitions(); got != 0 {
+		t.Fatalf("transitions = %d, want 0", got)
+	}
+}
+
+func TestFirstSuccessSetOnce(t *testing.T) {
+	r := New(restart.PolicyAlways, testBackoff())
+	logger, _ := testLogger(t)
+	done := startRun(r, logger, shellBuild("sleep 0.15; exit 1"), Options{SuccessAfterMs: 50})
+	defer func() {
+		r.RequestStop()
+		if err := waitRun(t, done, 10*time.Second); err != nil {
+			t.Errorf("run returned %v", err)
+		}
+	}()
+
+	waitFor(t, func() bool { return !r.FirstSuccess().IsZero() })
+	first := r.FirstSuccess()
+	// Later sessions move last_success but never the first success.
+	waitFor(t, func() bool { return r.LastSuccess().After(first) })
+	if got := r.FirstSuccess(); !got.Equal(first) {
+		t.Fatalf("first success moved from %s to %s", first, got)
+	}
+}
+
+// TestStartupConnectFromFirstSpawn drives the runner with a clock that moves
+// 1.5s per reading: the first spawn and the first success mark are two
+// readings apart, no matter when the process itself started.
+func TestStartupConnectFromFirstSpawn(t *testing.T) {
+	r := New(restart.PolicyAlways, testBackoff())
+	var mu sync.Mutex
+	clock := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	r.now = func() time.Time {
+		mu.Lock()
+		defer mu.Unlock()
+		clock = clock.Add(1500 * time.Millisecond)
+		return clock
+	}
+	if _, ok := r.StartupConnect(); ok {
+		t.Fatal("startup connect reported before any spawn")
+	}
+	logger, _ := testLogger(t)
+	done := startRun(r, logger, shellBuild("sleep 0.15; exit 1"), Options{SuccessAfterMs: 50})
+	defer func() {
+		r.RequestStop()
+		if err := waitRun(t, done, 10*time.Second); err != nil {
+			t.Errorf("run returned %v", err)
+		}
+	}()
+
+	waitFor(t, func() bool { _, ok := r.StartupConnect(); return ok })
+	if took, _ := r.StartupConnect(); took != 1500*time.Millisecond {
+		t.Fatalf("startup connect = %s, want 1.5s", took)
+	}
+	// Later success marks keep reading the clock, but the
+	// startup duration stays the one captured first.
+	first := r.LastSuccess()
+	waitFor(t, func() bool { return r.LastSuccess().After(first) })
+	if took, _ := r.StartupConnect(); took != 1500*time.Millisecond {
+		t.Fatalf("startup connect moved to %s", took)
+	}
+}
+
+func TestFailureWindow(t *testing.T) {
+	base := time.Date(2026, 5, 1, 12, 0, 0, 0, time.UTC)
+	at := func(sec int) time.Time { return base.Add(time.Duration(sec) * time.Second) }
+	cases := []struct {
+		name  string
+		adds  []int
+		count int
+		want  int
+	}{
+		{name: "empty", count: 0, want: 0},
+		{name: "all inside", adds: []int{0, 10, 59}, count: 59, want: 3},
+		{name: "oldest expires", adds: []int{0, 10, 59}, count: 61, want: 2},
+		{name: "exactly one window old is gone", adds: []int{0}, count: 60, want: 0},
+		{name: "all expired", adds: []int{0, 10, 20}, count: 200, want: 0},
+		{name: "adding prunes first", adds: []int{0, 5, 100}, count: 100, want: 1},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			w := newFailureWindow(flapWindow)
+			for _, sec := range tc.adds {
+				w.Add(at(sec))
+			}
+			if got := w.Count(at(tc.count)); got != tc.want {
+				t.Fatalf("Count = %d, want %d", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestRTTWindow(t *testing.T) {
+	ms := func(values ...int) []time.Duration {
+		out := make([]time.Duration, len(values))
+		for i, v := range values {
+			out[i] = time.Duration(v) * time.Millisecond
+		}
+		return out
+	}
+	cases := []struct {
+		name     string
+		size     int
+		samples  []time.Duration
+		wantLast time.Duration
+		wantAvg  time.Duration
+		wantOK   bool
+	}{
+		{name: "empty", size: 3},
+		{name: "one sample", size: 3, samples: ms(40), wantLast: 40 * time.Millisecond, wantAvg: 40 * time.Millisecond, wantOK: true},
+		{name: "below size", size: 3, samples: ms(10, 20), wantLast: 20 * time.Millisecond, wantAvg: 15 * time.Millisecond, wantOK: true},
+		{name: "at size", size: 3, samples: ms(10, 20, 60), wantLast: 60 * time.Millisecond, wantAvg: 30 * time.Millisecond, wantOK: true},
+		{name: "oldest dropped", size: 3, samples: ms(1000, 10, 20, 30), wantLast: 30 * time.Millisecond, wantAvg: 20 * time.Millisecond, wantOK: true},
+		{name: "long run keeps the newest", size: 2, samples: ms(5, 6, 7, 8, 9, 100, 200), wantLast: 200 * time.Millisecond, wantAvg: 150 * time.Millisecond, wantOK: true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			w := newRTTWindow(tc.size)
+			for _, sample := range tc.samples {
+				w.Add(sample)
+			}
+			last, avg, ok := w.Stats()
+			if last != tc.wantLast || avg != tc.wantAvg || ok != tc.wantOK {
+				t.Fatalf("Stats = %s, %s, %v, want %s, %s, %v", last, avg, ok, tc.wantLast, tc.wantAvg, tc.wantOK)
+			}
+			if len(w.samples) > tc.size {
+				t.Fatalf("window holds %d samples, want at most %d", len(w.samples), tc.size)
+			}
+		})
+	}
+}
+
+func TestProbeRTT(t *testing.T) {
+	r := New(restart.PolicyAlways, testBackoff())
+	if _, _, ok := r.ProbeRTT(); ok {
+		t.Fatal("ProbeRTT ok before any probe")
+	}
+	for i := 1; i <= rttSamples+2; i++ {
+		r.recordProbeRTT(time.Duration(i) * time.Millisecond)
+	}
+	last, avg, ok := r.ProbeRTT()
+	// Samples 3..12 remain; their mean is 7.5ms.
+	if !ok || last != 12*time.Millisecond || avg != 7500*time.Microsecond {
+		t.Fatalf("ProbeRTT = %s, %s, %v, want 12ms, 7.5ms, true", last, avg, ok)
+	}
+}
+
+func TestTriggerCounts(t *testing.T) {
+	type trigger struct {
+		reason   string
+		debounce int
+	}
+	cases := []struct {
+		name      string
+		connected bool
+		triggers  []trigger
+		want      map[string]int
+	}{
+		{name: "none", connected: true, want: map[string]int{}},
+		{
+			name:      "one per reason",
+			connected: true,
+			triggers:  []trigger{{reason: "sleep"}, {reason: "wake"}, {reason: "network change"}},
+			want:      map[string]int{"sleep": 1, "wake": 1, "network change": 1},
+		},
+		{
+			name:      "repeats add up",
+			connected: true,
+			triggers:  []trigger{{reason: "wake"}, {reason: "wake"}, {reason: "network change"}, {reason: "wake"}},
+			want:      map[string]int{"wake": 3, "network change": 1},
+		},
+		{
+			name:      "debounced triggers are not counted",
+			connected: true,
+			triggers:  []trigger{{reason: "network change", debounce: 60000}, {reason: "network change", debounce: 60000}, {reason: "wake", debounce: 60000}},
+			want:      map[string]int{"network change": 1},
+		},
+		{
+			name:     "ignored while not connected",
+			triggers: []trigger{{reason: "sleep"}, {reason: "wake"}},
+			want:     map[string]int{},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := New(restart.PolicyAlways, testBackoff())
+			logger, _ := testLogger(t)
+			if tc.connected {
+				connect(t, r)
+			}
+			for _, trig := range tc.triggers {
+				r.triggerRestart(logger, trig.reason, trig.debounce)
+			}
+			if got := r.TriggerCounts(); !equalCounts(got, tc.want) {
+				t.Fatalf("TriggerCounts = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestPeriodicTriggerCounted(t *testing.T) {
+	r := New(restart.PolicyAlways, testBackoff())
+	logger, _ := testLogger(t)
+	connect(t, r)
+	fired := 0
+	next := func(now time.Time) time.Time {
+		if fired == 3 {
+			return time.Time{}
+		}
+		fired++
+		return now.Add(5 * time.Millisecond)
+	}
+	r.periodicRestartLoop(logger, next, 0, make(chan struct{}))
+	if got := r.TriggerCounts(); !equalCounts(got, map[string]int{"periodic": 3}) {
+		t.Fatalf("TriggerCounts = %v, want periodic 3", got)
+	}
+	if got := r.LastTriggerReason(); got != "periodic" {
+		t.Fatalf("last trigger = %q, want periodic", got)
+	}
+}
+
+// connect moves r to CONNECTED without running a process.
+func connect(t *testing.T, r *Runner) {
+	t.Helper()
+	for _, next := range []state.State{state.StateConnecting, state.StateConnected} {
+		if err := r.transition(next); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func equalCounts(a, b map[string]int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if b[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+func TestLastTriggerAt(t *testing.T) {
+	cases := []struct {
+		name      string
+		connected bool
+		trigger   func(r *Runner, logger *logging.Logger)
+		wantSet   bool
+	}{
+		{name: "fresh runner", connected: true, trigger: func(*Runner, *logging.Logger) {}},
+		{name: "restart trigger", connected: true, trigger: func(r *Runner, logger *logging.Logger) { r.triggerRestart(logger, "wake", 0) }, wantSet: true},
+		{name: "debounced trigger still recorded", connected: true, trigger: func(r *Runner, logger *logging.Logger) {
+			r.triggerRestart(logger, "wake", 60000)
+			r.triggerRestart(logger, "network change", 60000)
+		}, wantSet: true},
+		{name: "trigger while not connected", trigger: func(r *Runner, logger *logging.Logger) { r.triggerRestart(logger, "wake", 0) }},
+		{name: "reconnect", trigger: func(r *Runner, _ *logging.Logger) { r.Reconnect("reconnect") }, wantSet: true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := New(restart.PolicyAlways, testBackoff())
+			logger, _ := testLogger(t)
+			if tc.connected {
+				connect(t, r)
+			}
+			before := time.Now()
+			tc.trigger(r, logger)
+			after := time.Now()
+
+			at := r.LastTriggerAt()
+			if !tc.wantSet {
+				if !at.IsZero() {
+					t.Fatalf("LastTriggerAt = %v, want zero", at)
+				}
+				return
+			}
+			if at.Before(before) || at.After(after) {
+				t.Fatalf("LastTriggerAt = %v, want between %v and %v", at, before, after)
+			}
+		})
+	}
+}
+
+func TestCheckInterval(t *testing.T) {
+	base := 10 * time.Second
+	cases := []struct {
+		name       string
+		rng        *rand.Rand
+		wantSpread bool
+	}{
+		{name: "jitter off", rng: nil},
+		{name: "jitter on", rng: rand.New(rand.NewSource(1)), wantSpread: true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			lo := time.Duration(float64(base) * (1 - tcpCheckJitter))
+			hi := time.Duration(float64(base) * (1 + tcpCheckJitter))
+			minSeen, maxSeen := hi, lo
+			for i := 0; i < 1000; i++ {
+				got := checkInterval(base, tc.rng)
+				if got < lo || got > hi {
+					t.Fatalf("interval %d = %s, want within [%s, %s]", i, got, lo, hi)
+				}
+				if !tc.wantSpread && got != base {
+					t.Fatalf("interval %d = %s, want exactly %s", i, got, base)
+				}
+				minSeen = min(minSeen, got)
+				maxSeen = max(maxSeen, got)
+			}
+			// Over many draws the spread should use most of the ±20% band.
+			if tc.wantSpread && (minSeen > 9*time.Second || maxSeen < 11*time.Second) {
+				t.Fatalf("intervals only spanned [%s, %s]", minSeen, maxSeen)
+			}
+		})
+	}
+}
+
+func TestSSHStderr(t *testing.T) {
+	cases := []struct {
+		name   string
+		script string
+		size   int
+		want   []string
+	}{
+		{name: "quiet ssh", script: "exit 1", size: 10, want: []string{}},
+		{name: "all lines kept", script: "echo 'debug1: Connecting to bastion' >&2; echo 'Permission denied (publickey).' >&2; exit 255", size: 10, want: []string{"debug1: Connecting to bastion", "Permission denied (publickey)."}},
+		{name: "oldest lines dropped", script: "for i in 1 2 3 4 5; do echo line$i >&2; done; exit 255", size: 3, want: []string{"line3", "line4", "line5"}},
+		{name: "stdout not captured", script: "echo banner; echo oops >&2; exit 1", size: 10, want: []string{"oops"}},
+		{name: "unset size uses the default", script: "for i in $(seq 1 15); do echo line$i >&2; done; exit 255", want: []string{"line6", "line7", "line8", "line9", "line10", "line11", "line12", "line13", "line14", "line15"}},
+		{name: "large size keeps a long banner", script: "for i in $(seq 1 15); do echo line$i >&2; done; exit 255", size: 40, want: []string{"line1", "line2", "line3", "line4", "line5", "line6", "line7", "line8", "line9", "line10", "line11", "line12", "line13", "line14", "line15"}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := New(restart.PolicyNever, testBackoff())
+			if got := r.SSHStderr(); got != nil {
+				t.Fatalf("SSHStderr before any run = %q, want nil", got)
+			}
+			logger, _ := testLogger(t)
+			if err := runWithTimeout(t, r, logger, shellBuild(tc.script), Options{SSHStderrLines: tc.size}, 10*time.Second); err != nil {
+				t.Fatalf("run returned %v", err)
+			}
+			if got := r.SSHStderr(); !equalLines(got, tc.want) {
+				t.Fatalf("SSHStderr = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func equalLines(a, b []string) bool {
+	return strings.Join(a, "\n") == strings.Join(b, "\n") && len(a) == len(b)
+}
+
+func TestExitClassCounts(t *testing.T) {
+	cases := []struct {
+		name   string
+		script string
+		want   map[string]int
+	}{
+		{name: "auth", script: "echo 'Permission denied (publickey).' >&2; exit 255", want: map[string]int{"auth": 1}},
+		{name: "dns", script: "echo 'ssh: Could not resolve hostname bastion: nodename nor servname provided' >&2; exit 255", want: map[string]int{"dns": 1}},
+		{name: "clean", script: "exit 0", want: map[string]int{"clean": 1}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := New(restart.PolicyNever, testBackoff())
+			if got := r.ExitClassCounts(); len(got) != 0 {
+				t.Fatalf("fresh ExitClassCounts = %v, want empty", got)
+			}
+			logger, _ := testLogger(t)
+			if err := runWithTimeout(t, r, logger, shellBuild(tc.script), Options{}, 10*time.Second); err != nil {
+				t.Fatalf("run returned %v", err)
+			}
+			got := r.ExitClassCounts()
+			if !equalCounts(got, tc.want) {
+				t.Fatalf("ExitClassCounts = %v, want %v", got, tc.want)
+			}
+			// The map is a copy; changing it leaves the runner alone.
+			got["auth"] = 99
+			if again := r.ExitClassCounts(); !equalCounts(again, tc.want) {
+				t.Fatalf("ExitClassCounts after mutating the copy = %v", again)
+			}
+		})
+	}
+}
+
+func TestExitClassCountsAccumulate(t *testing.T) {
+	r := New(restart.PolicyAlways, testBackoff())
+	logger, _ := testLogger(t)
+	done := startRun(r, logger, shellBuild("echo 'ssh: connect to host bastion port 22: Operation timed out' >&2; exit 255"), Options{})
+	waitFor(t, func() bool { return r.ExitClassCounts()["timeout"] >= 3 })
+	r.RequestStop()
+	if err := waitRun(t, done, 10*time.Second); err != nil {
+		t.Fatalf("run returned %v", err)
+	}
+	// The stop may kill one run before it writes its stderr line; that run
+	// counts as unknown.
+	for class, n := range r.ExitClassCounts() {
+		if class != "timeout" && (class != "unknown" || n > 1) {
+			t.Fatalf("ExitClassCounts = %v, want only timeout", r.ExitClassCounts())
+		}
+	}
+}
+
+func TestTCPCheckFollowsTarget(t *testing.T) {
+	live, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer live.Close()
+	dead, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	deadAddr := dead.Addr().String()
+	_ = dead.Close()
+
+	// The first check sees a removed forward; later ones see its replacement.
+	var mu sync.Mutex
+	targets := []string{deadAddr, live.Addr().String()}
+	calls := 0
+	target := func() string {
+		mu.Lock()
+		defer mu.Unlock()
+		addr := targets[min(calls, len(targets)-1)]
+		calls++
+		return addr
+	}
+
+	r := New(restart.PolicyAlways, testBackoff())
+	logger, ring := testLogger(t)
+	done := startRun(r, logger, shellBuild("exec sleep 30"), Options{
+		TCPCheckSec:      1,
+		TCPCheckAddr:     target,
+		TCPCheckFailures: 1,
+		ProbeRTT:         true,
+	})
+	defer func() {
+		r.RequestStop()
+		if err := waitRun(t, done, 10*time.Second); err != nil {
+			t.Errorf("run returned %v", err)
+		}
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, _, ok := r.ProbeRTT(); ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("no check reached the new target")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if !ringHas(ring, "tcp_check_failed") || !ringHas(ring, deadAddr) {
+		t.Fatalf("the first check did not fail against %s:\n%s", deadAddr, strings.Join(ring.List(), "\n"))
+	}
+}
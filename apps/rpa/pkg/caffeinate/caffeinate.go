@@ -0,0 +1,149 @@
+// Package caffeinate keeps a macOS caffeinate child running for prevent_sleep.
+// The Keeper relaunches caffeinate if it exits while the supervisor is still running.
+
+package caffeinate
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+
+	"reverse-proxy-agent/pkg/logging"
+)
+
+// relaunchDelay is the wait before each relaunch attempt; a Keeper copies it
+// at Start.
+var relaunchDelay = 5 * time.Second
+
+// command builds the caffeinate child, tied to this process with -w; tests
+// replace it with a stub.
+var command = func() *exec.Cmd {
+	return exec.Command("caffeinate", "-dimsu", "-w", fmt.Sprintf("%d", os.Getpid()))
+}
+
+type Keeper struct {
+	logger *logging.Logger
+	delay  time.Duration
+
+	mu       sync.Mutex
+	cmd      *exec.Cmd
+	active   bool
+	restarts int
+	stopped  bool
+	stopCh   chan struct{}
+}
+
+// Start launches caffeinate tied to this process and watches it. It returns
+// nil when caffeinate cannot be started.
+func Start(logger *logging.Logger) *Keeper {
+	k := &Keeper{logger: logger, delay: relaunchDelay, stopCh: make(chan struct{})}
+	cmd, ok := k.launch()
+	if !ok {
+		return nil
+	}
+	go k.watch(cmd)
+	return k
+}
+
+// launch starts one caffeinate child, or logs why it could not. A child that
+// starts after Stop is killed right away.
+func (k *Keeper) launch() (*exec.Cmd, bool) {
+	cmd := command()
+	if err := cmd.Start(); err != nil {
+		k.logger.Event("ERROR", "caffeinate_failed", map[string]any{
+			"error": err.Error(),
+		})
+		return nil, false
+	}
+	k.mu.Lock()
+	if k.stopped {
+		k.mu.Unlock()
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+		return nil, false
+	}
+	k.cmd = cmd
+	k.active = true
+	k.mu.Unlock()
+	k.logger.Event("INFO", "caffeinate_started", map[string]any{
+		"pid": fmt.Sprintf("%d", cmd.Process.Pid),
+	})
+	return cmd, true
+}
+
+// watch waits for cmd to exit and relaunches caffeinate, retrying every delay
+// until a launch succeeds or the keeper is stopped.
+func (k *Keeper) watch(cmd *exec.Cmd) {
+	for {
+		err := cmd.Wait()
+		k.mu.Lock()
+		k.active = false
+		stopped := k.stopped
+		k.mu.Unlock()
+		if stopped {
+			return
+		}
+		fields := map[string]any{"pid": fmt.Sprintf("%d", cmd.Process.Pid)}
+		if err != nil {
+			fields["error"] = err.Error()
+		}
+		k.logger.Event("WARN", "caffeinate_exited", fields)
+
+		for {
+			select {
+			case <-k.stopCh:
+				return
+			case <-time.After(k.delay):
+			}
+			next, ok := k.launch()
+			if ok {
+				k.mu.Lock()
+				k.restarts++
+				k.mu.Unlock()
+				cmd = next
+				break
+			}
+		}
+	}
+}
+
+// Active reports whether the caffeinate child is currently running.
+func (k *Keeper) Active() bool {
+	if k == nil {
+		return false
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return k.active
+}
+
+// Restarts counts caffeinate relaunches after an unexpected exit.
+func (k *Keeper) Restarts() int {
+	if k == nil {
+		return 0
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return k.restarts
+}
+
+// Stop ends the watch loop and terminates caffeinate.
+func (k *Keeper) Stop() {
+	if k == nil {
+		return
+	}
+	k.mu.Lock()
+	if k.stopped {
+		k.mu.Unlock()
+		return
+	}
+	k.stopped = true
+	close(k.stopCh)
+	cmd := k.cmd
+	k.mu.Unlock()
+	if cmd != nil && cmd.Process != nil {
+		_ = cmd.Process.Kill()
+	}
+}
@@ -0,0 +1,142 @@
+package caffeinate
+
+import (
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"reverse-proxy-agent/pkg/logging"
+)
+
+// stubCommands makes command hand out the given children in order, repeating
+// the last one, and returns a counter of calls.
+func stubCommands(t *testing.T, children ...func() *exec.Cmd) func() int {
+	t.Helper()
+	var mu sync.Mutex
+	calls := 0
+	oldCommand, oldDelay := command, relaunchDelay
+	command = func() *exec.Cmd {
+		mu.Lock()
+		defer mu.Unlock()
+		i := calls
+		if i >= len(children) {
+			i = len(children) - 1
+		}
+		calls++
+		return children[i]()
+	}
+	relaunchDelay = 10 * time.Millisecond
+	t.Cleanup(func() { command, relaunchDelay = oldCommand, oldDelay })
+	return func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return calls
+	}
+}
+
+func dies() *exec.Cmd   { return exec.Command("sh", "-c", "exit 1") }
+func lives() *exec.Cmd  { return exec.Command("sleep", "30") }
+func broken() *exec.Cmd { return exec.Command(filepath.Join("/nonexistent", "caffeinate")) }
+
+func TestKeeperRelaunch(t *testing.T) {
+	cases := []struct {
+		name         string
+		children     []func() *exec.Cmd
+		wantRestarts int
+		wantFailed   bool
+	}{
+		{name: "stays up", children: []func() *exec.Cmd{lives}},
+		{name: "relaunched after death", children: []func() *exec.Cmd{dies, lives}, wantRestarts: 1},
+		{name: "relaunched after repeated deaths", children: []func() *exec.Cmd{dies, dies, dies, lives}, wantRestarts: 3},
+		{name: "failed relaunch is retried", children: []func() *exec.Cmd{dies, broken, broken, lives}, wantRestarts: 1, wantFailed: true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			calls := stubCommands(t, tc.children...)
+			logger, ring := testLogger(t)
+			k := Start(logger)
+			if k == nil {
+				t.Fatal("Start returned nil")
+			}
+			defer k.Stop()
+
+			waitFor(t, func() bool { return calls() >= len(tc.children) && k.Active() })
+			if got := k.Restarts(); got != tc.wantRestarts {
+				t.Fatalf("restarts = %d, want %d", got, tc.wantRestarts)
+			}
+			if got := ringHas(ring, "caffeinate_failed"); got != tc.wantFailed {
+				t.Fatalf("caffeinate_failed logged = %v, want %v", got, tc.wantFailed)
+			}
+		})
+	}
+}
+
+func TestKeeperStartFails(t *testing.T) {
+	stubCommands(t, broken)
+	logger, _ := testLogger(t)
+	if k := Start(logger); k != nil {
+		k.Stop()
+		t.Fatal("Start returned a keeper for a command that cannot run")
+	}
+}
+
+func TestKeeperStopEndsRelaunch(t *testing.T) {
+	calls := stubCommands(t, lives)
+	logger, _ := testLogger(t)
+	k := Start(logger)
+	if k == nil {
+		t.Fatal("Start returned nil")
+	}
+	waitFor(t, k.Active)
+	k.Stop()
+	k.Stop()
+	waitFor(t, func() bool { return !k.Active() })
+	time.Sleep(5 * relaunchDelay)
+	if got := calls(); got != 1 {
+		t.Fatalf("command called %d times after Stop, want 1", got)
+	}
+	if k.Restarts() != 0 {
+		t.Fatalf("restarts = %d after Stop, want 0", k.Restarts())
+	}
+}
+
+func TestNilKeeper(t *testing.T) {
+	var k *Keeper
+	if k.Active() || k.Restarts() != 0 {
+		t.Fatal("nil keeper reports activity")
+	}
+	k.Stop()
+}
+
+func testLogger(t *testing.T) (*logging.Logger, *logging.LogBuffer) {
+	t.Helper()
+	ring := logging.NewLogBuffer()
+	logger, err := logging.NewLoggerWithPath(filepath.Join(t.TempDir(), "test.log"), ring)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return logger, ring
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met in time")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func ringHas(ring *logging.LogBuffer, substr string) bool {
+	for _, line := range ring.List() {
+		if strings.Contains(line, substr) {
+			return true
+		}
+	}
+	return false
+}
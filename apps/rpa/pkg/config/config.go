@@ -4,13 +4,23 @@
 package config
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
+	"net"
 	"os"
 	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
+	"unicode"
 
 	"gopkg.in/yaml.v3"
+
+	"reverse-proxy-agent/pkg/cron"
 )
 
 type Config struct {
@@ -19,45 +29,59 @@ type Config struct {
 	SSH           SSHConfig     `yaml:"ssh"`
 	Logging       LoggingConfig `yaml:"logging"`
 	ClientLogging LoggingConfig `yaml:"client_logging"`
+	IPC           IPCConfig     `yaml:"ipc"`
+	Hooks         HooksConfig   `yaml:"hooks"`
+	// Deprecations holds one note per renamed key Load migrated.
+	Deprecations []string `yaml:"-"`
 }
 
 type AgentConfig struct {
-	Name               string        `yaml:"name"`
-	LaunchdLabel       string        `yaml:"launchd_label"`
-	RestartPolicy      string        `yaml:"restart_policy"`
-	Restart            RestartConfig `yaml:"restart"`
-	PeriodicRestartSec int           `yaml:"periodic_restart_sec"`
-	SleepCheckSec      int           `yaml:"sleep_check_sec"`
-	SleepGapSec        int           `yaml:"sleep_gap_sec"`
-	NetworkPollSec     int           `yaml:"network_poll_sec"`
-	PreventSleep       bool          `yaml:"prevent_sleep"`
+	Name                string        `yaml:"name"`
+	LaunchdLabel        string        `yaml:"launchd_label"`
+	RestartPolicy       string        `yaml:"restart_policy"`
+	Restart             RestartConfig `yaml:"restart"`
+	PeriodicRestartSec  int           `yaml:"periodic_restart_sec"`
+	PeriodicRestartCron string        `yaml:"periodic_restart_cron,omitempty"`
+	SleepCheckSec       int           `yaml:"sleep_check_sec"`
+	SleepGapSec         int           `yaml:"sleep_gap_sec"`
+	NetworkPollSec      int           `yaml:"network_poll_sec"`
+	PowerPollSec        int           `yaml:"power_poll_sec"`
+	PreventSleep        bool          `yaml:"prevent_sleep"`
 }
 
 type ClientConfig struct {
-	Name               string        `yaml:"name"`
-	LaunchdLabel       string        `yaml:"launchd_label"`
-	RestartPolicy      string        `yaml:"restart_policy"`
-	Restart            RestartConfig `yaml:"restart"`
-	PeriodicRestartSec int           `yaml:"periodic_restart_sec"`
-	SleepCheckSec      int           `yaml:"sleep_check_sec"`
-	SleepGapSec        int           `yaml:"sleep_gap_sec"`
-	NetworkPollSec     int           `yaml:"network_poll_sec"`
-	LocalForwards      []string      `yaml:"local_forwards"`
-	PreventSleep       bool          `yaml:"prevent_sleep"`
+	Name                string        `yaml:"name"`
+	LaunchdLabel        string        `yaml:"launchd_label"`
+	RestartPolicy       string        `yaml:"restart_policy"`
+	Restart             RestartConfig `yaml:"restart"`
+	PeriodicRestartSec  int           `yaml:"periodic_restart_sec"`
+	PeriodicRestartCron string        `yaml:"periodic_restart_cron,omitempty"`
+	SleepCheckSec       int           `yaml:"sleep_check_sec"`
+	SleepGapSec         int           `yaml:"sleep_gap_sec"`
+	NetworkPollSec      int           `yaml:"network_poll_sec"`
+	PowerPollSec        int           `yaml:"power_poll_sec"`
+	LocalForwards       []Forward     `yaml:"local_forwards"`
+	DynamicForwards     []string      `yaml:"dynamic_forwards,omitempty"`
+	PreventSleep        bool          `yaml:"prevent_sleep"`
+	CheckLocalPorts     bool          `yaml:"check_local_ports,omitempty"`
 }
 
 type clientConfigRaw struct {
-	Name               string        `yaml:"name"`
-	LaunchdLabel       string        `yaml:"launchd_label"`
-	RestartPolicy      string        `yaml:"restart_policy"`
-	Restart            RestartConfig `yaml:"restart"`
-	PeriodicRestartSec int           `yaml:"periodic_restart_sec"`
-	SleepCheckSec      int           `yaml:"sleep_check_sec"`
-	SleepGapSec        int           `yaml:"sleep_gap_sec"`
-	NetworkPollSec     int           `yaml:"network_poll_sec"`
-	LocalForward       string        `yaml:"local_forward"`
-	LocalForwards      []string      `yaml:"local_forwards"`
-	PreventSleep       bool          `yaml:"prevent_sleep"`
+	Name                string        `yaml:"name"`
+	LaunchdLabel        string        `yaml:"launchd_label"`
+	RestartPolicy       string        `yaml:"restart_policy"`
+	Restart             RestartConfig `yaml:"restart"`
+	PeriodicRestartSec  int           `yaml:"periodic_restart_sec"`
+	PeriodicRestartCron string        `yaml:"periodic_restart_cron,omitempty"`
+	SleepCheckSec       int           `yaml:"sleep_check_sec"`
+	SleepGapSec         int           `yaml:"sleep_gap_sec"`
+	NetworkPollSec      int           `yaml:"network_poll_sec"`
+	PowerPollSec        int           `yaml:"power_poll_sec"`
+	LocalForward        string        `yaml:"local_forward"`
+	LocalForwards       []Forward     `yaml:"local_forwards"`
+	DynamicForwards     []string      `yaml:"dynamic_forwards,omitempty"`
+	PreventSleep        bool          `yaml:"prevent_sleep"`
+	CheckLocalPorts     bool          `yaml:"check_local_ports,omitempty"`
 }
 
 func (c *ClientConfig) UnmarshalYAML(value *yaml.Node) error {
@@ -66,62 +90,128 @@ func (c *ClientConfig) UnmarshalYAML(value *yaml.Node) error {
 		return err
 	}
 	*c = ClientConfig{
-		Name:               raw.Name,
-		LaunchdLabel:       raw.LaunchdLabel,
-		RestartPolicy:      raw.RestartPolicy,
-		Restart:            raw.Restart,
-		PeriodicRestartSec: raw.PeriodicRestartSec,
-		SleepCheckSec:      raw.SleepCheckSec,
-		SleepGapSec:        raw.SleepGapSec,
-		NetworkPollSec:     raw.NetworkPollSec,
-		LocalForwards:      mergeLocalForwards(raw.LocalForward, raw.LocalForwards),
-		PreventSleep:       raw.PreventSleep,
+		Name:                raw.Name,
+		LaunchdLabel:        raw.LaunchdLabel,
+		RestartPolicy:       raw.RestartPolicy,
+		Restart:             raw.Restart,
+		PeriodicRestartSec:  raw.PeriodicRestartSec,
+		PeriodicRestartCron: raw.PeriodicRestartCron,
+		SleepCheckSec:       raw.SleepCheckSec,
+		SleepGapSec:         raw.SleepGapSec,
+		NetworkPollSec:      raw.NetworkPollSec,
+		PowerPollSec:        raw.PowerPollSec,
+		LocalForwards:       mergeLocalForwards(raw.LocalForward, raw.LocalForwards),
+		DynamicForwards:     raw.DynamicForwards,
+		PreventSleep:        raw.PreventSleep,
+		CheckLocalPorts:     raw.CheckLocalPorts,
 	}
 	return nil
 }
 
 type SSHConfig struct {
-	User           string   `yaml:"user"`
-	Host           string   `yaml:"host"`
-	Port           int      `yaml:"port"`
-	RemoteForwards []string `yaml:"remote_forwards"`
-	IdentityFile   string   `yaml:"identity_file"`
-	Options        []string `yaml:"options"`
-	CheckSec       int      `yaml:"check_sec"`
+	User                  string            `yaml:"user"`
+	Host                  string            `yaml:"host"`
+	Port                  int               `yaml:"port"`
+	RemoteForwards        []Forward         `yaml:"remote_forwards"`
+	IdentityFile          string            `yaml:"identity_file"`
+	IdentityAgent         string            `yaml:"identity_agent,omitempty"`
+	Options               []string          `yaml:"options"`
+	SetEnv                map[string]string `yaml:"set_env,omitempty"`
+	TCPCheckSec           int               `yaml:"check_sec"`
+	CheckAddr             string            `yaml:"check_addr,omitempty"`
+	CheckLocalForward     bool              `yaml:"check_local_forward,omitempty"`
+	CheckJitter           bool              `yaml:"check_jitter,omitempty"`
+	TCPCheckFailures      int               `yaml:"tcp_check_failures"`
+	TCPCheckRestart       bool              `yaml:"tcp_check_restart,omitempty"`
+	ProbeRTT              bool              `yaml:"probe_rtt"`
+	ExitOnForwardFailure  *bool             `yaml:"exit_on_forward_failure,omitempty"`
+	LogLevel              string            `yaml:"log_level,omitempty"`
+	ControlMaster         bool              `yaml:"control_master,omitempty"`
+	DisableDefaultOptions bool              `yaml:"disable_default_options,omitempty"`
+	RequestTTY            string            `yaml:"request_tty,omitempty"`
+	ForwardAgent          bool              `yaml:"forward_agent,omitempty"`
+	AddKeysToAgent        string            `yaml:"add_keys_to_agent,omitempty"`
 }
 
 type LoggingConfig struct {
-	Level string `yaml:"level"`
-	Path  string `yaml:"path"`
+	Level          string `yaml:"level"`
+	Path           string `yaml:"path"`
+	Format         string `yaml:"format"`
+	TimeFormat     string `yaml:"time_format,omitempty"`
+	LocalTime      bool   `yaml:"local_time,omitempty"`
+	DedupeWindowMs int    `yaml:"dedupe_window_ms"`
+	SSHStderrLines int    `yaml:"ssh_stderr_lines"`
+}
+
+type IPCConfig struct {
+	SocketMode    string `yaml:"socket_mode"`
+	MaxConns      int    `yaml:"max_conns"`
+	ReadTimeoutMs int    `yaml:"read_timeout_ms"`
+}
+
+// HooksConfig holds local commands run around the tunnel lifecycle.
+type HooksConfig struct {
+	OnConnect    string `yaml:"on_connect,omitempty"`
+	OnDisconnect string `yaml:"on_disconnect,omitempty"`
+	TimeoutMs    int    `yaml:"timeout_ms"`
+	// NotifyOnReconnect posts a macOS notification when a connection
+	// succeeds after at least a minute of downtime.
+	NotifyOnReconnect bool `yaml:"notify_on_reconnect,omitempty"`
 }
 
 type RestartConfig struct {
-	MinDelayMs int     `yaml:"min_delay_ms"`
-	MaxDelayMs int     `yaml:"max_delay_ms"`
-	Factor     float64 `yaml:"factor"`
-	Jitter     float64 `yaml:"jitter"`
-	DebounceMs int     `yaml:"debounce_ms"`
+	MinDelayMs        int      `yaml:"min_delay_ms"`
+	MaxDelayMs        int      `yaml:"max_delay_ms"`
+	Factor            float64  `yaml:"factor"`
+	Jitter            float64  `yaml:"jitter"`
+	DebounceMs        int      `yaml:"debounce_ms"`
+	SuccessAfterMs    *int     `yaml:"success_after_ms,omitempty"`
+	RapidFailureLimit int      `yaml:"rapid_failure_limit"`
+	RestartOnStderr   []string `yaml:"restart_on_stderr,omitempty"`
 }
 
 func Load(path string) (*Config, error) {
+	return load(path, false)
+}
+
+// LoadStrict is Load but fails on keys that match no config field, so a typo
+// such as "agnet:" is reported instead of silently ignored.
+func LoadStrict(path string) (*Config, error) {
+	return load(path, true)
+}
+
+func load(path string, strict bool) (*Config, error) {
 	if path == "" {
 		return nil, errors.New("config path is empty")
 	}
 
-	data, err := os.ReadFile(path)
+	root, err := loadNode(path, map[string]bool{})
 	if err != nil {
-		return nil, fmt.Errorf("read config: %w", err)
+		return nil, err
+	}
+	notes := migrateKeys(root, renamedKeys)
+	if strict {
+		if unknown := unknownKeys(root, reflect.TypeOf(Config{}), ""); len(unknown) > 0 {
+			return nil, fmt.Errorf("unknown config keys: %s", strings.Join(unknown, ", "))
+		}
 	}
-
 	var cfg Config
-	if err := yaml.Unmarshal(data, &cfg); err != nil {
+	if err := root.Decode(&cfg); err != nil {
 		return nil, fmt.Errorf("parse yaml: %w", err)
 	}
+	cfg.Deprecations = notes
 
 	applyDefaults(&cfg)
 	return &cfg, nil
 }
 
+// normalizeYAML strips a UTF-8 BOM and converts CRLF line endings so files
+// edited on Windows parse the same as everywhere else.
+func normalizeYAML(data []byte) []byte {
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+	return bytes.ReplaceAll(data, []byte("\r\n"), []byte("\n"))
+}
+
 func ApplyDefaults(cfg *Config) {
 	if cfg == nil {
 		return
@@ -205,40 +295,110 @@ func applyDefaults(cfg *Config) {
 	if cfg.SSH.Port == 0 {
 		cfg.SSH.Port = 22
 	}
-	if cfg.SSH.CheckSec == 0 {
-		cfg.SSH.CheckSec = 5
+	if cfg.SSH.TCPCheckSec == 0 {
+		cfg.SSH.TCPCheckSec = 5
+	}
+	if cfg.SSH.TCPCheckFailures == 0 {
+		cfg.SSH.TCPCheckFailures = 1
 	}
 	if cfg.SSH.Options == nil {
 		cfg.SSH.Options = []string{}
 	}
-	ensureSSHOption(&cfg.SSH.Options, "ServerAliveInterval=30")
-	ensureSSHOption(&cfg.SSH.Options, "ServerAliveCountMax=3")
-	ensureSSHOption(&cfg.SSH.Options, "StrictHostKeyChecking=accept-new")
+	if !cfg.SSH.DisableDefaultOptions {
+		ensureSSHOption(&cfg.SSH.Options, "ServerAliveInterval=30")
+		ensureSSHOption(&cfg.SSH.Options, "ServerAliveCountMax=3")
+		ensureSSHOption(&cfg.SSH.Options, "StrictHostKeyChecking=accept-new")
+	}
 	if cfg.Logging.Level == "" {
 		cfg.Logging.Level = "info"
 	}
 	if cfg.Logging.Path == "" {
 		cfg.Logging.Path = "~/.rpa/logs/agent.log"
 	}
+	if cfg.Logging.Format == "" {
+		cfg.Logging.Format = "json"
+	}
 	if cfg.ClientLogging.Level == "" {
 		cfg.ClientLogging.Level = "info"
 	}
 	if cfg.ClientLogging.Path == "" {
 		cfg.ClientLogging.Path = "~/.rpa/logs/client.log"
 	}
+	if cfg.ClientLogging.Format == "" {
+		cfg.ClientLogging.Format = "json"
+	}
+	if cfg.Logging.SSHStderrLines == 0 {
+		cfg.Logging.SSHStderrLines = 10
+	}
+	if cfg.ClientLogging.SSHStderrLines == 0 {
+		cfg.ClientLogging.SSHStderrLines = 10
+	}
+	if cfg.IPC.SocketMode == "" {
+		cfg.IPC.SocketMode = "0600"
+	}
+	if cfg.IPC.MaxConns == 0 {
+		cfg.IPC.MaxConns = 16
+	}
+	if cfg.IPC.ReadTimeoutMs == 0 {
+		cfg.IPC.ReadTimeoutMs = 5000
+	}
+	if cfg.Hooks.TimeoutMs == 0 {
+		cfg.Hooks.TimeoutMs = 10000
+	}
 }
 
 func ensureSSHOption(options *[]string, value string) {
-	key := optionKey(value)
-	if key == "" {
+	if optionKey(value) == "" || HasSSHOption(*options, value) {
 		return
 	}
-	for _, opt := range *options {
-		if optionKey(opt) == key {
-			return
+	*options = append(*options, value)
+}
+
+// DefaultSuccessAfterMs is the success grace used when restart.success_after_ms
+// is not set.
+const DefaultSuccessAfterMs = 2000
+
+// SuccessAfterMs returns restart.success_after_ms or DefaultSuccessAfterMs.
+// The field is a pointer so an explicit 0 fails validation instead of
+// quietly turning into the default.
+func SuccessAfterMs(restart RestartConfig) int {
+	if restart.SuccessAfterMs == nil {
+		return DefaultSuccessAfterMs
+	}
+	return *restart.SuccessAfterMs
+}
+
+// ExitOnForwardFailure reports whether ssh should exit when a forward cannot
+// be bound, so the supervisor restarts it instead of running a broken tunnel.
+func ExitOnForwardFailure(cfg *Config) bool {
+	if cfg == nil || cfg.SSH.ExitOnForwardFailure == nil {
+		return true
+	}
+	return *cfg.SSH.ExitOnForwardFailure
+}
+
+// RequestTTY returns the RequestTTY mode for ssh. The supervised tunnel never
+// needs a terminal, so an unset ssh.request_tty means "no".
+func RequestTTY(cfg *Config) string {
+	if cfg == nil || strings.TrimSpace(cfg.SSH.RequestTTY) == "" {
+		return "no"
+	}
+	return strings.ToLower(strings.TrimSpace(cfg.SSH.RequestTTY))
+}
+
+// HasSSHOption reports whether options already sets the given ssh option key.
+// Keys are compared case-insensitively, matching ssh's own option parsing.
+func HasSSHOption(options []string, key string) bool {
+	want := optionKey(key)
+	if want == "" {
+		return false
+	}
+	for _, opt := range options {
+		if optionKey(opt) == want {
+			return true
 		}
 	}
-	*options = append(*options, value)
+	return false
 }
 
 func optionKey(value string) string {
@@ -246,7 +406,9 @@ func optionKey(value string) string {
 	if trimmed == "" {
 		return ""
 	}
-	if idx := strings.IndexAny(trimmed, " ="); idx >= 0 {
+	if idx := strings.IndexFunc(trimmed, func(r rune) bool {
+		return r == '=' || unicode.IsSpace(r)
+	}); idx >= 0 {
 		return strings.ToLower(trimmed[:idx])
 	}
 	return strings.ToLower(trimmed)
@@ -259,17 +421,79 @@ func ValidateAgent(cfg *Config) error {
 	if len(NormalizeRemoteForwards(cfg)) == 0 {
 		return errors.New("ssh.remote_forwards is required")
 	}
-	return validateSupervisor(cfg.Agent.RestartPolicy, cfg.Agent.Restart, cfg.Agent.PeriodicRestartSec, cfg.Agent.SleepCheckSec, cfg.Agent.SleepGapSec, cfg.Agent.NetworkPollSec, "agent")
+	if err := validateForwardAgent(cfg, NormalizeRemoteForwards(cfg), "ssh.remote_forwards"); err != nil {
+		return err
+	}
+	if err := validatePeriodicCron(cfg.Agent.PeriodicRestartCron, cfg.Agent.PeriodicRestartSec, "agent"); err != nil {
+		return err
+	}
+	return validateSupervisor(cfg.Agent.RestartPolicy, cfg.Agent.Restart, cfg.Agent.PeriodicRestartSec, cfg.Agent.SleepCheckSec, cfg.Agent.SleepGapSec, cfg.Agent.NetworkPollSec, cfg.Agent.PowerPollSec, "agent")
 }
 
 func ValidateClient(cfg *Config) error {
 	if err := validateCommon(cfg); err != nil {
 		return err
 	}
-	if len(NormalizeLocalForwards(cfg)) == 0 {
-		return errors.New("client.local_forwards is required")
+	dynamic := NormalizeDynamicForwards(cfg)
+	if len(NormalizeLocalForwards(cfg)) == 0 && len(dynamic) == 0 {
+		return errors.New("client.local_forwards or client.dynamic_forwards is required")
+	}
+	for _, spec := range dynamic {
+		if err := ValidateDynamicForwardSpec(spec); err != nil {
+			return fmt.Errorf("client.dynamic_forwards: %w", err)
+		}
+	}
+	if err := validateForwardAgent(cfg, NormalizeLocalForwards(cfg), "client.local_forwards"); err != nil {
+		return err
+	}
+	if err := validatePeriodicCron(cfg.Client.PeriodicRestartCron, cfg.Client.PeriodicRestartSec, "client"); err != nil {
+		return err
+	}
+	return validateSupervisor(cfg.Client.RestartPolicy, cfg.Client.Restart, cfg.Client.PeriodicRestartSec, cfg.Client.SleepCheckSec, cfg.Client.SleepGapSec, cfg.Client.NetworkPollSec, cfg.Client.PowerPollSec, "client")
+}
+
+// validateForwardAgent rejects ssh.forward_agent when a forward listens beyond
+// loopback: anyone who can reach that port rides a session where the server
+// can use your forwarded keys.
+func validateForwardAgent(cfg *Config, forwards []string, label string) error {
+	if !cfg.SSH.ForwardAgent {
+		return nil
+	}
+	for _, spec := range forwards {
+		forward, err := ParseForwardSpec(spec)
+		if err != nil || !forward.HasBind {
+			continue
+		}
+		switch strings.ToLower(forward.Bind) {
+		case "127.0.0.1", "localhost", "::1":
+			continue
+		}
+		return fmt.Errorf("ssh.forward_agent cannot be combined with the non-loopback bind %q in %s: while your keys are forwarded, the server (or anyone with root there) can use them, and the open bind widens who can reach that session; bind to 127.0.0.1 or disable forward_agent", spec, label)
+	}
+	return nil
+}
+
+// addKeysToAgentPattern matches the time-interval form of AddKeysToAgent
+// (e.g. 3600, 30m, 1h30m).
+var addKeysToAgentPattern = regexp.MustCompile(`^[0-9]+[smhdwSMHDW]?([0-9]+[smhdwSMHDW])*$`)
+
+func validAddKeysToAgent(value string) bool {
+	switch strings.ToLower(value) {
+	case "yes", "no", "ask", "confirm":
+		return true
 	}
-	return validateSupervisor(cfg.Client.RestartPolicy, cfg.Client.Restart, cfg.Client.PeriodicRestartSec, cfg.Client.SleepCheckSec, cfg.Client.SleepGapSec, cfg.Client.NetworkPollSec, "client")
+	return addKeysToAgentPattern.MatchString(value)
+}
+
+// sshRequestTTYModes are the values ssh accepts for -o RequestTTY.
+var sshRequestTTYModes = map[string]bool{
+	"no": true, "yes": true, "force": true, "auto": true,
+}
+
+// sshLogLevels are the values ssh accepts for -o LogLevel.
+var sshLogLevels = map[string]bool{
+	"QUIET": true, "FATAL": true, "ERROR": true, "INFO": true, "VERBOSE": true,
+	"DEBUG": true, "DEBUG1": true, "DEBUG2": true, "DEBUG3": true,
 }
 
 func validateCommon(cfg *Config) error {
@@ -285,17 +509,192 @@ func validateCommon(cfg *Config) error {
 	if cfg.SSH.Port <= 0 {
 		return fmt.Errorf("ssh.port must be > 0 (got %d)", cfg.SSH.Port)
 	}
-	if cfg.SSH.CheckSec < 0 {
-		return fmt.Errorf("ssh.check_sec must be >= 0 (got %d)", cfg.SSH.CheckSec)
+	if cfg.SSH.TCPCheckSec < 0 {
+		return fmt.Errorf("ssh.check_sec must be >= 0 (got %d)", cfg.SSH.TCPCheckSec)
+	}
+	if cfg.SSH.TCPCheckFailures <= 0 {
+		return fmt.Errorf("ssh.tcp_check_failures must be > 0 (got %d)", cfg.SSH.TCPCheckFailures)
+	}
+	if addr := strings.TrimSpace(cfg.SSH.CheckAddr); addr != "" {
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			return fmt.Errorf("ssh.check_addr must be host:port (got %q)", cfg.SSH.CheckAddr)
+		}
+	}
+	if !hasAuthMethod(cfg.SSH) {
+		return errors.New("ssh.identity_file or ssh.identity_agent is required")
+	}
+	if cfg.SSH.ControlMaster && (HasSSHOption(cfg.SSH.Options, "ControlMaster") || HasSSHOption(cfg.SSH.Options, "ControlPath")) {
+		return errors.New("ssh.control_master manages ControlMaster and ControlPath; remove them from ssh.options")
+	}
+	if level := strings.TrimSpace(cfg.SSH.LogLevel); level != "" && !sshLogLevels[strings.ToUpper(level)] {
+		return fmt.Errorf("ssh.log_level must be one of QUIET, FATAL, ERROR, INFO, VERBOSE, DEBUG, DEBUG1, DEBUG2, DEBUG3 (got %q)", cfg.SSH.LogLevel)
+	}
+	if keys := strings.TrimSpace(cfg.SSH.AddKeysToAgent); keys != "" && !validAddKeysToAgent(keys) {
+		return fmt.Errorf("ssh.add_keys_to_agent must be yes, no, ask, confirm, or a time interval such as 1h (got %q)", cfg.SSH.AddKeysToAgent)
+	}
+	if tty := strings.TrimSpace(cfg.SSH.RequestTTY); tty != "" && !sshRequestTTYModes[strings.ToLower(tty)] {
+		return fmt.Errorf("ssh.request_tty must be one of no, yes, force, auto (got %q)", cfg.SSH.RequestTTY)
+	}
+	if _, err := ParseSocketMode(cfg.IPC.SocketMode); err != nil {
+		return err
+	}
+	if cfg.IPC.MaxConns < 0 {
+		return errors.New("ipc.max_conns must be >= 0")
+	}
+	if cfg.IPC.ReadTimeoutMs < 0 {
+		return errors.New("ipc.read_timeout_ms must be >= 0")
+	}
+	if cfg.Hooks.TimeoutMs < 0 {
+		return errors.New("hooks.timeout_ms must be >= 0")
+	}
+	if err := validateSetEnv(cfg.SSH.SetEnv); err != nil {
+		return err
+	}
+	if err := validateLogFormat(cfg.Logging.Format, "logging"); err != nil {
+		return err
+	}
+	if err := validateLogFormat(cfg.ClientLogging.Format, "client_logging"); err != nil {
+		return err
+	}
+	if err := validateLogTimeFormat(cfg.Logging.TimeFormat, "logging"); err != nil {
+		return err
+	}
+	if err := validateLogTimeFormat(cfg.ClientLogging.TimeFormat, "client_logging"); err != nil {
+		return err
+	}
+	if cfg.Logging.DedupeWindowMs < 0 {
+		return fmt.Errorf("logging.dedupe_window_ms must be >= 0 (got %d)", cfg.Logging.DedupeWindowMs)
+	}
+	if cfg.ClientLogging.DedupeWindowMs < 0 {
+		return fmt.Errorf("client_logging.dedupe_window_ms must be >= 0 (got %d)", cfg.ClientLogging.DedupeWindowMs)
+	}
+	if cfg.Logging.SSHStderrLines <= 0 {
+		return fmt.Errorf("logging.ssh_stderr_lines must be > 0 (got %d)", cfg.Logging.SSHStderrLines)
+	}
+	if cfg.ClientLogging.SSHStderrLines <= 0 {
+		return fmt.Errorf("client_logging.ssh_stderr_lines must be > 0 (got %d)", cfg.ClientLogging.SSHStderrLines)
+	}
+	return nil
+}
+
+// hasAuthMethod reports whether a key source is configured, either through
+// the dedicated fields or through raw IdentityFile/IdentityAgent options.
+func hasAuthMethod(ssh SSHConfig) bool {
+	if strings.TrimSpace(ssh.IdentityFile) != "" {
+		return true
+	}
+	agent := strings.TrimSpace(ssh.IdentityAgent)
+	if agent != "" && !strings.EqualFold(agent, "none") {
+		return true
+	}
+	return HasSSHOption(ssh.Options, "IdentityFile") || HasSSHOption(ssh.Options, "IdentityAgent")
+}
+
+func validateSetEnv(env map[string]string) error {
+	for name, value := range env {
+		if !isEnvName(name) {
+			return fmt.Errorf("ssh.set_env key %q is not a valid environment variable name", name)
+		}
+		if strings.ContainsAny(value, "\r\n") {
+			return fmt.Errorf("ssh.set_env value for %s must not contain newlines", name)
+		}
+		if strings.Contains(value, "\"") {
+			return fmt.Errorf("ssh.set_env value for %s must not contain double quotes", name)
+		}
 	}
 	return nil
 }
 
-func validateSupervisor(policy string, restartCfg RestartConfig, periodic, sleepCheck, sleepGap, networkPoll int, label string) error {
+func isEnvName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i, r := range name {
+		switch {
+		case r == '_', r >= 'A' && r <= 'Z', r >= 'a' && r <= 'z':
+		case i > 0 && r >= '0' && r <= '9':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// SetEnvOptions renders ssh.set_env as sorted SetEnv=NAME=VALUE ssh options.
+func SetEnvOptions(cfg *Config) []string {
+	if cfg == nil || len(cfg.SSH.SetEnv) == 0 {
+		return nil
+	}
+	names := make([]string, 0, len(cfg.SSH.SetEnv))
+	for name := range cfg.SSH.SetEnv {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	out := make([]string, 0, len(names))
+	for _, name := range names {
+		value := cfg.SSH.SetEnv[name]
+		if strings.IndexFunc(value, unicode.IsSpace) >= 0 {
+			value = "\"" + value + "\""
+		}
+		out = append(out, fmt.Sprintf("SetEnv=%s=%s", name, value))
+	}
+	return out
+}
+
+func validateLogFormat(format, label string) error {
+	switch strings.ToLower(strings.TrimSpace(format)) {
+	case "", "json", "text":
+		return nil
+	default:
+		return fmt.Errorf("%s.format must be json or text (got %q)", label, format)
+	}
+}
+
+// LogTimeLayout resolves logging.time_format to a Go time layout. Empty and
+// "rfc3339" mean time.RFC3339, "rfc3339nano" means time.RFC3339Nano, and
+// anything else is used as a layout as-is.
+func LogTimeLayout(format string) string {
+	trimmed := strings.TrimSpace(format)
+	switch strings.ToLower(trimmed) {
+	case "", "rfc3339":
+		return time.RFC3339
+	case "rfc3339nano":
+		return time.RFC3339Nano
+	default:
+		return trimmed
+	}
+}
+
+// validateLogTimeFormat rejects layouts without any reference-time element,
+// which would stamp every line with the same literal text.
+func validateLogTimeFormat(format, label string) error {
+	layout := LogTimeLayout(format)
+	if time.Date(2001, 2, 3, 4, 5, 6, 0, time.UTC).Format(layout) == layout {
+		return fmt.Errorf("%s.time_format must be rfc3339, rfc3339nano, or a Go time layout such as \"2006-01-02 15:04:05\" (got %q)", label, format)
+	}
+	return nil
+}
+
+// validatePeriodicCron checks periodic_restart_cron, which replaces the fixed
+// periodic_restart_sec interval rather than adding to it.
+func validatePeriodicCron(expr string, periodicSec int, label string) error {
+	if strings.TrimSpace(expr) == "" {
+		return nil
+	}
+	if periodicSec > 0 {
+		return fmt.Errorf("%s: set periodic_restart_sec or periodic_restart_cron, not both", label)
+	}
+	if _, err := cron.Parse(expr); err != nil {
+		return fmt.Errorf("%s.periodic_restart_cron: %w", label, err)
+	}
+	return nil
+}
+
+func validateSupervisor(policy string, restartCfg RestartConfig, periodic, sleepCheck, sleepGap, networkPoll, powerPoll int, label string) error {
 	switch strings.ToLower(policy) {
-	case "always", "on-failure":
+	case "always", "on-failure", "never":
 	default:
-		return fmt.Errorf("%s.restart_policy must be always or on-failure (got %q)", label, policy)
+		return fmt.Errorf("%s.restart_policy must be always, on-failure, or never (got %q)", label, policy)
 	}
 	if restartCfg.MinDelayMs < 0 || restartCfg.MaxDelayMs < 0 {
 		return fmt.Errorf("%s.restart min/max delay must be >= 0", label)
@@ -312,6 +711,20 @@ func validateSupervisor(policy string, restartCfg RestartConfig, periodic, sleep
 	if restartCfg.DebounceMs < 0 {
 		return fmt.Errorf("%s.restart debounce_ms must be >= 0", label)
 	}
+	if restartCfg.SuccessAfterMs != nil && *restartCfg.SuccessAfterMs <= 0 {
+		return fmt.Errorf("%s.restart success_after_ms must be > 0 (omit it for the %d default)", label, DefaultSuccessAfterMs)
+	}
+	if restartCfg.RapidFailureLimit < 0 {
+		return fmt.Errorf("%s.restart rapid_failure_limit must be >= 0", label)
+	}
+	for _, pattern := range restartCfg.RestartOnStderr {
+		if strings.TrimSpace(pattern) == "" {
+			return fmt.Errorf("%s.restart restart_on_stderr entries must not be empty", label)
+		}
+		if _, err := regexp.Compile(pattern); err != nil {
+			return fmt.Errorf("%s.restart restart_on_stderr %q: %v", label, pattern, err)
+		}
+	}
 	if periodic < 0 {
 		return fmt.Errorf("%s.periodic_restart_sec must be >= 0", label)
 	}
@@ -324,6 +737,9 @@ func validateSupervisor(policy string, restartCfg RestartConfig, periodic, sleep
 	if networkPoll < 0 {
 		return fmt.Errorf("%s.network_poll_sec must be >= 0", label)
 	}
+	if powerPoll < 0 {
+		return fmt.Errorf("%s.power_poll_sec must be >= 0", label)
+	}
 	return nil
 }
 
@@ -344,8 +760,8 @@ func NormalizeRemoteForwards(cfg *Config) []string {
 		seen[trimmed] = struct{}{}
 		out = append(out, trimmed)
 	}
-	for _, value := range cfg.SSH.RemoteForwards {
-		add(value)
+	for _, forward := range cfg.SSH.RemoteForwards {
+		add(forward.Spec)
 	}
 	return out
 }
@@ -367,12 +783,170 @@ func NormalizeLocalForwards(cfg *Config) []string {
 		seen[trimmed] = struct{}{}
 		out = append(out, trimmed)
 	}
-	for _, value := range cfg.Client.LocalForwards {
-		add(value)
+	for _, forward := range cfg.Client.LocalForwards {
+		add(forward.Spec)
+	}
+	return out
+}
+
+func NormalizeDynamicForwards(cfg *Config) []string {
+	if cfg == nil {
+		return nil
+	}
+	return dedupeSpecs(cfg.Client.DynamicForwards)
+}
+
+// SetDynamicForwards replaces client.dynamic_forwards, trimming and
+// deduplicating like SetLocalForwards.
+func SetDynamicForwards(cfg *Config, forwards []string) {
+	if cfg == nil {
+		return
+	}
+	cfg.Client.DynamicForwards = dedupeSpecs(forwards)
+}
+
+func dedupeSpecs(specs []string) []string {
+	out := make([]string, 0, len(specs))
+	seen := make(map[string]struct{})
+	for _, value := range specs {
+		trimmed := strings.TrimSpace(value)
+		if trimmed == "" {
+			continue
+		}
+		if _, ok := seen[trimmed]; ok {
+			continue
+		}
+		seen[trimmed] = struct{}{}
+		out = append(out, trimmed)
 	}
 	return out
 }
 
+// ValidateDynamicForwardSpec checks the [bind:]port shape used by ssh -D.
+// IPv6 binds are written in brackets, e.g. [::1]:1080.
+func ValidateDynamicForwardSpec(spec string) error {
+	trimmed := strings.TrimSpace(spec)
+	if trimmed == "" {
+		return errors.New("dynamic forward spec is empty")
+	}
+	port := trimmed
+	if strings.Contains(trimmed, ":") {
+		host, p, err := net.SplitHostPort(trimmed)
+		if err != nil {
+			return fmt.Errorf("invalid dynamic forward spec %q (want [bind:]port)", trimmed)
+		}
+		if strings.TrimSpace(host) == "" {
+			return fmt.Errorf("invalid dynamic forward spec %q: bind host is empty", trimmed)
+		}
+		port = p
+	}
+	if err := validatePort(port, 1); err != nil {
+		return fmt.Errorf("invalid dynamic forward spec %q: %w", trimmed, err)
+	}
+	return nil
+}
+
+// ForwardSpec is an ssh -L/-R spec split into its parts. Bind and Host carry
+// no brackets; HasBind reports whether the spec named a bind at all, since an
+// empty bind (":8080:host:80") differs from an omitted one.
+type ForwardSpec struct {
+	Bind     string
+	HasBind  bool
+	Port     string
+	Host     string
+	HostPort string
+}
+
+// ParseForwardSpec splits spec into [bind:]port:host:hostport. IPv6 binds and
+// hosts are written in brackets as ssh expects, e.g. [::1]:8080:[::1]:80.
+func ParseForwardSpec(spec string) (ForwardSpec, error) {
+	trimmed := strings.TrimSpace(spec)
+	fields, ok := splitForwardFields(trimmed)
+	if !ok || (len(fields) != 3 && len(fields) != 4) {
+		return ForwardSpec{}, fmt.Errorf("invalid forward spec %q (want [bind:]port:host:hostport)", trimmed)
+	}
+	var out ForwardSpec
+	if len(fields) == 4 {
+		out.Bind, out.HasBind = fields[0], true
+		fields = fields[1:]
+	}
+	out.Port, out.Host, out.HostPort = fields[0], fields[1], fields[2]
+	return out, nil
+}
+
+// ForwardListen returns the host and port a forward listens on. An omitted
+// bind is loopback, which is what ssh uses without GatewayPorts.
+func ForwardListen(spec string) (string, string, error) {
+	forward, err := ParseForwardSpec(spec)
+	if err != nil {
+		return "", "", err
+	}
+	if !forward.HasBind {
+		return "127.0.0.1", forward.Port, nil
+	}
+	return forward.Bind, forward.Port, nil
+}
+
+// splitForwardFields splits spec on colons, keeping a bracketed field whole.
+func splitForwardFields(spec string) ([]string, bool) {
+	var fields []string
+	for {
+		var field string
+		if strings.HasPrefix(spec, "[") {
+			end := strings.Index(spec, "]")
+			if end < 0 {
+				return nil, false
+			}
+			field, spec = spec[1:end], spec[end+1:]
+			if spec != "" && !strings.HasPrefix(spec, ":") {
+				return nil, false
+			}
+		} else if i := strings.Index(spec, ":"); i >= 0 {
+			field, spec = spec[:i], spec[i:]
+		} else {
+			field, spec = spec, ""
+		}
+		fields = append(fields, field)
+		if spec == "" {
+			return fields, true
+		}
+		spec = spec[1:]
+	}
+}
+
+// ValidateForwardSpec checks that spec has the [bind:]port:host:hostport shape used by ssh -L/-R.
+func ValidateForwardSpec(spec string) error {
+	trimmed := strings.TrimSpace(spec)
+	if trimmed == "" {
+		return errors.New("forward spec is empty")
+	}
+	forward, err := ParseForwardSpec(trimmed)
+	if err != nil {
+		return err
+	}
+	if err := validatePort(forward.Port, 0); err != nil {
+		return fmt.Errorf("invalid forward spec %q: listen %w", trimmed, err)
+	}
+	if strings.TrimSpace(forward.Host) == "" {
+		return fmt.Errorf("invalid forward spec %q: target host is empty", trimmed)
+	}
+	if err := validatePort(forward.HostPort, 1); err != nil {
+		return fmt.Errorf("invalid forward spec %q: target %w", trimmed, err)
+	}
+	return nil
+}
+
+func validatePort(raw string, min int) error {
+	port, err := strconv.Atoi(strings.TrimSpace(raw))
+	if err != nil {
+		return fmt.Errorf("port %q is not a number", raw)
+	}
+	if port < min || port > 65535 {
+		return fmt.Errorf("port %d out of range", port)
+	}
+	return nil
+}
+
 func SetRemoteForwards(cfg *Config, forwards []string) {
 	if cfg == nil {
 		return
@@ -390,7 +964,7 @@ func SetRemoteForwards(cfg *Config, forwards []string) {
 		seen[val] = struct{}{}
 		trimmed = append(trimmed, val)
 	}
-	cfg.SSH.RemoteForwards = append([]string(nil), trimmed...)
+	cfg.SSH.RemoteForwards = WithForwardSpecs(cfg.SSH.RemoteForwards, trimmed)
 }
 
 func SetLocalForwards(cfg *Config, forwards []string) {
@@ -410,58 +984,108 @@ func SetLocalForwards(cfg *Config, forwards []string) {
 		seen[val] = struct{}{}
 		trimmed = append(trimmed, val)
 	}
-	cfg.Client.LocalForwards = append([]string(nil), trimmed...)
+	cfg.Client.LocalForwards = WithForwardSpecs(cfg.Client.LocalForwards, trimmed)
 }
 
-func mergeLocalForwards(single string, list []string) []string {
-	out := make([]string, 0, len(list)+1)
+func mergeLocalForwards(single string, list []Forward) []Forward {
+	out := make([]Forward, 0, len(list)+1)
 	if strings.TrimSpace(single) != "" {
-		out = append(out, single)
+		out = append(out, Forward{Spec: single})
 	}
 	out = append(out, list...)
 	return out
 }
 
-func Save(path string, cfg *Config) error {
-	if strings.TrimSpace(path) == "" {
-		return errors.New("config path is empty")
+// ParseSocketMode parses an octal permission string such as "0660" for the IPC socket.
+// The owner must keep read/write access and world access is rejected.
+func ParseSocketMode(raw string) (os.FileMode, error) {
+	trimmed := strings.TrimSpace(raw)
+	if trimmed == "" {
+		return 0o600, nil
 	}
-	data, err := yaml.Marshal(cfg)
+	value, err := strconv.ParseUint(strings.TrimPrefix(trimmed, "0o"), 8, 32)
 	if err != nil {
-		return fmt.Errorf("marshal config: %w", err)
+		return 0, fmt.Errorf("ipc.socket_mode must be an octal mode like 0660 (got %q)", raw)
+	}
+	mode := os.FileMode(value)
+	if mode&^os.ModePerm != 0 {
+		return 0, fmt.Errorf("ipc.socket_mode must be <= 0777 (got %q)", raw)
 	}
-	dir := filepath.Dir(path)
-	if dir != "." && dir != "" {
-		if err := os.MkdirAll(dir, 0o700); err != nil {
-			return fmt.Errorf("create config dir: %w", err)
+	if mode&0o600 != 0o600 {
+		return 0, fmt.Errorf("ipc.socket_mode must keep owner read/write (got %q)", raw)
+	}
+	if mode&0o007 != 0 {
+		return 0, fmt.Errorf("ipc.socket_mode must not grant world access (got %q)", raw)
+	}
+	return mode, nil
+}
+
+// homeOverride is set by the global --home flag and wins over RPA_HOME.
+var homeOverride string
+
+// SetHomeDir relocates every rpa artifact (sockets, state, default logs, and
+// default config) under dir. An empty dir restores the default.
+func SetHomeDir(dir string) {
+	homeOverride = strings.TrimSpace(dir)
+}
+
+// HomeOverride returns the relocated rpa directory from --home or RPA_HOME,
+// or "" when the default ~/.rpa is in use.
+func HomeOverride() string {
+	if homeOverride != "" {
+		return homeOverride
+	}
+	return strings.TrimSpace(os.Getenv("RPA_HOME"))
+}
+
+// HomeDir is the directory holding rpa sockets, state, and default logs:
+// --home, then RPA_HOME, then ~/.rpa. Only the last step needs a resolvable
+// user home.
+func HomeDir() (string, error) {
+	if dir := HomeOverride(); dir != "" {
+		expanded, err := expandUserHome(dir)
+		if err != nil {
+			return "", err
 		}
+		return filepath.Abs(expanded)
 	}
-	if err := os.WriteFile(path, data, 0o600); err != nil {
-		return fmt.Errorf("write config: %w", err)
+	home, err := UserHomeDir()
+	if err != nil {
+		return "", err
 	}
-	return nil
+	return filepath.Join(home, ".rpa"), nil
 }
 
-func SocketPath(cfg *Config) (string, error) {
+// UserHomeDir is the one place rpa asks the OS for the user's home. The error
+// points at RPA_HOME, which lets rpa run where no home can be resolved.
+func UserHomeDir() (string, error) {
 	home, err := os.UserHomeDir()
 	if err != nil {
-		return "", fmt.Errorf("resolve home dir: %w", err)
+		return "", fmt.Errorf("resolve home dir: %w (set RPA_HOME or --home to run without one)", err)
 	}
+	return home, nil
+}
+
+func SocketPath(cfg *Config) (string, error) {
 	if cfg == nil {
 		return "", errors.New("config is nil")
 	}
-	return filepath.Join(home, ".rpa", "agent.sock"), nil
+	home, err := HomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, "agent.sock"), nil
 }
 
 func ClientSocketPath(cfg *Config) (string, error) {
-	home, err := os.UserHomeDir()
-	if err != nil {
-		return "", fmt.Errorf("resolve home dir: %w", err)
-	}
 	if cfg == nil {
 		return "", errors.New("config is nil")
 	}
-	return filepath.Join(home, ".rpa", "client.sock"), nil
+	home, err := HomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, "client.sock"), nil
 }
 
 func LogPath(cfg *Config) (string, error) {
@@ -482,34 +1106,87 @@ func AgentStatePath(cfg *Config) (string, error) {
 	if cfg == nil {
 		return "", errors.New("config is nil")
 	}
-	home, err := os.UserHomeDir()
+	home, err := HomeDir()
 	if err != nil {
-		return "", fmt.Errorf("resolve home dir: %w", err)
+		return "", err
 	}
-	return filepath.Join(home, ".rpa", "agent.state.json"), nil
+	return filepath.Join(home, "agent.state.json"), nil
+}
+
+// ControlPath is the ssh ControlPath socket used when ssh.control_master is
+// on; kind is "agent" or "client".
+func ControlPath(cfg *Config, kind string) (string, error) {
+	if cfg == nil {
+		return "", errors.New("config is nil")
+	}
+	home, err := HomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, kind+".ssh.ctl"), nil
+}
+
+// PIDPath is the pid file written by a foreground run, ~/.rpa/<label>.pid,
+// keyed on the launchd label so several configs sharing a home keep apart;
+// kind is "agent" or "client".
+func PIDPath(cfg *Config, kind string) (string, error) {
+	if cfg == nil {
+		return "", errors.New("config is nil")
+	}
+	var label string
+	switch kind {
+	case "agent":
+		label = cfg.Agent.LaunchdLabel
+	case "client":
+		label = cfg.Client.LaunchdLabel
+	default:
+		return "", fmt.Errorf("unknown pid file kind %q", kind)
+	}
+	label = strings.TrimSpace(label)
+	if label == "" || strings.ContainsAny(label, `/\`) {
+		return "", fmt.Errorf("%s.launchd_label %q cannot name a pid file", kind, label)
+	}
+	home, err := HomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, label+".pid"), nil
 }
 
 func ClientStatePath(cfg *Config) (string, error) {
 	if cfg == nil {
 		return "", errors.New("config is nil")
 	}
-	home, err := os.UserHomeDir()
+	home, err := HomeDir()
 	if err != nil {
-		return "", fmt.Errorf("resolve home dir: %w", err)
+		return "", err
 	}
-	return filepath.Join(home, ".rpa", "client.state.json"), nil
+	return filepath.Join(home, "client.state.json"), nil
 }
 
+// expandHome expands a leading ~. Paths under ~/.rpa resolve against HomeDir
+// first, so they follow --home / RPA_HOME and need no user home when it is set.
 func expandHome(path string) (string, error) {
+	if path == "~/.rpa" || strings.HasPrefix(path, "~/.rpa/") {
+		home, err := HomeDir()
+		if err != nil {
+			return "", err
+		}
+		return filepath.Join(home, strings.TrimPrefix(strings.TrimPrefix(path, "~/.rpa"), "/")), nil
+	}
+	return expandUserHome(path)
+}
+
+func expandUserHome(path string) (string, error) {
 	if path == "" {
 		return "", errors.New("path is empty")
 	}
 	if !strings.HasPrefix(path, "~") {
 		return path, nil
 	}
-	home, err := os.UserHomeDir()
+	home, err := UserHomeDir()
 	if err != nil {
-		return "", fmt.Errorf("resolve home dir: %w", err)
+		return "", err
 	}
 	if path == "~" {
 		return home, nil
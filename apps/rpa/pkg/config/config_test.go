@@ -0,0 +1,924 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPIDPath(t *testing.T) {
+	home := t.TempDir()
+	SetHomeDir(home)
+	t.Cleanup(func() { SetHomeDir("") })
+	cases := []struct {
+		name    string
+		kind    string
+		agent   string
+		client  string
+		want    string
+		wantErr bool
+	}{
+		{name: "agent default label", kind: "agent", want: "com.rpa.agent.pid"},
+		{name: "client default label", kind: "client", want: "com.rpa.client.pid"},
+		{name: "custom agent label", kind: "agent", agent: "com.example.work", want: "com.example.work.pid"},
+		{name: "custom client label", kind: "client", client: "com.example.db", want: "com.example.db.pid"},
+		{name: "label with a slash", kind: "agent", agent: "../escape", wantErr: true},
+		{name: "unknown kind", kind: "proxy", wantErr: true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := &Config{}
+			cfg.Agent.LaunchdLabel = tc.agent
+			cfg.Client.LaunchdLabel = tc.client
+			ApplyDefaults(cfg)
+			got, err := PIDPath(cfg, tc.kind)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("PIDPath = %q, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("PIDPath: %v", err)
+			}
+			if want := filepath.Join(home, tc.want); got != want {
+				t.Fatalf("PIDPath = %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestApplyDefaultsTCPCheck(t *testing.T) {
+	cases := []struct {
+		name         string
+		failures     int
+		wantFailures int
+	}{
+		{name: "unset defaults to one", wantFailures: 1},
+		{name: "explicit value kept", failures: 4, wantFailures: 4},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := &Config{}
+			cfg.SSH.TCPCheckFailures = tc.failures
+			ApplyDefaults(cfg)
+			if cfg.SSH.TCPCheckFailures != tc.wantFailures {
+				t.Fatalf("tcp_check_failures = %d, want %d", cfg.SSH.TCPCheckFailures, tc.wantFailures)
+			}
+		})
+	}
+}
+
+func TestSuccessAfterMs(t *testing.T) {
+	intPtr := func(v int) *int { return &v }
+	cases := []struct {
+		name    string
+		value   *int
+		want    int
+		wantErr bool
+	}{
+		{name: "omitted uses the default", want: DefaultSuccessAfterMs},
+		{name: "explicit value kept", value: intPtr(3000), want: 3000},
+		{name: "explicit zero rejected", value: intPtr(0), wantErr: true},
+		{name: "negative rejected", value: intPtr(-1), wantErr: true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := &Config{}
+			cfg.Agent.Restart.SuccessAfterMs = tc.value
+			ApplyDefaults(cfg)
+			err := validateSupervisor(cfg.Agent.RestartPolicy, cfg.Agent.Restart, cfg.Agent.PeriodicRestartSec, cfg.Agent.SleepCheckSec, cfg.Agent.SleepGapSec, cfg.Agent.NetworkPollSec, cfg.Agent.PowerPollSec, "agent")
+			if tc.wantErr {
+				if err == nil {
+					t.Fatal("validateSupervisor accepted the value")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("validateSupervisor: %v", err)
+			}
+			if got := SuccessAfterMs(cfg.Agent.Restart); got != tc.want {
+				t.Fatalf("SuccessAfterMs = %d, want %d", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestValidatePeriodicCron(t *testing.T) {
+	cases := []struct {
+		name     string
+		expr     string
+		periodic int
+		wantErr  bool
+	}{
+		{name: "unset", expr: ""},
+		{name: "blank", expr: "  "},
+		{name: "valid", expr: "0 4 * * *"},
+		{name: "invalid", expr: "0 25 * * *", wantErr: true},
+		{name: "both schedules set", expr: "0 4 * * *", periodic: 3600, wantErr: true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := validatePeriodicCron(tc.expr, tc.periodic, "agent")
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("validatePeriodicCron(%q, %d) = %v, want error %v", tc.expr, tc.periodic, err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestSetEnvOptions(t *testing.T) {
+	cases := []struct {
+		name string
+		env  map[string]string
+		want []string
+	}{
+		{name: "unset", want: nil},
+		{name: "sorted by name", env: map[string]string{"ZONE": "b", "APP_ENV": "prod"}, want: []string{"SetEnv=APP_ENV=prod", "SetEnv=ZONE=b"}},
+		{name: "spaces are quoted", env: map[string]string{"GREETING": "hello world"}, want: []string{`SetEnv=GREETING="hello world"`}},
+		{name: "empty value", env: map[string]string{"EMPTY": ""}, want: []string{"SetEnv=EMPTY="}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := &Config{}
+			cfg.SSH.SetEnv = tc.env
+			got := SetEnvOptions(cfg)
+			if len(got) != len(tc.want) {
+				t.Fatalf("SetEnvOptions = %q, want %q", got, tc.want)
+			}
+			for i := range got {
+				if got[i] != tc.want[i] {
+					t.Fatalf("SetEnvOptions = %q, want %q", got, tc.want)
+				}
+			}
+		})
+	}
+}
+
+func TestValidateSetEnv(t *testing.T) {
+	cases := []struct {
+		name    string
+		env     map[string]string
+		wantErr bool
+	}{
+		{name: "valid names", env: map[string]string{"FOO": "bar", "_x1": "y", "a_B_2": ""}},
+		{name: "leading digit", env: map[string]string{"1FOO": "bar"}, wantErr: true},
+		{name: "dash in name", env: map[string]string{"MY-VAR": "bar"}, wantErr: true},
+		{name: "empty name", env: map[string]string{"": "bar"}, wantErr: true},
+		{name: "newline in value", env: map[string]string{"FOO": "a\nb"}, wantErr: true},
+		{name: "carriage return in value", env: map[string]string{"FOO": "a\rb"}, wantErr: true},
+		{name: "double quote in value", env: map[string]string{"FOO": `say "hi"`}, wantErr: true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := validateSetEnv(tc.env); (err != nil) != tc.wantErr {
+				t.Fatalf("validateSetEnv = %v, want error %v", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestParseSocketMode(t *testing.T) {
+	cases := []struct {
+		raw     string
+		want    os.FileMode
+		wantErr bool
+	}{
+		{raw: "", want: 0o600},
+		{raw: "  ", want: 0o600},
+		{raw: "0600", want: 0o600},
+		{raw: "0660", want: 0o660},
+		{raw: "660", want: 0o660},
+		{raw: "0o640", want: 0o640},
+		{raw: " 0660 ", want: 0o660},
+		{raw: "0666", wantErr: true},
+		{raw: "0400", wantErr: true},
+		{raw: "0060", wantErr: true},
+		{raw: "01660", wantErr: true},
+		{raw: "0680", wantErr: true},
+		{raw: "rw-rw----", wantErr: true},
+		{raw: "-1", wantErr: true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.raw, func(t *testing.T) {
+			got, err := ParseSocketMode(tc.raw)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("ParseSocketMode(%q) = %o, want an error", tc.raw, got)
+				}
+				return
+			}
+			if err != nil || got != tc.want {
+				t.Fatalf("ParseSocketMode(%q) = %o, %v, want %o", tc.raw, got, err, tc.want)
+			}
+		})
+	}
+}
+
+func TestValidateAuthMethod(t *testing.T) {
+	cases := []struct {
+		name    string
+		file    string
+		agent   string
+		options []string
+		wantErr bool
+	}{
+		{name: "identity file", file: "~/.ssh/id_ed25519"},
+		{name: "identity agent", agent: "~/.1password/agent.sock"},
+		{name: "agent from environment", agent: "SSH_AUTH_SOCK"},
+		{name: "file and agent", file: "~/.ssh/id_ed25519", agent: "SSH_AUTH_SOCK"},
+		{name: "identity file option", options: []string{"IdentityFile=~/.ssh/work"}},
+		{name: "identity agent option", options: []string{"IdentityAgent ~/agent.sock"}},
+		{name: "nothing configured", wantErr: true},
+		{name: "blank fields", file: "  ", agent: "  ", wantErr: true},
+		{name: "agent disabled", agent: "none", wantErr: true},
+		{name: "agent disabled any case", agent: "None", wantErr: true},
+		{name: "unrelated options", options: []string{"ServerAliveInterval=30"}, wantErr: true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := &Config{}
+			cfg.SSH.Host = "bastion.example.com"
+			cfg.SSH.User = "deploy"
+			cfg.SSH.RemoteForwards = []Forward{{Spec: "8080:localhost:8080"}}
+			cfg.SSH.IdentityFile = tc.file
+			cfg.SSH.IdentityAgent = tc.agent
+			cfg.SSH.Options = tc.options
+			ApplyDefaults(cfg)
+			err := ValidateAgent(cfg)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("ValidateAgent = %v, want error %v", err, tc.wantErr)
+			}
+			if tc.wantErr && !strings.Contains(err.Error(), "ssh.identity_file or ssh.identity_agent") {
+				t.Fatalf("ValidateAgent = %v, want the auth method error", err)
+			}
+		})
+	}
+}
+
+func TestLoadWindowsFile(t *testing.T) {
+	const body = "ssh:\n  host: bastion.example.com\n  user: deploy\n  remote_forwards:\n    - \"0.0.0.0:2222:localhost:22\"\n  options:\n    - ServerAliveInterval=30\n"
+	crlf := strings.ReplaceAll(body, "\n", "\r\n")
+	cases := []struct {
+		name string
+		data string
+		base string
+	}{
+		{name: "plain", data: body},
+		{name: "bom", data: "\xef\xbb\xbf" + body},
+		{name: "crlf", data: crlf},
+		{name: "bom and crlf", data: "\xef\xbb\xbf" + crlf},
+		{name: "included base with bom and crlf", data: "include: base.yaml\r\n", base: "\xef\xbb\xbf" + crlf},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			path := filepath.Join(dir, "rpa.yaml")
+			if err := os.WriteFile(path, []byte(tc.data), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			if tc.base != "" {
+				if err := os.WriteFile(filepath.Join(dir, "base.yaml"), []byte(tc.base), 0o600); err != nil {
+					t.Fatal(err)
+				}
+			}
+			cfg, err := Load(path)
+			if err != nil {
+				t.Fatalf("Load: %v", err)
+			}
+			if cfg.SSH.Host != "bastion.example.com" || cfg.SSH.User != "deploy" {
+				t.Fatalf("host %q user %q, want bastion.example.com deploy", cfg.SSH.Host, cfg.SSH.User)
+			}
+			if got := ForwardSpecs(cfg.SSH.RemoteForwards); len(got) != 1 || got[0] != "0.0.0.0:2222:localhost:22" {
+				t.Fatalf("remote forwards = %q", got)
+			}
+			if len(cfg.SSH.Options) == 0 || cfg.SSH.Options[0] != "ServerAliveInterval=30" {
+				t.Fatalf("options = %q", cfg.SSH.Options)
+			}
+		})
+	}
+}
+
+func TestValidateLogLevel(t *testing.T) {
+	cases := []struct {
+		level   string
+		wantErr bool
+	}{
+		{level: ""},
+		{level: "QUIET"},
+		{level: "VERBOSE"},
+		{level: "debug"},
+		{level: "Debug3"},
+		{level: " info "},
+		{level: "DEBUG4", wantErr: true},
+		{level: "TRACE", wantErr: true},
+		{level: "-v", wantErr: true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.level, func(t *testing.T) {
+			cfg := &Config{}
+			cfg.SSH.Host = "bastion.example.com"
+			cfg.SSH.User = "deploy"
+			cfg.SSH.IdentityFile = "~/.ssh/id_ed25519"
+			cfg.SSH.RemoteForwards = []Forward{{Spec: "8080:localhost:8080"}}
+			cfg.Client.LocalForwards = []Forward{{Spec: "5432:db.internal:5432"}}
+			cfg.SSH.LogLevel = tc.level
+			ApplyDefaults(cfg)
+			for name, validate := range map[string]func(*Config) error{"agent": ValidateAgent, "client": ValidateClient} {
+				err := validate(cfg)
+				if (err != nil) != tc.wantErr {
+					t.Fatalf("%s validation = %v, want error %v", name, err, tc.wantErr)
+				}
+				if tc.wantErr && !strings.Contains(err.Error(), "ssh.log_level must be one of") {
+					t.Fatalf("%s validation = %v, want the log level error", name, err)
+				}
+			}
+		})
+	}
+}
+
+func TestValidateRestartOnStderr(t *testing.T) {
+	cases := []struct {
+		name     string
+		patterns []string
+		wantErr  string
+	}{
+		{name: "unset"},
+		{name: "substring", patterns: []string{"open failed"}},
+		{name: "regex", patterns: []string{`^channel \d+: open failed`, "remote port forwarding failed"}},
+		{name: "empty entry", patterns: []string{"open failed", "  "}, wantErr: "restart_on_stderr entries must not be empty"},
+		{name: "bad regex", patterns: []string{"channel (open"}, wantErr: `restart_on_stderr "channel (open"`},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := &Config{}
+			cfg.SSH.Host = "bastion.example.com"
+			cfg.SSH.User = "deploy"
+			cfg.SSH.IdentityFile = "~/.ssh/id_ed25519"
+			cfg.SSH.RemoteForwards = []Forward{{Spec: "8080:localhost:8080"}}
+			cfg.Client.LocalForwards = []Forward{{Spec: "5432:db.internal:5432"}}
+			ApplyDefaults(cfg)
+			cfg.Agent.Restart.RestartOnStderr = tc.patterns
+			cfg.Client.Restart.RestartOnStderr = tc.patterns
+			for name, validate := range map[string]func(*Config) error{"agent": ValidateAgent, "client": ValidateClient} {
+				err := validate(cfg)
+				if tc.wantErr == "" {
+					if err != nil {
+						t.Fatalf("%s validation = %v, want nil", name, err)
+					}
+					continue
+				}
+				if err == nil || !strings.Contains(err.Error(), name+".restart "+tc.wantErr) {
+					t.Fatalf("%s validation = %v, want %q", name, err, tc.wantErr)
+				}
+			}
+		})
+	}
+}
+
+func TestValidateAddKeysToAgent(t *testing.T) {
+	cases := []struct {
+		value   string
+		wantErr bool
+	}{
+		{value: ""},
+		{value: "yes"},
+		{value: "No"},
+		{value: "ask"},
+		{value: " confirm "},
+		{value: "3600"},
+		{value: "1h30m"},
+		{value: "always", wantErr: true},
+		{value: "1x", wantErr: true},
+		{value: "-1h", wantErr: true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.value, func(t *testing.T) {
+			cfg := &Config{}
+			cfg.SSH.Host = "bastion.example.com"
+			cfg.SSH.User = "deploy"
+			cfg.SSH.IdentityFile = "~/.ssh/id_ed25519"
+			cfg.SSH.RemoteForwards = []Forward{{Spec: "8080:localhost:8080"}}
+			cfg.Client.LocalForwards = []Forward{{Spec: "5432:db.internal:5432"}}
+			cfg.SSH.AddKeysToAgent = tc.value
+			ApplyDefaults(cfg)
+			for name, validate := range map[string]func(*Config) error{"agent": ValidateAgent, "client": ValidateClient} {
+				err := validate(cfg)
+				if (err != nil) != tc.wantErr {
+					t.Fatalf("%s validation = %v, want error %v", name, err, tc.wantErr)
+				}
+				if tc.wantErr && !strings.Contains(err.Error(), "ssh.add_keys_to_agent must be") {
+					t.Fatalf("%s validation = %v, want the add_keys_to_agent error", name, err)
+				}
+			}
+		})
+	}
+}
+
+func TestValidateForwardAgent(t *testing.T) {
+	cases := []struct {
+		name          string
+		forwardAgent  bool
+		remote        string
+		local         string
+		wantAgentErr  bool
+		wantClientErr bool
+	}{
+		{name: "off with open binds", remote: "0.0.0.0:8080:localhost:8080", local: "0.0.0.0:5432:db.internal:5432"},
+		{name: "default binds", forwardAgent: true, remote: "8080:localhost:8080", local: "5432:db.internal:5432"},
+		{name: "loopback binds", forwardAgent: true, remote: "127.0.0.1:8080:localhost:8080", local: "localhost:5432:db.internal:5432"},
+		{name: "open remote bind", forwardAgent: true, remote: "0.0.0.0:8080:localhost:8080", local: "127.0.0.1:5432:db.internal:5432", wantAgentErr: true},
+		{name: "wildcard local bind", forwardAgent: true, remote: "8080:localhost:8080", local: "*:5432:db.internal:5432", wantClientErr: true},
+		{name: "ipv6 loopback binds", forwardAgent: true, remote: "[::1]:8080:localhost:8080", local: "[::1]:5432:[::1]:5432"},
+		{name: "ipv6 open binds", forwardAgent: true, remote: "[::]:8080:localhost:8080", local: "[::]:5432:db.internal:5432", wantAgentErr: true, wantClientErr: true},
+		{name: "empty bind is every interface", forwardAgent: true, remote: ":8080:localhost:8080", local: ":5432:db.internal:5432", wantAgentErr: true, wantClientErr: true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := &Config{}
+			cfg.SSH.Host = "bastion.example.com"
+			cfg.SSH.User = "deploy"
+			cfg.SSH.IdentityFile = "~/.ssh/id_ed25519"
+			cfg.SSH.RemoteForwards = []Forward{{Spec: tc.remote}}
+			cfg.Client.LocalForwards = []Forward{{Spec: tc.local}}
+			cfg.SSH.ForwardAgent = tc.forwardAgent
+			ApplyDefaults(cfg)
+			checks := []struct {
+				name     string
+				validate func(*Config) error
+				wantErr  bool
+				label    string
+			}{
+				{name: "agent", validate: ValidateAgent, wantErr: tc.wantAgentErr, label: "ssh.remote_forwards"},
+				{name: "client", validate: ValidateClient, wantErr: tc.wantClientErr, label: "client.local_forwards"},
+			}
+			for _, check := range checks {
+				err := check.validate(cfg)
+				if (err != nil) != check.wantErr {
+					t.Fatalf("%s validation = %v, want error %v", check.name, err, check.wantErr)
+				}
+				if check.wantErr && (!strings.Contains(err.Error(), "ssh.forward_agent cannot be combined") || !strings.Contains(err.Error(), check.label)) {
+					t.Fatalf("%s validation = %v, want the forward_agent error naming %s", check.name, err, check.label)
+				}
+			}
+		})
+	}
+}
+
+func TestParseForwardSpec(t *testing.T) {
+	cases := []struct {
+		spec    string
+		want    ForwardSpec
+		wantErr bool
+	}{
+		{spec: "8080:localhost:80", want: ForwardSpec{Port: "8080", Host: "localhost", HostPort: "80"}},
+		{spec: "127.0.0.1:8080:localhost:80", want: ForwardSpec{Bind: "127.0.0.1", HasBind: true, Port: "8080", Host: "localhost", HostPort: "80"}},
+		{spec: ":8080:localhost:80", want: ForwardSpec{HasBind: true, Port: "8080", Host: "localhost", HostPort: "80"}},
+		{spec: "[::1]:8080:localhost:80", want: ForwardSpec{Bind: "::1", HasBind: true, Port: "8080", Host: "localhost", HostPort: "80"}},
+		{spec: "[::]:8080:[::1]:80", want: ForwardSpec{Bind: "::", HasBind: true, Port: "8080", Host: "::1", HostPort: "80"}},
+		{spec: "8080:[fe80::1%en0]:80", want: ForwardSpec{Port: "8080", Host: "fe80::1%en0", HostPort: "80"}},
+		{spec: "::1:8080:localhost:80", wantErr: true},
+		{spec: "[::1:8080:localhost:80", wantErr: true},
+		{spec: "[::1]8080:localhost:80", wantErr: true},
+		{spec: "8080:localhost", wantErr: true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.spec, func(t *testing.T) {
+			got, err := ParseForwardSpec(tc.spec)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("ParseForwardSpec = %+v, want an error", got)
+				}
+				return
+			}
+			if err != nil || got != tc.want {
+				t.Fatalf("ParseForwardSpec = %+v, %v; want %+v", got, err, tc.want)
+			}
+		})
+	}
+}
+
+func TestForwardListen(t *testing.T) {
+	cases := []struct {
+		spec     string
+		wantHost string
+		wantPort string
+		wantErr  bool
+	}{
+		{spec: "5432:db.internal:5432", wantHost: "127.0.0.1", wantPort: "5432"},
+		{spec: "0.0.0.0:8080:web:80", wantHost: "0.0.0.0", wantPort: "8080"},
+		{spec: "*:8080:web:80", wantHost: "*", wantPort: "8080"},
+		{spec: ":8080:web:80", wantHost: "", wantPort: "8080"},
+		{spec: "[::1]:8080:web:80", wantHost: "::1", wantPort: "8080"},
+		{spec: "/tmp/app.sock:/run/app.sock", wantErr: true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.spec, func(t *testing.T) {
+			host, port, err := ForwardListen(tc.spec)
+			if (err != nil) != tc.wantErr || host != tc.wantHost || port != tc.wantPort {
+				t.Fatalf("ForwardListen = %q, %q, %v; want %q, %q (error %v)", host, port, err, tc.wantHost, tc.wantPort, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateForwardSpec(t *testing.T) {
+	cases := []struct {
+		spec    string
+		wantErr string
+	}{
+		{spec: "8080:localhost:80"},
+		{spec: "0.0.0.0:8080:localhost:80"},
+		{spec: "[::1]:8080:localhost:80"},
+		{spec: "[::]:8080:[::1]:80"},
+		{spec: "[2001:db8::1]:0:db.internal:5432"},
+		{spec: "", wantErr: "forward spec is empty"},
+		{spec: "::1:8080:localhost:80", wantErr: "want [bind:]port:host:hostport"},
+		{spec: "[::1]:http:localhost:80", wantErr: `listen port "http" is not a number`},
+		{spec: "[::1]:8080:[]:80", wantErr: "target host is empty"},
+		{spec: "[::1]:8080:[::1]:70000", wantErr: "target port 70000 out of range"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.spec, func(t *testing.T) {
+			err := ValidateForwardSpec(tc.spec)
+			if tc.wantErr == "" {
+				if err != nil {
+					t.Fatalf("ValidateForwardSpec = %v, want nil", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Fatalf("ValidateForwardSpec = %v, want %q", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateDynamicForwardSpec(t *testing.T) {
+	cases := []struct {
+		spec    string
+		wantErr string
+	}{
+		{spec: "1080"},
+		{spec: " 1080 "},
+		{spec: "127.0.0.1:1080"},
+		{spec: "localhost:1080"},
+		{spec: "*:1080"},
+		{spec: "[::1]:1080"},
+		{spec: "", wantErr: "empty"},
+		{spec: "socks", wantErr: "not a number"},
+		{spec: "0", wantErr: "out of range"},
+		{spec: "127.0.0.1:65536", wantErr: "out of range"},
+		{spec: ":1080", wantErr: "bind host is empty"},
+		{spec: "::1:1080", wantErr: "want [bind:]port"},
+		{spec: "127.0.0.1:1080:extra", wantErr: "want [bind:]port"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.spec, func(t *testing.T) {
+			err := ValidateDynamicForwardSpec(tc.spec)
+			if tc.wantErr == "" {
+				if err != nil {
+					t.Fatalf("ValidateDynamicForwardSpec(%q) = %v, want nil", tc.spec, err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Fatalf("ValidateDynamicForwardSpec(%q) = %v, want error containing %q", tc.spec, err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateClientDynamicForwards(t *testing.T) {
+	cases := []struct {
+		name    string
+		local   []Forward
+		dynamic []string
+		wantErr string
+	}{
+		{name: "local only", local: []Forward{{Spec: "5432:db.internal:5432"}}},
+		{name: "dynamic only", dynamic: []string{"127.0.0.1:1080"}},
+		{name: "both", local: []Forward{{Spec: "5432:db.internal:5432"}}, dynamic: []string{"1080"}},
+		{name: "neither", wantErr: "client.local_forwards or client.dynamic_forwards is required"},
+		{name: "blank dynamic is none", dynamic: []string{" "}, wantErr: "client.local_forwards or client.dynamic_forwards is required"},
+		{name: "bad dynamic", local: []Forward{{Spec: "5432:db.internal:5432"}}, dynamic: []string{"1080", "socks"}, wantErr: "client.dynamic_forwards: invalid dynamic forward spec \"socks\""},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := &Config{}
+			cfg.SSH.Host = "bastion.example.com"
+			cfg.SSH.User = "deploy"
+			cfg.SSH.IdentityFile = "~/.ssh/id_ed25519"
+			cfg.Client.LocalForwards = tc.local
+			cfg.Client.DynamicForwards = tc.dynamic
+			ApplyDefaults(cfg)
+			err := ValidateClient(cfg)
+			if tc.wantErr == "" {
+				if err != nil {
+					t.Fatalf("ValidateClient = %v, want nil", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Fatalf("ValidateClient = %v, want error containing %q", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestSetDynamicForwards(t *testing.T) {
+	cfg := &Config{}
+	SetDynamicForwards(cfg, []string{" 1080", "", "127.0.0.1:1081", "1080 ", "127.0.0.1:1081"})
+	if got, want := strings.Join(cfg.Client.DynamicForwards, ","), "1080,127.0.0.1:1081"; got != want {
+		t.Fatalf("DynamicForwards = %q, want %q", got, want)
+	}
+	cfg.Client.DynamicForwards = append(cfg.Client.DynamicForwards, " 1080 ")
+	if got, want := strings.Join(NormalizeDynamicForwards(cfg), ","), "1080,127.0.0.1:1081"; got != want {
+		t.Fatalf("NormalizeDynamicForwards = %q, want %q", got, want)
+	}
+	if NormalizeDynamicForwards(nil) != nil {
+		t.Fatal("NormalizeDynamicForwards(nil) is not nil")
+	}
+}
+
+func TestLogTimeFormat(t *testing.T) {
+	cases := []struct {
+		format     string
+		wantLayout string
+		wantErr    bool
+	}{
+		{format: "", wantLayout: time.RFC3339},
+		{format: "rfc3339", wantLayout: time.RFC3339},
+		{format: " RFC3339 ", wantLayout: time.RFC3339},
+		{format: "rfc3339nano", wantLayout: time.RFC3339Nano},
+		{format: "2006-01-02 15:04:05", wantLayout: "2006-01-02 15:04:05"},
+		{format: " Jan _2 15:04:05 ", wantLayout: "Jan _2 15:04:05"},
+		{format: "iso", wantLayout: "iso", wantErr: true},
+		{format: "yyyy-mm-dd", wantLayout: "yyyy-mm-dd", wantErr: true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.format, func(t *testing.T) {
+			if got := LogTimeLayout(tc.format); got != tc.wantLayout {
+				t.Fatalf("LogTimeLayout(%q) = %q, want %q", tc.format, got, tc.wantLayout)
+			}
+			for _, label := range []string{"logging", "client_logging"} {
+				cfg := &Config{}
+				cfg.SSH.Host = "bastion.example.com"
+				cfg.SSH.User = "deploy"
+				cfg.SSH.IdentityFile = "~/.ssh/id_ed25519"
+				cfg.SSH.RemoteForwards = []Forward{{Spec: "8080:localhost:8080"}}
+				if label == "logging" {
+					cfg.Logging.TimeFormat = tc.format
+				} else {
+					cfg.ClientLogging.TimeFormat = tc.format
+				}
+				ApplyDefaults(cfg)
+				err := ValidateAgent(cfg)
+				if (err != nil) != tc.wantErr {
+					t.Fatalf("%s.time_format %q: ValidateAgent = %v, want error %v", label, tc.format, err, tc.wantErr)
+				}
+				if tc.wantErr && !strings.Contains(err.Error(), label+".time_format must be") {
+					t.Fatalf("%s.time_format %q: error %v does not name the field", label, tc.format, err)
+				}
+			}
+		})
+	}
+}
+
+func TestHomeDir(t *testing.T) {
+	user := t.TempDir()
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		name string
+		flag string
+		env  string
+		want string
+	}{
+		{name: "default", want: filepath.Join(user, ".rpa")},
+		{name: "env", env: "/srv/rpa", want: "/srv/rpa"},
+		{name: "flag", flag: "/opt/rpa", want: "/opt/rpa"},
+		{name: "flag wins over env", flag: "/opt/rpa", env: "/srv/rpa", want: "/opt/rpa"},
+		{name: "relative made absolute", env: "state/rpa", want: filepath.Join(cwd, "state/rpa")},
+		{name: "tilde expanded", flag: "~/isolated", want: filepath.Join(user, "isolated")},
+		{name: "blank env ignored", env: "  ", want: filepath.Join(user, ".rpa")},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Setenv("HOME", user)
+			t.Setenv("RPA_HOME", tc.env)
+			SetHomeDir(tc.flag)
+			t.Cleanup(func() { SetHomeDir("") })
+			got, err := HomeDir()
+			if err != nil {
+				t.Fatalf("HomeDir: %v", err)
+			}
+			if got != tc.want {
+				t.Fatalf("HomeDir = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestPathsFollowHome(t *testing.T) {
+	home := t.TempDir()
+	// No user home: every default path must come from the override alone.
+	t.Setenv("HOME", "")
+	t.Setenv("RPA_HOME", home)
+	cfg := &Config{}
+	ApplyDefaults(cfg)
+
+	paths := []struct {
+		name string
+		path func(*Config) (string, error)
+		want string
+	}{
+		{name: "agent socket", path: SocketPath, want: "agent.sock"},
+		{name: "client socket", path: ClientSocketPath, want: "client.sock"},
+		{name: "agent log", path: LogPath, want: "logs/agent.log"},
+		{name: "client log", path: ClientLogPath, want: "logs/client.log"},
+		{name: "agent state", path: AgentStatePath, want: "agent.state.json"},
+		{name: "client state", path: ClientStatePath, want: "client.state.json"},
+		{name: "agent control socket", path: func(cfg *Config) (string, error) { return ControlPath(cfg, "agent") }, want: "agent.ssh.ctl"},
+		{name: "client pid", path: func(cfg *Config) (string, error) { return PIDPath(cfg, "client") }, want: "com.rpa.client.pid"},
+	}
+	for _, tc := range paths {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := tc.path(cfg)
+			if err != nil {
+				t.Fatalf("%s: %v", tc.name, err)
+			}
+			if want := filepath.Join(home, tc.want); got != want {
+				t.Fatalf("%s = %q, want %q", tc.name, got, want)
+			}
+		})
+	}
+
+	// An explicit log path outside ~/.rpa stays where it is.
+	cfg.Logging.Path = "/var/log/rpa/agent.log"
+	if got, err := LogPath(cfg); err != nil || got != "/var/log/rpa/agent.log" {
+		t.Fatalf("LogPath = %q, %v; want the configured path", got, err)
+	}
+}
+
+func TestNoUserHome(t *testing.T) {
+	t.Setenv("HOME", "")
+	t.Setenv("RPA_HOME", "")
+	cfg := &Config{}
+	ApplyDefaults(cfg)
+
+	cases := []struct {
+		name string
+		path func() (string, error)
+	}{
+		{name: "home dir", path: HomeDir},
+		{name: "user home", path: UserHomeDir},
+		{name: "agent socket", path: func() (string, error) { return SocketPath(cfg) }},
+		{name: "client state", path: func() (string, error) { return ClientStatePath(cfg) }},
+		{name: "default agent log", path: func() (string, error) { return LogPath(cfg) }},
+		{name: "tilde log outside rpa home", path: func() (string, error) {
+			return LogPath(&Config{Logging: LoggingConfig{Path: "~/logs/agent.log"}})
+		}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := tc.path()
+			if err == nil || !strings.Contains(err.Error(), "set RPA_HOME or --home") {
+				t.Fatalf("path = %q, %v; want an error pointing at RPA_HOME", got, err)
+			}
+		})
+	}
+
+	// Absolute paths never need a home.
+	if got, err := LogPath(&Config{Logging: LoggingConfig{Path: "/var/log/rpa/agent.log"}}); err != nil || got != "/var/log/rpa/agent.log" {
+		t.Fatalf("LogPath = %q, %v; want the absolute path", got, err)
+	}
+
+	// With RPA_HOME set, only paths outside ~/.rpa still need the user home.
+	rpaHome := t.TempDir()
+	t.Setenv("RPA_HOME", rpaHome)
+	if got, err := LogPath(cfg); err != nil || got != filepath.Join(rpaHome, "logs", "agent.log") {
+		t.Fatalf("LogPath = %q, %v; want it under RPA_HOME", got, err)
+	}
+	if got, err := expandHome("~/.rpa"); err != nil || got != rpaHome {
+		t.Fatalf("expandHome(~/.rpa) = %q, %v; want %q", got, err, rpaHome)
+	}
+	if _, err := expandHome("~/.rpa-old/agent.log"); err == nil {
+		t.Fatal("expandHome(~/.rpa-old/...) resolved without a user home")
+	}
+}
+
+func TestSSHStderrLines(t *testing.T) {
+	cases := []struct {
+		name       string
+		agent      int
+		client     int
+		wantAgent  int
+		wantClient int
+		wantErr    string
+	}{
+		{name: "defaults", wantAgent: 10, wantClient: 10},
+		{name: "configured", agent: 50, client: 3, wantAgent: 50, wantClient: 3},
+		{name: "negative agent size", agent: -1, wantErr: "logging.ssh_stderr_lines must be > 0 (got -1)"},
+		{name: "negative client size", client: -5, wantErr: "client_logging.ssh_stderr_lines must be > 0 (got -5)"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := &Config{}
+			cfg.SSH.Host = "bastion.example.com"
+			cfg.SSH.User = "deploy"
+			cfg.SSH.IdentityFile = "~/.ssh/id_ed25519"
+			cfg.SSH.RemoteForwards = []Forward{{Spec: "8080:localhost:8080"}}
+			cfg.Logging.SSHStderrLines = tc.agent
+			cfg.ClientLogging.SSHStderrLines = tc.client
+			ApplyDefaults(cfg)
+			err := ValidateAgent(cfg)
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("ValidateAgent = %v, want %q", err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ValidateAgent: %v", err)
+			}
+			if cfg.Logging.SSHStderrLines != tc.wantAgent || cfg.ClientLogging.SSHStderrLines != tc.wantClient {
+				t.Fatalf("sizes = %d/%d, want %d/%d", cfg.Logging.SSHStderrLines, cfg.ClientLogging.SSHStderrLines, tc.wantAgent, tc.wantClient)
+			}
+		})
+	}
+}
+
+func TestDisableDefaultOptions(t *testing.T) {
+	defaults := []string{"ServerAliveInterval=30", "ServerAliveCountMax=3", "StrictHostKeyChecking=accept-new"}
+	cases := []struct {
+		name string
+		yaml string
+		want []string
+	}{
+		{name: "defaults added", yaml: "", want: defaults},
+		{name: "user value kept", yaml: "  options:\n    - ServerAliveInterval 10\n", want: []string{"ServerAliveInterval 10", "ServerAliveCountMax=3", "StrictHostKeyChecking=accept-new"}},
+		{name: "disabled with no options", yaml: "  disable_default_options: true\n", want: nil},
+		{name: "disabled keeps only user options", yaml: "  disable_default_options: true\n  options:\n    - Compression=yes\n", want: []string{"Compression=yes"}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "rpa.yaml")
+			body := "ssh:\n  user: deploy\n  host: bastion.example.com\n  identity_file: ~/.ssh/id_ed25519\n  remote_forwards:\n    - \"8080:localhost:8080\"\n" + tc.yaml
+			if err := os.WriteFile(path, []byte(body), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			cfg, err := Load(path)
+			if err != nil {
+				t.Fatalf("Load: %v", err)
+			}
+			if strings.Join(cfg.SSH.Options, "|") != strings.Join(tc.want, "|") {
+				t.Fatalf("options = %q, want %q", cfg.SSH.Options, tc.want)
+			}
+		})
+	}
+}
+
+func TestValidateRequestTTY(t *testing.T) {
+	cases := []struct {
+		tty     string
+		want    string
+		wantErr bool
+	}{
+		{tty: "", want: "no"},
+		{tty: "no", want: "no"},
+		{tty: "YES", want: "yes"},
+		{tty: " force ", want: "force"},
+		{tty: "auto", want: "auto"},
+		{tty: "always", wantErr: true},
+		{tty: "-t", wantErr: true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.tty, func(t *testing.T) {
+			cfg := &Config{}
+			cfg.SSH.Host = "bastion.example.com"
+			cfg.SSH.User = "deploy"
+			cfg.SSH.IdentityFile = "~/.ssh/id_ed25519"
+			cfg.SSH.RemoteForwards = []Forward{{Spec: "8080:localhost:8080"}}
+			cfg.SSH.RequestTTY = tc.tty
+			ApplyDefaults(cfg)
+			err := ValidateAgent(cfg)
+			if tc.wantErr {
+				if err == nil || !strings.Contains(err.Error(), "ssh.request_tty must be one of") {
+					t.Fatalf("ValidateAgent = %v, want the request_tty error", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ValidateAgent: %v", err)
+			}
+			if got := RequestTTY(cfg); got != tc.want {
+				t.Fatalf("RequestTTY = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,111 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Forward is one forward spec plus optional metadata. In YAML it is either a
+// plain spec string or a mapping with spec, name and comment. Only Spec is
+// passed to ssh.
+type Forward struct {
+	Spec    string `yaml:"spec"`
+	Name    string `yaml:"name,omitempty"`
+	Comment string `yaml:"comment,omitempty"`
+}
+
+type forwardRaw Forward
+
+func (f *Forward) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		*f = Forward{Spec: value.Value}
+		return nil
+	}
+	if value.Kind != yaml.MappingNode {
+		return fmt.Errorf("line %d: forward must be a spec string or a mapping with spec", value.Line)
+	}
+	var raw forwardRaw
+	if err := value.Decode(&raw); err != nil {
+		return err
+	}
+	if strings.TrimSpace(raw.Spec) == "" {
+		return fmt.Errorf("line %d: forward mapping is missing spec", value.Line)
+	}
+	*f = Forward(raw)
+	return nil
+}
+
+// MarshalYAML writes forwards without metadata as plain strings so existing
+// files keep their shape.
+func (f Forward) MarshalYAML() (interface{}, error) {
+	if f.Name == "" && f.Comment == "" {
+		return f.Spec, nil
+	}
+	return forwardRaw(f), nil
+}
+
+// Label returns the name and comment for display, or "" when neither is set.
+func (f Forward) Label() string {
+	switch {
+	case f.Name != "" && f.Comment != "":
+		return f.Name + " - " + f.Comment
+	case f.Name != "":
+		return f.Name
+	default:
+		return f.Comment
+	}
+}
+
+// ForwardSpecs returns the spec of every forward in order.
+func ForwardSpecs(forwards []Forward) []string {
+	out := make([]string, 0, len(forwards))
+	for _, forward := range forwards {
+		out = append(out, forward.Spec)
+	}
+	return out
+}
+
+// WithForwardSpecs builds a forward list for specs, keeping the metadata of
+// any spec already present in prev.
+func WithForwardSpecs(prev []Forward, specs []string) []Forward {
+	meta := make(map[string]Forward, len(prev))
+	for _, forward := range prev {
+		meta[strings.TrimSpace(forward.Spec)] = forward
+	}
+	out := make([]Forward, 0, len(specs))
+	for _, spec := range specs {
+		forward := meta[spec]
+		forward.Spec = spec
+		out = append(out, forward)
+	}
+	return out
+}
+
+// ForwardLabels maps each spec that has a name or comment to its label.
+func ForwardLabels(forwards []Forward) map[string]string {
+	out := make(map[string]string)
+	for _, forward := range forwards {
+		if label := forward.Label(); label != "" {
+			out[strings.TrimSpace(forward.Spec)] = label
+		}
+	}
+	return out
+}
+
+// ReplaceRemoteForward swaps old for next in place, keeping its position and
+// name/comment. It reports whether old was present.
+func ReplaceRemoteForward(cfg *Config, old, next string) bool {
+	if cfg == nil {
+		return false
+	}
+	old = strings.TrimSpace(old)
+	for i, forward := range cfg.SSH.RemoteForwards {
+		if strings.TrimSpace(forward.Spec) == old {
+			cfg.SSH.RemoteForwards[i].Spec = strings.TrimSpace(next)
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,139 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestForwardUnmarshal(t *testing.T) {
+	cases := []struct {
+		name    string
+		yaml    string
+		want    []Forward
+		wantErr string
+	}{
+		{name: "plain specs", yaml: "- 8080:localhost:80\n- \"0.0.0.0:2222:localhost:22\"\n", want: []Forward{{Spec: "8080:localhost:80"}, {Spec: "0.0.0.0:2222:localhost:22"}}},
+		{name: "mapping", yaml: "- spec: 8080:localhost:80\n  name: web\n  comment: staging dashboard\n", want: []Forward{{Spec: "8080:localhost:80", Name: "web", Comment: "staging dashboard"}}},
+		{name: "mixed", yaml: "- 8080:localhost:80\n- {spec: \"5432:db:5432\", name: db}\n", want: []Forward{{Spec: "8080:localhost:80"}, {Spec: "5432:db:5432", Name: "db"}}},
+		{name: "mapping without spec", yaml: "- name: web\n", wantErr: "line 1: forward mapping is missing spec"},
+		{name: "nested list", yaml: "- [8080, localhost, 80]\n", wantErr: "forward must be a spec string or a mapping with spec"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			var got []Forward
+			err := yaml.Unmarshal([]byte(tc.yaml), &got)
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("Unmarshal error = %v, want %q", err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unmarshal: %v", err)
+			}
+			if len(got) != len(tc.want) {
+				t.Fatalf("forwards = %+v, want %+v", got, tc.want)
+			}
+			for i := range got {
+				if got[i] != tc.want[i] {
+					t.Fatalf("forward %d = %+v, want %+v", i, got[i], tc.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestForwardMarshal(t *testing.T) {
+	cases := []struct {
+		name    string
+		forward Forward
+		want    string
+	}{
+		{name: "plain stays a string", forward: Forward{Spec: "8080:localhost:80"}, want: "- 8080:localhost:80\n"},
+		{name: "name", forward: Forward{Spec: "8080:localhost:80", Name: "web"}, want: "- spec: 8080:localhost:80\n  name: web\n"},
+		{name: "comment", forward: Forward{Spec: "8080:localhost:80", Comment: "staging"}, want: "- spec: 8080:localhost:80\n  comment: staging\n"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			data, err := yaml.Marshal([]Forward{tc.forward})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(data) != tc.want {
+				t.Fatalf("Marshal = %q, want %q", data, tc.want)
+			}
+			var back []Forward
+			if err := yaml.Unmarshal(data, &back); err != nil {
+				t.Fatal(err)
+			}
+			if len(back) != 1 || back[0] != tc.forward {
+				t.Fatalf("round trip = %+v, want %+v", back, tc.forward)
+			}
+		})
+	}
+}
+
+func TestForwardLabel(t *testing.T) {
+	cases := []struct {
+		forward Forward
+		want    string
+	}{
+		{forward: Forward{Spec: "8080:localhost:80"}, want: ""},
+		{forward: Forward{Spec: "8080:localhost:80", Name: "web"}, want: "web"},
+		{forward: Forward{Spec: "8080:localhost:80", Comment: "staging"}, want: "staging"},
+		{forward: Forward{Spec: "8080:localhost:80", Name: "web", Comment: "staging"}, want: "web - staging"},
+	}
+	for _, tc := range cases {
+		if got := tc.forward.Label(); got != tc.want {
+			t.Fatalf("%+v Label = %q, want %q", tc.forward, got, tc.want)
+		}
+	}
+	labels := ForwardLabels([]Forward{{Spec: " 8080:localhost:80 ", Name: "web"}, {Spec: "9090:localhost:90"}})
+	if len(labels) != 1 || labels["8080:localhost:80"] != "web" {
+		t.Fatalf("ForwardLabels = %v, want only the named forward", labels)
+	}
+}
+
+func TestWithForwardSpecs(t *testing.T) {
+	prev := []Forward{
+		{Spec: "8080:localhost:80", Name: "web"},
+		{Spec: "5432:db:5432", Comment: "primary"},
+	}
+	got := WithForwardSpecs(prev, []string{"5432:db:5432", "9090:localhost:90"})
+	want := []Forward{{Spec: "5432:db:5432", Comment: "primary"}, {Spec: "9090:localhost:90"}}
+	if len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+		t.Fatalf("WithForwardSpecs = %+v, want %+v", got, want)
+	}
+}
+
+func TestForwardMetadataSurvivesSave(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rpa.yaml")
+	content := "ssh:\n  host: bastion.example.com\n  user: deploy\n  remote_forwards:\n    - spec: \"0.0.0.0:2222:localhost:22\"\n      name: sshd\n      comment: office laptop\n    - \"0.0.0.0:8080:localhost:80\"\n"
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := NormalizeRemoteForwards(cfg); strings.Join(got, ",") != "0.0.0.0:2222:localhost:22,0.0.0.0:8080:localhost:80" {
+		t.Fatalf("NormalizeRemoteForwards = %q", got)
+	}
+	SetRemoteForwards(cfg, []string{"0.0.0.0:2222:localhost:22", "0.0.0.0:9090:localhost:90"})
+	if err := Save(path, cfg); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	reloaded, err := Load(path)
+	if err != nil {
+		t.Fatalf("reload: %v", err)
+	}
+	want := []Forward{{Spec: "0.0.0.0:2222:localhost:22", Name: "sshd", Comment: "office laptop"}, {Spec: "0.0.0.0:9090:localhost:90"}}
+	got := reloaded.SSH.RemoteForwards
+	if len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+		t.Fatalf("reloaded forwards = %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,87 @@
+// Package config resolves the top-level include key so several configs can share a base file.
+// The base is merged first and the including file's values override it key by key.
+
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const includeKey = "include"
+
+// loadNode reads path and returns its top-level mapping with any include
+// chain merged in. seen holds the absolute paths already on the chain.
+func loadNode(path string, seen map[string]bool) (*yaml.Node, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, fmt.Errorf("resolve config path: %w", err)
+	}
+	if seen[abs] {
+		return nil, fmt.Errorf("include cycle detected at %s", abs)
+	}
+	seen[abs] = true
+	defer delete(seen, abs)
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read config: %w", err)
+	}
+	var doc yaml.Node
+	if err := yaml.Unmarshal(normalizeYAML(data), &doc); err != nil {
+		return nil, fmt.Errorf("parse yaml: %w", err)
+	}
+	root := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+	if len(doc.Content) > 0 {
+		root = doc.Content[0]
+	}
+	if root.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("parse yaml: %s: top level must be a mapping", path)
+	}
+
+	include := mappingValue(root, includeKey)
+	if include == nil {
+		return root, nil
+	}
+	deleteMappingKey(root, includeKey)
+	target := strings.TrimSpace(include.Value)
+	if include.Kind != yaml.ScalarNode || target == "" {
+		return nil, fmt.Errorf("%s: include must be a file path", path)
+	}
+	target, err = expandHome(target)
+	if err != nil {
+		return nil, err
+	}
+	if !filepath.IsAbs(target) {
+		target = filepath.Join(filepath.Dir(abs), target)
+	}
+	base, err := loadNode(target, seen)
+	if err != nil {
+		return nil, fmt.Errorf("include %s: %w", target, err)
+	}
+	return mergeNodes(base, root), nil
+}
+
+// mergeNodes overlays over onto base. Mappings merge recursively; any other
+// value in over replaces the base value.
+func mergeNodes(base, over *yaml.Node) *yaml.Node {
+	if base.Kind != yaml.MappingNode || over.Kind != yaml.MappingNode {
+		return over
+	}
+	out := &yaml.Node{Kind: yaml.MappingNode, Tag: base.Tag, Style: base.Style}
+	out.Content = append(out.Content, base.Content...)
+	for i := 0; i+1 < len(over.Content); i += 2 {
+		key := over.Content[i].Value
+		next := over.Content[i+1]
+		if current := mappingValue(out, key); current != nil {
+			setMappingValue(out, key, mergeNodes(current, next))
+			continue
+		}
+		out.Content = append(out.Content, over.Content[i], next)
+	}
+	return out
+}
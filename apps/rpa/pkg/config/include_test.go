@@ -0,0 +1,156 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadInclude(t *testing.T) {
+	const base = "ssh:\n  host: bastion.example.com\n  user: deploy\n  port: 2200\n  options:\n    - BatchMode=yes\n    - Compression=yes\nlogging:\n  level: debug\n"
+	cases := []struct {
+		name    string
+		files   map[string]string
+		check   func(t *testing.T, cfg *Config)
+		wantErr string
+	}{
+		{
+			name: "base values fill in",
+			files: map[string]string{
+				"rpa.yaml":  "include: base.yaml\nssh:\n  remote_forwards:\n    - \"0.0.0.0:2222:localhost:22\"\n",
+				"base.yaml": base,
+			},
+			check: func(t *testing.T, cfg *Config) {
+				if cfg.SSH.Host != "bastion.example.com" || cfg.SSH.User != "deploy" || cfg.SSH.Port != 2200 {
+					t.Fatalf("ssh = %s@%s:%d, want the base values", cfg.SSH.User, cfg.SSH.Host, cfg.SSH.Port)
+				}
+				if cfg.Logging.Level != "debug" {
+					t.Fatalf("logging.level = %q, want debug", cfg.Logging.Level)
+				}
+				if got := ForwardSpecs(cfg.SSH.RemoteForwards); len(got) != 1 {
+					t.Fatalf("remote forwards = %q", got)
+				}
+			},
+		},
+		{
+			name: "local scalars override key by key",
+			files: map[string]string{
+				"rpa.yaml":  "include: base.yaml\nssh:\n  user: ops\n",
+				"base.yaml": base,
+			},
+			check: func(t *testing.T, cfg *Config) {
+				if cfg.SSH.User != "ops" || cfg.SSH.Host != "bastion.example.com" {
+					t.Fatalf("ssh = %s@%s, want ops@bastion.example.com", cfg.SSH.User, cfg.SSH.Host)
+				}
+			},
+		},
+		{
+			name: "local lists replace base lists",
+			files: map[string]string{
+				"rpa.yaml":  "include: base.yaml\nssh:\n  options:\n    - ServerAliveInterval=15\n",
+				"base.yaml": base,
+			},
+			check: func(t *testing.T, cfg *Config) {
+				if len(cfg.SSH.Options) == 0 || cfg.SSH.Options[0] != "ServerAliveInterval=15" {
+					t.Fatalf("options = %q, want the local list first", cfg.SSH.Options)
+				}
+				for _, opt := range cfg.SSH.Options {
+					if opt == "BatchMode=yes" {
+						t.Fatalf("options = %q kept a base entry", cfg.SSH.Options)
+					}
+				}
+			},
+		},
+		{
+			name: "chain resolves relative to each file",
+			files: map[string]string{
+				"rpa.yaml":           "include: shared/team.yaml\nssh:\n  user: ops\n",
+				"shared/team.yaml":   "include: ../org.yaml\nssh:\n  port: 2201\n",
+				"org.yaml":           base,
+				"shared/unused.yaml": "ssh:\n  host: wrong.example.com\n",
+			},
+			check: func(t *testing.T, cfg *Config) {
+				if cfg.SSH.User != "ops" || cfg.SSH.Port != 2201 || cfg.SSH.Host != "bastion.example.com" {
+					t.Fatalf("ssh = %s@%s:%d, want ops@bastion.example.com:2201", cfg.SSH.User, cfg.SSH.Host, cfg.SSH.Port)
+				}
+			},
+		},
+		{
+			name:    "self include",
+			files:   map[string]string{"rpa.yaml": "include: rpa.yaml\n"},
+			wantErr: "include cycle detected",
+		},
+		{
+			name: "two file cycle",
+			files: map[string]string{
+				"rpa.yaml": "include: a.yaml\n",
+				"a.yaml":   "include: b.yaml\n",
+				"b.yaml":   "include: ./a.yaml\n",
+			},
+			wantErr: "include cycle detected",
+		},
+		{
+			name:    "missing base",
+			files:   map[string]string{"rpa.yaml": "include: nowhere.yaml\n"},
+			wantErr: "nowhere.yaml",
+		},
+		{
+			name:    "include is not a path",
+			files:   map[string]string{"rpa.yaml": "include:\n  - a.yaml\n"},
+			wantErr: "include must be a file path",
+		},
+		{
+			name: "base top level is not a mapping",
+			files: map[string]string{
+				"rpa.yaml":  "include: base.yaml\n",
+				"base.yaml": "- just\n- a list\n",
+			},
+			wantErr: "top level must be a mapping",
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for name, content := range tc.files {
+				path := filepath.Join(dir, name)
+				if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+					t.Fatal(err)
+				}
+			}
+			cfg, err := Load(filepath.Join(dir, "rpa.yaml"))
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("Load error = %v, want one containing %q", err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Load: %v", err)
+			}
+			tc.check(t, cfg)
+		})
+	}
+}
+
+func TestLoadIncludeHome(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	if err := os.WriteFile(filepath.Join(home, "base.yaml"), []byte("ssh:\n  host: bastion.example.com\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "rpa.yaml")
+	if err := os.WriteFile(path, []byte("include: ~/base.yaml\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if cfg.SSH.Host != "bastion.example.com" {
+		t.Fatalf("ssh.host = %q, want the value from ~/base.yaml", cfg.SSH.Host)
+	}
+}
@@ -0,0 +1,75 @@
+// Package config maps renamed keys onto their replacements before decoding.
+// Old files keep working and Load records a deprecation note for each key moved.
+
+package config
+
+import (
+	"fmt"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// keyRename records a key that moved. Both paths are dotted from the top
+// level, e.g. "ssh.check_sec".
+type keyRename struct {
+	from string
+	to   string
+}
+
+// renamedKeys lists every config key rename; add an entry when a key is
+// renamed so files written for older releases still load. No key has been
+// renamed yet.
+var renamedKeys []keyRename
+
+// migrateKeys moves each renamed key in root to its new path and returns one
+// deprecation note per key found. When both the old and the new key are set,
+// the new key wins and the old value is dropped.
+func migrateKeys(root *yaml.Node, renames []keyRename) []string {
+	var notes []string
+	for _, rename := range renames {
+		fromParent, fromKey := splitKeyPath(rename.from)
+		parent := lookupMapping(root, fromParent, false)
+		value := mappingValue(parent, fromKey)
+		if value == nil {
+			continue
+		}
+		deleteMappingKey(parent, fromKey)
+		toParent, toKey := splitKeyPath(rename.to)
+		target := lookupMapping(root, toParent, true)
+		if target == nil {
+			notes = append(notes, fmt.Sprintf("%s is deprecated and was ignored: %s is not a mapping", rename.from, strings.Join(toParent, ".")))
+			continue
+		}
+		if mappingValue(target, toKey) != nil {
+			notes = append(notes, fmt.Sprintf("%s is deprecated and was ignored because %s is also set", rename.from, rename.to))
+			continue
+		}
+		setMappingValue(target, toKey, value)
+		notes = append(notes, fmt.Sprintf("%s is deprecated; use %s", rename.from, rename.to))
+	}
+	return notes
+}
+
+func splitKeyPath(path string) ([]string, string) {
+	parts := strings.Split(path, ".")
+	return parts[:len(parts)-1], parts[len(parts)-1]
+}
+
+// lookupMapping walks path from root, creating empty mappings on the way when
+// create is set. It returns nil when a step is missing or not a mapping.
+func lookupMapping(root *yaml.Node, path []string, create bool) *yaml.Node {
+	current := root
+	for _, key := range path {
+		next := mappingValue(current, key)
+		if next == nil && create && current != nil && current.Kind == yaml.MappingNode {
+			next = &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+			setMappingValue(current, key, next)
+		}
+		if next == nil || next.Kind != yaml.MappingNode {
+			return nil
+		}
+		current = next
+	}
+	return current
+}
@@ -0,0 +1,170 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testRenames stands in for a future rename; no shipped key has moved yet.
+var testRenames = []keyRename{{from: "ssh.check_interval_sec", to: "ssh.check_sec"}}
+
+// withRenames swaps the rename registry for the length of the test.
+func withRenames(t *testing.T, renames []keyRename) {
+	t.Helper()
+	saved := renamedKeys
+	renamedKeys = renames
+	t.Cleanup(func() { renamedKeys = saved })
+}
+
+func TestLoadMigratesRenamedKeys(t *testing.T) {
+	withRenames(t, testRenames)
+	cases := []struct {
+		name      string
+		yaml      string
+		wantSec   int
+		wantNotes []string
+	}{
+		{
+			name:      "old key is mapped",
+			yaml:      "ssh:\n  host: example.com\n  check_interval_sec: 9\n",
+			wantSec:   9,
+			wantNotes: []string{"ssh.check_interval_sec is deprecated; use ssh.check_sec"},
+		},
+		{
+			name:      "new key wins over old",
+			yaml:      "ssh:\n  check_interval_sec: 9\n  check_sec: 12\n",
+			wantSec:   12,
+			wantNotes: []string{"ssh.check_interval_sec is deprecated and was ignored because ssh.check_sec is also set"},
+		},
+		{
+			name:    "new key alone has no note",
+			yaml:    "ssh:\n  check_sec: 7\n",
+			wantSec: 7,
+		},
+		{
+			name:    "unset falls back to the default",
+			yaml:    "ssh:\n  host: example.com\n",
+			wantSec: 5,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "rpa.yaml")
+			if err := os.WriteFile(path, []byte(tc.yaml), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			for _, load := range []func(string) (*Config, error){Load, LoadStrict} {
+				cfg, err := load(path)
+				if err != nil {
+					t.Fatalf("load: %v", err)
+				}
+				if cfg.SSH.TCPCheckSec != tc.wantSec {
+					t.Errorf("check_sec = %d, want %d", cfg.SSH.TCPCheckSec, tc.wantSec)
+				}
+				if len(cfg.Deprecations) != len(tc.wantNotes) {
+					t.Fatalf("deprecations = %q, want %q", cfg.Deprecations, tc.wantNotes)
+				}
+				for i, note := range tc.wantNotes {
+					if cfg.Deprecations[i] != note {
+						t.Errorf("deprecation[%d] = %q, want %q", i, cfg.Deprecations[i], note)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestShippedKeysAreNotRenamed(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rpa.yaml")
+	if err := os.WriteFile(path, []byte("ssh:\n  host: example.com\n  check_sec: 9\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := LoadStrict(path)
+	if err != nil {
+		t.Fatalf("LoadStrict: %v", err)
+	}
+	if cfg.SSH.TCPCheckSec != 9 || len(cfg.Deprecations) != 0 {
+		t.Fatalf("check_sec = %d, deprecations %q; want 9 and none", cfg.SSH.TCPCheckSec, cfg.Deprecations)
+	}
+}
+
+func TestSaveDropsRenamedKeys(t *testing.T) {
+	withRenames(t, testRenames)
+	cases := []struct {
+		name     string
+		yaml     string
+		edit     func(cfg *Config)
+		want     []string
+		wantGone []string
+		wantSec  int
+	}{
+		{
+			name:     "new key set over an old file",
+			yaml:     "ssh:\n  host: example.com\n  check_interval_sec: 9\n",
+			edit:     func(cfg *Config) { cfg.SSH.TCPCheckSec = 30 },
+			want:     []string{"check_sec: 30"},
+			wantGone: []string{"check_interval_sec"},
+			wantSec:  30,
+		},
+		{
+			name:     "both keys in the file",
+			yaml:     "ssh:\n  host: example.com\n  check_interval_sec: 9\n  check_sec: 12\n",
+			edit:     func(cfg *Config) { cfg.SSH.TCPCheckSec = 20 },
+			want:     []string{"check_sec: 20"},
+			wantGone: []string{"check_interval_sec"},
+			wantSec:  20,
+		},
+		{
+			name:    "untouched old key is kept",
+			yaml:    "ssh:\n  host: example.com\n  check_interval_sec: 9\n",
+			edit:    func(cfg *Config) { cfg.SSH.User = "deploy" },
+			want:    []string{"check_interval_sec: 9", "user: deploy"},
+			wantSec: 9,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "rpa.yaml")
+			if err := os.WriteFile(path, []byte(tc.yaml), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			cfg, err := Load(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tc.edit(cfg)
+			if err := Save(path, cfg); err != nil {
+				t.Fatalf("Save: %v", err)
+			}
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			out := string(data)
+			for _, want := range tc.want {
+				if !strings.Contains(out, want) {
+					t.Fatalf("saved config lacks %q:\n%s", want, out)
+				}
+			}
+			for _, gone := range tc.wantGone {
+				if strings.Contains(out, gone) {
+					t.Fatalf("saved config still has %q:\n%s", gone, out)
+				}
+			}
+			reloaded, err := Load(path)
+			if err != nil {
+				t.Fatalf("reload: %v", err)
+			}
+			if reloaded.SSH.TCPCheckSec != tc.wantSec {
+				t.Fatalf("reloaded check_sec = %d, want %d", reloaded.SSH.TCPCheckSec, tc.wantSec)
+			}
+			for _, note := range reloaded.Deprecations {
+				if strings.Contains(note, "also set") {
+					t.Fatalf("reload warns %q", note)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,176 @@
+// Package config writes config files back to disk.
+// Save edits only the values that changed so hand-written comments and key order survive.
+
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// legacyKeys lists keys that are read for compatibility but no longer
+// written; they are dropped when their replacement is updated.
+var legacyKeys = map[string]string{
+	"local_forwards": "local_forward",
+}
+
+func Save(path string, cfg *Config) error {
+	if strings.TrimSpace(path) == "" {
+		return errors.New("config path is empty")
+	}
+	data, err := marshalPreserving(path, cfg)
+	if err != nil {
+		return err
+	}
+	dir := filepath.Dir(path)
+	if dir != "." && dir != "" {
+		if err := os.MkdirAll(dir, 0o700); err != nil {
+			return fmt.Errorf("create config dir: %w", err)
+		}
+	}
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		return fmt.Errorf("write config: %w", err)
+	}
+	return nil
+}
+
+// marshalPreserving applies the differences between the file on disk and cfg
+// to the existing YAML node tree. Without a usable existing document it falls
+// back to a plain marshal.
+func marshalPreserving(path string, cfg *Config) ([]byte, error) {
+	fresh, err := yaml.Marshal(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("marshal config: %w", err)
+	}
+	existing, err := os.ReadFile(path)
+	if err != nil || len(bytes.TrimSpace(existing)) == 0 {
+		return fresh, nil
+	}
+	existing = normalizeYAML(existing)
+	var doc yaml.Node
+	if err := yaml.Unmarshal(existing, &doc); err != nil {
+		return fresh, nil
+	}
+	if len(doc.Content) == 0 || doc.Content[0].Kind != yaml.MappingNode {
+		return fresh, nil
+	}
+	// Compare against the fully resolved file (includes and defaults applied)
+	// so inherited values are not copied into the including file.
+	prev, err := Load(path)
+	if err != nil {
+		return fresh, nil
+	}
+
+	var before, after yaml.Node
+	if err := before.Encode(prev); err != nil {
+		return fresh, nil
+	}
+	if err := after.Encode(cfg); err != nil {
+		return nil, fmt.Errorf("marshal config: %w", err)
+	}
+	mergeChanges(doc.Content[0], &before, &after)
+	dropRenamedKeys(doc.Content[0], renamedKeys)
+
+	data, err := yaml.Marshal(&doc)
+	if err != nil {
+		return nil, fmt.Errorf("marshal config: %w", err)
+	}
+	return data, nil
+}
+
+// dropRenamedKeys removes the old key of each rename whose new key is in doc,
+// so a save that writes the new key does not leave both behind.
+func dropRenamedKeys(doc *yaml.Node, renames []keyRename) {
+	for _, rename := range renames {
+		toParent, toKey := splitKeyPath(rename.to)
+		if mappingValue(lookupMapping(doc, toParent, false), toKey) == nil {
+			continue
+		}
+		fromParent, fromKey := splitKeyPath(rename.from)
+		if parent := lookupMapping(doc, fromParent, false); parent != nil {
+			deleteMappingKey(parent, fromKey)
+		}
+	}
+}
+
+// mergeChanges updates doc with every key whose value differs between before
+// and after, recursing into mappings so untouched siblings keep their comments.
+func mergeChanges(doc, before, after *yaml.Node) {
+	for i := 0; i+1 < len(after.Content); i += 2 {
+		key := after.Content[i].Value
+		next := after.Content[i+1]
+		prev := mappingValue(before, key)
+		if prev != nil && nodesEqual(prev, next) {
+			continue
+		}
+		current := mappingValue(doc, key)
+		if current != nil && prev != nil &&
+			current.Kind == yaml.MappingNode && prev.Kind == yaml.MappingNode && next.Kind == yaml.MappingNode {
+			mergeChanges(current, prev, next)
+			continue
+		}
+		setMappingValue(doc, key, next)
+		if legacy, ok := legacyKeys[key]; ok {
+			deleteMappingKey(doc, legacy)
+		}
+	}
+	for i := 0; i+1 < len(before.Content); i += 2 {
+		key := before.Content[i].Value
+		if mappingValue(after, key) == nil {
+			deleteMappingKey(doc, key)
+		}
+	}
+}
+
+func mappingValue(node *yaml.Node, key string) *yaml.Node {
+	if node == nil || node.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
+
+func setMappingValue(node *yaml.Node, key string, value *yaml.Node) {
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value != key {
+			continue
+		}
+		old := node.Content[i+1]
+		value.LineComment = old.LineComment
+		value.FootComment = old.FootComment
+		node.Content[i+1] = value
+		return
+	}
+	node.Content = append(node.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key}, value)
+}
+
+func deleteMappingKey(node *yaml.Node, key string) {
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			node.Content = append(node.Content[:i], node.Content[i+2:]...)
+			return
+		}
+	}
+}
+
+func nodesEqual(a, b *yaml.Node) bool {
+	if a.Kind != b.Kind || a.Tag != b.Tag || a.Value != b.Value || len(a.Content) != len(b.Content) {
+		return false
+	}
+	for i := range a.Content {
+		if !nodesEqual(a.Content[i], b.Content[i]) {
+			return false
+		}
+	}
+	return true
+}
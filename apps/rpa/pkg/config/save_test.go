@@ -0,0 +1,146 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const annotatedConfig = `# work laptop tunnel
+ssh:
+  # bastion in the office
+  host: bastion.example.com
+  user: deploy # shared account
+  port: 22
+  remote_forwards:
+    - "0.0.0.0:2222:localhost:22" # sshd
+agent:
+  # keep retrying
+  restart_policy: always
+`
+
+func TestSavePreservesComments(t *testing.T) {
+	cases := []struct {
+		name     string
+		edit     func(cfg *Config)
+		want     []string
+		wantGone []string
+	}{
+		{
+			name: "no change",
+			edit: func(*Config) {},
+			want: []string{"# work laptop tunnel", "# bastion in the office", "user: deploy # shared account", "# keep retrying"},
+		},
+		{
+			name: "scalar keeps its line comment",
+			edit: func(cfg *Config) { cfg.SSH.User = "ops" },
+			want: []string{"# work laptop tunnel", "# bastion in the office", "user: ops # shared account"},
+		},
+		{
+			name: "sibling comments survive a nested change",
+			edit: func(cfg *Config) { cfg.SSH.Port = 2200 },
+			want: []string{"# bastion in the office", "port: 2200", "# keep retrying"},
+		},
+		{
+			name: "new key is appended",
+			edit: func(cfg *Config) { cfg.SSH.Options = []string{"BatchMode=yes"} },
+			want: []string{"# bastion in the office", "options:", "- BatchMode=yes"},
+		},
+		{
+			name:     "replaced list drops the old entry",
+			edit:     func(cfg *Config) { SetRemoteForwards(cfg, []string{"0.0.0.0:8080:localhost:80"}) },
+			want:     []string{"# work laptop tunnel", "0.0.0.0:8080:localhost:80"},
+			wantGone: []string{"0.0.0.0:2222:localhost:22"},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "rpa.yaml")
+			if err := os.WriteFile(path, []byte(annotatedConfig), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			cfg, err := Load(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tc.edit(cfg)
+			if err := Save(path, cfg); err != nil {
+				t.Fatalf("Save: %v", err)
+			}
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			out := string(data)
+			for _, want := range tc.want {
+				if !strings.Contains(out, want) {
+					t.Fatalf("saved config lacks %q:\n%s", want, out)
+				}
+			}
+			for _, gone := range tc.wantGone {
+				if strings.Contains(out, gone) {
+					t.Fatalf("saved config still has %q:\n%s", gone, out)
+				}
+			}
+			// Key order is kept: ssh stays ahead of agent, host ahead of user.
+			if !inOrder(out, "ssh:", "host:", "user:", "agent:") {
+				t.Fatalf("saved config reordered keys:\n%s", out)
+			}
+			// Defaults filled in by Load are not written back.
+			if strings.Contains(out, "check_sec") {
+				t.Fatalf("saved config gained defaults:\n%s", out)
+			}
+			reloaded, err := Load(path)
+			if err != nil {
+				t.Fatalf("reload: %v", err)
+			}
+			if got, want := ForwardSpecs(reloaded.SSH.RemoteForwards), ForwardSpecs(cfg.SSH.RemoteForwards); strings.Join(got, ",") != strings.Join(want, ",") {
+				t.Fatalf("reloaded forwards = %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestSaveLeavesIncludedValues(t *testing.T) {
+	dir := t.TempDir()
+	base := filepath.Join(dir, "base.yaml")
+	path := filepath.Join(dir, "rpa.yaml")
+	if err := os.WriteFile(base, []byte("ssh:\n  host: bastion.example.com\n  user: deploy\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte("include: base.yaml\n# mine\nssh:\n  port: 22\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.SSH.Port = 2200
+	if err := Save(path, cfg); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := string(data)
+	if !strings.Contains(out, "include: base.yaml") || !strings.Contains(out, "# mine") || !strings.Contains(out, "port: 2200") {
+		t.Fatalf("saved config lost its own content:\n%s", out)
+	}
+	if strings.Contains(out, "bastion.example.com") {
+		t.Fatalf("saved config copied an included value:\n%s", out)
+	}
+}
+
+func inOrder(s string, parts ...string) bool {
+	at := 0
+	for _, part := range parts {
+		idx := strings.Index(s[at:], part)
+		if idx < 0 {
+			return false
+		}
+		at += idx + len(part)
+	}
+	return true
+}
@@ -0,0 +1,77 @@
+// Package config can reject keys that do not match any field. yaml.v3 cannot
+// do this for us: KnownFields is lost inside custom unmarshalers such as
+// ClientConfig's, so the check walks the node tree against the struct types.
+
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// strictTypes swaps a type for the one its UnmarshalYAML actually decodes,
+// so accepted aliases such as client.local_forward are not reported.
+var strictTypes = map[reflect.Type]reflect.Type{
+	reflect.TypeOf(ClientConfig{}): reflect.TypeOf(clientConfigRaw{}),
+}
+
+// unknownKeys returns the dotted path of every mapping key under node that
+// matches no yaml field of t.
+func unknownKeys(node *yaml.Node, t reflect.Type, prefix string) []string {
+	if node == nil {
+		return nil
+	}
+	if alt, ok := strictTypes[t]; ok {
+		t = alt
+	}
+	var out []string
+	switch t.Kind() {
+	case reflect.Pointer:
+		return unknownKeys(node, t.Elem(), prefix)
+	case reflect.Struct:
+		if node.Kind != yaml.MappingNode {
+			return nil
+		}
+		fields := yamlFields(t)
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key := node.Content[i].Value
+			if key == "<<" {
+				continue
+			}
+			field, ok := fields[key]
+			if !ok {
+				out = append(out, prefix+key)
+				continue
+			}
+			out = append(out, unknownKeys(node.Content[i+1], field, prefix+key+".")...)
+		}
+	case reflect.Slice:
+		if node.Kind != yaml.SequenceNode {
+			return nil
+		}
+		base := strings.TrimSuffix(prefix, ".")
+		for i, item := range node.Content {
+			out = append(out, unknownKeys(item, t.Elem(), fmt.Sprintf("%s[%d].", base, i))...)
+		}
+	}
+	return out
+}
+
+func yamlFields(t reflect.Type) map[string]reflect.Type {
+	fields := make(map[string]reflect.Type, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := strings.Split(field.Tag.Get("yaml"), ",")[0]
+		if tag == "-" || !field.IsExported() {
+			continue
+		}
+		if tag == "" {
+			tag = strings.ToLower(field.Name)
+		}
+		fields[tag] = field.Type
+	}
+	return fields
+}
@@ -0,0 +1,75 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadStrict(t *testing.T) {
+	withRenames(t, testRenames)
+	const ssh = "ssh:\n  host: bastion.example.com\n  user: deploy\n  remote_forwards:\n    - \"0.0.0.0:2222:localhost:22\"\n"
+	cases := []struct {
+		name    string
+		files   map[string]string
+		wantErr string
+		check   func(t *testing.T, cfg *Config)
+	}{
+		{name: "known keys", files: map[string]string{"rpa.yaml": ssh + "agent:\n  restart_policy: always\n  restart:\n    min_delay_ms: 100\nlogging:\n  level: debug\n"}},
+		{name: "top level typo", files: map[string]string{"rpa.yaml": ssh + "agnet:\n  restart_policy: always\n"}, wantErr: "unknown config keys: agnet"},
+		{name: "nested typo", files: map[string]string{"rpa.yaml": ssh + "agent:\n  restart:\n    min_delay: 100\n"}, wantErr: "unknown config keys: agent.restart.min_delay"},
+		{name: "every typo is listed", files: map[string]string{"rpa.yaml": ssh + "  hots: x\nlogging:\n  levle: debug\n"}, wantErr: "unknown config keys: ssh.hots, logging.levle"},
+		{name: "forward mapping typo", files: map[string]string{"rpa.yaml": "ssh:\n  remote_forwards:\n    - spec: \"0.0.0.0:2222:localhost:22\"\n      nmae: sshd\n"}, wantErr: "unknown config keys: ssh.remote_forwards[0].nmae"},
+		{name: "forward mapping", files: map[string]string{"rpa.yaml": "ssh:\n  remote_forwards:\n    - spec: \"0.0.0.0:2222:localhost:22\"\n      name: sshd\n      comment: laptop\n"}},
+		{
+			name:  "local_forward alias",
+			files: map[string]string{"rpa.yaml": ssh + "client:\n  local_forward: \"5432:db.internal:5432\"\n  local_forwards:\n    - \"6379:cache.internal:6379\"\n"},
+			check: func(t *testing.T, cfg *Config) {
+				if got := NormalizeLocalForwards(cfg); strings.Join(got, ",") != "5432:db.internal:5432,6379:cache.internal:6379" {
+					t.Fatalf("local forwards = %q", got)
+				}
+			},
+		},
+		{name: "client typo", files: map[string]string{"rpa.yaml": ssh + "client:\n  local_forwrds:\n    - \"5432:db.internal:5432\"\n"}, wantErr: "unknown config keys: client.local_forwrds"},
+		{
+			name:  "renamed key is migrated first",
+			files: map[string]string{"rpa.yaml": ssh + "  check_interval_sec: 15\n"},
+			check: func(t *testing.T, cfg *Config) {
+				if cfg.SSH.TCPCheckSec != 15 {
+					t.Fatalf("ssh.check_sec = %d, want 15", cfg.SSH.TCPCheckSec)
+				}
+			},
+		},
+		{name: "typo in an included file", files: map[string]string{"rpa.yaml": "include: base.yaml\n" + ssh, "base.yaml": "logging:\n  levle: debug\n"}, wantErr: "unknown config keys: logging.levle"},
+		{name: "map values are free form", files: map[string]string{"rpa.yaml": ssh + "  set_env:\n    ANY_NAME: x\n"}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for name, content := range tc.files {
+				if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o600); err != nil {
+					t.Fatal(err)
+				}
+			}
+			path := filepath.Join(dir, "rpa.yaml")
+			// Plain Load always ignores unknown keys.
+			if _, err := Load(path); err != nil {
+				t.Fatalf("Load: %v", err)
+			}
+			cfg, err := LoadStrict(path)
+			if tc.wantErr != "" {
+				if err == nil || err.Error() != tc.wantErr {
+					t.Fatalf("LoadStrict error = %v, want %q", err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("LoadStrict: %v", err)
+			}
+			if tc.check != nil {
+				tc.check(t, cfg)
+			}
+		})
+	}
+}
@@ -0,0 +1,82 @@
+package cron
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNext(t *testing.T) {
+	utc := func(year int, month time.Month, day, hour, minute, sec int) time.Time {
+		return time.Date(year, month, day, hour, minute, sec, 0, time.UTC)
+	}
+	cases := []struct {
+		name string
+		expr string
+		from time.Time
+		want time.Time
+	}{
+		{name: "daily before the hour", expr: "0 4 * * *", from: utc(2024, 1, 1, 3, 59, 0), want: utc(2024, 1, 1, 4, 0, 0)},
+		{name: "strictly after a match", expr: "0 4 * * *", from: utc(2024, 1, 1, 4, 0, 0), want: utc(2024, 1, 2, 4, 0, 0)},
+		{name: "step with seconds dropped", expr: "*/15 * * * *", from: utc(2024, 1, 1, 10, 7, 30), want: utc(2024, 1, 1, 10, 15, 0)},
+		{name: "sunday as 0", expr: "0 0 * * 0", from: utc(2024, 1, 1, 0, 0, 0), want: utc(2024, 1, 7, 0, 0, 0)},
+		{name: "sunday as 7", expr: "0 0 * * 7", from: utc(2024, 1, 1, 0, 0, 0), want: utc(2024, 1, 7, 0, 0, 0)},
+		{name: "restricted days match either (weekday)", expr: "0 0 1 * 1", from: utc(2024, 1, 2, 0, 0, 0), want: utc(2024, 1, 8, 0, 0, 0)},
+		{name: "restricted days match either (date)", expr: "0 0 1 * 1", from: utc(2024, 1, 29, 12, 0, 0), want: utc(2024, 2, 1, 0, 0, 0)},
+		{name: "range with step", expr: "0 0 1-5/2 * *", from: utc(2024, 1, 1, 0, 0, 0), want: utc(2024, 1, 3, 0, 0, 0)},
+		{name: "list", expr: "0 6,18 * * *", from: utc(2024, 1, 1, 7, 0, 0), want: utc(2024, 1, 1, 18, 0, 0)},
+		{name: "month rollover", expr: "0 12 * 6 *", from: utc(2024, 1, 1, 0, 0, 0), want: utc(2024, 6, 1, 12, 0, 0)},
+		{name: "leap day", expr: "30 2 29 2 *", from: utc(2024, 3, 1, 0, 0, 0), want: utc(2028, 2, 29, 2, 30, 0)},
+		{name: "year rollover", expr: "0 0 1 1 *", from: utc(2024, 12, 31, 23, 59, 0), want: utc(2025, 1, 1, 0, 0, 0)},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			s, err := Parse(tc.expr)
+			if err != nil {
+				t.Fatalf("Parse(%q): %v", tc.expr, err)
+			}
+			if got := s.Next(tc.from); !got.Equal(tc.want) {
+				t.Fatalf("Next(%s) = %s, want %s", tc.from, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestNextSkipsMissingDSTHour(t *testing.T) {
+	loc, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skipf("no tzdata: %v", err)
+	}
+	s, err := Parse("30 2 * * *")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 2:30 does not exist on 2024-03-10, so the next run is the day after.
+	from := time.Date(2024, 3, 10, 0, 0, 0, 0, loc)
+	want := time.Date(2024, 3, 11, 2, 30, 0, 0, loc)
+	if got := s.Next(from); !got.Equal(want) {
+		t.Fatalf("Next = %s, want %s", got, want)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	cases := []struct {
+		name string
+		expr string
+	}{
+		{name: "too few fields", expr: "0 4 * *"},
+		{name: "too many fields", expr: "0 4 * * * *"},
+		{name: "minute out of range", expr: "60 * * * *"},
+		{name: "day-of-month zero", expr: "0 0 0 * *"},
+		{name: "backwards range", expr: "5-1 * * * *"},
+		{name: "zero step", expr: "*/0 * * * *"},
+		{name: "names unsupported", expr: "0 0 * * MON"},
+		{name: "never matches", expr: "0 0 30 2 *"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := Parse(tc.expr); err == nil {
+				t.Fatalf("Parse(%q) succeeded, want an error", tc.expr)
+			}
+		})
+	}
+}
@@ -0,0 +1,152 @@
+package agent
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"reverse-proxy-agent/pkg/config"
+)
+
+func TestQueryStaleSocket(t *testing.T) {
+	cases := []struct {
+		name  string
+		setup func(t *testing.T, path string)
+		// pid is what the kind's pid file holds: "self" (a live owner),
+		// "dead", "garbled", or "" for no file.
+		pid         string
+		wantErr     error
+		wantMessage string
+		wantRemoved bool
+	}{
+		{name: "no socket", wantErr: ErrAgentNotRunning},
+		{name: "stale socket", setup: staleSocket, wantErr: ErrAgentNotRunning, wantMessage: "removed stale socket", wantRemoved: true},
+		{name: "regular file is kept", setup: plainFile, wantErr: ErrAgentSocketRefused},
+		{name: "dead owner", setup: staleSocket, pid: "dead", wantErr: ErrAgentNotRunning, wantMessage: "removed stale socket", wantRemoved: true},
+		{name: "live owner keeps its socket", setup: staleSocket, pid: "self", wantErr: ErrAgentSocketRefused, wantMessage: "is running but not accepting connections"},
+		{name: "unknown owner keeps the socket", setup: staleSocket, pid: "garbled", wantErr: ErrAgentNotRunning, wantMessage: "left for the next start"},
+		{name: "live socket", setup: func(t *testing.T, path string) { serve(t, path) }},
+		{
+			name: "server starting during the retry",
+			setup: func(t *testing.T, path string) {
+				staleSocket(t, path)
+				go func() {
+					time.Sleep(staleRetryDelay / 4)
+					os.Remove(path)
+					serve(t, path)
+				}()
+			},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			home, err := os.MkdirTemp("", "rpa")
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() { os.RemoveAll(home) })
+			config.SetHomeDir(home)
+			t.Cleanup(func() { config.SetHomeDir("") })
+			cfg := &config.Config{}
+			cfg.Agent.LaunchdLabel = "com.rpa.agent"
+			path, err := config.SocketPath(cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tc.pid != "" {
+				writePID(t, cfg, tc.pid)
+			}
+			if tc.setup != nil {
+				tc.setup(t, path)
+			}
+
+			resp, err := Query(cfg, "ping")
+			if tc.wantErr == nil {
+				if err != nil || !resp.OK {
+					t.Fatalf("Query = %+v, %v, want ok", resp, err)
+				}
+				return
+			}
+			if !errors.Is(err, tc.wantErr) {
+				t.Fatalf("Query error = %v, want %v", err, tc.wantErr)
+			}
+			if !strings.Contains(err.Error(), tc.wantMessage) {
+				t.Fatalf("Query error = %q, want it to mention %q", err, tc.wantMessage)
+			}
+			_, statErr := os.Lstat(path)
+			if removed := os.IsNotExist(statErr); tc.setup != nil && removed != tc.wantRemoved {
+				t.Fatalf("socket file removed = %v, want %v", removed, tc.wantRemoved)
+			}
+		})
+	}
+}
+
+// staleSocket leaves a socket file behind with nobody listening, as a
+// process that died without cleaning up would.
+func staleSocket(t *testing.T, path string) {
+	t.Helper()
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln.(*net.UnixListener).SetUnlinkOnClose(false)
+	ln.Close()
+}
+
+// writePID fills the pid file a foreground run would leave.
+func writePID(t *testing.T, cfg *config.Config, owner string) {
+	t.Helper()
+	path, err := config.PIDPath(cfg, "agent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	content := "not a pid"
+	switch owner {
+	case "self":
+		content = strconv.Itoa(os.Getpid())
+	case "dead":
+		cmd := exec.Command("true")
+		if err := cmd.Run(); err != nil {
+			t.Fatal(err)
+		}
+		content = strconv.Itoa(cmd.Process.Pid)
+	}
+	if err := os.WriteFile(path, []byte(content+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func plainFile(t *testing.T, path string) {
+	t.Helper()
+	if err := os.WriteFile(path, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// serve answers every request on path with ok until the test ends.
+func serve(t *testing.T, path string) {
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			var req map[string]any
+			_ = json.NewDecoder(conn).Decode(&req)
+			_ = json.NewEncoder(conn).Encode(Response{OK: true, Message: "pong"})
+			conn.Close()
+		}
+	}()
+}
@@ -0,0 +1,100 @@
+package agent
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseStatus(t *testing.T) {
+	full := map[string]string{
+		"state":               "CONNECTED",
+		"health":              "ok",
+		"summary":             "me@example.com:22",
+		"uptime":              "1h2m3s",
+		"socket":              "/home/me/.rpa/agent.sock",
+		"restarts":            "4",
+		"last_exit":           "exit status 255",
+		"last_class":          "network",
+		"last_trigger":        "wake",
+		"last_trigger_unix":   "1777636800",
+		"last_success_unix":   "1777636860",
+		"remote_forwards":     "0.0.0.0:2222:localhost:22,0.0.0.0:8080:localhost:80",
+		"forwards_version":    "2",
+		"startup_connect_sec": "1.250",
+		"tcp_check":           "failed",
+		"tcp_check_error":     "connection refused",
+		"tcp_check_unix":      "1777636900",
+		"backoff_ms":          "1500",
+		"monitors":            "sleep=poll network=poll",
+	}
+	st, err := ParseStatus(full)
+	if err != nil {
+		t.Fatalf("ParseStatus: %v", err)
+	}
+	checks := []struct {
+		name string
+		got  any
+		want any
+	}{
+		{"state", st.State, "CONNECTED"},
+		{"health", st.Health, "ok"},
+		{"summary", st.Summary, "me@example.com:22"},
+		{"uptime", st.Uptime, time.Hour + 2*time.Minute + 3*time.Second},
+		{"socket", st.Socket, "/home/me/.rpa/agent.sock"},
+		{"restarts", st.Restarts, 4},
+		{"last_exit", st.LastExit, "exit status 255"},
+		{"last_class", st.LastClass, "network"},
+		{"last_trigger", st.LastTrigger, "wake"},
+		{"last_trigger_at", st.LastTriggerAt, time.Unix(1777636800, 0)},
+		{"last_success", st.LastSuccess, time.Unix(1777636860, 0)},
+		{"remote_forwards", strings.Join(st.RemoteForwards, " "), "0.0.0.0:2222:localhost:22 0.0.0.0:8080:localhost:80"},
+		{"forwards_version", st.ForwardsVersion, 2},
+		{"startup_connect", st.StartupConnect, 1250 * time.Millisecond},
+		{"tcp_check", st.TCPCheck, "failed"},
+		{"tcp_check_error", st.TCPCheckError, "connection refused"},
+		{"tcp_check_at", st.TCPCheckAt, time.Unix(1777636900, 0)},
+		{"backoff", st.Backoff, 1500 * time.Millisecond},
+		{"untyped key kept", st.Data["monitors"], "sleep=poll network=poll"},
+	}
+	for _, c := range checks {
+		if c.got != c.want {
+			t.Errorf("%s = %v, want %v", c.name, c.got, c.want)
+		}
+	}
+}
+
+func TestParseStatusPartial(t *testing.T) {
+	cases := []struct {
+		name    string
+		data    map[string]string
+		wantErr string
+	}{
+		{name: "nil map"},
+		{name: "empty map", data: map[string]string{}},
+		{name: "state only", data: map[string]string{"state": "STOPPED"}},
+		{name: "never connected", data: map[string]string{"state": "CONNECTING", "restarts": "0", "uptime": "5s"}},
+		{name: "bad restarts", data: map[string]string{"restarts": "many"}, wantErr: "status restarts"},
+		{name: "bad uptime", data: map[string]string{"uptime": "forever"}, wantErr: "status uptime"},
+		{name: "bad last success", data: map[string]string{"last_success_unix": "yesterday"}, wantErr: "status last_success_unix"},
+		{name: "bad startup connect", data: map[string]string{"startup_connect_sec": "fast"}, wantErr: "status startup_connect_sec"},
+		{name: "bad backoff", data: map[string]string{"backoff_ms": "1.5"}, wantErr: "status backoff_ms"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			st, err := ParseStatus(tc.data)
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("ParseStatus error = %v, want %q", err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseStatus: %v", err)
+			}
+			if st.State != tc.data["state"] || !st.LastSuccess.IsZero() || st.RemoteForwards != nil || st.Backoff != 0 {
+				t.Fatalf("missing keys did not leave zero values: %+v", st)
+			}
+		})
+	}
+}
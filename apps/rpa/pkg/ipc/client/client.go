@@ -11,8 +11,10 @@ import (
 	"net"
 	"os"
 	"syscall"
+	"time"
 
 	"reverse-proxy-agent/pkg/config"
+	"reverse-proxy-agent/pkg/pidfile"
 )
 
 type Response struct {
@@ -22,6 +24,8 @@ type Response struct {
 	Logs    []string          `json:"logs,omitempty"`
 }
 
+const staleRetryDelay = 200 * time.Millisecond
+
 var (
 	ErrClientNotRunning    = errors.New("client not running")
 	ErrClientSocketRefused = errors.New("client socket refused")
@@ -36,6 +40,18 @@ func Query(cfg *config.Config, command string) (*Response, error) {
 	return send(cfg, request{Command: command})
 }
 
+// Ping sends ping and returns the round trip, including the socket connect.
+func Ping(cfg *config.Config) (*Response, time.Duration, error) {
+	start := time.Now()
+	resp, err := send(cfg, request{Command: "ping"})
+	return resp, time.Since(start), err
+}
+
+// Send issues command with args as-is; it exists for debugging new commands.
+func Send(cfg *config.Config, command string, args map[string]string) (*Response, error) {
+	return send(cfg, request{Command: command, Args: args})
+}
+
 func AddLocalForward(cfg *config.Config, forward string) (*Response, error) {
 	return send(cfg, request{
 		Command: "add_local_forward",
@@ -54,14 +70,34 @@ func ClearLocalForwards(cfg *config.Config) (*Response, error) {
 	return send(cfg, request{Command: "clear_local_forwards"})
 }
 
+func AddDynamicForward(cfg *config.Config, forward string) (*Response, error) {
+	return send(cfg, request{
+		Command: "add_dynamic_forward",
+		Args:    map[string]string{"dynamic_forward": forward},
+	})
+}
+
+func RemoveDynamicForward(cfg *config.Config, forward string) (*Response, error) {
+	return send(cfg, request{
+		Command: "remove_dynamic_forward",
+		Args:    map[string]string{"dynamic_forward": forward},
+	})
+}
+
+// MonitorIntervals sets the given monitor intervals (seconds keyed by config
+// name, e.g. sleep_check_sec) and returns the current ones.
+func MonitorIntervals(cfg *config.Config, changes map[string]string) (*Response, error) {
+	return send(cfg, request{Command: "monitor_intervals", Args: changes})
+}
+
 func send(cfg *config.Config, req request) (*Response, error) {
 	socketPath, err := config.ClientSocketPath(cfg)
 	if err != nil {
 		return nil, err
 	}
-	conn, err := net.Dial("unix", socketPath)
+	conn, err := dialSocket(socketPath)
 	if err != nil {
-		return nil, friendlyDialError("client", err)
+		return nil, friendlyDialError(cfg, "client", socketPath, err)
 	}
 	defer conn.Close()
 
@@ -78,13 +114,67 @@ func send(cfg *config.Config, req request) (*Response, error) {
 	return &resp, nil
 }
 
-func friendlyDialError(kind string, err error) error {
+// dialSocket retries a refused connection once with a short timeout so a
+// server that is just starting is not mistaken for a stale socket.
+func dialSocket(socketPath string) (net.Conn, error) {
+	conn, err := net.Dial("unix", socketPath)
+	if err == nil || !errors.Is(err, syscall.ECONNREFUSED) {
+		return conn, err
+	}
+	time.Sleep(staleRetryDelay)
+	return net.DialTimeout("unix", socketPath, staleRetryDelay)
+}
+
+// socketOwner returns the pid of the live process recorded in kind's pid file,
+// or 0 when the file is missing or names a dead process. An error means the
+// owner cannot be told, so the socket must be left alone.
+func socketOwner(cfg *config.Config, kind string) (int, error) {
+	path, err := config.PIDPath(cfg, kind)
+	if err != nil {
+		return 0, err
+	}
+	pid, err := pidfile.Read(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	if pidfile.Alive(pid) {
+		return pid, nil
+	}
+	return 0, nil
+}
+
+// removeStaleSocket deletes a socket file nobody is listening on.
+func removeStaleSocket(socketPath string) bool {
+	info, err := os.Lstat(socketPath)
+	if err != nil || info.Mode()&os.ModeSocket == 0 {
+		return false
+	}
+	return os.Remove(socketPath) == nil
+}
+
+// friendlyDialError explains a failed dial. A refused connection only means
+// the socket is stale once no live process owns it: a busy server with a full
+// listen backlog is refused too (macOS), and unlinking its socket would cut it
+// off until restart.
+func friendlyDialError(cfg *config.Config, kind, socketPath string, err error) error {
 	switch {
 	case errors.Is(err, os.ErrNotExist), errors.Is(err, syscall.ENOENT):
 		return fmt.Errorf("%w: %s not running; start with `rpa %s up` or `rpa %s run`", ErrClientNotRunning, kind, kind, kind)
-	case errors.Is(err, syscall.ECONNREFUSED):
-		return fmt.Errorf("%w: %s socket refused connection; check if %s is running", ErrClientSocketRefused, kind, kind)
-	default:
+	case !errors.Is(err, syscall.ECONNREFUSED):
 		return fmt.Errorf("connect to %s: %w", kind, err)
 	}
+	pid, ownerErr := socketOwner(cfg, kind)
+	switch {
+	case ownerErr != nil:
+		return fmt.Errorf("%w: %s not running (socket %s refused the connection and was left for the next start to replace)", ErrClientNotRunning, kind, socketPath)
+	case pid > 0:
+		return fmt.Errorf("%w: %s socket refused connection; %s (pid %d) is running but not accepting connections, try again", ErrClientSocketRefused, kind, kind, pid)
+	case removeStaleSocket(socketPath):
+		return fmt.Errorf("%w: %s not running (removed stale socket %s); start with `rpa %s up` or `rpa %s run`", ErrClientNotRunning, kind, socketPath, kind, kind)
+	default:
+		return fmt.Errorf("%w: %s socket refused connection; check if %s is running", ErrClientSocketRefused, kind, kind)
+	}
 }
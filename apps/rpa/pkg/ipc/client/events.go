@@ -0,0 +1,57 @@
+// Package client reads the events stream from a running client.
+// Each event is one JSON line; the stream ends when the handler returns an error or the client exits.
+
+package client
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"reverse-proxy-agent/pkg/config"
+)
+
+type Event struct {
+	Type   string         `json:"type"`
+	Time   time.Time      `json:"time"`
+	Fields map[string]any `json:"fields,omitempty"`
+}
+
+// Events subscribes to lifecycle events and calls handle for each one.
+func Events(cfg *config.Config, handle func(Event) error) error {
+	socketPath, err := config.ClientSocketPath(cfg)
+	if err != nil {
+		return err
+	}
+	conn, err := dialSocket(socketPath)
+	if err != nil {
+		return friendlyDialError(cfg, "client", socketPath, err)
+	}
+	defer conn.Close()
+
+	if err := json.NewEncoder(conn).Encode(map[string]string{"command": "events"}); err != nil {
+		return fmt.Errorf("send request: %w", err)
+	}
+	dec := json.NewDecoder(bufio.NewReader(conn))
+	var resp Response
+	if err := dec.Decode(&resp); err != nil {
+		return fmt.Errorf("read response: %w", err)
+	}
+	if !resp.OK {
+		if resp.Message != "" {
+			return errors.New(resp.Message)
+		}
+		return errors.New("events subscription failed")
+	}
+	for {
+		var ev Event
+		if err := dec.Decode(&ev); err != nil {
+			return fmt.Errorf("read event: %w", err)
+		}
+		if err := handle(ev); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,144 @@
+// Package client parses the status response into a typed Status.
+// Callers that need more than display strings use StatusTyped instead of reading the data map.
+
+package client
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"reverse-proxy-agent/pkg/config"
+)
+
+type Status struct {
+	State           string
+	Health          string
+	Summary         string
+	Uptime          time.Duration
+	Socket          string
+	Restarts        int
+	LastExit        string
+	LastClass       string
+	LastTrigger     string
+	LastTriggerAt   time.Time
+	LastSuccess     time.Time
+	LocalForwards   []string
+	DynamicForwards []string
+	ForwardsVersion int
+	StartupConnect  time.Duration
+	TCPCheck        string
+	TCPCheckError   string
+	TCPCheckAt      time.Time
+	Backoff         time.Duration
+	// Data is the untouched status map, for keys without a typed field.
+	Data map[string]string
+}
+
+// StatusTyped queries status and parses the data map into a Status.
+func StatusTyped(cfg *config.Config) (*Status, error) {
+	resp, err := Query(cfg, "status")
+	if err != nil {
+		return nil, err
+	}
+	if !resp.OK {
+		if resp.Message != "" {
+			return nil, errors.New(resp.Message)
+		}
+		return nil, errors.New("status failed")
+	}
+	return ParseStatus(resp.Data)
+}
+
+// ParseStatus converts a status data map. Missing keys leave the zero value;
+// a present key that does not parse is an error.
+func ParseStatus(data map[string]string) (*Status, error) {
+	st := &Status{
+		State:         data["state"],
+		Health:        data["health"],
+		Summary:       data["summary"],
+		Socket:        data["socket"],
+		LastExit:      data["last_exit"],
+		LastClass:     data["last_class"],
+		LastTrigger:   data["last_trigger"],
+		TCPCheck:      data["tcp_check"],
+		TCPCheckError: data["tcp_check_error"],
+		Data:          data,
+	}
+	if raw := strings.TrimSpace(data["local_forwards"]); raw != "" {
+		st.LocalForwards = strings.Split(raw, ",")
+	}
+	if raw := strings.TrimSpace(data["dynamic_forwards"]); raw != "" {
+		st.DynamicForwards = strings.Split(raw, ",")
+	}
+	var err error
+	if st.Uptime, err = parseDuration(data, "uptime"); err != nil {
+		return nil, err
+	}
+	if st.Restarts, err = parseInt(data, "restarts"); err != nil {
+		return nil, err
+	}
+	if st.ForwardsVersion, err = parseInt(data, "forwards_version"); err != nil {
+		return nil, err
+	}
+	if st.LastSuccess, err = parseUnix(data, "last_success_unix"); err != nil {
+		return nil, err
+	}
+	if st.LastTriggerAt, err = parseUnix(data, "last_trigger_unix"); err != nil {
+		return nil, err
+	}
+	if st.TCPCheckAt, err = parseUnix(data, "tcp_check_unix"); err != nil {
+		return nil, err
+	}
+	if raw := data["startup_connect_sec"]; raw != "" {
+		sec, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return nil, fmt.Errorf("status startup_connect_sec: %w", err)
+		}
+		st.StartupConnect = time.Duration(sec * float64(time.Second))
+	}
+	backoffMs, err := parseInt(data, "backoff_ms")
+	if err != nil {
+		return nil, err
+	}
+	st.Backoff = time.Duration(backoffMs) * time.Millisecond
+	return st, nil
+}
+
+func parseInt(data map[string]string, key string) (int, error) {
+	raw := data[key]
+	if raw == "" {
+		return 0, nil
+	}
+	v, err := strconv.Atoi(raw)
+	if err != nil {
+		return 0, fmt.Errorf("status %s: %w", key, err)
+	}
+	return v, nil
+}
+
+func parseDuration(data map[string]string, key string) (time.Duration, error) {
+	raw := data[key]
+	if raw == "" {
+		return 0, nil
+	}
+	v, err := time.ParseDuration(raw)
+	if err != nil {
+		return 0, fmt.Errorf("status %s: %w", key, err)
+	}
+	return v, nil
+}
+
+func parseUnix(data map[string]string, key string) (time.Time, error) {
+	raw := data[key]
+	if raw == "" {
+		return time.Time{}, nil
+	}
+	v, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("status %s: %w", key, err)
+	}
+	return time.Unix(v, 0), nil
+}
@@ -10,7 +10,11 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strings"
 	"text/template"
+	"time"
+
+	"reverse-proxy-agent/pkg/config"
 )
 
 type Spec struct {
@@ -44,16 +48,78 @@ func Install(spec Spec) (string, error) {
 	return plistPath, nil
 }
 
+// Render returns the plist XML Install would write, for users who deploy
+// the LaunchAgent themselves.
+func Render(spec Spec) ([]byte, error) {
+	if spec.Label == "" {
+		return nil, fmt.Errorf("launchd label is required")
+	}
+	return renderPlist(spec)
+}
+
+const launchctlPath = "/bin/launchctl"
+
+// runner executes a command and returns its combined output. Bootstrap,
+// Bootout and Print go through it, so tests can replace it to check the
+// launchctl argv and error handling without launchd.
+var runner = func(name string, args ...string) ([]byte, error) {
+	return exec.Command(name, args...).CombinedOutput()
+}
+
+// bootstrapAttempts bounds the retries of a bootstrap that fails while
+// launchd is still tearing down the previous job; the wait starts at
+// bootstrapDelay and doubles after each busy attempt.
+const (
+	bootstrapAttempts = 5
+	bootstrapDelay    = 250 * time.Millisecond
+)
+
+// sleep waits between bootstrap attempts; tests replace it.
+var sleep = time.Sleep
+
+// bootstrapBusyErrors are launchctl messages that mean "try again shortly":
+// right after bootout the label can still be in use for a moment.
+var bootstrapBusyErrors = []string{
+	"operation already in progress",
+	"resource busy",
+}
+
+// Bootstrap loads plistPath into the gui domain, retrying with a growing
+// delay when launchctl reports the label as busy. Other errors fail at once.
 func Bootstrap(plistPath string) error {
 	if plistPath == "" {
 		return fmt.Errorf("plist path is required")
 	}
 	target := fmt.Sprintf("gui/%d", os.Getuid())
-	cmd := exec.Command("/bin/launchctl", "bootstrap", target, plistPath)
-	if output, err := cmd.CombinedOutput(); err != nil {
-		return fmt.Errorf("launchctl bootstrap failed: %v (%s)", err, string(output))
+	delay := bootstrapDelay
+	var err error
+	for attempt := 1; ; attempt++ {
+		var output []byte
+		output, err = runner(launchctlPath, "bootstrap", target, plistPath)
+		if err == nil {
+			return nil
+		}
+		err = fmt.Errorf("launchctl bootstrap failed: %v (%s)", err, strings.TrimSpace(string(output)))
+		if attempt >= bootstrapAttempts || !bootstrapBusy(string(output)) {
+			return err
+		}
+		sleep(delay)
+		delay *= 2
 	}
-	return nil
+}
+
+// bootstrapBusy reports whether output is a busy label worth retrying. A bare
+// "Input/output error" is launchctl's catch-all (a bad plist gives it too), so
+// it only counts when the output also says the job is loaded or in progress.
+func bootstrapBusy(output string) bool {
+	output = strings.ToLower(output)
+	for _, msg := range bootstrapBusyErrors {
+		if strings.Contains(output, msg) {
+			return true
+		}
+	}
+	return strings.Contains(output, "input/output error") &&
+		(strings.Contains(output, "already loaded") || strings.Contains(output, "in progress"))
 }
 
 func Bootout(plistPath string) error {
@@ -61,8 +127,7 @@ func Bootout(plistPath string) error {
 		return fmt.Errorf("plist path is required")
 	}
 	target := fmt.Sprintf("gui/%d", os.Getuid())
-	cmd := exec.Command("/bin/launchctl", "bootout", target, plistPath)
-	if output, err := cmd.CombinedOutput(); err != nil {
+	if output, err := runner(launchctlPath, "bootout", target, plistPath); err != nil {
 		return fmt.Errorf("launchctl bootout failed: %v (%s)", err, string(output))
 	}
 	return nil
@@ -73,8 +138,7 @@ func Print(label string) (string, error) {
 		return "", fmt.Errorf("launchd label is required")
 	}
 	target := fmt.Sprintf("gui/%d/%s", os.Getuid(), label)
-	cmd := exec.Command("/bin/launchctl", "print", target)
-	output, err := cmd.CombinedOutput()
+	output, err := runner(launchctlPath, "print", target)
 	if err != nil {
 		return string(output), fmt.Errorf("launchctl print failed: %v", err)
 	}
@@ -96,10 +160,12 @@ func PlistPath(label string) (string, error) {
 	return plistPathForLabel(label)
 }
 
+// plistPathForLabel places the plist in the user's LaunchAgents folder, the
+// one launchd loads at login; RPA_HOME does not move it.
 func plistPathForLabel(label string) (string, error) {
-	home, err := os.UserHomeDir()
+	home, err := config.UserHomeDir()
 	if err != nil {
-		return "", fmt.Errorf("resolve home dir: %w", err)
+		return "", err
 	}
 	return filepath.Join(home, "Library", "LaunchAgents", label+".plist"), nil
 }
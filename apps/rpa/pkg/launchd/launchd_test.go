@@ -0,0 +1,347 @@
+package launchd
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeLaunchctl answers runner calls from a script of results and records
+// each argv.
+type fakeLaunchctl struct {
+	results []fakeResult
+	calls   [][]string
+}
+
+type fakeResult struct {
+	output string
+	err    error
+}
+
+func (f *fakeLaunchctl) run(name string, args ...string) ([]byte, error) {
+	f.calls = append(f.calls, append([]string{name}, args...))
+	if len(f.results) == 0 {
+		return nil, nil
+	}
+	res := f.results[0]
+	f.results = f.results[1:]
+	return []byte(res.output), res.err
+}
+
+// stubLaunchctl swaps runner and sleep for the test and returns the fake and
+// the recorded sleeps.
+func stubLaunchctl(t *testing.T, results ...fakeResult) (*fakeLaunchctl, *[]time.Duration) {
+	t.Helper()
+	fake := &fakeLaunchctl{results: results}
+	var slept []time.Duration
+	oldRunner, oldSleep := runner, sleep
+	runner = fake.run
+	sleep = func(d time.Duration) { slept = append(slept, d) }
+	t.Cleanup(func() { runner, sleep = oldRunner, oldSleep })
+	return fake, &slept
+}
+
+func TestBootstrapRetries(t *testing.T) {
+	exit := errors.New("exit status 5")
+	busy := fakeResult{output: "Bootstrap failed: 37: Operation already in progress", err: exit}
+	cases := []struct {
+		name      string
+		results   []fakeResult
+		wantCalls int
+		wantErr   string
+		wantSleep []time.Duration
+	}{
+		{
+			name:      "success first try",
+			results:   []fakeResult{{}},
+			wantCalls: 1,
+		},
+		{
+			name:      "busy then succeeds",
+			results:   []fakeResult{busy, {output: "Bootstrap failed: 16: Resource busy", err: exit}, {}},
+			wantCalls: 3,
+			wantSleep: []time.Duration{250 * time.Millisecond, 500 * time.Millisecond},
+		},
+		{
+			name:      "busy every time gives up",
+			results:   []fakeResult{busy, busy, busy, busy, busy, busy},
+			wantCalls: bootstrapAttempts,
+			wantErr:   "Operation already in progress",
+			wantSleep: []time.Duration{250 * time.Millisecond, 500 * time.Millisecond, time.Second, 2 * time.Second},
+		},
+		{
+			name:      "bare io error fails at once",
+			results:   []fakeResult{{output: "Bootstrap failed: 5: Input/output error", err: exit}},
+			wantCalls: 1,
+			wantErr:   "Input/output error",
+		},
+		{
+			name:      "io error for a loaded job retries",
+			results:   []fakeResult{{output: "Bootstrap failed: 5: Input/output error\nservice already loaded", err: exit}, {}},
+			wantCalls: 2,
+			wantSleep: []time.Duration{250 * time.Millisecond},
+		},
+		{
+			name:      "other error fails at once",
+			results:   []fakeResult{{output: "Bootstrap failed: 2: No such file or directory", err: exit}},
+			wantCalls: 1,
+			wantErr:   "No such file or directory",
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			fake, slept := stubLaunchctl(t, tc.results...)
+			err := Bootstrap("/tmp/com.example.rpa.plist")
+			if tc.wantErr == "" && err != nil {
+				t.Fatalf("Bootstrap: %v", err)
+			}
+			if tc.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tc.wantErr)) {
+				t.Fatalf("Bootstrap error = %v, want it to mention %q", err, tc.wantErr)
+			}
+			if len(fake.calls) != tc.wantCalls {
+				t.Fatalf("launchctl calls = %d, want %d", len(fake.calls), tc.wantCalls)
+			}
+			if len(*slept) != len(tc.wantSleep) {
+				t.Fatalf("sleeps = %v, want %v", *slept, tc.wantSleep)
+			}
+			for i, d := range tc.wantSleep {
+				if (*slept)[i] != d {
+					t.Fatalf("sleeps = %v, want %v", *slept, tc.wantSleep)
+				}
+			}
+		})
+	}
+}
+
+func TestLaunchctlArgv(t *testing.T) {
+	domain := "gui/" + strconv.Itoa(os.Getuid())
+	cases := []struct {
+		name     string
+		call     func() error
+		wantArgv []string
+	}{
+		{
+			name:     "bootstrap",
+			call:     func() error { return Bootstrap("/tmp/a.plist") },
+			wantArgv: []string{launchctlPath, "bootstrap", domain, "/tmp/a.plist"},
+		},
+		{
+			name:     "bootout",
+			call:     func() error { return Bootout("/tmp/a.plist") },
+			wantArgv: []string{launchctlPath, "bootout", domain, "/tmp/a.plist"},
+		},
+		{
+			name: "print",
+			call: func() error {
+				_, err := Print("com.example.rpa")
+				return err
+			},
+			wantArgv: []string{launchctlPath, "print", domain + "/com.example.rpa"},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			fake, _ := stubLaunchctl(t)
+			if err := tc.call(); err != nil {
+				t.Fatalf("call: %v", err)
+			}
+			if len(fake.calls) != 1 || strings.Join(fake.calls[0], " ") != strings.Join(tc.wantArgv, " ") {
+				t.Fatalf("argv = %q, want %q", fake.calls, tc.wantArgv)
+			}
+		})
+	}
+}
+
+func TestLaunchctlErrors(t *testing.T) {
+	exit := errors.New("exit status 3")
+	cases := []struct {
+		name       string
+		call       func() (string, error)
+		wantErr    []string
+		wantOutput string
+	}{
+		{
+			name:    "bootstrap",
+			call:    func() (string, error) { return "", Bootstrap("/tmp/a.plist") },
+			wantErr: []string{"launchctl bootstrap failed", "exit status 3", "No such process"},
+		},
+		{
+			name:    "bootout",
+			call:    func() (string, error) { return "", Bootout("/tmp/a.plist") },
+			wantErr: []string{"launchctl bootout failed", "exit status 3", "No such process"},
+		},
+		{
+			name:       "print keeps the output",
+			call:       func() (string, error) { return Print("com.example.rpa") },
+			wantErr:    []string{"launchctl print failed", "exit status 3"},
+			wantOutput: "Boot-out failed: 3: No such process",
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			stubLaunchctl(t, fakeResult{output: "Boot-out failed: 3: No such process", err: exit})
+			output, err := tc.call()
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+			for _, want := range tc.wantErr {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("error %q does not mention %q", err, want)
+				}
+			}
+			if output != tc.wantOutput {
+				t.Errorf("output = %q, want %q", output, tc.wantOutput)
+			}
+		})
+	}
+}
+
+func TestLaunchctlRejectsEmptyInput(t *testing.T) {
+	fake, _ := stubLaunchctl(t)
+	if err := Bootstrap(""); err == nil {
+		t.Error("Bootstrap accepted an empty plist path")
+	}
+	if err := Bootout(""); err == nil {
+		t.Error("Bootout accepted an empty plist path")
+	}
+	if _, err := Print(""); err == nil {
+		t.Error("Print accepted an empty label")
+	}
+	if len(fake.calls) != 0 {
+		t.Fatalf("launchctl ran for empty input: %q", fake.calls)
+	}
+}
+
+func TestRender(t *testing.T) {
+	cases := []struct {
+		name    string
+		spec    Spec
+		want    map[string][]string
+		wantErr string
+	}{
+		{
+			name: "agent job",
+			spec: Spec{Label: "com.rpa.agent", ProgramArgs: []string{"/usr/local/bin/rpa", "agent", "run", "--config", "/etc/rpa.yaml"}, RunAtLoad: true, KeepAlive: true, StdoutPath: "/tmp/agent.log", StderrPath: "/tmp/agent.log"},
+			want: map[string][]string{
+				"Label":             {"com.rpa.agent"},
+				"ProgramArguments":  {"/usr/local/bin/rpa", "agent", "run", "--config", "/etc/rpa.yaml"},
+				"RunAtLoad":         {"true"},
+				"KeepAlive":         {"true"},
+				"StandardOutPath":   {"/tmp/agent.log"},
+				"StandardErrorPath": {"/tmp/agent.log"},
+			},
+		},
+		{
+			name: "markup in arguments is escaped",
+			spec: Spec{Label: "com.rpa.client", ProgramArgs: []string{"/opt/R&D/rpa", "--config", "/tmp/<a>\"b\".yaml"}},
+			want: map[string][]string{
+				"Label":            {"com.rpa.client"},
+				"ProgramArguments": {"/opt/R&D/rpa", "--config", "/tmp/<a>\"b\".yaml"},
+				"RunAtLoad":        {"false"},
+				"KeepAlive":        {"false"},
+			},
+		},
+		{name: "no label", spec: Spec{ProgramArgs: []string{"rpa"}}, wantErr: "launchd label is required"},
+		{name: "no arguments", spec: Spec{Label: "com.rpa.agent"}, wantErr: "program arguments are required"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			out, err := Render(tc.spec)
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("Render error = %v, want %q", err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Render: %v", err)
+			}
+			got := parsePlist(t, out)
+			if len(got) != len(tc.want) {
+				t.Fatalf("plist keys = %v, want %v", got, tc.want)
+			}
+			for key, want := range tc.want {
+				if strings.Join(got[key], "\x00") != strings.Join(want, "\x00") {
+					t.Fatalf("%s = %q, want %q", key, got[key], want)
+				}
+			}
+		})
+	}
+}
+
+// parsePlist decodes the top-level dict of a plist into key -> values:
+// strings as themselves, arrays as their strings, booleans as "true"/"false".
+// It fails the test when data is not well-formed XML.
+func parsePlist(t *testing.T, data []byte) map[string][]string {
+	t.Helper()
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	out := map[string][]string{}
+	var key, text string
+	depth := 0
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("plist is not valid XML: %v\n%s", err, data)
+		}
+		switch tok := tok.(type) {
+		case xml.StartElement:
+			depth++
+			text = ""
+			if depth == 3 && (tok.Name.Local == "true" || tok.Name.Local == "false") {
+				out[key] = append(out[key], tok.Name.Local)
+			}
+		case xml.CharData:
+			text += string(tok)
+		case xml.EndElement:
+			switch {
+			case tok.Name.Local == "key":
+				key = text
+			case tok.Name.Local == "string":
+				out[key] = append(out[key], text)
+			}
+			depth--
+		}
+	}
+	return out
+}
+
+func TestPlistPath(t *testing.T) {
+	user := t.TempDir()
+	cases := []struct {
+		name    string
+		home    string
+		rpaHome string
+		want    string
+		wantErr string
+	}{
+		{name: "user launch agents", home: user, want: filepath.Join(user, "Library", "LaunchAgents", "com.rpa.agent.plist")},
+		{name: "rpa home does not move it", home: user, rpaHome: t.TempDir(), want: filepath.Join(user, "Library", "LaunchAgents", "com.rpa.agent.plist")},
+		{name: "no user home", rpaHome: t.TempDir(), wantErr: "set RPA_HOME or --home"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Setenv("HOME", tc.home)
+			t.Setenv("RPA_HOME", tc.rpaHome)
+			got, err := PlistPath("com.rpa.agent")
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("PlistPath = %q, %v; want error %q", got, err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil || got != tc.want {
+				t.Fatalf("PlistPath = %q, %v; want %q", got, err, tc.want)
+			}
+		})
+	}
+}
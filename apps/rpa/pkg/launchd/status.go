@@ -0,0 +1,94 @@
+// Package launchd parses `launchctl print` output into a ServiceStatus.
+// Only the top-level service fields are read; nested blocks are skipped.
+
+package launchd
+
+import (
+	"strconv"
+	"strings"
+)
+
+type ServiceStatus struct {
+	State        string
+	PID          int
+	LastExitCode string
+	Runs         int
+	Path         string
+	Program      string
+}
+
+// Status runs `launchctl print` for label and parses the result.
+func Status(label string) (*ServiceStatus, error) {
+	output, err := Print(label)
+	if err != nil {
+		return nil, err
+	}
+	return ParsePrint(output), nil
+}
+
+// ParsePrint reads the `key = value` lines directly inside the service block.
+// Missing fields keep their zero value.
+func ParsePrint(output string) *ServiceStatus {
+	st := &ServiceStatus{}
+	depth := 0
+	for _, raw := range strings.Split(strings.ReplaceAll(output, "\r\n", "\n"), "\n") {
+		line := strings.TrimSpace(raw)
+		if line == "" {
+			continue
+		}
+		if strings.HasSuffix(line, "{") {
+			depth++
+			continue
+		}
+		if line == "}" {
+			depth--
+			continue
+		}
+		if depth != 1 {
+			continue
+		}
+		key, value, ok := strings.Cut(line, " = ")
+		if !ok {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		switch strings.TrimSpace(key) {
+		case "state":
+			st.State = value
+		case "pid":
+			if pid, err := strconv.Atoi(value); err == nil {
+				st.PID = pid
+			}
+		case "last exit code":
+			st.LastExitCode = value
+		case "runs":
+			if runs, err := strconv.Atoi(value); err == nil {
+				st.Runs = runs
+			}
+		case "path":
+			st.Path = value
+		case "program":
+			st.Program = value
+		}
+	}
+	return st
+}
+
+// Summary renders the parsed fields on one line, e.g.
+// "state=running pid=412 last_exit=0 runs=3".
+func (s *ServiceStatus) Summary() string {
+	parts := []string{}
+	if s.State != "" {
+		parts = append(parts, "state="+s.State)
+	}
+	if s.PID > 0 {
+		parts = append(parts, "pid="+strconv.Itoa(s.PID))
+	}
+	if s.LastExitCode != "" {
+		parts = append(parts, "last_exit="+s.LastExitCode)
+	}
+	if s.Runs > 0 {
+		parts = append(parts, "runs="+strconv.Itoa(s.Runs))
+	}
+	return strings.Join(parts, " ")
+}
@@ -0,0 +1,138 @@
+package launchd
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// runningPrint is trimmed `launchctl print gui/501/com.rpa.agent` output
+// from macOS 14 for a running job.
+const runningPrint = `gui/501/com.rpa.agent = {
+	active count = 1
+	path = /Users/me/Library/LaunchAgents/com.rpa.agent.plist
+	type = LaunchAgent
+	state = running
+
+	program = /usr/local/bin/rpa
+	arguments = {
+		/usr/local/bin/rpa
+		agent
+		run
+		--config
+		/Users/me/.rpa/rpa.yaml
+	}
+
+	stdout path = /Users/me/.rpa/logs/agent.log
+	stderr path = /Users/me/.rpa/logs/agent.log
+	default environment = {
+		PATH => /usr/bin:/bin:/usr/sbin:/sbin
+	}
+
+	runs = 3
+	pid = 412
+	immediate reason = speculative
+	forks = 0
+	execs = 1
+	last exit code = 0
+
+	endpoints = {
+		"com.rpa.agent.sock" = {
+			port = 0x1a03
+			active = 0
+			state = listening
+		}
+	}
+}
+`
+
+// exitedPrint is a job launchd is throttling after ssh kept failing.
+const exitedPrint = `gui/501/com.rpa.client = {
+	active count = 0
+	path = /Users/me/Library/LaunchAgents/com.rpa.client.plist
+	state = not running
+
+	program = /usr/local/bin/rpa
+	runs = 12
+	last exit code = 1
+	spawn type = daemon (3)
+	properties = keepalive | runatload | inferred program
+}
+`
+
+func TestParsePrint(t *testing.T) {
+	cases := []struct {
+		name        string
+		output      string
+		want        ServiceStatus
+		wantSummary string
+	}{
+		{
+			name:        "running",
+			output:      runningPrint,
+			want:        ServiceStatus{State: "running", PID: 412, LastExitCode: "0", Runs: 3, Path: "/Users/me/Library/LaunchAgents/com.rpa.agent.plist", Program: "/usr/local/bin/rpa"},
+			wantSummary: "state=running pid=412 last_exit=0 runs=3",
+		},
+		{
+			name:        "not running",
+			output:      exitedPrint,
+			want:        ServiceStatus{State: "not running", LastExitCode: "1", Runs: 12, Path: "/Users/me/Library/LaunchAgents/com.rpa.client.plist", Program: "/usr/local/bin/rpa"},
+			wantSummary: "state=not running last_exit=1 runs=12",
+		},
+		{
+			name:        "crlf line endings",
+			output:      strings.ReplaceAll(runningPrint, "\n", "\r\n"),
+			want:        ServiceStatus{State: "running", PID: 412, LastExitCode: "0", Runs: 3, Path: "/Users/me/Library/LaunchAgents/com.rpa.agent.plist", Program: "/usr/local/bin/rpa"},
+			wantSummary: "state=running pid=412 last_exit=0 runs=3",
+		},
+		{
+			name:        "unparsable numbers keep zero",
+			output:      "gui/501/x = {\n\tpid = none\n\truns = many\n\tstate = spawn scheduled\n}\n",
+			want:        ServiceStatus{State: "spawn scheduled"},
+			wantSummary: "state=spawn scheduled",
+		},
+		{name: "not loaded", output: "Could not find service \"com.rpa.agent\" in domain for user gui: 501\n"},
+		{name: "empty", output: ""},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := ParsePrint(tc.output)
+			if *got != tc.want {
+				t.Fatalf("ParsePrint = %+v, want %+v", *got, tc.want)
+			}
+			if summary := got.Summary(); summary != tc.wantSummary {
+				t.Fatalf("Summary = %q, want %q", summary, tc.wantSummary)
+			}
+		})
+	}
+}
+
+func TestStatus(t *testing.T) {
+	cases := []struct {
+		name    string
+		result  fakeResult
+		wantPID int
+		wantErr string
+	}{
+		{name: "loaded", result: fakeResult{output: runningPrint}, wantPID: 412},
+		{name: "not loaded", result: fakeResult{output: "Could not find service", err: errors.New("exit status 113")}, wantErr: "launchctl print failed"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			fake, _ := stubLaunchctl(t, tc.result)
+			st, err := Status("com.rpa.agent")
+			if len(fake.calls) != 1 || fake.calls[0][1] != "print" || !strings.HasSuffix(fake.calls[0][2], "/com.rpa.agent") {
+				t.Fatalf("launchctl calls = %q", fake.calls)
+			}
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("Status error = %v, want %q", err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil || st.PID != tc.wantPID {
+				t.Fatalf("Status = %+v, %v; want pid %d", st, err, tc.wantPID)
+			}
+		})
+	}
+}
@@ -5,18 +5,25 @@ package logging
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/rs/zerolog"
 
 	"reverse-proxy-agent/pkg/config"
 )
 
+const (
+	FormatJSON = "json"
+	FormatText = "text"
+)
+
 type LogBuffer struct {
 	mu    sync.Mutex
 	size  int
@@ -55,7 +62,27 @@ type Logger struct {
 	ring    *LogBuffer
 	mu      sync.Mutex
 	level   zerolog.Level
+	format  string
 	console io.Writer
+
+	timeLayout string
+	localTime  bool
+
+	fileWarned bool
+
+	dedupeWindow time.Duration
+	pending      *pendingEvent
+}
+
+// pendingEvent tracks the last written event so identical follow-ups inside the
+// dedupe window can be collapsed into a single "repeated" line.
+type pendingEvent struct {
+	signature string
+	level     string
+	event     string
+	fields    map[string]any
+	count     int
+	timer     *time.Timer
 }
 
 func NewLogger(cfg *config.Config, ring *LogBuffer) (*Logger, error) {
@@ -68,6 +95,9 @@ func NewLogger(cfg *config.Config, ring *LogBuffer) (*Logger, error) {
 		return nil, err
 	}
 	logger.SetLevel(cfg.Logging.Level)
+	logger.SetFormat(cfg.Logging.Format)
+	logger.SetTimeFormat(cfg.Logging.TimeFormat, cfg.Logging.LocalTime)
+	logger.SetDedupeWindow(cfg.Logging.DedupeWindowMs)
 	return logger, nil
 }
 
@@ -75,7 +105,7 @@ func NewLoggerWithPath(path string, ring *LogBuffer) (*Logger, error) {
 	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
 		return nil, fmt.Errorf("create log dir: %w", err)
 	}
-	return &Logger{path: path, ring: ring, level: zerolog.InfoLevel}, nil
+	return &Logger{path: path, ring: ring, level: zerolog.InfoLevel, format: FormatJSON, timeLayout: time.RFC3339}, nil
 }
 
 func (l *Logger) Info(format string, args ...any) {
@@ -94,27 +124,89 @@ func (l *Logger) Event(level, event string, fields map[string]any) {
 	l.mu.Lock()
 	defer l.mu.Unlock()
 
-	f, err := os.OpenFile(l.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
-	if err != nil {
+	if parseLevel(level) < l.level {
 		return
 	}
-	defer f.Close()
+	if l.dedupeWindow <= 0 {
+		l.writeLocked(level, event, fields)
+		return
+	}
+
+	signature := eventSignature(level, event, fields)
+	if l.pending != nil && l.pending.signature == signature {
+		l.pending.count++
+		return
+	}
+	l.flushPendingLocked()
+	l.writeLocked(level, event, fields)
+	pending := &pendingEvent{
+		signature: signature,
+		level:     level,
+		event:     event,
+		fields:    fields,
+	}
+	pending.timer = time.AfterFunc(l.dedupeWindow, func() {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		if l.pending == pending {
+			l.flushPendingLocked()
+		}
+	})
+	l.pending = pending
+}
 
+// Flush writes any collapsed repeats that are still waiting for the dedupe
+// window. The run commands defer it so a shutdown does not drop the count.
+func (l *Logger) Flush() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.flushPendingLocked()
+}
+
+func (l *Logger) flushPendingLocked() {
+	pending := l.pending
+	if pending == nil {
+		return
+	}
+	l.pending = nil
+	pending.timer.Stop()
+	if pending.count == 0 {
+		return
+	}
+	fields := make(map[string]any, len(pending.fields)+1)
+	for k, v := range pending.fields {
+		fields[k] = v
+	}
+	fields["repeated"] = pending.count
+	l.writeLocked(pending.level, pending.event, fields)
+}
+
+func (l *Logger) writeLocked(level, event string, fields map[string]any) {
 	var buf bytes.Buffer
-	writer := zerolog.New(&buf).With().Timestamp().Logger().Level(l.level)
+	var out io.Writer = &buf
+	if l.format == FormatText {
+		// The stamp is already formatted; print it as-is instead of letting
+		// ConsoleWriter re-parse it.
+		out = zerolog.ConsoleWriter{Out: &buf, NoColor: true, FormatTimestamp: func(i any) string {
+			return fmt.Sprint(i)
+		}}
+	}
+	now := time.Now()
+	if !l.localTime {
+		now = now.UTC()
+	}
+	writer := zerolog.New(out).Level(l.level)
 	ev := writer.WithLevel(parseLevel(level)).Str("event", event)
 	for k, v := range fields {
 		ev = ev.Interface(k, v)
 	}
-	ev.Send()
+	ev.Str(zerolog.TimestampFieldName, now.Format(l.timeLayout)).Send()
 
 	line := strings.TrimSpace(buf.String())
 	if line == "" {
 		return
 	}
-	if _, err := f.WriteString(line + "\n"); err != nil {
-		return
-	}
+	l.writeFileLocked(line)
 	if l.ring != nil {
 		l.ring.Add(line)
 	}
@@ -123,6 +215,46 @@ func (l *Logger) Event(level, event string, fields map[string]any) {
 	}
 }
 
+// writeFileLocked appends line to the log file. Failures are reported to stderr
+// once per failure streak so the ring buffer and console keep working silently.
+func (l *Logger) writeFileLocked(line string) {
+	f, err := os.OpenFile(l.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err == nil {
+		_, err = f.WriteString(line + "\n")
+		_ = f.Close()
+	}
+	if err != nil {
+		if !l.fileWarned {
+			l.fileWarned = true
+			fmt.Fprintf(os.Stderr, "rpa: log file %s not writable, keeping logs in memory only: %v\n", l.path, err)
+		}
+		return
+	}
+	l.fileWarned = false
+}
+
+// Reopen checks that the log path can be opened again, typically after an
+// external tool rotated it. The file is opened per write, so new lines already
+// follow the path; this only resets the unwritable warning and reports errors early.
+func (l *Logger) Reopen() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	f, err := os.OpenFile(l.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	l.fileWarned = false
+	return f.Close()
+}
+
+func eventSignature(level, event string, fields map[string]any) string {
+	encoded, err := json.Marshal(fields)
+	if err != nil {
+		encoded = []byte(fmt.Sprintf("%v", fields))
+	}
+	return strings.ToLower(level) + "|" + event + "|" + string(encoded)
+}
+
 func parseLevel(level string) zerolog.Level {
 	switch strings.ToLower(level) {
 	case "debug":
@@ -144,6 +276,39 @@ func (l *Logger) SetLevel(level string) {
 	l.level = parseLevel(level)
 }
 
+// SetFormat selects json (default) or human-readable text output.
+func (l *Logger) SetFormat(format string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if strings.EqualFold(strings.TrimSpace(format), FormatText) {
+		l.format = FormatText
+		return
+	}
+	l.format = FormatJSON
+}
+
+// SetTimeFormat sets the timestamp layout (see config.LogTimeLayout) and
+// whether stamps use the local zone instead of UTC.
+func (l *Logger) SetTimeFormat(format string, local bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.timeLayout = config.LogTimeLayout(format)
+	l.localTime = local
+}
+
+// SetDedupeWindow enables collapsing identical consecutive events within windowMs.
+// A value <= 0 disables deduplication.
+func (l *Logger) SetDedupeWindow(windowMs int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.flushPendingLocked()
+	if windowMs <= 0 {
+		l.dedupeWindow = 0
+		return
+	}
+	l.dedupeWindow = time.Duration(windowMs) * time.Millisecond
+}
+
 func (l *Logger) SetConsoleWriter(w io.Writer) {
 	l.mu.Lock()
 	defer l.mu.Unlock()
@@ -0,0 +1,440 @@
+package logging
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+type logCall struct {
+	event string
+	err   string
+}
+
+type wantLine struct {
+	event    string
+	repeated int
+}
+
+func TestLoggerDedupe(t *testing.T) {
+	exited := logCall{event: "ssh_exited", err: "exit status 255"}
+	cases := []struct {
+		name     string
+		windowMs int
+		calls    []logCall
+		flush    bool
+		want     []wantLine
+	}{
+		{
+			name:     "disabled writes every event",
+			windowMs: 0,
+			calls:    []logCall{exited, exited, exited},
+			want:     []wantLine{{event: "ssh_exited"}, {event: "ssh_exited"}, {event: "ssh_exited"}},
+		},
+		{
+			name:     "identical events collapse on flush",
+			windowMs: 60000,
+			calls:    []logCall{exited, exited, exited},
+			flush:    true,
+			want:     []wantLine{{event: "ssh_exited"}, {event: "ssh_exited", repeated: 2}},
+		},
+		{
+			name:     "repeats wait for the window without a flush",
+			windowMs: 60000,
+			calls:    []logCall{exited, exited, exited},
+			want:     []wantLine{{event: "ssh_exited"}},
+		},
+		{
+			name:     "different event flushes the repeats",
+			windowMs: 60000,
+			calls:    []logCall{exited, exited, exited, {event: "ssh_started"}},
+			want:     []wantLine{{event: "ssh_exited"}, {event: "ssh_exited", repeated: 2}, {event: "ssh_started"}},
+		},
+		{
+			name:     "different fields are a different event",
+			windowMs: 60000,
+			calls:    []logCall{exited, {event: "ssh_exited", err: "exit status 1"}},
+			flush:    true,
+			want:     []wantLine{{event: "ssh_exited"}, {event: "ssh_exited"}},
+		},
+		{
+			name:     "single event has no repeat line",
+			windowMs: 60000,
+			calls:    []logCall{exited},
+			flush:    true,
+			want:     []wantLine{{event: "ssh_exited"}},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			logger, ring := newTestLogger(t, tc.windowMs)
+			for _, call := range tc.calls {
+				logger.Event("ERROR", call.event, map[string]any{"error": call.err})
+			}
+			if tc.flush {
+				logger.Flush()
+			}
+			checkLines(t, ring.List(), tc.want)
+		})
+	}
+}
+
+func TestLoggerDedupeWindowFlushes(t *testing.T) {
+	logger, ring := newTestLogger(t, 20)
+	for i := 0; i < 3; i++ {
+		logger.Event("ERROR", "ssh_exited", nil)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for len(ring.List()) < 2 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	checkLines(t, ring.List(), []wantLine{{event: "ssh_exited"}, {event: "ssh_exited", repeated: 2}})
+
+	// Flushing with nothing pending writes nothing more.
+	logger.Flush()
+	if got := len(ring.List()); got != 2 {
+		t.Fatalf("lines after empty flush = %d, want 2", got)
+	}
+}
+
+func newTestLogger(t *testing.T, windowMs int) (*Logger, *LogBuffer) {
+	t.Helper()
+	ring := NewLogBuffer()
+	logger, err := NewLoggerWithPath(filepath.Join(t.TempDir(), "logs", "agent.log"), ring)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger.SetDedupeWindow(windowMs)
+	return logger, ring
+}
+
+func checkLines(t *testing.T, lines []string, want []wantLine) {
+	t.Helper()
+	if len(lines) != len(want) {
+		t.Fatalf("got %d lines %q, want %d", len(lines), lines, len(want))
+	}
+	for i, line := range lines {
+		var fields struct {
+			Event    string `json:"event"`
+			Repeated int    `json:"repeated"`
+		}
+		if err := json.Unmarshal([]byte(line), &fields); err != nil {
+			t.Fatalf("line %d %q: %v", i, line, err)
+		}
+		if fields.Event != want[i].event || fields.Repeated != want[i].repeated {
+			t.Errorf("line %d = %s repeated %d, want %s repeated %d", i, fields.Event, fields.Repeated, want[i].event, want[i].repeated)
+		}
+	}
+}
+
+func TestLoggerFormats(t *testing.T) {
+	cases := []struct {
+		name   string
+		format string
+		check  func(t *testing.T, line string)
+	}{
+		{name: "json by default", format: "", check: checkJSONLine},
+		{name: "explicit json", format: "json", check: checkJSONLine},
+		{name: "unknown falls back to json", format: "yaml", check: checkJSONLine},
+		{name: "text", format: "text", check: checkTextLine},
+		{name: "text ignores case and spaces", format: " Text ", check: checkTextLine},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			logger, ring := newTestLogger(t, 0)
+			logger.SetFormat(tc.format)
+			logger.Event("WARN", "ssh_exited", map[string]any{"class": "network"})
+			lines := ring.List()
+			if len(lines) != 1 {
+				t.Fatalf("got %d lines %q, want 1", len(lines), lines)
+			}
+			tc.check(t, lines[0])
+
+			// The file gets exactly what the ring holds.
+			data, err := os.ReadFile(logger.path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := strings.TrimSuffix(string(data), "\n"); got != lines[0] {
+				t.Fatalf("file line %q differs from ring line %q", got, lines[0])
+			}
+		})
+	}
+}
+
+func checkJSONLine(t *testing.T, line string) {
+	t.Helper()
+	var fields map[string]any
+	if err := json.Unmarshal([]byte(line), &fields); err != nil {
+		t.Fatalf("line %q is not JSON: %v", line, err)
+	}
+	if fields["event"] != "ssh_exited" || fields["level"] != "warn" || fields["class"] != "network" {
+		t.Fatalf("unexpected fields %v", fields)
+	}
+	if _, err := time.Parse(time.RFC3339, fmt.Sprint(fields["time"])); err != nil {
+		t.Fatalf("time %v: %v", fields["time"], err)
+	}
+}
+
+func checkTextLine(t *testing.T, line string) {
+	t.Helper()
+	if strings.HasPrefix(line, "{") {
+		t.Fatalf("text line looks like JSON: %q", line)
+	}
+	stamp, rest, _ := strings.Cut(line, " ")
+	if _, err := time.Parse(time.RFC3339, stamp); err != nil {
+		t.Fatalf("line %q does not start with a timestamp: %v", line, err)
+	}
+	for _, want := range []string{"WRN", "event=ssh_exited", "class=network"} {
+		if !strings.Contains(rest, want) {
+			t.Fatalf("line %q is missing %q", line, want)
+		}
+	}
+}
+
+func TestLoggerTimeFormat(t *testing.T) {
+	// Pin the local zone so local stamps differ visibly from UTC ones.
+	saved := time.Local
+	time.Local = time.FixedZone("TEST", 5*3600+1800)
+	t.Cleanup(func() { time.Local = saved })
+
+	cases := []struct {
+		name       string
+		format     string
+		timeFormat string
+		local      bool
+		layout     string
+		wantSuffix string
+	}{
+		{name: "default is rfc3339 utc", layout: time.RFC3339, wantSuffix: "Z"},
+		{name: "rfc3339 local", timeFormat: "rfc3339", local: true, layout: time.RFC3339, wantSuffix: "+05:30"},
+		{name: "rfc3339nano", timeFormat: " RFC3339Nano ", layout: time.RFC3339Nano, wantSuffix: "Z"},
+		{name: "custom layout utc", timeFormat: "2006-01-02 15:04:05", layout: "2006-01-02 15:04:05"},
+		{name: "custom layout local with zone", timeFormat: "2006-01-02 15:04:05 -0700", local: true, layout: "2006-01-02 15:04:05 -0700", wantSuffix: "+0530"},
+		{name: "text custom layout", format: "text", timeFormat: "2006-01-02 15:04:05", layout: "2006-01-02 15:04:05"},
+		{name: "text rfc3339 local", format: "text", local: true, layout: time.RFC3339, wantSuffix: "+05:30"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			logger, ring := newTestLogger(t, 0)
+			logger.SetFormat(tc.format)
+			logger.SetTimeFormat(tc.timeFormat, tc.local)
+			before := time.Now().Truncate(time.Second)
+			logger.Event("INFO", "ssh_started", nil)
+			after := time.Now()
+			lines := ring.List()
+			if len(lines) != 1 {
+				t.Fatalf("got %d lines %q, want 1", len(lines), lines)
+			}
+
+			var stamp string
+			if tc.format == "text" {
+				words := strings.Count(tc.layout, " ") + 1
+				parts := strings.SplitN(lines[0], " ", words+1)
+				if len(parts) <= words || !strings.Contains(parts[words], "event=ssh_started") {
+					t.Fatalf("text line %q does not start with a %q stamp", lines[0], tc.layout)
+				}
+				stamp = strings.Join(parts[:words], " ")
+			} else {
+				var fields struct {
+					Time string `json:"time"`
+				}
+				if err := json.Unmarshal([]byte(lines[0]), &fields); err != nil {
+					t.Fatalf("line %q: %v", lines[0], err)
+				}
+				stamp = fields.Time
+			}
+			if !strings.HasSuffix(stamp, tc.wantSuffix) {
+				t.Fatalf("stamp %q does not end in %q", stamp, tc.wantSuffix)
+			}
+			loc := time.UTC
+			if tc.local {
+				loc = time.Local
+			}
+			at, err := time.ParseInLocation(tc.layout, stamp, loc)
+			if err != nil {
+				t.Fatalf("stamp %q does not match layout %q: %v", stamp, tc.layout, err)
+			}
+			if at.Before(before) || at.After(after) {
+				t.Fatalf("stamp %q reads as %s, want between %s and %s", stamp, at, before, after)
+			}
+		})
+	}
+}
+
+func TestLoggerUnwritableFile(t *testing.T) {
+	cases := []struct {
+		name  string
+		block func(t *testing.T, path string) (unblock func())
+	}{
+		{
+			name: "read-only log dir",
+			block: func(t *testing.T, path string) func() {
+				dir := filepath.Dir(path)
+				if err := os.Chmod(dir, 0o500); err != nil {
+					t.Fatal(err)
+				}
+				if f, err := os.OpenFile(filepath.Join(dir, "probe"), os.O_CREATE|os.O_WRONLY, 0o600); err == nil {
+					f.Close()
+					os.Chmod(dir, 0o700)
+					t.Skip("read-only dirs are writable for this user (root)")
+				}
+				return func() { os.Chmod(dir, 0o700) }
+			},
+		},
+		{
+			name: "log path is a directory",
+			block: func(t *testing.T, path string) func() {
+				os.Remove(path)
+				if err := os.Mkdir(path, 0o700); err != nil {
+					t.Fatal(err)
+				}
+				return func() { os.Remove(path) }
+			},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			logger, ring := newTestLogger(t, 0)
+			unblock := tc.block(t, logger.path)
+			stderr := captureStderr(t, func() {
+				logger.Event("INFO", "first", nil)
+				logger.Event("INFO", "second", nil)
+			})
+			if n := strings.Count(stderr, "not writable"); n != 1 {
+				t.Fatalf("warned %d times, want once: %q", n, stderr)
+			}
+			if got := len(ring.List()); got != 2 {
+				t.Fatalf("ring has %d lines, want 2", got)
+			}
+
+			// A successful write ends the streak, so the next failure warns again.
+			unblock()
+			logger.Event("INFO", "recovered", nil)
+			tc.block(t, logger.path)
+			stderr = captureStderr(t, func() { logger.Event("INFO", "again", nil) })
+			if n := strings.Count(stderr, "not writable"); n != 1 {
+				t.Fatalf("warned %d times after recovery, want once: %q", n, stderr)
+			}
+		})
+	}
+}
+
+func captureStderr(t *testing.T, fn func()) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := os.Stderr
+	os.Stderr = w
+	fn()
+	os.Stderr = old
+	w.Close()
+	data, _ := io.ReadAll(r)
+	r.Close()
+	return string(data)
+}
+
+func TestLoggerReopen(t *testing.T) {
+	cases := []struct {
+		name    string
+		rotate  func(t *testing.T, path string)
+		wantErr bool
+	}{
+		{
+			name: "renamed away",
+			rotate: func(t *testing.T, path string) {
+				if err := os.Rename(path, path+".1"); err != nil {
+					t.Fatal(err)
+				}
+			},
+		},
+		{
+			name: "removed",
+			rotate: func(t *testing.T, path string) {
+				if err := os.Remove(path); err != nil {
+					t.Fatal(err)
+				}
+			},
+		},
+		{
+			name: "replaced by a directory",
+			rotate: func(t *testing.T, path string) {
+				if err := os.Remove(path); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.Mkdir(path, 0o700); err != nil {
+					t.Fatal(err)
+				}
+			},
+			wantErr: true,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			logger, _ := newTestLogger(t, 0)
+			logger.Event("INFO", "before_rotate", nil)
+			tc.rotate(t, logger.path)
+
+			err := logger.Reopen()
+			if tc.wantErr {
+				if err == nil {
+					t.Fatal("Reopen succeeded, want an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Reopen: %v", err)
+			}
+			// Reopen creates the file at the path straight away.
+			if _, err := os.Stat(logger.path); err != nil {
+				t.Fatalf("log path after reopen: %v", err)
+			}
+			logger.Event("INFO", "after_rotate", nil)
+			data, err := os.ReadFile(logger.path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(string(data), "after_rotate") || strings.Contains(string(data), "before_rotate") {
+				t.Fatalf("log path holds %q, want only the post-rotate line", data)
+			}
+			if old, err := os.ReadFile(logger.path + ".1"); err == nil && strings.Contains(string(old), "after_rotate") {
+				t.Fatalf("rotated file gained a new line: %q", old)
+			}
+		})
+	}
+}
+
+func TestLoggerReopenResetsWarning(t *testing.T) {
+	logger, _ := newTestLogger(t, 0)
+	os.Remove(logger.path)
+	if err := os.Mkdir(logger.path, 0o700); err != nil {
+		t.Fatal(err)
+	}
+	stderr := captureStderr(t, func() { logger.Event("INFO", "blocked", nil) })
+	if !strings.Contains(stderr, "not writable") {
+		t.Fatalf("no warning for the blocked path: %q", stderr)
+	}
+	if err := os.Remove(logger.path); err != nil {
+		t.Fatal(err)
+	}
+	if err := logger.Reopen(); err != nil {
+		t.Fatalf("Reopen: %v", err)
+	}
+	if err := os.Remove(logger.path); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(logger.path, 0o700); err != nil {
+		t.Fatal(err)
+	}
+	stderr = captureStderr(t, func() { logger.Event("INFO", "blocked_again", nil) })
+	if !strings.Contains(stderr, "not writable") {
+		t.Fatalf("no warning after a successful reopen: %q", stderr)
+	}
+}
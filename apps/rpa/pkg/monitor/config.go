@@ -8,4 +8,5 @@ type Config struct {
 	SleepCheckSec  int
 	SleepGapSec    int
 	NetworkPollSec int
+	PowerPollSec   int
 }
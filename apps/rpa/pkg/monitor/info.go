@@ -0,0 +1,28 @@
+package monitor
+
+import "strings"
+
+// ModeInfo names the sleep, network and power monitor implementations
+// compiled into this binary.
+type ModeInfo struct {
+	Sleep   string
+	Network string
+	Power   string
+}
+
+// Info reports the active monitor implementations. Each build-tagged file
+// sets its mode, so a darwin build without cgo reports the polling fallbacks.
+func Info() ModeInfo {
+	return ModeInfo{Sleep: sleepMode, Network: networkMode, Power: powerMode}
+}
+
+// Fallback reports whether a darwin-native monitor was replaced by polling
+// because cgo was disabled at build time.
+func (m ModeInfo) Fallback() bool {
+	return strings.HasSuffix(m.Sleep, "(cgo disabled)") || strings.HasSuffix(m.Network, "(cgo disabled)")
+}
+
+// String formats the modes as "sleep=... network=... power=...".
+func (m ModeInfo) String() string {
+	return "sleep=" + m.Sleep + " network=" + m.Network + " power=" + m.Power
+}
@@ -0,0 +1,15 @@
+//go:build darwin && !cgo
+
+package monitor
+
+import "testing"
+
+func TestInfo(t *testing.T) {
+	want := ModeInfo{Sleep: "polling (cgo disabled)", Network: "polling (cgo disabled)", Power: "pmset polling"}
+	if got := Info(); got != want {
+		t.Fatalf("Info = %+v, want %+v", got, want)
+	}
+	if got := Info().Fallback(); got != true {
+		t.Fatalf("Fallback = %v, want true", got)
+	}
+}
@@ -0,0 +1,15 @@
+//go:build !darwin
+
+package monitor
+
+import "testing"
+
+func TestInfo(t *testing.T) {
+	want := ModeInfo{Sleep: "polling", Network: "polling", Power: "unsupported"}
+	if got := Info(); got != want {
+		t.Fatalf("Info = %+v, want %+v", got, want)
+	}
+	if got := Info().Fallback(); got != false {
+		t.Fatalf("Fallback = %v, want false", got)
+	}
+}
@@ -0,0 +1,27 @@
+package monitor
+
+import "testing"
+
+func TestModeInfo(t *testing.T) {
+	cases := []struct {
+		name         string
+		info         ModeInfo
+		wantString   string
+		wantFallback bool
+	}{
+		{name: "darwin cgo", info: ModeInfo{Sleep: "iokit", Network: "systemconfiguration", Power: "pmset polling"}, wantString: "sleep=iokit network=systemconfiguration power=pmset polling"},
+		{name: "darwin without cgo", info: ModeInfo{Sleep: "polling (cgo disabled)", Network: "polling (cgo disabled)", Power: "pmset polling"}, wantString: "sleep=polling (cgo disabled) network=polling (cgo disabled) power=pmset polling", wantFallback: true},
+		{name: "other platforms", info: ModeInfo{Sleep: "polling", Network: "polling", Power: "unsupported"}, wantString: "sleep=polling network=polling power=unsupported"},
+		{name: "one fallback is enough", info: ModeInfo{Sleep: "iokit", Network: "polling (cgo disabled)", Power: "pmset polling"}, wantString: "sleep=iokit network=polling (cgo disabled) power=pmset polling", wantFallback: true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := tc.info.String(); got != tc.wantString {
+				t.Fatalf("String = %q, want %q", got, tc.wantString)
+			}
+			if got := tc.info.Fallback(); got != tc.wantFallback {
+				t.Fatalf("Fallback = %v, want %v", got, tc.wantFallback)
+			}
+		})
+	}
+}
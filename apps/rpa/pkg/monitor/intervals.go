@@ -0,0 +1,96 @@
+package monitor
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Intervals holds the poll settings of running monitors. The polling
+// watchers read it on every tick, so Apply takes effect without a restart.
+type Intervals struct {
+	mu      sync.Mutex
+	cfg     Config
+	started Config
+}
+
+func NewIntervals(cfg Config) *Intervals {
+	return &Intervals{cfg: cfg, started: cfg}
+}
+
+// Config returns the current settings.
+func (i *Intervals) Config() Config {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return i.cfg
+}
+
+// IntervalKeys are the settings Apply accepts, named as in the config file.
+var IntervalKeys = []string{"sleep_check_sec", "sleep_gap_sec", "network_poll_sec", "power_poll_sec"}
+
+// Apply sets each key in changes to its value in seconds. Either every change
+// is applied or none is. A monitor that was disabled at start, or that is
+// event-driven on this build, cannot be tuned.
+func (i *Intervals) Apply(changes map[string]int) (Config, error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if len(changes) == 0 {
+		return i.cfg, nil
+	}
+	next := i.cfg
+	keys := make([]string, 0, len(changes))
+	for key := range changes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		sec := changes[key]
+		if sec < 1 {
+			return i.cfg, fmt.Errorf("%s must be >= 1", key)
+		}
+		switch key {
+		case "sleep_check_sec", "sleep_gap_sec":
+			if err := tunable("sleep", sleepMode, i.started.SleepCheckSec); err != nil {
+				return i.cfg, err
+			}
+			if key == "sleep_check_sec" {
+				next.SleepCheckSec = sec
+			} else {
+				next.SleepGapSec = sec
+			}
+		case "network_poll_sec":
+			if err := tunable("network", networkMode, i.started.NetworkPollSec); err != nil {
+				return i.cfg, err
+			}
+			next.NetworkPollSec = sec
+		case "power_poll_sec":
+			if err := tunable("power", powerMode, i.started.PowerPollSec); err != nil {
+				return i.cfg, err
+			}
+			next.PowerPollSec = sec
+		default:
+			return i.cfg, fmt.Errorf("unknown monitor setting %q (want %s)", key, strings.Join(IntervalKeys, ", "))
+		}
+	}
+	if next.SleepGapSec > 0 && next.SleepCheckSec >= next.SleepGapSec {
+		return i.cfg, fmt.Errorf("sleep_check_sec (%d) must be below sleep_gap_sec (%d)", next.SleepCheckSec, next.SleepGapSec)
+	}
+	i.cfg = next
+	return next, nil
+}
+
+func tunable(name, mode string, startedSec int) error {
+	if !strings.Contains(mode, "polling") {
+		return fmt.Errorf("%s monitor is %s and has no poll interval", name, mode)
+	}
+	if startedSec <= 0 {
+		return fmt.Errorf("%s monitor is disabled; enable it in the config and restart", name)
+	}
+	return nil
+}
+
+func seconds(sec int) time.Duration {
+	return time.Duration(sec) * time.Second
+}
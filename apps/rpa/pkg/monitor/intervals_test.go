@@ -0,0 +1,111 @@
+package monitor
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTunable(t *testing.T) {
+	cases := []struct {
+		mode    string
+		started int
+		wantErr string
+	}{
+		{mode: "polling", started: 5},
+		{mode: "polling (cgo disabled)", started: 5},
+		{mode: "pmset polling", started: 30},
+		{mode: "polling", started: 0, wantErr: "network monitor is disabled"},
+		{mode: "systemconfiguration", started: 5, wantErr: "network monitor is systemconfiguration and has no poll interval"},
+		{mode: "unsupported", started: 5, wantErr: "has no poll interval"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.mode, func(t *testing.T) {
+			err := tunable("network", tc.mode, tc.started)
+			if tc.wantErr == "" {
+				if err != nil {
+					t.Fatalf("tunable = %v, want nil", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Fatalf("tunable = %v, want %q", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestIntervalsApply(t *testing.T) {
+	started := Config{SleepCheckSec: 5, SleepGapSec: 30, NetworkPollSec: 5, PowerPollSec: 10}
+	// Which monitors poll depends on the build; expect their own errors.
+	modeErr := func(name, mode string) string {
+		if strings.Contains(mode, "polling") {
+			return ""
+		}
+		return name + " monitor is " + mode
+	}
+	cases := []struct {
+		name    string
+		start   Config
+		changes map[string]int
+		want    Config
+		wantErr string
+	}{
+		{name: "no changes", start: started, want: started},
+		{name: "network", start: started, changes: map[string]int{"network_poll_sec": 2}, want: Config{SleepCheckSec: 5, SleepGapSec: 30, NetworkPollSec: 2, PowerPollSec: 10}, wantErr: modeErr("network", networkMode)},
+		{name: "sleep check and gap", start: started, changes: map[string]int{"sleep_check_sec": 10, "sleep_gap_sec": 60}, want: Config{SleepCheckSec: 10, SleepGapSec: 60, NetworkPollSec: 5, PowerPollSec: 10}, wantErr: modeErr("sleep", sleepMode)},
+		{name: "power", start: started, changes: map[string]int{"power_poll_sec": 20}, want: Config{SleepCheckSec: 5, SleepGapSec: 30, NetworkPollSec: 5, PowerPollSec: 20}, wantErr: modeErr("power", powerMode)},
+		{name: "below one", start: started, changes: map[string]int{"network_poll_sec": 0}, wantErr: "network_poll_sec must be >= 1"},
+		{name: "unknown key", start: started, changes: map[string]int{"dns_poll_sec": 5}, wantErr: `unknown monitor setting "dns_poll_sec"`},
+		{name: "all or nothing", start: started, changes: map[string]int{"network_poll_sec": 2, "zzz_sec": 1}, wantErr: "unknown monitor setting"},
+		{name: "disabled at start", start: Config{SleepCheckSec: 5, SleepGapSec: 30}, changes: map[string]int{"network_poll_sec": 2}, wantErr: "network monitor"},
+	}
+	if modeErr("sleep", sleepMode) == "" {
+		cases = append(cases, struct {
+			name    string
+			start   Config
+			changes map[string]int
+			want    Config
+			wantErr string
+		}{name: "check must stay below gap", start: started, changes: map[string]int{"sleep_check_sec": 30}, wantErr: "sleep_check_sec (30) must be below sleep_gap_sec (30)"})
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			live := NewIntervals(tc.start)
+			got, err := live.Apply(tc.changes)
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("Apply = %+v, %v; want error %q", got, err, tc.wantErr)
+				}
+				if now := live.Config(); now != tc.start {
+					t.Fatalf("failed Apply left %+v, want %+v", now, tc.start)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Apply: %v", err)
+			}
+			if got != tc.want || live.Config() != tc.want {
+				t.Fatalf("Apply = %+v, Config = %+v; want %+v", got, live.Config(), tc.want)
+			}
+		})
+	}
+}
+
+func TestSleepTiming(t *testing.T) {
+	cases := []struct {
+		cfg          Config
+		wantInterval time.Duration
+		wantGap      time.Duration
+	}{
+		{cfg: Config{SleepCheckSec: 5, SleepGapSec: 30}, wantInterval: 5 * time.Second, wantGap: 30 * time.Second},
+		{cfg: Config{SleepCheckSec: 5}, wantInterval: 5 * time.Second, wantGap: 10 * time.Second},
+		{cfg: Config{}, wantInterval: 0, wantGap: 0},
+	}
+	for _, tc := range cases {
+		interval, gap := sleepTiming(tc.cfg)
+		if interval != tc.wantInterval || gap != tc.wantGap {
+			t.Errorf("sleepTiming(%+v) = %s, %s; want %s, %s", tc.cfg, interval, gap, tc.wantInterval, tc.wantGap)
+		}
+	}
+}
@@ -71,6 +71,8 @@ import (
 	"reverse-proxy-agent/pkg/logging"
 )
 
+const networkMode = "systemconfiguration"
+
 var (
 	networkEventMu sync.Mutex
 	networkEventCh chan struct{}
@@ -90,7 +92,7 @@ func networkCallbackGo() {
 	}
 }
 
-func StartNetworkMonitor(ctx context.Context, _ Config, logger *logging.Logger, onEvent func(reason string)) {
+func StartNetworkMonitor(ctx context.Context, _ *Intervals, logger *logging.Logger, onEvent func(reason string)) {
 	if onEvent == nil {
 		onEvent = func(string) {}
 	}
@@ -14,33 +14,68 @@ import (
 	"reverse-proxy-agent/pkg/logging"
 )
 
-func networkWatcher(ctx context.Context, logger *logging.Logger, interval time.Duration, onEvent func(reason string)) {
+func networkWatcher(ctx context.Context, logger *logging.Logger, live *Intervals, onEvent func(reason string)) {
+	interval := seconds(live.Config().NetworkPollSec)
 	if interval <= 0 {
 		return
 	}
 	prev, _ := networkFingerprint()
-	ticker := time.NewTicker(interval)
-	defer ticker.Stop()
+	change := newChangeFilter(prev, networkConfirmPolls)
+	timer := time.NewTimer(interval)
+	defer timer.Stop()
 
 	for {
 		select {
 		case <-ctx.Done():
 			return
-		case <-ticker.C:
+		case <-timer.C:
+			timer.Reset(seconds(live.Config().NetworkPollSec))
 			next, err := networkFingerprint()
 			if err != nil {
 				logger.Error("network fingerprint failed: %v", err)
 				continue
 			}
-			if next != prev {
+			if change.Observe(next) {
 				logger.Info("network change detected")
 				onEvent("network change")
-				prev = next
 			}
 		}
 	}
 }
 
+// networkConfirmPolls is how many consecutive polls must disagree with the
+// last fingerprint before a change fires, so a momentary all-down blip that
+// recovers on the next poll is ignored.
+const networkConfirmPolls = 2
+
+type changeFilter struct {
+	current string
+	need    int
+	streak  int
+}
+
+func newChangeFilter(initial string, need int) *changeFilter {
+	if need < 1 {
+		need = 1
+	}
+	return &changeFilter{current: initial, need: need}
+}
+
+// Observe records a poll result and reports whether a confirmed change happened.
+func (f *changeFilter) Observe(next string) bool {
+	if next == f.current {
+		f.streak = 0
+		return false
+	}
+	f.streak++
+	if f.streak < f.need {
+		return false
+	}
+	f.current = next
+	f.streak = 0
+	return true
+}
+
 func networkFingerprint() (string, error) {
 	ifaces, err := net.Interfaces()
 	if err != nil {
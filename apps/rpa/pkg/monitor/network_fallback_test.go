@@ -0,0 +1,59 @@
+package monitor
+
+import "testing"
+
+func TestChangeFilterObserve(t *testing.T) {
+	cases := []struct {
+		name  string
+		need  int
+		polls []string
+		want  []bool
+	}{
+		{
+			name:  "flapping to empty never fires",
+			need:  2,
+			polls: []string{"", "en0|10.0.0.2", "", "en0|10.0.0.2", ""},
+			want:  []bool{false, false, false, false, false},
+		},
+		{
+			name:  "steady change fires once",
+			need:  2,
+			polls: []string{"en0|10.0.0.9", "en0|10.0.0.9", "en0|10.0.0.9"},
+			want:  []bool{false, true, false},
+		},
+		{
+			name:  "blip then new address fires",
+			need:  2,
+			polls: []string{"", "en0|10.0.0.9", "en0|10.0.0.9"},
+			want:  []bool{false, true, false},
+		},
+		{
+			name:  "back to the old value resets the streak",
+			need:  2,
+			polls: []string{"en0|10.0.0.9", "en0|10.0.0.2", "en0|10.0.0.9", "en0|10.0.0.2"},
+			want:  []bool{false, false, false, false},
+		},
+		{
+			name:  "need one fires on the first difference",
+			need:  1,
+			polls: []string{"en0|10.0.0.9", "en0|10.0.0.9", "en0|10.0.0.2"},
+			want:  []bool{true, false, true},
+		},
+		{
+			name:  "need below one acts as one",
+			need:  0,
+			polls: []string{""},
+			want:  []bool{true},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			f := newChangeFilter("en0|10.0.0.2", tc.need)
+			for i, poll := range tc.polls {
+				if got := f.Observe(poll); got != tc.want[i] {
+					t.Fatalf("poll %d (%q): Observe = %v, want %v", i, poll, got, tc.want[i])
+				}
+			}
+		})
+	}
+}
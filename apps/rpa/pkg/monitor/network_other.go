@@ -7,18 +7,19 @@ package monitor
 
 import (
 	"context"
-	"time"
 
 	"reverse-proxy-agent/pkg/logging"
 )
 
-func StartNetworkMonitor(ctx context.Context, cfg Config, logger *logging.Logger, onEvent func(reason string)) {
-	if cfg.NetworkPollSec <= 0 {
+const networkMode = "polling"
+
+func StartNetworkMonitor(ctx context.Context, live *Intervals, logger *logging.Logger, onEvent func(reason string)) {
+	if live.Config().NetworkPollSec <= 0 {
 		return
 	}
 	if onEvent == nil {
 		onEvent = func(string) {}
 	}
 	logger.Info("network monitor: using polling fallback")
-	networkWatcher(ctx, logger, time.Duration(cfg.NetworkPollSec)*time.Second, onEvent)
+	networkWatcher(ctx, logger, live, onEvent)
 }
@@ -0,0 +1,34 @@
+//go:build darwin
+
+// Package monitor provides platform-specific sleep, network, and power monitoring hooks.
+// It is used by the agent and can be reused by client code.
+
+package monitor
+
+import (
+	"context"
+	"os/exec"
+
+	"reverse-proxy-agent/pkg/logging"
+)
+
+const powerMode = "pmset polling"
+
+func StartPowerMonitor(ctx context.Context, live *Intervals, logger *logging.Logger, onEvent func(reason string)) {
+	if live.Config().PowerPollSec <= 0 {
+		return
+	}
+	if onEvent == nil {
+		onEvent = func(string) {}
+	}
+	logger.Info("power monitor: using pmset polling")
+	powerWatcher(ctx, logger, live, readPowerSource, onEvent)
+}
+
+func readPowerSource() (string, error) {
+	out, err := exec.Command("/usr/bin/pmset", "-g", "batt").Output()
+	if err != nil {
+		return "", err
+	}
+	return parsePowerSource(string(out)), nil
+}
@@ -0,0 +1,74 @@
+// Package monitor provides a polling power-source watcher and pmset output parsing.
+// It is shared by platform hooks; only darwin currently supplies a source reader.
+
+package monitor
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"reverse-proxy-agent/pkg/logging"
+)
+
+func powerWatcher(ctx context.Context, logger *logging.Logger, live *Intervals, read func() (string, error), onEvent func(reason string)) {
+	interval := seconds(live.Config().PowerPollSec)
+	if interval <= 0 {
+		return
+	}
+	prev, err := read()
+	if err != nil {
+		logger.Error("power source read failed: %v", err)
+	}
+	timer := time.NewTimer(interval)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+			timer.Reset(seconds(live.Config().PowerPollSec))
+			next, err := read()
+			if err != nil {
+				logger.Error("power source read failed: %v", err)
+				continue
+			}
+			if next == "" {
+				continue
+			}
+			if prev != "" && next != prev {
+				logger.Info("power source changed (%s -> %s)", prev, next)
+				onEvent("power change")
+			}
+			prev = next
+		}
+	}
+}
+
+// parsePowerSource extracts the source from `pmset -g batt` output, for example
+// "Now drawing from 'AC Power'" yields "ac". It returns "" when no source is found.
+func parsePowerSource(output string) string {
+	for _, line := range strings.Split(output, "\n") {
+		idx := strings.Index(line, "drawing from '")
+		if idx < 0 {
+			continue
+		}
+		rest := line[idx+len("drawing from '"):]
+		end := strings.Index(rest, "'")
+		if end < 0 {
+			continue
+		}
+		switch source := strings.ToLower(strings.TrimSpace(rest[:end])); source {
+		case "ac power":
+			return "ac"
+		case "battery power":
+			return "battery"
+		case "ups power":
+			return "ups"
+		default:
+			return source
+		}
+	}
+	return ""
+}
@@ -0,0 +1,21 @@
+//go:build !darwin
+
+// Package monitor provides platform-specific sleep, network, and power monitoring hooks.
+// It is used by the agent and can be reused by client code.
+
+package monitor
+
+import (
+	"context"
+
+	"reverse-proxy-agent/pkg/logging"
+)
+
+const powerMode = "unsupported"
+
+func StartPowerMonitor(_ context.Context, live *Intervals, logger *logging.Logger, _ func(reason string)) {
+	if live.Config().PowerPollSec <= 0 {
+		return
+	}
+	logger.Info("power monitor: not supported on this platform")
+}
@@ -0,0 +1,47 @@
+package monitor
+
+import "testing"
+
+func TestParsePowerSource(t *testing.T) {
+	cases := []struct {
+		name   string
+		output string
+		want   string
+	}{
+		{
+			name:   "laptop on ac",
+			output: "Now drawing from 'AC Power'\n -InternalBattery-0 (id=4653155)\t100%; charged; 0:00 remaining present: true\n",
+			want:   "ac",
+		},
+		{
+			name:   "laptop on battery",
+			output: "Now drawing from 'Battery Power'\n -InternalBattery-0 (id=4653155)\t87%; discharging; 5:12 remaining present: true\n",
+			want:   "battery",
+		},
+		{
+			name:   "desktop without a battery",
+			output: "Now drawing from 'AC Power'\n",
+			want:   "ac",
+		},
+		{
+			name:   "ups",
+			output: "Now drawing from 'UPS Power'\n -CP1500PFCLCD (id=1234)\t96%; discharging; (no estimate) present: true\n",
+			want:   "ups",
+		},
+		{
+			name:   "unknown source kept",
+			output: "Now drawing from 'Solar Power'\n",
+			want:   "solar power",
+		},
+		{name: "empty", output: "", want: ""},
+		{name: "no source line", output: " -InternalBattery-0 (id=4653155)\t100%; charged\n", want: ""},
+		{name: "unterminated quote", output: "Now drawing from 'AC Power\n", want: ""},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := parsePowerSource(tc.output); got != tc.want {
+				t.Fatalf("parsePowerSource = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
@@ -75,6 +75,8 @@ import (
 	"reverse-proxy-agent/pkg/logging"
 )
 
+const sleepMode = "iokit"
+
 var (
 	sleepEventMu sync.Mutex
 	sleepEventCh chan uint32
@@ -94,8 +96,8 @@ func powerCallbackGo(messageType C.uint32_t) {
 	}
 }
 
-func StartSleepMonitor(ctx context.Context, cfg Config, logger *logging.Logger, onEvent func(reason string)) {
-	if cfg.SleepCheckSec <= 0 {
+func StartSleepMonitor(ctx context.Context, live *Intervals, logger *logging.Logger, onEvent func(reason string)) {
+	if live.Config().SleepCheckSec <= 0 {
 		return
 	}
 	if onEvent == nil {
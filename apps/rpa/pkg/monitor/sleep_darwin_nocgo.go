@@ -7,18 +7,19 @@ package monitor
 
 import (
 	"context"
-	"time"
 
 	"reverse-proxy-agent/pkg/logging"
 )
 
-func StartSleepMonitor(ctx context.Context, cfg Config, logger *logging.Logger, onEvent func(reason string)) {
-	if cfg.SleepCheckSec <= 0 {
+const sleepMode = "polling (cgo disabled)"
+
+func StartSleepMonitor(ctx context.Context, live *Intervals, logger *logging.Logger, onEvent func(reason string)) {
+	if live.Config().SleepCheckSec <= 0 {
 		return
 	}
 	if onEvent == nil {
 		onEvent = func(string) {}
 	}
 	logger.Info("sleep monitor: using polling fallback (cgo disabled)")
-	sleepWatcher(ctx, logger, time.Duration(cfg.SleepCheckSec)*time.Second, time.Duration(cfg.SleepGapSec)*time.Second, onEvent)
+	sleepWatcher(ctx, logger, live, onEvent)
 }
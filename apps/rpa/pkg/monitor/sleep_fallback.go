@@ -10,28 +10,40 @@ import (
 	"reverse-proxy-agent/pkg/logging"
 )
 
-func sleepWatcher(ctx context.Context, logger *logging.Logger, interval, gap time.Duration, onEvent func(reason string)) {
+// sleepWatcher rereads live after every tick. The gap a tick is judged by is
+// the one in effect when it was armed, so shortening the interval never turns
+// the pending, longer tick into a false wake.
+func sleepWatcher(ctx context.Context, logger *logging.Logger, live *Intervals, onEvent func(reason string)) {
+	interval, gap := sleepTiming(live.Config())
 	if interval <= 0 {
 		return
 	}
-	if gap <= 0 {
-		gap = interval * 2
-	}
 	last := time.Now()
-	ticker := time.NewTicker(interval)
-	defer ticker.Stop()
+	timer := time.NewTimer(interval)
+	defer timer.Stop()
 
 	for {
 		select {
 		case <-ctx.Done():
 			return
-		case <-ticker.C:
+		case <-timer.C:
 			now := time.Now()
 			if now.Sub(last) > gap {
 				logger.Info("wake detected (gap=%s)", now.Sub(last).Truncate(time.Second))
 				onEvent("wake")
 			}
 			last = now
+			interval, gap = sleepTiming(live.Config())
+			timer.Reset(interval)
 		}
 	}
 }
+
+func sleepTiming(cfg Config) (interval, gap time.Duration) {
+	interval = seconds(cfg.SleepCheckSec)
+	gap = seconds(cfg.SleepGapSec)
+	if gap <= 0 {
+		gap = interval * 2
+	}
+	return interval, gap
+}
@@ -0,0 +1,91 @@
+// Package pidfile records the pid of a foreground run so external tools can
+// find and signal it.
+package pidfile
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// Write records the current process in path and returns the pid the file
+// held before, if any, and whether that process is still alive. Callers
+// decide what a live previous pid means; a garbled file is just overwritten.
+func Write(path string) (prev int, alive bool, err error) {
+	if path == "" {
+		return 0, false, fmt.Errorf("pid path is empty")
+	}
+	self := os.Getpid()
+	if pid, err := Read(path); err == nil && pid != self {
+		prev, alive = pid, Alive(pid)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return 0, false, fmt.Errorf("create pid dir: %w", err)
+	}
+	if err := os.WriteFile(path, []byte(strconv.Itoa(self)+"\n"), 0o600); err != nil {
+		return 0, false, fmt.Errorf("write pid file: %w", err)
+	}
+	return prev, alive, nil
+}
+
+// Remove deletes path if it still names the current process, so a run that
+// was taken over with --force does not delete the newer run's file.
+func Remove(path string) error {
+	return Release(path, 0)
+}
+
+// Release gives up path at the end of a run. A file naming another process is
+// left alone. If it still names this one, it goes back to prev (the pid
+// Write replaced) while that process is alive, so a --force run that exits
+// first does not leave the older, still running instance without a file;
+// otherwise it is deleted.
+func Release(path string, prev int) error {
+	pid, err := Read(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	self := os.Getpid()
+	if pid != self {
+		return nil
+	}
+	if prev > 0 && prev != self && Alive(prev) {
+		if err := os.WriteFile(path, []byte(strconv.Itoa(prev)+"\n"), 0o600); err != nil {
+			return fmt.Errorf("restore pid file: %w", err)
+		}
+		return nil
+	}
+	return os.Remove(path)
+}
+
+func Read(path string) (int, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil || pid <= 0 {
+		return 0, fmt.Errorf("parse pid file %s: invalid pid %q", path, strings.TrimSpace(string(data)))
+	}
+	return pid, nil
+}
+
+// Alive reports whether pid names a running process. EPERM means the process
+// exists but belongs to someone else, which still counts.
+func Alive(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+	proc, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	err = proc.Signal(syscall.Signal(0))
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
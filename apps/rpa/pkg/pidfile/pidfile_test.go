@@ -0,0 +1,171 @@
+package pidfile
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+// deadPID returns the pid of a process that has already exited.
+func deadPID(t *testing.T) int {
+	t.Helper()
+	cmd := exec.Command("true")
+	if err := cmd.Run(); err != nil {
+		t.Skipf("cannot run true: %v", err)
+	}
+	return cmd.Process.Pid
+}
+
+func TestWrite(t *testing.T) {
+	cases := []struct {
+		name      string
+		existing  func(t *testing.T) string
+		wantPrev  func(t *testing.T, content string) int
+		wantAlive bool
+	}{
+		{
+			name:     "no previous file",
+			wantPrev: func(*testing.T, string) int { return 0 },
+		},
+		{
+			name:     "stale pid",
+			existing: func(t *testing.T) string { return strconv.Itoa(deadPID(t)) + "\n" },
+			wantPrev: func(t *testing.T, content string) int {
+				pid, _ := strconv.Atoi(content[:len(content)-1])
+				return pid
+			},
+		},
+		{
+			name:      "live pid",
+			existing:  func(*testing.T) string { return strconv.Itoa(os.Getppid()) + "\n" },
+			wantPrev:  func(*testing.T, string) int { return os.Getppid() },
+			wantAlive: true,
+		},
+		{
+			name:     "own pid is not a previous run",
+			existing: func(*testing.T) string { return strconv.Itoa(os.Getpid()) },
+			wantPrev: func(*testing.T, string) int { return 0 },
+		},
+		{
+			name:     "garbled file is overwritten",
+			existing: func(*testing.T) string { return "not a pid" },
+			wantPrev: func(*testing.T, string) int { return 0 },
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "sub", "com.rpa.agent.pid")
+			content := ""
+			if tc.existing != nil {
+				content = tc.existing(t)
+				if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+					t.Fatal(err)
+				}
+			}
+			prev, alive, err := Write(path)
+			if err != nil {
+				t.Fatalf("Write: %v", err)
+			}
+			if want := tc.wantPrev(t, content); prev != want || alive != tc.wantAlive {
+				t.Fatalf("Write = (%d, %v), want (%d, %v)", prev, alive, want, tc.wantAlive)
+			}
+			if pid, err := Read(path); err != nil || pid != os.Getpid() {
+				t.Fatalf("Read = (%d, %v), want own pid %d", pid, err, os.Getpid())
+			}
+		})
+	}
+}
+
+func TestRemove(t *testing.T) {
+	cases := []struct {
+		name     string
+		content  string
+		wantGone bool
+	}{
+		{name: "own pid is removed", content: strconv.Itoa(os.Getpid()), wantGone: true},
+		{name: "newer run's pid is kept", content: strconv.Itoa(os.Getppid())},
+		{name: "missing file is fine", wantGone: true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "com.rpa.client.pid")
+			if tc.content != "" {
+				if err := os.WriteFile(path, []byte(tc.content+"\n"), 0o600); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := Remove(path); err != nil {
+				t.Fatalf("Remove: %v", err)
+			}
+			_, err := os.Stat(path)
+			if gone := os.IsNotExist(err); gone != tc.wantGone {
+				t.Fatalf("file gone = %v, want %v", gone, tc.wantGone)
+			}
+		})
+	}
+}
+
+func TestRelease(t *testing.T) {
+	self, parent := strconv.Itoa(os.Getpid()), strconv.Itoa(os.Getppid())
+	cases := []struct {
+		name    string
+		content string
+		prev    func(t *testing.T) int
+		// want is the file content afterwards; "" means removed.
+		want string
+	}{
+		{name: "live predecessor gets the file back", content: self, prev: func(*testing.T) int { return os.Getppid() }, want: parent},
+		{name: "dead predecessor", content: self, prev: deadPID},
+		{name: "no predecessor", content: self, prev: func(*testing.T) int { return 0 }},
+		{name: "own pid as predecessor", content: self, prev: func(*testing.T) int { return os.Getpid() }},
+		{name: "newer run's pid is kept", content: parent, prev: deadPID, want: parent},
+		{name: "missing file is fine", prev: func(*testing.T) int { return os.Getppid() }},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "com.rpa.agent.pid")
+			if tc.content != "" {
+				if err := os.WriteFile(path, []byte(tc.content+"\n"), 0o600); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := Release(path, tc.prev(t)); err != nil {
+				t.Fatalf("Release: %v", err)
+			}
+			if tc.want == "" {
+				if _, err := os.Stat(path); !os.IsNotExist(err) {
+					t.Fatalf("pid file still there (stat %v)", err)
+				}
+				return
+			}
+			if pid, err := Read(path); err != nil || strconv.Itoa(pid) != tc.want {
+				t.Fatalf("Read = (%d, %v), want %s", pid, err, tc.want)
+			}
+		})
+	}
+}
+
+func TestAlive(t *testing.T) {
+	cases := []struct {
+		name string
+		pid  func(t *testing.T) int
+		want bool
+	}{
+		{name: "self", pid: func(*testing.T) int { return os.Getpid() }, want: true},
+		{name: "exited", pid: deadPID},
+		{name: "zero", pid: func(*testing.T) int { return 0 }},
+		{name: "negative", pid: func(*testing.T) int { return -1 }},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := Alive(tc.pid(t)); got != tc.want {
+				t.Fatalf("Alive = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
@@ -16,20 +16,26 @@ type Policy int
 const (
 	PolicyAlways Policy = iota
 	PolicyOnFailure
+	PolicyNever
 )
 
 func ParsePolicy(raw string) Policy {
 	switch strings.ToLower(raw) {
 	case "on-failure":
 		return PolicyOnFailure
+	case "never":
+		return PolicyNever
 	default:
 		return PolicyAlways
 	}
 }
 
 func (p Policy) Name() string {
-	if p == PolicyOnFailure {
+	switch p {
+	case PolicyOnFailure:
 		return "on-failure"
+	case PolicyNever:
+		return "never"
 	}
 	return "always"
 }
@@ -57,16 +63,51 @@ func (b *Backoff) Next() time.Duration {
 	if b.min <= 0 {
 		return 0
 	}
-	if b.cur == 0 {
-		b.cur = b.min
-	} else {
-		next := time.Duration(float64(b.cur) * b.factor)
-		if b.max > 0 && next > b.max {
-			next = b.max
+	b.cur = b.step(b.cur)
+	return b.jittered(b.cur)
+}
+
+// step returns the base delay that follows cur, before jitter.
+func (b *Backoff) step(cur time.Duration) time.Duration {
+	if cur == 0 {
+		return b.min
+	}
+	next := time.Duration(float64(cur) * b.factor)
+	if b.max > 0 && next > b.max {
+		next = b.max
+	}
+	return next
+}
+
+// Step is one previewed delay: Base before jitter and the range jitter can
+// move it within.
+type Step struct {
+	Base time.Duration
+	Low  time.Duration
+	High time.Duration
+}
+
+// Preview returns the first n delays Next would produce after a Reset,
+// without changing b.
+func (b *Backoff) Preview(n int) []Step {
+	if b.min <= 0 || n <= 0 {
+		return nil
+	}
+	out := make([]Step, 0, n)
+	var cur time.Duration
+	for i := 0; i < n; i++ {
+		cur = b.step(cur)
+		low, high := cur, cur
+		if b.jitter > 0 {
+			low = time.Duration(float64(cur) * (1 - b.jitter))
+			if low < 0 {
+				low = 0
+			}
+			high = time.Duration(float64(cur) * (1 + b.jitter))
 		}
-		b.cur = next
+		out = append(out, Step{Base: cur, Low: low, High: high})
 	}
-	return b.jittered(b.cur)
+	return out
 }
 
 func (b *Backoff) Reset() {
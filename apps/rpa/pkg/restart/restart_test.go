@@ -0,0 +1,72 @@
+package restart
+
+import (
+	"testing"
+	"time"
+
+	"reverse-proxy-agent/pkg/config"
+)
+
+func TestPreviewMatchesNext(t *testing.T) {
+	cases := []struct {
+		name string
+		cfg  config.RestartConfig
+		n    int
+		want []time.Duration
+	}{
+		{name: "doubling to the cap", cfg: config.RestartConfig{MinDelayMs: 2000, MaxDelayMs: 30000, Factor: 2}, n: 6, want: []time.Duration{2 * time.Second, 4 * time.Second, 8 * time.Second, 16 * time.Second, 30 * time.Second, 30 * time.Second}},
+		{name: "factor one stays flat", cfg: config.RestartConfig{MinDelayMs: 500, MaxDelayMs: 5000, Factor: 1}, n: 3, want: []time.Duration{500 * time.Millisecond, 500 * time.Millisecond, 500 * time.Millisecond}},
+		{name: "no max keeps growing", cfg: config.RestartConfig{MinDelayMs: 1000, Factor: 3}, n: 4, want: []time.Duration{time.Second, 3 * time.Second, 9 * time.Second, 27 * time.Second}},
+		{name: "fractional factor", cfg: config.RestartConfig{MinDelayMs: 1000, MaxDelayMs: 3000, Factor: 1.5}, n: 4, want: []time.Duration{time.Second, 1500 * time.Millisecond, 2250 * time.Millisecond, 3 * time.Second}},
+		{name: "zero min means no delay", cfg: config.RestartConfig{MaxDelayMs: 30000, Factor: 2}, n: 3},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			b := NewBackoff(tc.cfg)
+			steps := b.Preview(tc.n)
+			if len(steps) != len(tc.want) {
+				t.Fatalf("Preview(%d) = %+v, want %d steps", tc.n, steps, len(tc.want))
+			}
+			for i, step := range steps {
+				if step.Base != tc.want[i] || step.Low != step.Base || step.High != step.Base {
+					t.Fatalf("step %d = %+v, want %s with no jitter range", i+1, step, tc.want[i])
+				}
+				if got := b.Next(); got != step.Base {
+					t.Fatalf("Next %d = %s, preview says %s", i+1, got, step.Base)
+				}
+			}
+		})
+	}
+}
+
+func TestPreviewJitterRange(t *testing.T) {
+	b := NewBackoff(config.RestartConfig{MinDelayMs: 1000, MaxDelayMs: 8000, Factor: 2, Jitter: 0.25})
+	steps := b.Preview(5)
+	for i, step := range steps {
+		wantLow := time.Duration(float64(step.Base) * 0.75)
+		wantHigh := time.Duration(float64(step.Base) * 1.25)
+		if step.Low != wantLow || step.High != wantHigh {
+			t.Fatalf("step %d = %+v, want range %s - %s", i+1, step, wantLow, wantHigh)
+		}
+		if got := b.Next(); got < step.Low || got > step.High {
+			t.Fatalf("Next %d = %s, outside the previewed %s - %s", i+1, got, step.Low, step.High)
+		}
+	}
+}
+
+func TestPreviewLeavesBackoff(t *testing.T) {
+	b := NewBackoff(config.RestartConfig{MinDelayMs: 1000, MaxDelayMs: 30000, Factor: 2})
+	b.Next()
+	b.Next()
+	before := b.Current()
+	// The preview always starts from a reset backoff.
+	if steps := b.Preview(2); steps[0].Base != time.Second || steps[1].Base != 2*time.Second {
+		t.Fatalf("Preview from a used backoff = %+v, want it to start at min", steps)
+	}
+	if got := b.Current(); got != before {
+		t.Fatalf("Current after Preview = %s, want %s", got, before)
+	}
+	if got := b.Next(); got != 4*time.Second {
+		t.Fatalf("Next after Preview = %s, want 4s", got)
+	}
+}
@@ -61,6 +61,11 @@ func ClassifyExit(lines *LineBuffer, exitCode int, err error) string {
 		return "auth"
 	case strings.Contains(text, "host key verification failed"):
 		return "hostkey"
+	case strings.Contains(text, "port forwarding failed"),
+		strings.Contains(text, "cannot listen to port"),
+		strings.Contains(text, "could not request local forwarding"),
+		strings.Contains(text, "address already in use"):
+		return "forward_failed"
 	case strings.Contains(text, "could not resolve hostname"):
 		return "dns"
 	case strings.Contains(text, "name or service not known"):
@@ -0,0 +1,58 @@
+package sshutil
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestClassifyExit(t *testing.T) {
+	failed := errors.New("exit status 255")
+	cases := []struct {
+		name     string
+		lines    []string
+		exitCode int
+		err      error
+		want     string
+	}{
+		{name: "clean exit", want: "clean"},
+		{name: "no output", exitCode: 255, err: failed, want: "unknown"},
+		{name: "remote bind refused", lines: []string{"Error: remote port forwarding failed for listen port 8080"}, exitCode: 255, err: failed, want: "forward_failed"},
+		{name: "local port busy", lines: []string{"bind [127.0.0.1]:5432: Address already in use", "channel_setup_fwd_listener_tcpip: cannot listen to port: 5432", "Could not request local forwarding."}, exitCode: 255, err: failed, want: "forward_failed"},
+		{name: "auth", lines: []string{"deploy@bastion: Permission denied (publickey)."}, exitCode: 255, err: failed, want: "auth"},
+		{name: "host key", lines: []string{"Host key verification failed."}, exitCode: 255, err: failed, want: "hostkey"},
+		{name: "dns", lines: []string{"ssh: Could not resolve hostname bastion: nodename nor servname provided"}, exitCode: 255, err: failed, want: "dns"},
+		{name: "refused", lines: []string{"ssh: connect to host bastion port 22: Connection refused"}, exitCode: 255, err: failed, want: "refused"},
+		{name: "timeout", lines: []string{"ssh: connect to host bastion port 22: Operation timed out"}, exitCode: 255, err: failed, want: "timeout"},
+		{name: "no route", lines: []string{"ssh: connect to host bastion port 22: No route to host"}, exitCode: 255, err: failed, want: "network"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			buf := NewLineBuffer(10)
+			for _, line := range tc.lines {
+				buf.Add(line)
+			}
+			if got := ClassifyExit(buf, tc.exitCode, tc.err); got != tc.want {
+				t.Fatalf("ClassifyExit = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestParseVersion(t *testing.T) {
+	cases := []struct {
+		output string
+		want   string
+	}{
+		{output: "OpenSSH_9.6p1 Ubuntu-3ubuntu13, OpenSSL 3.0.13 30 Jan 2024\n", want: "OpenSSH_9.6p1"},
+		{output: "OpenSSH_9.6p1, LibreSSL 3.3.6\n", want: "OpenSSH_9.6p1"},
+		{output: "OpenSSH_for_Windows_8.6p1, LibreSSL 3.4.3\r\n", want: "OpenSSH_for_Windows_8.6p1"},
+		{output: "OpenSSH_8.9p1\nsecond line\n", want: "OpenSSH_8.9p1"},
+		{output: "", want: ""},
+		{output: "  \n", want: ""},
+	}
+	for _, tc := range cases {
+		if got := ParseVersion(tc.output); got != tc.want {
+			t.Errorf("ParseVersion(%q) = %q, want %q", tc.output, got, tc.want)
+		}
+	}
+}
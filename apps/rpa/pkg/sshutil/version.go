@@ -0,0 +1,44 @@
+package sshutil
+
+import (
+	"context"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	versionOnce sync.Once
+	version     string
+)
+
+// Version returns the local ssh version (e.g. "OpenSSH_9.6p1"), or "" when
+// ssh -V fails. The lookup runs once per process.
+func Version() string {
+	versionOnce.Do(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		// ssh -V writes to stderr and exits 0; keep whatever it printed.
+		out, _ := exec.CommandContext(ctx, "ssh", "-V").CombinedOutput()
+		version = ParseVersion(string(out))
+	})
+	return version
+}
+
+// ParseVersion extracts the leading version token from ssh -V output such as
+// "OpenSSH_9.6p1 Ubuntu-3ubuntu13, OpenSSL 3.0.13 30 Jan 2024".
+func ParseVersion(output string) string {
+	line := strings.TrimSpace(output)
+	if i := strings.IndexByte(line, '\n'); i >= 0 {
+		line = strings.TrimSpace(line[:i])
+	}
+	if i := strings.IndexByte(line, ','); i >= 0 {
+		line = line[:i]
+	}
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return ""
+	}
+	return fields[0]
+}
@@ -14,6 +14,9 @@ const (
 	StateStopped State = iota
 	StateConnecting
 	StateConnected
+	// StatePaused means supervision is on hold: no ssh process runs and none
+	// is started until the runner is resumed.
+	StatePaused
 )
 
 func (s State) String() string {
@@ -24,14 +27,42 @@ func (s State) String() string {
 		return "CONNECTING"
 	case StateConnected:
 		return "RUNNING"
+	case StatePaused:
+		return "PAUSED"
 	default:
 		return "UNKNOWN"
 	}
 }
 
+const (
+	HealthHealthy  = "healthy"
+	HealthDegraded = "degraded"
+	HealthDown     = "down"
+	HealthPaused   = "paused"
+)
+
+// Health combines the state with the last tcp check result ("ok", "failed",
+// "unknown" or "" when the check is off). A connected tunnel whose check
+// fails is degraded: ssh is up but the forwarded service is not answering.
+func Health(s State, tcpCheck string) string {
+	switch s {
+	case StateConnected:
+		if tcpCheck == "failed" {
+			return HealthDegraded
+		}
+		return HealthHealthy
+	case StatePaused:
+		return HealthPaused
+	default:
+		return HealthDown
+	}
+}
+
 type StateMachine struct {
-	mu    sync.Mutex
-	state State
+	mu          sync.Mutex
+	state       State
+	transitions int
+	onChange    func(from, to State)
 }
 
 func NewStateMachine() *StateMachine {
@@ -44,26 +75,51 @@ func (sm *StateMachine) State() State {
 	return sm.state
 }
 
-func (sm *StateMachine) Transition(next State) error {
+// Transitions returns how many transitions changed the state.
+func (sm *StateMachine) Transitions() int {
 	sm.mu.Lock()
 	defer sm.mu.Unlock()
+	return sm.transitions
+}
 
+// OnChange registers fn to run after every transition that changes the state.
+func (sm *StateMachine) OnChange(fn func(from, to State)) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	sm.onChange = fn
+}
+
+func (sm *StateMachine) Transition(next State) error {
+	sm.mu.Lock()
 	if !allowedTransition(sm.state, next) {
-		return fmt.Errorf("invalid transition: %s -> %s", sm.state, next)
+		err := fmt.Errorf("invalid transition: %s -> %s", sm.state, next)
+		sm.mu.Unlock()
+		return err
 	}
-
+	prev := sm.state
 	sm.state = next
+	if prev != next {
+		sm.transitions++
+	}
+	fn := sm.onChange
+	sm.mu.Unlock()
+
+	if fn != nil && prev != next {
+		fn(prev, next)
+	}
 	return nil
 }
 
 func allowedTransition(from, to State) bool {
 	switch from {
 	case StateStopped:
-		return to == StateConnecting || to == StateStopped
+		return to == StateConnecting || to == StateStopped || to == StatePaused
 	case StateConnecting:
 		return to == StateConnected || to == StateStopped
 	case StateConnected:
 		return to == StateConnecting || to == StateStopped || to == StateConnected
+	case StatePaused:
+		return to == StateConnecting || to == StateStopped || to == StatePaused
 	default:
 		return false
 	}
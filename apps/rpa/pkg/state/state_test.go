@@ -0,0 +1,108 @@
+package state
+
+import "testing"
+
+// reach walks a fresh machine to s along allowed transitions.
+func reach(t *testing.T, s State) *StateMachine {
+	t.Helper()
+	sm := NewStateMachine()
+	var path []State
+	switch s {
+	case StateConnecting:
+		path = []State{StateConnecting}
+	case StateConnected:
+		path = []State{StateConnecting, StateConnected}
+	case StatePaused:
+		path = []State{StatePaused}
+	}
+	for _, next := range path {
+		if err := sm.Transition(next); err != nil {
+			t.Fatalf("reaching %s: %v", s, err)
+		}
+	}
+	return sm
+}
+
+func TestTransitionGraph(t *testing.T) {
+	all := []State{StateStopped, StateConnecting, StateConnected, StatePaused}
+	allowed := map[State][]State{
+		StateStopped:    {StateStopped, StateConnecting, StatePaused},
+		StateConnecting: {StateConnected, StateStopped},
+		StateConnected:  {StateConnected, StateConnecting, StateStopped},
+		StatePaused:     {StatePaused, StateConnecting, StateStopped},
+	}
+	for _, from := range all {
+		for _, to := range all {
+			want := false
+			for _, s := range allowed[from] {
+				want = want || s == to
+			}
+			t.Run(from.String()+"->"+to.String(), func(t *testing.T) {
+				sm := reach(t, from)
+				before := sm.Transitions()
+				var calls int
+				sm.OnChange(func(State, State) { calls++ })
+
+				err := sm.Transition(to)
+				if (err == nil) != want {
+					t.Fatalf("Transition error = %v, want allowed %v", err, want)
+				}
+				wantState, wantCount, wantCalls := from, before, 0
+				if want {
+					wantState = to
+					if from != to {
+						wantCount, wantCalls = before+1, 1
+					}
+				}
+				if got := sm.State(); got != wantState {
+					t.Fatalf("state = %s, want %s", got, wantState)
+				}
+				if got := sm.Transitions(); got != wantCount {
+					t.Fatalf("transitions = %d, want %d", got, wantCount)
+				}
+				if calls != wantCalls {
+					t.Fatalf("OnChange ran %d times, want %d", calls, wantCalls)
+				}
+			})
+		}
+	}
+}
+
+func TestStateString(t *testing.T) {
+	cases := []struct {
+		state State
+		want  string
+	}{
+		{StateStopped, "STOPPED"},
+		{StateConnecting, "CONNECTING"},
+		{StateConnected, "RUNNING"},
+		{StatePaused, "PAUSED"},
+		{State(42), "UNKNOWN"},
+	}
+	for _, tc := range cases {
+		if got := tc.state.String(); got != tc.want {
+			t.Errorf("State(%d).String() = %q, want %q", int(tc.state), got, tc.want)
+		}
+	}
+}
+
+func TestHealth(t *testing.T) {
+	cases := []struct {
+		state    State
+		tcpCheck string
+		want     string
+	}{
+		{StateConnected, "", HealthHealthy},
+		{StateConnected, "ok", HealthHealthy},
+		{StateConnected, "unknown", HealthHealthy},
+		{StateConnected, "failed", HealthDegraded},
+		{StateConnecting, "ok", HealthDown},
+		{StateStopped, "", HealthDown},
+		{StatePaused, "failed", HealthPaused},
+	}
+	for _, tc := range cases {
+		if got := Health(tc.state, tc.tcpCheck); got != tc.want {
+			t.Errorf("Health(%s, %q) = %q, want %q", tc.state, tc.tcpCheck, got, tc.want)
+		}
+	}
+}
@@ -15,6 +15,8 @@ type Snapshot struct {
 	LastTrigger     string `json:"last_trigger,omitempty"`
 	LastSuccessUnix int64  `json:"last_success_unix,omitempty"`
 	UpdatedUnix     int64  `json:"updated_unix,omitempty"`
+	Version         string `json:"version,omitempty"`
+	SSHVersion      string `json:"ssh_version,omitempty"`
 }
 
 func Write(path string, snap Snapshot) error {
@@ -49,3 +51,19 @@ func Read(path string) (Snapshot, error) {
 	}
 	return snap, nil
 }
+
+// ReadRaw returns the state file as stored, after checking it is JSON, so
+// fields this build does not know (say, from a newer release) are kept.
+func ReadRaw(path string) ([]byte, error) {
+	if path == "" {
+		return nil, fmt.Errorf("state path is empty")
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if !json.Valid(data) {
+		return nil, fmt.Errorf("parse state: invalid JSON")
+	}
+	return data, nil
+}
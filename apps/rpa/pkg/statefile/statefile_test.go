@@ -0,0 +1,117 @@
+package statefile
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRoundTrip(t *testing.T) {
+	cases := []struct {
+		name string
+		snap Snapshot
+	}{
+		{name: "with build metadata", snap: Snapshot{LastExit: "exit status 255", LastClass: "network", LastTrigger: "wake", LastSuccessUnix: 1777636800, Version: "1.4.0", SSHVersion: "OpenSSH_9.6p1"}},
+		{name: "without build metadata", snap: Snapshot{LastExit: "exit status 1", LastClass: "auth"}},
+		{name: "empty", snap: Snapshot{}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "state", "agent.state.json")
+			before := time.Now().Unix()
+			if err := Write(path, tc.snap); err != nil {
+				t.Fatalf("Write: %v", err)
+			}
+			got, err := Read(path)
+			if err != nil {
+				t.Fatalf("Read: %v", err)
+			}
+			if got.UpdatedUnix < before {
+				t.Fatalf("UpdatedUnix = %d, want >= %d", got.UpdatedUnix, before)
+			}
+			want := tc.snap
+			want.UpdatedUnix = got.UpdatedUnix
+			if got != want {
+				t.Fatalf("Read = %+v, want %+v", got, want)
+			}
+			data, _ := os.ReadFile(path)
+			if tc.snap.Version == "" && strings.Contains(string(data), "version") {
+				t.Fatalf("empty versions written: %s", data)
+			}
+		})
+	}
+}
+
+func TestRead(t *testing.T) {
+	cases := []struct {
+		name    string
+		content string
+		want    Snapshot
+		wantErr string
+	}{
+		{name: "file from before build metadata", content: `{"last_exit":"exit status 255","last_class":"dns","updated_unix":1777636800}`, want: Snapshot{LastExit: "exit status 255", LastClass: "dns", UpdatedUnix: 1777636800}},
+		{name: "unknown fields ignored", content: `{"last_class":"clean","written_by_host":"mac","version":"2.0.0"}`, want: Snapshot{LastClass: "clean", Version: "2.0.0"}},
+		{name: "corrupt", content: `{"last_class":`, wantErr: "parse state"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "agent.state.json")
+			if err := os.WriteFile(path, []byte(tc.content), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			got, err := Read(path)
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("Read error = %v, want %q", err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil || got != tc.want {
+				t.Fatalf("Read = %+v, %v; want %+v", got, err, tc.want)
+			}
+		})
+	}
+}
+
+func TestReadRaw(t *testing.T) {
+	cases := []struct {
+		name    string
+		content string
+		wantErr string
+	}{
+		{name: "unknown fields kept", content: `{"written_by_host":"mac","last_class":"clean"}`},
+		{name: "corrupt", content: `{"last_class":`, wantErr: "parse state"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "agent.state.json")
+			if err := os.WriteFile(path, []byte(tc.content), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			got, err := ReadRaw(path)
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("ReadRaw error = %v, want %q", err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil || string(got) != tc.content {
+				t.Fatalf("ReadRaw = %q, %v; want %q", got, err, tc.content)
+			}
+		})
+	}
+}
+
+func TestEmptyPath(t *testing.T) {
+	if err := Write("", Snapshot{}); err == nil {
+		t.Fatal("Write with an empty path succeeded")
+	}
+	if _, err := Read(""); err == nil {
+		t.Fatal("Read with an empty path succeeded")
+	}
+	if _, err := ReadRaw(""); err == nil {
+		t.Fatal("ReadRaw with an empty path succeeded")
+	}
+}